@@ -0,0 +1,211 @@
+package action
+
+import (
+	"encoding/json"
+	"fmt"
+	"runtime/debug"
+
+	boshlog "github.com/cloudfoundry/bosh-utils/logger"
+	"github.com/cppforlife/bosh-cpi-go/apiv1"
+	"github.com/cppforlife/bosh-cpi-go/rpc"
+
+	"bosh-esxi-cpi/logging"
+)
+
+// APIVersion is the highest CPI API version info advertises. Directors send the version they chose
+// with each request, older directors send none and get API v1.
+const APIVersion = 2
+
+// Dispatcher serves each request with the CPI API version it asks for. bosh-cpi-go only speaks v1,
+// so v2 replaces the results of info, create_vm and attach_disk.
+type Dispatcher struct {
+	factory Factory
+	logger  boshlog.Logger
+}
+
+var _ rpc.Dispatcher = Dispatcher{}
+
+func NewDispatcher(factory Factory, logger boshlog.Logger) Dispatcher {
+	return Dispatcher{factory: factory, logger: logger}
+}
+
+// Dispatch serves a request, logging through a logger stamped with the request's id, method and CIDs
+func (d Dispatcher) Dispatch(reqBytes []byte) (respBytes []byte) {
+	var req struct {
+		Method    string        `json:"method"`
+		Arguments []interface{} `json:"arguments"`
+		Context   struct {
+			RequestID string `json:"request_id"`
+		} `json:"context"`
+		APIVersion int `json:"api_version"`
+	}
+	// the JSON dispatcher reports malformed requests
+	_ = json.Unmarshal(reqBytes, &req)
+
+	logger := logging.NewRequestLogger(d.logger, requestFields(req.Method, req.Arguments, req.Context.RequestID))
+
+	// a method which panics, such as one the CPI does not implement, fails its own request
+	// rather than the daemon serving the others
+	defer func() {
+		if r := recover(); r != nil {
+			logger.Error("dispatcher", "Panic serving '%s': %v\n%s", req.Method, r, debug.Stack())
+			respBytes = panicResponse(req.Method, r)
+		}
+	}()
+
+	factory := d.factory
+	factory.logger = logger
+
+	actionFactory := versionedActionFactory{factory: factory, apiVersion: req.APIVersion}
+	return rpc.NewJSONDispatcher(actionFactory, rpc.NewJSONCaller(), logger).Dispatch(reqBytes)
+}
+
+// panicResponse fails the request with a CpiError, as the JSON dispatcher fails requests it cannot serve
+func panicResponse(method string, r interface{}) []byte {
+	respBytes, _ := json.Marshal(rpc.Response{
+		Error: &rpc.ResponseError{
+			Type:    "Bosh::Clouds::CpiError",
+			Message: fmt.Sprintf("Panic serving '%s': %v", method, r),
+		},
+	})
+	return respBytes
+}
+
+// cidArguments are the positions of the VM and disk CIDs among the arguments of the methods taking them, or -1
+var cidArguments = map[string]struct{ vm, disk int }{
+	"delete_vm":         {0, -1},
+	"has_vm":            {0, -1},
+	"reboot_vm":         {0, -1},
+	"set_vm_metadata":   {0, -1},
+	"get_disks":         {0, -1},
+	"create_disk":       {2, -1},
+	"attach_disk":       {0, 1},
+	"detach_disk":       {0, 1},
+	"delete_disk":       {-1, 0},
+	"has_disk":          {-1, 0},
+	"set_disk_metadata": {-1, 0},
+	"resize_disk":       {-1, 0},
+}
+
+func requestFields(method string, arguments []interface{}, requestID string) logging.Fields {
+	fields := logging.Fields{RequestID: requestID, Method: method}
+
+	if positions, found := cidArguments[method]; found {
+		fields.VMCID = stringArgument(arguments, positions.vm)
+		fields.DiskCID = stringArgument(arguments, positions.disk)
+	}
+
+	return fields
+}
+
+// stringArgument returns the argument at the position if it is a string, e.g. not null for create_disk without a VM
+func stringArgument(arguments []interface{}, i int) string {
+	if i < 0 || i >= len(arguments) {
+		return ""
+	}
+	argument, _ := arguments[i].(string)
+	return argument
+}
+
+// versionedActionFactory binds the methods whose results differ by API version, and leaves the others to apiv1
+type versionedActionFactory struct {
+	factory    Factory
+	apiVersion int
+}
+
+func (f versionedActionFactory) Create(method string, context apiv1.CallContext) (interface{}, error) {
+	if method != "info" && (f.apiVersion < 2 || (method != "create_vm" && method != "attach_disk")) {
+		return apiv1.NewActionFactory(versionedFactory{f}).Create(method, context)
+	}
+
+	cpi, err := f.factory.newCPI(context, f.apiVersion)
+	if err != nil {
+		return nil, err
+	}
+
+	switch method {
+	case "create_vm":
+		return func(
+			agentID apiv1.AgentID, stemcellCID apiv1.StemcellCID, props apiv1.CloudPropsImpl,
+			networks apiv1.Networks, diskCIDs []apiv1.DiskCID, env apiv1.VMEnv) ([]interface{}, error) {
+
+			vmCID, networksV2, err := cpi.CreateVMV2(agentID, stemcellCID, props, networks, diskCIDs, env)
+			if err != nil {
+				return nil, err
+			}
+			return []interface{}{vmCID, networksV2}, nil
+		}, nil
+
+	case "attach_disk":
+		return cpi.AttachDiskV2, nil
+	}
+
+	return cpi.InfoV2, nil
+}
+
+// versionedFactory builds the CPI for the API version of the request
+type versionedFactory struct {
+	versionedActionFactory
+}
+
+func (f versionedFactory) New(context apiv1.CallContext) (apiv1.CPI, error) {
+	cpi, err := f.factory.newCPI(context, f.apiVersion)
+	if err != nil {
+		return nil, err
+	}
+	return cpi, nil
+}
+
+// stemcellAPIVersion returns the API version of the stemcell of the VM a request is for, which
+// directors pass in the context. Stemcells with v2 agents take settings from the director.
+func stemcellAPIVersion(context apiv1.CallContext) int {
+	var vmContext struct {
+		VM struct {
+			Stemcell struct {
+				APIVersion int `json:"api_version"`
+			} `json:"stemcell"`
+		} `json:"vm"`
+	}
+
+	if context == nil || context.As(&vmContext) != nil {
+		return 1
+	}
+
+	return vmContext.VM.Stemcell.APIVersion
+}
+
+// NetworkV2 is a network as create_vm returns it with API v2, with the MAC address of its NIC
+type NetworkV2 struct {
+	Type       string                 `json:"type"`
+	IP         string                 `json:"ip,omitempty"`
+	Netmask    string                 `json:"netmask,omitempty"`
+	Gateway    string                 `json:"gateway,omitempty"`
+	DNS        []string               `json:"dns,omitempty"`
+	Default    []string               `json:"default,omitempty"`
+	MAC        string                 `json:"mac,omitempty"`
+	CloudProps map[string]interface{} `json:"cloud_properties"`
+}
+
+// newNetworksV2 returns the networks with the MAC addresses of their NICs, by network name
+func newNetworksV2(networks apiv1.Networks, macAddresses map[string]string) map[string]NetworkV2 {
+	networksV2 := map[string]NetworkV2{}
+
+	for name, network := range networks {
+		// networks without cloud properties have none to decode
+		cloudProps := map[string]interface{}{}
+		_ = network.CloudProps().As(&cloudProps)
+
+		networksV2[name] = NetworkV2{
+			Type:       network.Type(),
+			IP:         network.IP(),
+			Netmask:    network.Netmask(),
+			Gateway:    network.Gateway(),
+			DNS:        network.DNS(),
+			Default:    network.Default(),
+			MAC:        macAddresses[name],
+			CloudProps: cloudProps,
+		}
+	}
+
+	return networksV2
+}
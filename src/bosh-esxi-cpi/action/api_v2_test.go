@@ -0,0 +1,207 @@
+package action_test
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"strings"
+
+	"github.com/cppforlife/bosh-cpi-go/apiv1"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	fakegovc "bosh-esxi-cpi/govc/fakes"
+	fakevm "bosh-esxi-cpi/vm/fakes"
+
+	boshlog "github.com/cloudfoundry/bosh-utils/logger"
+	fakelogger "github.com/cloudfoundry/bosh-utils/logger/loggerfakes"
+	fakesys "github.com/cloudfoundry/bosh-utils/system/fakes"
+	fakeuuid "github.com/cloudfoundry/bosh-utils/uuid/fakes"
+
+	"bosh-esxi-cpi/action"
+	"bosh-esxi-cpi/config"
+	"bosh-esxi-cpi/logging"
+	"bosh-esxi-cpi/pool"
+)
+
+var _ = Describe("CPI API v2", func() {
+	Describe("Dispatcher", func() {
+		var (
+			govcRunner *fakegovc.FakeGovcRunner
+			dispatcher action.Dispatcher
+		)
+
+		BeforeEach(func() {
+			govcRunner = &fakegovc.FakeGovcRunner{}
+
+			cpiConfig := config.Config{Cloud: config.Cloud{Properties: config.CPIProperties{
+				Vcenters: []config.Vcenter{{Host: "esxi-1", Datacenters: []config.Datacenter{{Name: "ha-datacenter"}}}},
+			}}}
+			factory := action.NewFactory(govcRunner, nil, apiv1.NewAgentEnvFactory(), cpiConfig, fakesys.NewFakeFileSystem(), &fakeuuid.FakeGenerator{}, &fakelogger.FakeLogger{})
+			dispatcher = action.NewDispatcher(factory, &fakelogger.FakeLogger{})
+		})
+
+		dispatch := func(request string) map[string]interface{} {
+			var response map[string]interface{}
+			Expect(json.Unmarshal(dispatcher.Dispatch([]byte(request)), &response)).To(Succeed())
+			Expect(response["error"]).To(BeNil())
+			return response
+		}
+
+		commands := func() []string {
+			var names []string
+			for i := 0; i < govcRunner.CliCommandCallCount(); i++ {
+				name, _, _ := govcRunner.CliCommandArgsForCall(i)
+				names = append(names, name)
+			}
+			return names
+		}
+
+		It("advertises API v2 to directors which do not send a version yet", func() {
+			response := dispatch(`{"method":"info","arguments":[],"context":{}}`)
+			Expect(response["result"]).To(Equal(map[string]interface{}{
+				"stemcell_formats": []interface{}{"general-ovf", "vsphere-ovf", "vsphere-ova", "vsphere-light"},
+				"api_version":      float64(2),
+			}))
+		})
+
+		It("returns the disk hint without updating the env ISO of stemcells with API v2", func() {
+			response := dispatch(`{"method":"attach_disk","arguments":["vm-cid","disk-cid"],"context":{"vm":{"stemcell":{"api_version":2}}},"api_version":2}`)
+
+			Expect(response["result"]).To(Equal(map[string]interface{}{"path": "/dev/sdc", "volume_id": "2", "lun": "0"}))
+			Expect(commands()).To(ConsistOf("vm.disk.attach"))
+		})
+
+		It("reports attach_disk failures like API v1", func() {
+			govcRunner.CliCommandReturnsOnCall(0, "", errors.New("too many disks"))
+
+			response := dispatcher.Dispatch([]byte(`{"method":"attach_disk","arguments":["vm-cid","disk-cid"],"context":{}}`))
+			Expect(string(response)).To(ContainSubstring(`"type":"Bosh::Clouds::DiskNotAttached"`))
+		})
+
+		It("fails a request whose method panics with a CpiError", func() {
+			var response map[string]interface{}
+			Expect(json.Unmarshal(dispatcher.Dispatch([]byte(`{"method":"has_disk","arguments":["disk-cid"],"context":{}}`)), &response)).To(Succeed())
+			Expect(response["error"]).To(Equal(map[string]interface{}{
+				"type":        "Bosh::Clouds::CpiError",
+				"message":     "Panic serving 'has_disk': HasDisk",
+				"ok_to_retry": false,
+			}))
+
+			response = dispatch(`{"method":"info","arguments":[],"context":{}}`)
+			Expect(response["result"]).ToNot(BeNil())
+		})
+
+		It("stamps log lines with the request id, method and CIDs", func() {
+			out := &bytes.Buffer{}
+			logger, err := logging.NewLogger(boshlog.LevelDebug, logging.FormatJSON, out)
+			Expect(err).ToNot(HaveOccurred())
+
+			cpiConfig := config.Config{Cloud: config.Cloud{Properties: config.CPIProperties{
+				Vcenters: []config.Vcenter{{Host: "esxi-1"}},
+			}}}
+			factory := action.NewFactory(govcRunner, nil, apiv1.NewAgentEnvFactory(), cpiConfig, fakesys.NewFakeFileSystem(), &fakeuuid.FakeGenerator{}, logger)
+			govcRunner.CliCommandReturns("", errors.New("destroy failed"))
+
+			action.NewDispatcher(factory, logger).Dispatch([]byte(`{"method":"delete_vm","arguments":["vm-uuid"],"context":{"request_id":"cpi-123"}}`))
+
+			var lines []map[string]interface{}
+			for _, line := range strings.Split(strings.TrimSpace(out.String()), "\n") {
+				var parsed map[string]interface{}
+				Expect(json.Unmarshal([]byte(line), &parsed)).To(Succeed())
+				lines = append(lines, parsed)
+			}
+
+			Expect(lines).ToNot(BeEmpty())
+			Expect(lines).To(ContainElement(HaveKeyWithValue("tag", "govc")))
+			for _, line := range lines {
+				Expect(line).To(HaveKeyWithValue("request_id", "cpi-123"))
+				Expect(line).To(HaveKeyWithValue("method", "delete_vm"))
+				Expect(line).To(HaveKeyWithValue("vm_cid", "vm-uuid"))
+			}
+		})
+
+		It("serves the other methods like API v1", func() {
+			govcRunner.CliCommandReturns(`{"VirtualMachines":null}`, nil)
+
+			response := dispatch(`{"method":"has_vm","arguments":["vm-cid"],"context":{},"api_version":2}`)
+			Expect(response["result"]).To(BeFalse())
+		})
+	})
+
+	Describe("CreateVMV2", func() {
+		It("returns the networks with the MAC addresses of their NICs", func() {
+			govcClient := &fakegovc.FakeGovcClient{}
+			agentSettings := &fakevm.FakeAgentSettings{}
+			agentSettings.GenerateMacAddressReturns("00:50:56:3f:00:01", nil)
+
+			networks := apiv1.Networks{}
+			Expect(networks.UnmarshalJSON([]byte(`{"default":{"type":"manual","ip":"10.0.0.5","netmask":"255.255.255.0","gateway":"10.0.0.1","dns":["8.8.8.8"],"default":["dns","gateway"],"cloud_properties":{"name":"VM Network"}}}`))).To(Succeed())
+
+			var cloudProps apiv1.CloudPropsImpl
+			Expect(json.Unmarshal([]byte(`{}`), &cloudProps)).To(Succeed())
+
+			m := action.NewCreateVMMethod(govcClient, nil, agentSettings, apiv1.AgentOptions{}, apiv1.NewAgentEnvFactory(), pool.Pool{}, false, &fakeuuid.FakeGenerator{}, &fakelogger.FakeLogger{})
+			cid, networksV2, err := m.CreateVMV2(apiv1.AgentID{}, apiv1.NewStemcellCID("stemcell"), cloudProps, networks, nil, apiv1.VMEnv{})
+			Expect(err).ToNot(HaveOccurred())
+			Expect(cid.AsString()).To(Equal("fake-uuid-0"))
+
+			Expect(networksV2).To(Equal(map[string]action.NetworkV2{
+				"default": {
+					Type:       "manual",
+					IP:         "10.0.0.5",
+					Netmask:    "255.255.255.0",
+					Gateway:    "10.0.0.1",
+					DNS:        []string{"8.8.8.8"},
+					Default:    []string{"dns", "gateway"},
+					MAC:        "00:50:56:3f:00:01",
+					CloudProps: map[string]interface{}{"name": "VM Network"},
+				},
+			}))
+		})
+	})
+
+	Describe("AttachDiskV2", func() {
+		var (
+			govcClient    *fakegovc.FakeGovcClient
+			agentSettings *fakevm.FakeAgentSettings
+		)
+
+		BeforeEach(func() {
+			govcClient = &fakegovc.FakeGovcClient{}
+			agentSettings = &fakevm.FakeAgentSettings{}
+			agentSettings.AgentEnvBytesReturns([]byte(`{}`), nil)
+		})
+
+		It("updates the env ISO for stemcells with API v1", func() {
+			m := action.NewAttachDiskMethod(govcClient, agentSettings, apiv1.NewAgentEnvFactory(), true)
+
+			hint, err := m.AttachDiskV2(apiv1.NewVMCID("vm-uuid"), apiv1.NewDiskCID("disk-uuid"))
+			Expect(err).ToNot(HaveOccurred())
+			Expect(hint).To(Equal(action.DiskHint{Path: "/dev/sdc", VolumeID: "2", Lun: "0"}))
+			Expect(govcClient.UpdateVMIsoCallCount()).To(Equal(1))
+
+			Expect(agentSettings.AgentEnvBytesArgsForCall(0)).To(Equal("vm-vm-uuid"))
+			vmId, _ := agentSettings.GenerateAgentEnvIsoArgsForCall(0)
+			Expect(vmId).To(Equal("vm-vm-uuid"))
+		})
+
+		It("fails when the env of the VM cannot be read", func() {
+			agentSettings.AgentEnvBytesReturns(nil, errors.New("reading agent env of 'vm-vm-uuid' failed"))
+			m := action.NewAttachDiskMethod(govcClient, agentSettings, apiv1.NewAgentEnvFactory(), true)
+
+			_, err := m.AttachDiskV2(apiv1.NewVMCID("vm-uuid"), apiv1.NewDiskCID("disk-uuid"))
+			Expect(err).To(MatchError("reading agent env of 'vm-vm-uuid' failed"))
+			Expect(govcClient.UpdateVMIsoCallCount()).To(Equal(0))
+		})
+
+		It("leaves the env ISO to the director otherwise", func() {
+			m := action.NewAttachDiskMethod(govcClient, agentSettings, apiv1.NewAgentEnvFactory(), false)
+
+			_, err := m.AttachDiskV2(apiv1.NewVMCID("vm-uuid"), apiv1.NewDiskCID("disk-uuid"))
+			Expect(err).ToNot(HaveOccurred())
+			Expect(agentSettings.GenerateAgentEnvIsoCallCount()).To(Equal(0))
+			Expect(govcClient.UpdateVMIsoCallCount()).To(Equal(0))
+		})
+	})
+})
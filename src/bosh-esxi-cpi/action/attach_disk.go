@@ -7,50 +7,72 @@ import (
 	"bosh-esxi-cpi/vm"
 )
 
+// DiskHint tells the agent where to find an attached persistent disk
+type DiskHint struct {
+	Path     string `json:"path"`      //can be removed?
+	VolumeID string `json:"volume_id"` //should be 3?
+	Lun      string `json:"lun"`
+}
+
 type AttachDiskMethod struct {
 	govcClient      govc.GovcClient
 	agentSettings   vm.AgentSettings
 	agentEnvFactory apiv1.AgentEnvFactory
+	updateEnvIso    bool
 }
 
-func NewAttachDiskMethod(govcClient govc.GovcClient, agentSettings vm.AgentSettings, agentEnvFactory apiv1.AgentEnvFactory) AttachDiskMethod {
+// NewAttachDiskMethod returns a method which adds the disk to the agent settings in the VM's env ISO
+// when updateEnvIso is set. Directors with API v2 update the settings of stemcells with API v2 themselves.
+func NewAttachDiskMethod(govcClient govc.GovcClient, agentSettings vm.AgentSettings, agentEnvFactory apiv1.AgentEnvFactory, updateEnvIso bool) AttachDiskMethod {
 	return AttachDiskMethod{
 		govcClient:      govcClient,
 		agentSettings:   agentSettings,
 		agentEnvFactory: agentEnvFactory,
+		updateEnvIso:    updateEnvIso,
 	}
 }
 
 func (c AttachDiskMethod) AttachDisk(vmCID apiv1.VMCID, diskCID apiv1.DiskCID) error {
+	_, err := c.AttachDiskV2(vmCID, diskCID)
+	return err
+}
+
+// AttachDiskV2 attaches the disk like AttachDisk, and returns the hint for directors with API v2
+func (c AttachDiskMethod) AttachDiskV2(vmCID apiv1.VMCID, diskCID apiv1.DiskCID) (DiskHint, error) {
 	vmId := "vm-" + vmCID.AsString()
 	diskId := "disk-" + diskCID.AsString()
 
 	err := c.govcClient.AttachDisk(vmId, diskId)
 	if err != nil {
-		return err
+		return DiskHint{}, attachDiskError(err, vmCID, diskCID)
 	}
 
-	agentEnvBytes := c.agentSettings.AgentEnvBytesFromFile()
+	diskHint := DiskHint{"/dev/sdc", "2", "0"}
+
+	if !c.updateEnvIso {
+		return diskHint, nil
+	}
+
+	agentEnvBytes, err := c.agentSettings.AgentEnvBytes(vmId)
+	if err != nil {
+		return DiskHint{}, err
+	}
 	agentEnv, err := c.agentEnvFactory.FromBytes(agentEnvBytes)
 	if err != nil {
-		return err
+		return DiskHint{}, err
 	}
-	agentEnv.AttachPersistentDisk(diskCID, struct {
-		Path     string `json:"path"`      //can be removed?
-		VolumeID string `json:"volume_id"` //should be 3?
-		Lun      string `json:"lun"`
-	}{"/dev/sdc", "2", "0"})
+	agentEnv.AttachPersistentDisk(diskCID, diskHint)
 
-	envIsoPath, err := c.agentSettings.GenerateAgentEnvIso(agentEnv)
+	envIsoPath, err := c.agentSettings.GenerateAgentEnvIso(vmId, agentEnv)
 	if err != nil {
-		return err
+		return DiskHint{}, err
 	}
 
 	_, err = c.govcClient.UpdateVMIso(vmId, envIsoPath)
 	if err != nil {
-		return err
+		return DiskHint{}, vmError(err, vmCID)
 	}
 	c.agentSettings.Cleanup()
 
-	return nil
+	return diskHint, nil
 }
@@ -6,6 +6,13 @@ import (
 	. "github.com/onsi/ginkgo"
 	. "github.com/onsi/gomega"
 	"github.com/onsi/gomega/gexec"
+	"github.com/vmware/govmomi/simulator"
+
+	fakegovc "bosh-esxi-cpi/govc/fakes"
+
+	fakelogger "github.com/cloudfoundry/bosh-utils/logger/loggerfakes"
+
+	"bosh-esxi-cpi/govc"
 )
 
 func TestCpi(t *testing.T) {
@@ -16,3 +23,23 @@ func TestCpi(t *testing.T) {
 var _ = AfterSuite(func() {
 	gexec.CleanupBuildArtifacts()
 })
+
+// vmNotFoundError returns the error a govc client gives for a VM missing from a simulated host,
+// for fake clients to fail with
+func vmNotFoundError(vmName string) error {
+	model := simulator.VPX()
+	Expect(model.Create()).To(Succeed())
+	defer model.Remove()
+
+	server := model.Service.NewServer()
+	defer server.Close()
+
+	config := &fakegovc.FakeGovcConfig{}
+	config.EsxUrlReturns(server.URL.String())
+	config.DatacenterReturns("DC0")
+
+	logger := &fakelogger.FakeLogger{}
+	_, err := govc.NewClient(govc.NewGovcRunner(logger), config, logger).GetVMExtraConfig(vmName)
+	Expect(govc.IsVMNotFound(err)).To(BeTrue())
+	return err
+}
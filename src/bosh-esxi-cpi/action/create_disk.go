@@ -28,7 +28,7 @@ func (c CreateDiskMethod) CreateDisk(sizeMB int,
 
 	err := c.govcClient.CreateDisk(diskId, sizeMB)
 	if err != nil {
-		return newDiskCID, err
+		return newDiskCID, hostError(err)
 	}
 	return newDiskCID, nil
 }
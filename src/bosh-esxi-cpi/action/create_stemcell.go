@@ -1,41 +1,184 @@
 package action
 
 import (
+	"strings"
+
 	boshlog "github.com/cloudfoundry/bosh-utils/logger"
 	boshuuid "github.com/cloudfoundry/bosh-utils/uuid"
 	"github.com/cppforlife/bosh-cpi-go/apiv1"
 
+	"bosh-esxi-cpi/filelock"
 	"bosh-esxi-cpi/govc"
+	"bosh-esxi-cpi/pool"
 	"bosh-esxi-cpi/stemcell"
 )
 
+const (
+	stemcellPrefix         = "cs-"
+	stemcellFingerprintKey = "bosh.stemcell_fingerprint"
+	stemcellCIDsKey        = "bosh.stemcell_cids"
+
+	// stemcellCIDSeparator joins the template and the reference in the CID of a stemcell sharing another's template
+	stemcellCIDSeparator = ":"
+)
+
 type CreateStemcellMethod struct {
-	govcClient     govc.GovcClient
-	stemcellClient stemcell.StemcellClient
-	uuidGen        boshuuid.Generator
-	logger         boshlog.Logger
+	govcClient govc.GovcClient
+	uuidGen    boshuuid.Generator
+	logger     boshlog.Logger
 }
 
-func NewCreateStemcellMethod(govcClient govc.GovcClient, stemcellClient stemcell.StemcellClient, uuidGen boshuuid.Generator, logger boshlog.Logger) CreateStemcellMethod {
-	return CreateStemcellMethod{govcClient: govcClient, stemcellClient: stemcellClient, uuidGen: uuidGen, logger: logger}
+func NewCreateStemcellMethod(govcClient govc.GovcClient, uuidGen boshuuid.Generator, logger boshlog.Logger) CreateStemcellMethod {
+	return CreateStemcellMethod{govcClient: govcClient, uuidGen: uuidGen, logger: logger}
 }
 
-func (c CreateStemcellMethod) CreateStemcell(imagePath string, _ apiv1.StemcellCloudProps) (apiv1.StemcellCID, error) {
+// CreateStemcell imports the stemcell unless a template with the same fingerprint already exists on the host.
+// Every CID pointing at a template is listed in its extra config, so the template is only deleted with the last one.
+func (c CreateStemcellMethod) CreateStemcell(imagePath string, cloudProps apiv1.StemcellCloudProps) (apiv1.StemcellCID, error) {
+	stemcellProps, err := stemcell.NewStemcellProps(cloudProps)
+	if err != nil {
+		return apiv1.StemcellCID{}, err
+	}
+
+	fingerprint, err := stemcell.Fingerprint(stemcell.NewArchive(imagePath), stemcellProps)
+	if err != nil {
+		return apiv1.StemcellCID{}, err
+	}
+
 	stemcellUuid, _ := c.uuidGen.Generate()
-	stemcellId := "cs-" + stemcellUuid
+
+	if fingerprint != "" {
+		templateId, err := c.findTemplate(fingerprint)
+		if err != nil {
+			return apiv1.StemcellCID{}, hostError(err)
+		}
+
+		if templateId != "" {
+			cid, reused, err := c.reuseTemplate(templateId, stemcellUuid)
+			if err != nil || reused {
+				return cid, err
+			}
+		}
+	}
+
+	stemcellId := stemcellPrefix + stemcellUuid
 	stemcellCID := apiv1.NewStemcellCID(stemcellUuid)
 
-	c.logger.Debug("cpi", "ImagePath: %s", imagePath)
-	ovfPath, err := c.stemcellClient.ExtractOvf(imagePath)
+	c.logger.Debug("cpi", "ImagePath: %s, stemcell: %s/%s", imagePath, stemcellProps.Name, stemcellProps.Version)
+	err = c.importStemcell(imagePath, stemcellProps, stemcellId)
 	if err != nil {
-		return stemcellCID, err
+		// a failed import may leave a partially registered VM and its folder behind
+		_, destroyErr := c.govcClient.DestroyVM(stemcellId)
+		if destroyErr != nil {
+			c.logger.Error("create-stemcell", "Rolling back stemcell '%s': destroying VM: %s", stemcellId, destroyErr)
+		}
+		return stemcellCID, hostError(err)
+	}
+
+	// tagged only once imported, so a fingerprint always points at a complete template
+	extraConfig := map[string]string{stemcellCIDsKey: stemcellUuid}
+	if fingerprint != "" {
+		extraConfig[stemcellFingerprintKey] = fingerprint
+	}
+	if stemcellProps.Name != "" {
+		extraConfig[pool.StemcellNameKey] = stemcellProps.Name
 	}
 
-	_, err = c.govcClient.ImportOvf(ovfPath, stemcellId)
+	err = c.govcClient.SetVMExtraConfig(stemcellId, extraConfig)
 	if err != nil {
-		return stemcellCID, err
+		c.logger.Warn("create-stemcell", "Stemcell '%s' will not be reused: %s", stemcellId, err)
 	}
-	c.stemcellClient.Cleanup()
 
 	return stemcellCID, nil
 }
+
+// importStemcell imports the image, or clones the template named by a light stemcell
+func (c CreateStemcellMethod) importStemcell(imagePath string, stemcellProps stemcell.StemcellProps, stemcellId string) error {
+	var err error
+
+	switch {
+	case stemcellProps.SourceVM != "":
+		_, err = c.govcClient.CloneVM(stemcellProps.SourceVM, stemcellId, "")
+	case stemcellProps.SourcePath != "":
+		_, err = c.govcClient.CloneVMX(stemcellProps.SourcePath, stemcellId)
+	default:
+		_, err = c.govcClient.ImportStemcell(imagePath, stemcellId)
+	}
+
+	return err
+}
+
+// findTemplate returns the stemcell template with the fingerprint, if there is one
+func (c CreateStemcellMethod) findTemplate(fingerprint string) (string, error) {
+	vmNames, err := c.govcClient.ListVMs()
+	if err != nil {
+		return "", err
+	}
+
+	for _, vmName := range vmNames {
+		if !strings.HasPrefix(vmName, stemcellPrefix) {
+			continue
+		}
+
+		// deleted by delete_stemcell since it was listed
+		extraConfig, err := c.govcClient.GetVMExtraConfig(vmName)
+		if govc.IsVMNotFound(err) {
+			continue
+		}
+		if err != nil {
+			return "", err
+		}
+
+		if extraConfig[stemcellFingerprintKey] == fingerprint {
+			return vmName, nil
+		}
+	}
+
+	return "", nil
+}
+
+// reuseTemplate adds a CID to the references of the template, and reports false when delete_stemcell
+// destroyed the template after it was found
+func (c CreateStemcellMethod) reuseTemplate(templateId string, stemcellUuid string) (apiv1.StemcellCID, bool, error) {
+	lock, err := lockTemplate(c.govcClient.Host(), templateId)
+	if err != nil {
+		return apiv1.StemcellCID{}, false, err
+	}
+	defer lock.Unlock()
+
+	// read again under the lock, other create_stemcell and delete_stemcell calls may have changed the references
+	extraConfig, err := c.govcClient.GetVMExtraConfig(templateId)
+	if govc.IsVMNotFound(err) {
+		return apiv1.StemcellCID{}, false, nil
+	}
+	if err != nil {
+		return apiv1.StemcellCID{}, false, hostError(err)
+	}
+
+	cid := strings.TrimPrefix(templateId, stemcellPrefix) + stemcellCIDSeparator + stemcellUuid
+
+	cids := append(splitList(extraConfig[stemcellCIDsKey]), cid)
+	err = c.govcClient.SetVMExtraConfig(templateId, map[string]string{
+		stemcellCIDsKey: strings.Join(cids, ","),
+	})
+	if err != nil {
+		return apiv1.StemcellCID{}, false, hostError(err)
+	}
+
+	c.logger.Info("create-stemcell", "Reusing stemcell template '%s' for stemcell '%s'", templateId, cid)
+
+	return apiv1.NewStemcellCID(cid), true, nil
+}
+
+// stemcellTemplateId returns the template a stemcell CID points at
+func stemcellTemplateId(stemcellCID apiv1.StemcellCID) string {
+	templateUuid := strings.SplitN(stemcellCID.AsString(), stemcellCIDSeparator, 2)[0]
+	return stemcellPrefix + templateUuid
+}
+
+// lockTemplate blocks until no other process, or goroutine, holds the lock on the references of the stemcell template.
+// The director runs create_stemcell and delete_stemcell calls concurrently, each reading the references and writing them back.
+// Templates of different hosts, which one director may drive as separate CPIs, are locked apart.
+func lockTemplate(host string, templateId string) (filelock.Lock, error) {
+	return filelock.Acquire("stemcell", host, templateId)
+}
@@ -1,38 +1,303 @@
 package action_test
 
 import (
+	"archive/tar"
+	"compress/gzip"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/cppforlife/bosh-cpi-go/apiv1"
 	. "github.com/onsi/ginkgo"
 	. "github.com/onsi/gomega"
 
 	fakegovc "bosh-esxi-cpi/govc/fakes"
-	fakestemcell "bosh-esxi-cpi/stemcell/fakes"
 
 	fakelogger "github.com/cloudfoundry/bosh-utils/logger/loggerfakes"
 	fakeuuid "github.com/cloudfoundry/bosh-utils/uuid/fakes"
 
 	"bosh-esxi-cpi/action"
+	"bosh-esxi-cpi/stemcell"
 )
 
 var _ = Describe("CreateStemcell", func() {
+	var (
+		govcClient *fakegovc.FakeGovcClient
+		logger     *fakelogger.FakeLogger
+		uuidGen    *fakeuuid.FakeGenerator
+		cloudProps apiv1.CloudPropsImpl
+		tempDir    string
+		imagePath  string
+	)
+
+	BeforeEach(func() {
+		govcClient = &fakegovc.FakeGovcClient{}
+		logger = &fakelogger.FakeLogger{}
+		uuidGen = &fakeuuid.FakeGenerator{}
+
+		json.Unmarshal([]byte(`{"infrastructure": "vsphere", "hypervisor": "esxi", "architecture": "x86_64"}`), &cloudProps)
+
+		var err error
+		tempDir, err = ioutil.TempDir("", "create-stemcell-")
+		Expect(err).ToNot(HaveOccurred())
+		imagePath = writeImage(tempDir, map[string]string{"image.ovf": "<Envelope/>", "image.mf": "SHA1(image.ovf)= 0123"})
+	})
+
+	AfterEach(func() {
+		os.RemoveAll(tempDir)
+	})
+
+	fingerprintOf := func(imagePath string) string {
+		fingerprint, err := stemcell.Fingerprint(stemcell.NewArchive(imagePath), stemcell.StemcellProps{})
+		Expect(err).ToNot(HaveOccurred())
+		return fingerprint
+	}
+
 	It("runs the cpi", func() {
-		stemcellClient := &fakestemcell.FakeStemcellClient{}
-		govcClient := &fakegovc.FakeGovcClient{}
-		logger := &fakelogger.FakeLogger{}
-		uuidGen := &fakeuuid.FakeGenerator{}
+		m := action.NewCreateStemcellMethod(govcClient, uuidGen, logger)
+		var cid, err = m.CreateStemcell(imagePath, cloudProps)
+		Expect(err).ToNot(HaveOccurred())
+
+		Expect(cid.AsString()).To(Equal("fake-uuid-0"))
+
+		govcClientImportImagePath, govcClientImportVmId := govcClient.ImportStemcellArgsForCall(0)
+		Expect(govcClientImportImagePath).To(Equal(imagePath))
+		Expect(govcClientImportVmId).To(Equal("cs-fake-uuid-0"))
+
+		vmName, extraConfig := govcClient.SetVMExtraConfigArgsForCall(0)
+		Expect(vmName).To(Equal("cs-fake-uuid-0"))
+		Expect(extraConfig).To(Equal(map[string]string{
+			"bosh.stemcell_fingerprint": fingerprintOf(imagePath),
+			"bosh.stemcell_cids":        "fake-uuid-0",
+		}))
+	})
+
+	It("records the stemcell name, which VM pools are sized by", func() {
+		json.Unmarshal([]byte(`{"name": "bosh-vsphere-esxi-ubuntu-xenial-go_agent", "version": "97.12", "infrastructure": "vsphere", "hypervisor": "esxi", "architecture": "x86_64"}`), &cloudProps)
+
+		m := action.NewCreateStemcellMethod(govcClient, uuidGen, logger)
+		_, err := m.CreateStemcell(imagePath, cloudProps)
+		Expect(err).ToNot(HaveOccurred())
+
+		_, extraConfig := govcClient.SetVMExtraConfigArgsForCall(0)
+		Expect(extraConfig).To(HaveKeyWithValue("bosh.stemcell_name", "bosh-vsphere-esxi-ubuntu-xenial-go_agent"))
+	})
+
+	It("reuses a template with the same fingerprint", func() {
+		govcClient.ListVMsReturns([]string{"vm-uuid", "cs-other", "cs-template"}, nil)
+		govcClient.GetVMExtraConfigStub = func(vmName string) (map[string]string, error) {
+			if vmName == "cs-template" {
+				return map[string]string{
+					"bosh.stemcell_fingerprint": fingerprintOf(imagePath),
+					"bosh.stemcell_cids":        "template",
+				}, nil
+			}
+			return map[string]string{"bosh.stemcell_fingerprint": "sha256:other"}, nil
+		}
+
+		m := action.NewCreateStemcellMethod(govcClient, uuidGen, logger)
+		cid, err := m.CreateStemcell(imagePath, cloudProps)
+		Expect(err).ToNot(HaveOccurred())
+
+		Expect(cid.AsString()).To(Equal("template:fake-uuid-0"))
+		Expect(govcClient.ImportStemcellCallCount()).To(Equal(0))
+		Expect(govcClient.GetVMExtraConfigCallCount()).To(Equal(3))
+		Expect(govcClient.GetVMExtraConfigArgsForCall(2)).To(Equal("cs-template"))
+
+		vmName, extraConfig := govcClient.SetVMExtraConfigArgsForCall(0)
+		Expect(vmName).To(Equal("cs-template"))
+		Expect(extraConfig).To(Equal(map[string]string{"bosh.stemcell_cids": "template,template:fake-uuid-0"}))
+	})
+
+	It("records every stemcell reusing a template concurrently", func() {
+		var mutex sync.Mutex
+		templateConfig := map[string]string{
+			"bosh.stemcell_fingerprint": fingerprintOf(imagePath),
+			"bosh.stemcell_cids":        "template",
+		}
+
+		govcClient.ListVMsReturns([]string{"cs-template"}, nil)
+		govcClient.GetVMExtraConfigStub = func(vmName string) (map[string]string, error) {
+			mutex.Lock()
+			defer mutex.Unlock()
+
+			extraConfig := map[string]string{}
+			for key, value := range templateConfig {
+				extraConfig[key] = value
+			}
+			return extraConfig, nil
+		}
+		govcClient.SetVMExtraConfigStub = func(vmName string, extraConfig map[string]string) error {
+			// widens the window between reading the references and writing them back
+			time.Sleep(10 * time.Millisecond)
+
+			mutex.Lock()
+			defer mutex.Unlock()
+
+			for key, value := range extraConfig {
+				templateConfig[key] = value
+			}
+			return nil
+		}
+
+		var wg sync.WaitGroup
+		for i := 0; i < 5; i++ {
+			wg.Add(1)
+			go func(i int) {
+				defer GinkgoRecover()
+				defer wg.Done()
+
+				m := action.NewCreateStemcellMethod(govcClient, &fakeuuid.FakeGenerator{GeneratedUUID: fmt.Sprintf("uuid-%d", i)}, logger)
+				_, err := m.CreateStemcell(imagePath, cloudProps)
+				Expect(err).ToNot(HaveOccurred())
+			}(i)
+		}
+		wg.Wait()
+
+		Expect(strings.Split(templateConfig["bosh.stemcell_cids"], ",")).To(ConsistOf(
+			"template", "template:uuid-0", "template:uuid-1", "template:uuid-2", "template:uuid-3", "template:uuid-4",
+		))
+	})
 
-		stemcellClient.ExtractOvfReturns("extracted-path", nil)
+	It("imports the stemcell when the template it found was deleted meanwhile", func() {
+		govcClient.ListVMsReturns([]string{"cs-template"}, nil)
+		govcClient.GetVMExtraConfigReturnsOnCall(0, map[string]string{"bosh.stemcell_fingerprint": fingerprintOf(imagePath)}, nil)
+		govcClient.GetVMExtraConfigReturnsOnCall(1, nil, vmNotFoundError("cs-template"))
 
-		m := action.NewCreateStemcellMethod(govcClient, stemcellClient, uuidGen, logger)
-		var cid, err = m.CreateStemcell("image-path", nil)
+		m := action.NewCreateStemcellMethod(govcClient, uuidGen, logger)
+		cid, err := m.CreateStemcell(imagePath, cloudProps)
 		Expect(err).ToNot(HaveOccurred())
 
 		Expect(cid.AsString()).To(Equal("fake-uuid-0"))
+		Expect(govcClient.ImportStemcellCallCount()).To(Equal(1))
 
-		govcClientImportOvfPath, govcClientImportOvfVmId := govcClient.ImportOvfArgsForCall(0)
-		Expect(govcClientImportOvfPath).To(Equal("extracted-path"))
-		Expect(govcClientImportOvfVmId).To(Equal("cs-fake-uuid-0"))
+		vmName, _ := govcClient.SetVMExtraConfigArgsForCall(0)
+		Expect(vmName).To(Equal("cs-fake-uuid-0"))
+	})
+
+	It("skips a template deleted after it was listed", func() {
+		govcClient.ListVMsReturns([]string{"cs-deleted", "cs-template"}, nil)
+		govcClient.GetVMExtraConfigReturnsOnCall(0, nil, vmNotFoundError("cs-deleted"))
+		govcClient.GetVMExtraConfigReturnsOnCall(1, map[string]string{"bosh.stemcell_fingerprint": fingerprintOf(imagePath)}, nil)
+		govcClient.GetVMExtraConfigReturnsOnCall(2, map[string]string{"bosh.stemcell_fingerprint": fingerprintOf(imagePath)}, nil)
+
+		m := action.NewCreateStemcellMethod(govcClient, uuidGen, logger)
+		cid, err := m.CreateStemcell(imagePath, cloudProps)
+		Expect(err).ToNot(HaveOccurred())
+
+		Expect(cid.AsString()).To(Equal("template:fake-uuid-0"))
+		Expect(govcClient.ImportStemcellCallCount()).To(Equal(0))
+	})
+
+	It("imports the stemcell when no template matches", func() {
+		govcClient.ListVMsReturns([]string{"cs-other"}, nil)
+		govcClient.GetVMExtraConfigReturns(map[string]string{"bosh.stemcell_fingerprint": "sha256:other"}, nil)
 
-		Expect(stemcellClient.ExtractOvfArgsForCall(0)).To(Equal("image-path"))
-		Expect(stemcellClient.CleanupCallCount()).To(Equal(1))
+		m := action.NewCreateStemcellMethod(govcClient, uuidGen, logger)
+		cid, err := m.CreateStemcell(imagePath, cloudProps)
+		Expect(err).ToNot(HaveOccurred())
+
+		Expect(cid.AsString()).To(Equal("fake-uuid-0"))
+		Expect(govcClient.ImportStemcellCallCount()).To(Equal(1))
+	})
+
+	It("fails when the templates cannot be listed", func() {
+		govcClient.ListVMsReturns(nil, errors.New("ls failed"))
+
+		m := action.NewCreateStemcellMethod(govcClient, uuidGen, logger)
+		_, err := m.CreateStemcell(imagePath, cloudProps)
+		Expect(err).To(MatchError("ls failed"))
+		Expect(govcClient.ImportStemcellCallCount()).To(Equal(0))
+	})
+
+	It("removes the partially imported stemcell when importing fails", func() {
+		govcClient.ImportStemcellReturns("", errors.New("import failed"))
+
+		m := action.NewCreateStemcellMethod(govcClient, uuidGen, logger)
+		_, err := m.CreateStemcell(imagePath, cloudProps)
+		Expect(err).To(MatchError("import failed"))
+
+		Expect(govcClient.DestroyVMCallCount()).To(Equal(1))
+		Expect(govcClient.DestroyVMArgsForCall(0)).To(Equal("cs-fake-uuid-0"))
+	})
+
+	Context("for light stemcells", func() {
+		BeforeEach(func() {
+			imagePath = writeImage(tempDir, map[string]string{})
+		})
+
+		It("clones the source VM without importing the image", func() {
+			json.Unmarshal([]byte(`{"name": "bosh-vsphere-esxi-ubuntu-xenial-go_agent", "version": "97.12", "infrastructure": "vsphere", "hypervisor": "esxi", "source_vm": "xenial-97.12"}`), &cloudProps)
+
+			m := action.NewCreateStemcellMethod(govcClient, uuidGen, logger)
+			cid, err := m.CreateStemcell(imagePath, cloudProps)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(cid.AsString()).To(Equal("fake-uuid-0"))
+
+			sourceVmName, cloneVmName, _ := govcClient.CloneVMArgsForCall(0)
+			Expect(sourceVmName).To(Equal("xenial-97.12"))
+			Expect(cloneVmName).To(Equal("cs-fake-uuid-0"))
+			Expect(govcClient.ImportStemcellCallCount()).To(Equal(0))
+		})
+
+		It("clones the VM at the source path", func() {
+			json.Unmarshal([]byte(`{"infrastructure": "vsphere", "hypervisor": "esxi", "source_path": "templates/xenial/xenial.vmx"}`), &cloudProps)
+
+			m := action.NewCreateStemcellMethod(govcClient, uuidGen, logger)
+			_, err := m.CreateStemcell(imagePath, cloudProps)
+			Expect(err).ToNot(HaveOccurred())
+
+			vmxPath, cloneVmName := govcClient.CloneVMXArgsForCall(0)
+			Expect(vmxPath).To(Equal("templates/xenial/xenial.vmx"))
+			Expect(cloneVmName).To(Equal("cs-fake-uuid-0"))
+			Expect(govcClient.ImportStemcellCallCount()).To(Equal(0))
+		})
+
+		It("removes the partial clone when cloning fails", func() {
+			json.Unmarshal([]byte(`{"infrastructure": "vsphere", "hypervisor": "esxi", "source_vm": "xenial"}`), &cloudProps)
+			govcClient.CloneVMReturns("", errors.New("clone failed"))
+
+			m := action.NewCreateStemcellMethod(govcClient, uuidGen, logger)
+			_, err := m.CreateStemcell(imagePath, cloudProps)
+			Expect(err).To(MatchError("clone failed"))
+			Expect(govcClient.DestroyVMArgsForCall(0)).To(Equal("cs-fake-uuid-0"))
+		})
+	})
+
+	It("rejects stemcells for other infrastructures without importing them", func() {
+		json.Unmarshal([]byte(`{"name": "bosh-aws-xen-hvm-ubuntu-trusty-go_agent", "version": "3541.5", "infrastructure": "aws", "hypervisor": "xen"}`), &cloudProps)
+
+		m := action.NewCreateStemcellMethod(govcClient, uuidGen, logger)
+		_, err := m.CreateStemcell("image-path", cloudProps)
+		Expect(err).To(MatchError(ContainSubstring("infrastructure must be 'vsphere', got 'aws'")))
+
+		Expect(govcClient.ImportStemcellCallCount()).To(Equal(0))
 	})
 })
+
+func writeImage(dir string, files map[string]string) string {
+	imagePath := filepath.Join(dir, "image")
+	file, err := os.Create(imagePath)
+	Expect(err).ToNot(HaveOccurred())
+	defer file.Close()
+
+	gzipWriter := gzip.NewWriter(file)
+	defer gzipWriter.Close()
+
+	tarWriter := tar.NewWriter(gzipWriter)
+	defer tarWriter.Close()
+
+	for name, contents := range files {
+		Expect(tarWriter.WriteHeader(&tar.Header{Name: name, Mode: 0644, Size: int64(len(contents))})).To(Succeed())
+		_, err = tarWriter.Write([]byte(contents))
+		Expect(err).ToNot(HaveOccurred())
+	}
+
+	return imagePath
+}
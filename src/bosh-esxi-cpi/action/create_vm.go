@@ -1,31 +1,49 @@
 package action
 
 import (
+	"sort"
+	"strings"
+
 	boshlog "github.com/cloudfoundry/bosh-utils/logger"
 	boshuuid "github.com/cloudfoundry/bosh-utils/uuid"
 	"github.com/cppforlife/bosh-cpi-go/apiv1"
 
 	"bosh-esxi-cpi/govc"
+	"bosh-esxi-cpi/nsxt"
+	"bosh-esxi-cpi/pool"
 	"bosh-esxi-cpi/vm"
 )
 
+const (
+	createdPortGroupsKey = "bosh.created_port_groups"
+	nsxtVMCIDScope       = "bosh/vm_cid"
+)
+
 type CreateVMMethod struct {
-	govcClient      govc.GovcClient
-	agentSettings   vm.AgentSettings
-	agentOptions    apiv1.AgentOptions
-	agentEnvFactory apiv1.AgentEnvFactory
-	uuidGen         boshuuid.Generator
-	logger          boshlog.Logger
+	govcClient        govc.GovcClient
+	nsxtClient        nsxt.NsxtClient
+	agentSettings     vm.AgentSettings
+	agentOptions      apiv1.AgentOptions
+	agentEnvFactory   apiv1.AgentEnvFactory
+	vmPool            pool.Pool
+	cleanupPortGroups bool
+	uuidGen           boshuuid.Generator
+	logger            boshlog.Logger
 }
 
-func NewCreateVMMethod(govcClient govc.GovcClient, agentSettings vm.AgentSettings, agentOptions apiv1.AgentOptions, agentEnvFactory apiv1.AgentEnvFactory, uuidGen boshuuid.Generator, logger boshlog.Logger) CreateVMMethod {
+// NewCreateVMMethod returns a method which, when cleanupPortGroups is set, removes the port groups it created
+// for a VM it fails to create, as delete_vm does for the VMs it deletes
+func NewCreateVMMethod(govcClient govc.GovcClient, nsxtClient nsxt.NsxtClient, agentSettings vm.AgentSettings, agentOptions apiv1.AgentOptions, agentEnvFactory apiv1.AgentEnvFactory, vmPool pool.Pool, cleanupPortGroups bool, uuidGen boshuuid.Generator, logger boshlog.Logger) CreateVMMethod {
 	return CreateVMMethod{
-		govcClient:      govcClient,
-		agentSettings:   agentSettings,
-		agentOptions:    agentOptions,
-		agentEnvFactory: agentEnvFactory,
-		uuidGen:         uuidGen,
-		logger:          logger,
+		govcClient:        govcClient,
+		nsxtClient:        nsxtClient,
+		agentSettings:     agentSettings,
+		agentOptions:      agentOptions,
+		agentEnvFactory:   agentEnvFactory,
+		vmPool:            vmPool,
+		cleanupPortGroups: cleanupPortGroups,
+		uuidGen:           uuidGen,
+		logger:            logger,
 	}
 }
 
@@ -34,78 +52,228 @@ func (c CreateVMMethod) CreateVM(
 	cloudProps apiv1.VMCloudProps, networks apiv1.Networks,
 	associatedDiskCIDs []apiv1.DiskCID, vmEnv apiv1.VMEnv) (apiv1.VMCID, error) {
 
+	vmCID, _, err := c.createVM(agentID, stemcellCID, cloudProps, networks, vmEnv)
+	return vmCID, err
+}
+
+// CreateVMV2 creates a VM like CreateVM, and returns the networks with the MAC addresses of their NICs
+func (c CreateVMMethod) CreateVMV2(
+	agentID apiv1.AgentID, stemcellCID apiv1.StemcellCID,
+	cloudProps apiv1.VMCloudProps, networks apiv1.Networks,
+	associatedDiskCIDs []apiv1.DiskCID, vmEnv apiv1.VMEnv) (apiv1.VMCID, map[string]NetworkV2, error) {
+
+	vmCID, macAddresses, err := c.createVM(agentID, stemcellCID, cloudProps, networks, vmEnv)
+	if err != nil {
+		return vmCID, nil, err
+	}
+
+	return vmCID, newNetworksV2(networks, macAddresses), nil
+}
+
+// createVM returns the MAC addresses of the VM's NICs by network name
+func (c CreateVMMethod) createVM(
+	agentID apiv1.AgentID, stemcellCID apiv1.StemcellCID,
+	cloudProps apiv1.VMCloudProps, networks apiv1.Networks, vmEnv apiv1.VMEnv) (apiv1.VMCID, map[string]string, error) {
+
 	c.logger.DebugWithDetails("create-vm", "networks", networks)
 
 	vmUuid, _ := c.uuidGen.Generate()
 	newVMCID := apiv1.NewVMCID(vmUuid)
 
-	stemcellId := "cs-" + stemcellCID.AsString()
+	stemcellId := stemcellTemplateId(stemcellCID)
 	vmId := "vm-" + vmUuid
 
-	var vmProps vm.VMProps
-	err := cloudProps.As(&vmProps)
+	vmProps, err := vm.NewVMProps(cloudProps)
 	if err != nil {
-		return newVMCID, err
+		return newVMCID, nil, err
+	}
+
+	networkNames := make([]string, 0, len(networks))
+	for networkName := range networks {
+		networkNames = append(networkNames, networkName)
+	}
+	sort.Strings(networkNames)
+
+	networkProps := map[string]vm.NetworkProps{}
+	for _, networkName := range networkNames {
+		networkProps[networkName], err = vm.NewNetworkProps(networks[networkName].CloudProps(), vmProps.NetworkAdapterType)
+		if err != nil {
+			return newVMCID, nil, err
+		}
+	}
+
+	var createdPortGroups []string
+	for _, networkName := range networkNames {
+		props := networkProps[networkName]
+		if !props.ManagesPortGroup() {
+			continue
+		}
+
+		created, err := c.govcClient.EnsurePortGroup(props.Name, props.VSwitch, props.VLAN())
+		if err != nil {
+			c.removePortGroups(createdPortGroups)
+			return newVMCID, nil, hostError(err)
+		}
+
+		if created {
+			createdPortGroups = append(createdPortGroups, props.Name)
+		}
 	}
 
-	_, err = c.govcClient.CloneVM(stemcellId, vmId)
+	err = c.cloneVM(stemcellId, vmId, vmProps.Datastore)
 	if err != nil {
-		return newVMCID, err
+		c.rollback(vmId, createdPortGroups)
+		return newVMCID, nil, createVMError(err)
 	}
 
-	err = c.govcClient.SetVMResources(vmId, vmProps.CPU, vmProps.RAM)
+	macAddresses, err := c.buildVM(vmId, newVMCID, agentID, vmProps, networks, networkNames, networkProps, createdPortGroups, vmEnv)
 	if err != nil {
-		return newVMCID, err
+		c.rollback(vmId, createdPortGroups)
+		return newVMCID, nil, createVMError(err)
 	}
 
-	updatedNetworks := apiv1.Networks{}
-	for networkName, network := range networks {
-		var networkCloudProps struct {
-			Name string
-			Type string //remove?
-		}
-		network.CloudProps().As(&networkCloudProps)
-		adapterNetworkName := networkCloudProps.Name
+	return newVMCID, macAddresses, nil
+}
 
-		macAddress, err := c.agentSettings.GenerateMacAddress()
+// cloneVM claims a clone of the stemcell from its pool, which only holds clones on the default
+// datastore, or copies the stemcell when the pool is empty
+func (c CreateVMMethod) cloneVM(stemcellId string, vmId string, datastore string) error {
+	if datastore == "" {
+		claimed, err := c.vmPool.Claim(stemcellId, vmId)
 		if err != nil {
-			return newVMCID, err
+			c.logger.Warn("create-vm", "Cloning stemcell '%s' instead of claiming a VM from its pool: %s", stemcellId, err)
+		}
+		if claimed {
+			return nil
+		}
+	}
+
+	_, err := c.govcClient.CloneVM(stemcellId, vmId, datastore)
+	return err
+}
+
+// buildVM configures and starts a cloned VM, and returns the MAC addresses of its NICs by network name;
+// on error the caller rolls the VM back
+func (c CreateVMMethod) buildVM(
+	vmId string, vmCID apiv1.VMCID, agentID apiv1.AgentID,
+	vmProps vm.VMProps, networks apiv1.Networks, networkNames []string,
+	networkProps map[string]vm.NetworkProps, createdPortGroups []string, vmEnv apiv1.VMEnv) (map[string]string, error) {
+
+	spec := govc.VMSpec{Props: vmProps}
+
+	// remembered so delete_vm can optionally remove the port groups this VM caused to exist
+	if len(createdPortGroups) > 0 {
+		spec.ExtraConfig = map[string]string{
+			createdPortGroupsKey: strings.Join(createdPortGroups, ","),
 		}
+	}
 
-		err = c.govcClient.SetVMNetworkAdapter(vmId, adapterNetworkName, macAddress)
+	updatedNetworks := apiv1.Networks{}
+	macAddresses := map[string]string{}
+	for _, networkName := range networkNames {
+		network := networks[networkName]
+
+		macAddress, err := c.agentSettings.GenerateMacAddress()
 		if err != nil {
-			return newVMCID, err
+			return nil, err
 		}
 
+		spec.NICs = append(spec.NICs, govc.NIC{
+			Network:     networkProps[networkName].Name,
+			MACAddress:  macAddress,
+			AdapterType: networkProps[networkName].AdapterType,
+		})
+
 		network.SetMAC(macAddress)
+		macAddresses[networkName] = macAddress
 		updatedNetworks[networkName] = network
 	}
 
-	agentEnv := c.agentEnvFactory.ForVM(agentID, newVMCID, updatedNetworks, vmEnv, c.agentOptions)
+	agentEnv := c.agentEnvFactory.ForVM(agentID, vmCID, updatedNetworks, vmEnv, c.agentOptions)
 	agentEnv.AttachSystemDisk("0")
+	agentEnv.AttachEphemeralDisk("1")
 
-	err = c.govcClient.CreateEphemeralDisk(vmId, vmProps.Disk)
+	envIsoPath, err := c.agentSettings.GenerateAgentEnvIso(vmId, agentEnv)
 	if err != nil {
-		return newVMCID, err
+		return nil, err
 	}
+	spec.EnvIsoPath = envIsoPath
 
-	agentEnv.AttachEphemeralDisk("1")
+	err = c.govcClient.ConfigureVM(vmId, spec)
+	if err != nil {
+		return nil, err
+	}
+	c.agentSettings.Cleanup()
+
+	_, err = c.govcClient.StartVM(vmId)
+	if err != nil {
+		return nil, err
+	}
+
+	// NICs only get their NSX-T attachment once the VM is powered on
+	if c.nsxtClient != nil {
+		err = c.updateLogicalPorts(vmId)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return macAddresses, nil
+}
+
+// rollback removes a partially created VM, and the port groups created for it, so they do not linger
+// unknown to the director. Failures are only logged, the error which caused the rollback is the one returned.
+func (c CreateVMMethod) rollback(vmId string, createdPortGroups []string) {
+	c.logger.Info("create-vm", "Rolling back VM '%s'", vmId)
 
-	envIsoPath, err := c.agentSettings.GenerateAgentEnvIso(agentEnv)
+	_, err := c.govcClient.DestroyVM(vmId)
 	if err != nil {
-		return newVMCID, err
+		c.logger.Error("create-vm", "Rolling back VM '%s': destroying VM: %s", vmId, err)
 	}
 
-	_, err = c.govcClient.UpdateVMIso(vmId, envIsoPath)
+	err = c.govcClient.DestroyVMIso(vmId)
 	if err != nil {
-		return newVMCID, err
+		c.logger.Error("create-vm", "Rolling back VM '%s': deleting env ISO: %s", vmId, err)
 	}
+
 	c.agentSettings.Cleanup()
 
-	_, err = c.govcClient.StartVM(vmId)
+	err = c.agentSettings.DeleteAgentEnv(vmId)
+	if err != nil {
+		c.logger.Error("create-vm", "Rolling back VM '%s': deleting agent env: %s", vmId, err)
+	}
+
+	c.removePortGroups(createdPortGroups)
+}
+
+// removePortGroups removes the port groups created by a failed create_vm when cleanup_port_groups is set;
+// like delete_vm it keeps the ones which cannot be removed, such as those another VM started using meanwhile
+func (c CreateVMMethod) removePortGroups(portGroups []string) {
+	if !c.cleanupPortGroups {
+		return
+	}
+
+	for _, portGroup := range portGroups {
+		err := c.govcClient.RemovePortGroup(portGroup)
+		if err != nil {
+			c.logger.Warn("create-vm", "Keeping port group '%s': %s", portGroup, err)
+		}
+	}
+}
+
+func (c CreateVMMethod) updateLogicalPorts(vmId string) error {
+	attachmentIDs, err := c.govcClient.GetVMNetworkAttachmentIDs(vmId)
 	if err != nil {
-		return newVMCID, err
+		return err
+	}
+
+	tags := []nsxt.Tag{{Scope: nsxtVMCIDScope, Tag: vmId}}
+	for _, attachmentID := range attachmentIDs {
+		err = c.nsxtClient.UpdateLogicalPort(attachmentID, tags)
+		if err != nil {
+			return err
+		}
 	}
 
-	return newVMCID, nil
+	return nil
 }
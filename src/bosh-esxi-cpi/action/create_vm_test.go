@@ -2,18 +2,25 @@ package action_test
 
 import (
 	"encoding/json"
+	"errors"
 
 	"github.com/cppforlife/bosh-cpi-go/apiv1"
 	. "github.com/onsi/ginkgo"
 	. "github.com/onsi/gomega"
 
 	fakegovc "bosh-esxi-cpi/govc/fakes"
+	fakensxt "bosh-esxi-cpi/nsxt/fakes"
 	fakevm "bosh-esxi-cpi/vm/fakes"
 
 	fakelogger "github.com/cloudfoundry/bosh-utils/logger/loggerfakes"
 	fakeuuid "github.com/cloudfoundry/bosh-utils/uuid/fakes"
 
 	"bosh-esxi-cpi/action"
+	"bosh-esxi-cpi/config"
+	"bosh-esxi-cpi/govc"
+	"bosh-esxi-cpi/nsxt"
+	"bosh-esxi-cpi/pool"
+	"bosh-esxi-cpi/vm"
 )
 
 var _ = Describe("CreateVM", func() {
@@ -41,62 +48,476 @@ var _ = Describe("CreateVM", func() {
 		  "second":{
 		    "cloud_properties":{
 		      "name":"BOSH Network",
-					"type":"manual"
+					"type":"manual",
+					"adapter_type":"e1000e"
 		    }
 		  }
 		}`))
 
 		var resourceCloudProps apiv1.CloudPropsImpl
 		json.Unmarshal([]byte(`{
-			"cpu":  1,
+			"cpu":  2,
 			"ram":  1024,
-			"disk": 2048
+			"disk": 2048,
+			"cores_per_socket": 2,
+			"memory_reservation": 512,
+			"cpu_hot_add": true,
+			"firmware": "efi",
+			"vmx_options": {"disk.enableUUID": "TRUE"},
+			"network_adapter_type": "pcnet32",
+			"datastore": "datastore2"
 		}`), &resourceCloudProps)
 
 		govcClient.CloneVMReturns("", nil)
-		govcClient.SetVMResourcesReturns(nil)
-		govcClient.SetVMNetworkAdapterReturns(nil)
-		govcClient.CreateEphemeralDiskReturns(nil)
-		govcClient.UpdateVMIsoReturns("", nil)
+		govcClient.ConfigureVMReturns(nil)
 		govcClient.StartVMReturns("", nil)
 		agentSettings.GenerateAgentEnvIsoReturns("iso-path", nil)
 		agentSettings.GenerateMacAddressReturnsOnCall(0, "00:11:22:33:44:55", nil)
 		agentSettings.GenerateMacAddressReturnsOnCall(1, "55:44:33:22:11:00", nil)
 
-		m := action.NewCreateVMMethod(govcClient, agentSettings, agentOptions, agentEnvFactory, uuidGen, logger)
+		m := action.NewCreateVMMethod(govcClient, nil, agentSettings, agentOptions, agentEnvFactory, pool.Pool{}, false, uuidGen, logger)
 		cid, err := m.CreateVM(agentId, stemcellCid, resourceCloudProps, networks, disks, vmEnv)
 
 		Expect(err).ToNot(HaveOccurred())
 		Expect(cid.AsString()).To(Equal("fake-uuid-0"))
 
-		cloneVmStemcellId, cloneVmVmId := govcClient.CloneVMArgsForCall(0)
+		cloneVmStemcellId, cloneVmVmId, cloneVmDatastore := govcClient.CloneVMArgsForCall(0)
 		Expect(cloneVmStemcellId).To(Equal("cs-stemcell"))
 		Expect(cloneVmVmId).To(Equal("vm-fake-uuid-0"))
+		Expect(cloneVmDatastore).To(Equal("datastore2"))
 
-		setResourcesVmId, setResourcesCpu, setResourcesRam := govcClient.SetVMResourcesArgsForCall(0)
-		Expect(setResourcesVmId).To(Equal("vm-fake-uuid-0"))
-		Expect(setResourcesCpu).To(Equal(1))
-		Expect(setResourcesRam).To(Equal(1024))
+		Expect(govcClient.ConfigureVMCallCount()).To(Equal(1))
+		configureVmId, spec := govcClient.ConfigureVMArgsForCall(0)
+		Expect(configureVmId).To(Equal("vm-fake-uuid-0"))
+		Expect(spec).To(Equal(govc.VMSpec{
+			Props: vm.VMProps{
+				CPU:                          2,
+				RAM:                          1024,
+				Disk:                         2048,
+				CoresPerSocket:               2,
+				MemoryReservation:            512,
+				CPUHotAdd:                    true,
+				NestedHardwareVirtualization: true,
+				SyncTimeWithHost:             true,
+				Firmware:                     "efi",
+				VMXOptions:                   map[string]interface{}{"disk.enableUUID": "TRUE"},
+				NetworkAdapterType:           "pcnet32",
+				Datastore:                    "datastore2",
+			},
+			NICs: []govc.NIC{
+				{Network: "VM Network", MACAddress: "00:11:22:33:44:55", AdapterType: "pcnet32"},
+				{Network: "BOSH Network", MACAddress: "55:44:33:22:11:00", AdapterType: "e1000e"},
+			},
+			EnvIsoPath: "iso-path",
+		}))
 
-		setAdapterVmId1, setAdapterNetName1, setAdapterMac1 := govcClient.SetVMNetworkAdapterArgsForCall(0)
-		Expect(setAdapterVmId1).To(Equal("vm-fake-uuid-0"))
-		Expect(setAdapterNetName1).To(Equal("VM Network"))
-		Expect(setAdapterMac1).To(Equal("00:11:22:33:44:55"))
+		startVmVmId := govcClient.StartVMArgsForCall(0)
+		Expect(startVmVmId).To(Equal("vm-fake-uuid-0"))
+	})
 
-		setAdapterVmId2, setAdapterNetName2, setAdapterMac2 := govcClient.SetVMNetworkAdapterArgsForCall(1)
-		Expect(setAdapterVmId2).To(Equal("vm-fake-uuid-0"))
-		Expect(setAdapterNetName2).To(Equal("BOSH Network"))
-		Expect(setAdapterMac2).To(Equal("55:44:33:22:11:00"))
+	It("rejects invalid hardware cloud properties before cloning", func() {
+		govcClient := &fakegovc.FakeGovcClient{}
+		agentSettings := &fakevm.FakeAgentSettings{}
+		uuidGen := &fakeuuid.FakeGenerator{}
+		logger := &fakelogger.FakeLogger{}
 
-		ephemeralDiskVmId, ephemeralDiskSize := govcClient.CreateEphemeralDiskArgsForCall(0)
-		Expect(ephemeralDiskVmId).To(Equal("vm-fake-uuid-0"))
-		Expect(ephemeralDiskSize).To(Equal(2048))
+		var resourceCloudProps apiv1.CloudPropsImpl
+		json.Unmarshal([]byte(`{"cpu": 2, "firmware": "uefi"}`), &resourceCloudProps)
 
-		updateIsoVmId, updateIsoPath := govcClient.UpdateVMIsoArgsForCall(0)
-		Expect(updateIsoVmId).To(Equal("vm-fake-uuid-0"))
-		Expect(updateIsoPath).To(Equal("iso-path"))
+		m := action.NewCreateVMMethod(govcClient, nil, agentSettings, apiv1.AgentOptions{}, apiv1.NewAgentEnvFactory(), pool.Pool{}, false, uuidGen, logger)
+		_, err := m.CreateVM(apiv1.AgentID{}, apiv1.NewStemcellCID("stemcell"), resourceCloudProps, apiv1.Networks{}, []apiv1.DiskCID{}, apiv1.VMEnv{})
 
-		startVmVmId := govcClient.StartVMArgsForCall(0)
-		Expect(startVmVmId).To(Equal("vm-fake-uuid-0"))
+		Expect(err).To(MatchError(ContainSubstring("firmware must be 'bios' or 'efi'")))
+		Expect(govcClient.CloneVMCallCount()).To(Equal(0))
+	})
+
+	Describe("network adapter types", func() {
+		var (
+			govcClient    *fakegovc.FakeGovcClient
+			agentSettings *fakevm.FakeAgentSettings
+			m             action.CreateVMMethod
+		)
+
+		BeforeEach(func() {
+			govcClient = &fakegovc.FakeGovcClient{}
+			agentSettings = &fakevm.FakeAgentSettings{}
+			uuidGen := &fakeuuid.FakeGenerator{}
+			logger := &fakelogger.FakeLogger{}
+
+			m = action.NewCreateVMMethod(govcClient, nil, agentSettings, apiv1.AgentOptions{}, apiv1.NewAgentEnvFactory(), pool.Pool{}, false, uuidGen, logger)
+		})
+
+		createVM := func(vmCloudProps string, adapterType string) error {
+			var resourceCloudProps apiv1.CloudPropsImpl
+			json.Unmarshal([]byte(vmCloudProps), &resourceCloudProps)
+
+			networkCloudProps := map[string]string{"name": "VM Network"}
+			if adapterType != "" {
+				networkCloudProps["adapter_type"] = adapterType
+			}
+			networksJSON, _ := json.Marshal(map[string]interface{}{
+				"default": map[string]interface{}{"cloud_properties": networkCloudProps},
+			})
+
+			networks := apiv1.Networks{}
+			networks.UnmarshalJSON(networksJSON)
+
+			_, err := m.CreateVM(apiv1.AgentID{}, apiv1.NewStemcellCID("stemcell"), resourceCloudProps, networks, []apiv1.DiskCID{}, apiv1.VMEnv{})
+			return err
+		}
+
+		It("defaults to vmxnet3", func() {
+			Expect(createVM(`{}`, "")).To(Succeed())
+
+			_, spec := govcClient.ConfigureVMArgsForCall(0)
+			Expect(spec.NICs[0].AdapterType).To(Equal("vmxnet3"))
+		})
+
+		It("uses the VM adapter type when the network does not set one", func() {
+			Expect(createVM(`{"network_adapter_type": "e1000"}`, "")).To(Succeed())
+
+			_, spec := govcClient.ConfigureVMArgsForCall(0)
+			Expect(spec.NICs[0].AdapterType).To(Equal("e1000"))
+		})
+
+		for _, adapterType := range []string{"e1000", "e1000e", "vmxnet3", "pcnet32"} {
+			adapterType := adapterType
+
+			It("maps the network adapter type "+adapterType, func() {
+				Expect(createVM(`{"network_adapter_type": "e1000"}`, adapterType)).To(Succeed())
+
+				_, spec := govcClient.ConfigureVMArgsForCall(0)
+				Expect(spec.NICs[0].AdapterType).To(Equal(adapterType))
+			})
+		}
+
+		It("ensures port groups for networks with vswitch or vlan_id and records the created ones", func() {
+			networks := apiv1.Networks{}
+			networks.UnmarshalJSON([]byte(`{
+				"a":{"cloud_properties":{"name":"VLAN 10","vswitch":"vSwitch1","vlan_id":10}},
+				"b":{"cloud_properties":{"name":"VLAN 20","vlan_id":20}},
+				"c":{"cloud_properties":{"name":"VM Network"}}
+			}`))
+			govcClient.EnsurePortGroupReturnsOnCall(0, true, nil)
+			govcClient.EnsurePortGroupReturnsOnCall(1, false, nil)
+
+			var resourceCloudProps apiv1.CloudPropsImpl
+			json.Unmarshal([]byte(`{}`), &resourceCloudProps)
+
+			_, err := m.CreateVM(apiv1.AgentID{}, apiv1.NewStemcellCID("stemcell"), resourceCloudProps, networks, []apiv1.DiskCID{}, apiv1.VMEnv{})
+			Expect(err).ToNot(HaveOccurred())
+
+			Expect(govcClient.EnsurePortGroupCallCount()).To(Equal(2))
+			name, vswitch, vlan := govcClient.EnsurePortGroupArgsForCall(0)
+			Expect([]interface{}{name, vswitch, vlan}).To(Equal([]interface{}{"VLAN 10", "vSwitch1", 10}))
+			name, vswitch, vlan = govcClient.EnsurePortGroupArgsForCall(1)
+			Expect([]interface{}{name, vswitch, vlan}).To(Equal([]interface{}{"VLAN 20", "vSwitch0", 20}))
+
+			_, spec := govcClient.ConfigureVMArgsForCall(0)
+			Expect(spec.ExtraConfig).To(Equal(map[string]string{"bosh.created_port_groups": "VLAN 10"}))
+		})
+
+		It("fails before cloning when a port group cannot be ensured", func() {
+			networks := apiv1.Networks{}
+			networks.UnmarshalJSON([]byte(`{"a":{"cloud_properties":{"name":"VLAN 10","vlan_id":10}}}`))
+			govcClient.EnsurePortGroupReturns(false, errors.New("vswitch 'vSwitch0' not found on host"))
+
+			var resourceCloudProps apiv1.CloudPropsImpl
+			json.Unmarshal([]byte(`{}`), &resourceCloudProps)
+
+			_, err := m.CreateVM(apiv1.AgentID{}, apiv1.NewStemcellCID("stemcell"), resourceCloudProps, networks, []apiv1.DiskCID{}, apiv1.VMEnv{})
+			Expect(err).To(MatchError("vswitch 'vSwitch0' not found on host"))
+			Expect(govcClient.CloneVMCallCount()).To(Equal(0))
+		})
+
+		It("rejects unknown network adapter types before cloning", func() {
+			err := createVM(`{}`, "vmxnet2")
+			Expect(err).To(MatchError(ContainSubstring("Validating network 'VM Network'")))
+			Expect(err).To(MatchError(ContainSubstring("got 'vmxnet2'")))
+			Expect(govcClient.CloneVMCallCount()).To(Equal(0))
+		})
+	})
+
+	Describe("NSX-T", func() {
+		var (
+			govcClient *fakegovc.FakeGovcClient
+			nsxtClient *fakensxt.FakeNsxtClient
+			m          action.CreateVMMethod
+		)
+
+		BeforeEach(func() {
+			govcClient = &fakegovc.FakeGovcClient{}
+			nsxtClient = &fakensxt.FakeNsxtClient{}
+			agentSettings := &fakevm.FakeAgentSettings{}
+			uuidGen := &fakeuuid.FakeGenerator{}
+			logger := &fakelogger.FakeLogger{}
+
+			m = action.NewCreateVMMethod(govcClient, nsxtClient, agentSettings, apiv1.AgentOptions{}, apiv1.NewAgentEnvFactory(), pool.Pool{}, false, uuidGen, logger)
+		})
+
+		createVM := func() error {
+			var resourceCloudProps apiv1.CloudPropsImpl
+			json.Unmarshal([]byte(`{}`), &resourceCloudProps)
+
+			networks := apiv1.Networks{}
+			networks.UnmarshalJSON([]byte(`{"default":{"cloud_properties":{"name":"nsxt-switch"}}}`))
+
+			_, err := m.CreateVM(apiv1.AgentID{}, apiv1.NewStemcellCID("stemcell"), resourceCloudProps, networks, []apiv1.DiskCID{}, apiv1.VMEnv{})
+			return err
+		}
+
+		It("tags the logical ports of the started VM", func() {
+			govcClient.GetVMNetworkAttachmentIDsReturns([]string{"attachment-1", "attachment-2"}, nil)
+
+			Expect(createVM()).To(Succeed())
+
+			Expect(govcClient.StartVMCallCount()).To(Equal(1))
+			Expect(govcClient.GetVMNetworkAttachmentIDsArgsForCall(0)).To(Equal("vm-fake-uuid-0"))
+			Expect(nsxtClient.UpdateLogicalPortCallCount()).To(Equal(2))
+
+			attachmentID, tags := nsxtClient.UpdateLogicalPortArgsForCall(0)
+			Expect(attachmentID).To(Equal("attachment-1"))
+			Expect(tags).To(Equal([]nsxt.Tag{{Scope: "bosh/vm_cid", Tag: "vm-fake-uuid-0"}}))
+
+			attachmentID, _ = nsxtClient.UpdateLogicalPortArgsForCall(1)
+			Expect(attachmentID).To(Equal("attachment-2"))
+		})
+
+		It("returns logical port errors", func() {
+			govcClient.GetVMNetworkAttachmentIDsReturns([]string{"attachment-1"}, nil)
+			nsxtClient.UpdateLogicalPortReturns(errors.New("logical port not found"))
+
+			Expect(createVM()).To(MatchError("logical port not found"))
+		})
+	})
+
+	Describe("rollback", func() {
+		var (
+			govcClient    *fakegovc.FakeGovcClient
+			agentSettings *fakevm.FakeAgentSettings
+			logger        *fakelogger.FakeLogger
+			m             action.CreateVMMethod
+		)
+
+		BeforeEach(func() {
+			govcClient = &fakegovc.FakeGovcClient{}
+			agentSettings = &fakevm.FakeAgentSettings{}
+			logger = &fakelogger.FakeLogger{}
+			uuidGen := &fakeuuid.FakeGenerator{}
+
+			m = action.NewCreateVMMethod(govcClient, nil, agentSettings, apiv1.AgentOptions{}, apiv1.NewAgentEnvFactory(), pool.Pool{}, true, uuidGen, logger)
+		})
+
+		createVM := func() error {
+			var resourceCloudProps apiv1.CloudPropsImpl
+			json.Unmarshal([]byte(`{}`), &resourceCloudProps)
+
+			networks := apiv1.Networks{}
+			networks.UnmarshalJSON([]byte(`{"default":{"cloud_properties":{"name":"VM Network"}}}`))
+
+			_, err := m.CreateVM(apiv1.AgentID{}, apiv1.NewStemcellCID("stemcell"), resourceCloudProps, networks, []apiv1.DiskCID{}, apiv1.VMEnv{})
+			return err
+		}
+
+		It("leaves a successfully created VM alone", func() {
+			Expect(createVM()).To(Succeed())
+
+			Expect(govcClient.DestroyVMCallCount()).To(Equal(0))
+			Expect(govcClient.DestroyVMIsoCallCount()).To(Equal(0))
+		})
+
+		It("destroys the VM and its env ISO when a step after cloning fails", func() {
+			govcClient.ConfigureVMReturns(errors.New("reconfigure failed"))
+
+			Expect(createVM()).To(MatchError("reconfigure failed"))
+
+			Expect(govcClient.DestroyVMCallCount()).To(Equal(1))
+			Expect(govcClient.DestroyVMArgsForCall(0)).To(Equal("vm-fake-uuid-0"))
+			Expect(govcClient.DestroyVMIsoCallCount()).To(Equal(1))
+			Expect(govcClient.DestroyVMIsoArgsForCall(0)).To(Equal("vm-fake-uuid-0"))
+			Expect(agentSettings.CleanupCallCount()).To(Equal(1))
+		})
+
+		It("destroys the VM when starting it fails", func() {
+			govcClient.StartVMReturns("", errors.New("power on failed"))
+
+			Expect(createVM()).To(MatchError("power on failed"))
+			Expect(govcClient.DestroyVMCallCount()).To(Equal(1))
+			Expect(govcClient.DestroyVMIsoCallCount()).To(Equal(1))
+		})
+
+		It("destroys copied files when cloning fails", func() {
+			govcClient.CloneVMReturns("", errors.New("register failed"))
+
+			Expect(createVM()).To(MatchError("register failed"))
+			Expect(govcClient.DestroyVMCallCount()).To(Equal(1))
+			Expect(govcClient.ConfigureVMCallCount()).To(Equal(0))
+		})
+
+		It("removes the port groups it created when a step after ensuring them fails", func() {
+			networks := apiv1.Networks{}
+			networks.UnmarshalJSON([]byte(`{
+				"a":{"cloud_properties":{"name":"VLAN 10","vlan_id":10}},
+				"b":{"cloud_properties":{"name":"VLAN 20","vlan_id":20}}
+			}`))
+			govcClient.EnsurePortGroupReturnsOnCall(0, true, nil)
+			govcClient.EnsurePortGroupReturnsOnCall(1, false, nil)
+			govcClient.StartVMReturns("", errors.New("power on failed"))
+
+			var resourceCloudProps apiv1.CloudPropsImpl
+			json.Unmarshal([]byte(`{}`), &resourceCloudProps)
+
+			_, err := m.CreateVM(apiv1.AgentID{}, apiv1.NewStemcellCID("stemcell"), resourceCloudProps, networks, []apiv1.DiskCID{}, apiv1.VMEnv{})
+			Expect(err).To(MatchError("power on failed"))
+
+			Expect(govcClient.DestroyVMCallCount()).To(Equal(1))
+			Expect(govcClient.RemovePortGroupCallCount()).To(Equal(1))
+			Expect(govcClient.RemovePortGroupArgsForCall(0)).To(Equal("VLAN 10"))
+		})
+
+		It("removes the port groups it created when a later one cannot be ensured", func() {
+			networks := apiv1.Networks{}
+			networks.UnmarshalJSON([]byte(`{
+				"a":{"cloud_properties":{"name":"VLAN 10","vlan_id":10}},
+				"b":{"cloud_properties":{"name":"VLAN 20","vswitch":"vSwitch9","vlan_id":20}}
+			}`))
+			govcClient.EnsurePortGroupReturnsOnCall(0, true, nil)
+			govcClient.EnsurePortGroupReturnsOnCall(1, false, errors.New("vswitch 'vSwitch9' not found on host"))
+
+			var resourceCloudProps apiv1.CloudPropsImpl
+			json.Unmarshal([]byte(`{}`), &resourceCloudProps)
+
+			_, err := m.CreateVM(apiv1.AgentID{}, apiv1.NewStemcellCID("stemcell"), resourceCloudProps, networks, []apiv1.DiskCID{}, apiv1.VMEnv{})
+			Expect(err).To(MatchError("vswitch 'vSwitch9' not found on host"))
+
+			Expect(govcClient.CloneVMCallCount()).To(Equal(0))
+			Expect(govcClient.RemovePortGroupCallCount()).To(Equal(1))
+			Expect(govcClient.RemovePortGroupArgsForCall(0)).To(Equal("VLAN 10"))
+		})
+
+		It("keeps the port groups it created when cleanup_port_groups is not set", func() {
+			m = action.NewCreateVMMethod(govcClient, nil, agentSettings, apiv1.AgentOptions{}, apiv1.NewAgentEnvFactory(), pool.Pool{}, false, &fakeuuid.FakeGenerator{}, logger)
+			networks := apiv1.Networks{}
+			networks.UnmarshalJSON([]byte(`{
+				"a":{"cloud_properties":{"name":"VLAN 10","vlan_id":10}},
+				"b":{"cloud_properties":{"name":"VLAN 20","vswitch":"vSwitch9","vlan_id":20}}
+			}`))
+			govcClient.EnsurePortGroupReturnsOnCall(0, true, nil)
+			govcClient.EnsurePortGroupReturnsOnCall(1, false, errors.New("vswitch 'vSwitch9' not found on host"))
+
+			var resourceCloudProps apiv1.CloudPropsImpl
+			json.Unmarshal([]byte(`{}`), &resourceCloudProps)
+
+			_, err := m.CreateVM(apiv1.AgentID{}, apiv1.NewStemcellCID("stemcell"), resourceCloudProps, networks, []apiv1.DiskCID{}, apiv1.VMEnv{})
+			Expect(err).To(MatchError("vswitch 'vSwitch9' not found on host"))
+
+			govcClient.EnsurePortGroupReturns(true, nil)
+			govcClient.StartVMReturns("", errors.New("power on failed"))
+
+			_, err = m.CreateVM(apiv1.AgentID{}, apiv1.NewStemcellCID("stemcell"), resourceCloudProps, networks, []apiv1.DiskCID{}, apiv1.VMEnv{})
+			Expect(err).To(MatchError("power on failed"))
+
+			Expect(govcClient.DestroyVMCallCount()).To(Equal(1))
+			Expect(govcClient.RemovePortGroupCallCount()).To(Equal(0))
+		})
+
+		It("keeps port groups it cannot remove and logs why", func() {
+			networks := apiv1.Networks{}
+			networks.UnmarshalJSON([]byte(`{"a":{"cloud_properties":{"name":"VLAN 10","vlan_id":10}}}`))
+			govcClient.EnsurePortGroupReturns(true, nil)
+			govcClient.ConfigureVMReturns(errors.New("reconfigure failed"))
+			govcClient.RemovePortGroupReturns(errors.New("port group in use"))
+
+			var resourceCloudProps apiv1.CloudPropsImpl
+			json.Unmarshal([]byte(`{}`), &resourceCloudProps)
+
+			_, err := m.CreateVM(apiv1.AgentID{}, apiv1.NewStemcellCID("stemcell"), resourceCloudProps, networks, []apiv1.DiskCID{}, apiv1.VMEnv{})
+			Expect(err).To(MatchError("reconfigure failed"))
+
+			Expect(logger.WarnCallCount()).To(Equal(1))
+			_, msg, args := logger.WarnArgsForCall(0)
+			Expect(msg).To(ContainSubstring("Keeping port group"))
+			Expect(args).To(ContainElement(MatchError("port group in use")))
+		})
+
+		It("returns the original error and logs rollback failures", func() {
+			govcClient.ConfigureVMReturns(errors.New("network not found"))
+			govcClient.DestroyVMReturns("", errors.New("destroy failed"))
+			govcClient.DestroyVMIsoReturns(errors.New("iso delete failed"))
+
+			Expect(createVM()).To(MatchError("network not found"))
+
+			Expect(govcClient.DestroyVMIsoCallCount()).To(Equal(1))
+			Expect(logger.ErrorCallCount()).To(Equal(2))
+
+			_, msg, args := logger.ErrorArgsForCall(0)
+			Expect(msg).To(ContainSubstring("destroying VM"))
+			Expect(args).To(ContainElement(MatchError("destroy failed")))
+
+			_, msg, args = logger.ErrorArgsForCall(1)
+			Expect(msg).To(ContainSubstring("deleting env ISO"))
+			Expect(args).To(ContainElement(MatchError("iso delete failed")))
+		})
+	})
+
+	Describe("VM pool", func() {
+		var (
+			govcClient *fakegovc.FakeGovcClient
+			logger     *fakelogger.FakeLogger
+			m          action.CreateVMMethod
+		)
+
+		BeforeEach(func() {
+			govcClient = &fakegovc.FakeGovcClient{}
+			govcClient.ListVMsReturns([]string{"cs-stemcell", "pool-cs-stemcell.1"}, nil)
+			logger = &fakelogger.FakeLogger{}
+			uuidGen := &fakeuuid.FakeGenerator{}
+
+			vmPool := pool.NewPool(govcClient, config.VmPool{Size: 1}, uuidGen, logger)
+			m = action.NewCreateVMMethod(govcClient, nil, &fakevm.FakeAgentSettings{}, apiv1.AgentOptions{}, apiv1.NewAgentEnvFactory(), vmPool, false, uuidGen, logger)
+		})
+
+		createVM := func(vmCloudProps string) error {
+			var resourceCloudProps apiv1.CloudPropsImpl
+			json.Unmarshal([]byte(vmCloudProps), &resourceCloudProps)
+
+			_, err := m.CreateVM(apiv1.AgentID{}, apiv1.NewStemcellCID("stemcell"), resourceCloudProps, apiv1.Networks{}, []apiv1.DiskCID{}, apiv1.VMEnv{})
+			return err
+		}
+
+		It("claims a clone of the stemcell instead of cloning it", func() {
+			Expect(createVM(`{}`)).To(Succeed())
+
+			Expect(govcClient.CloneVMCallCount()).To(Equal(0))
+			member, vmName := govcClient.RenameVMArgsForCall(0)
+			Expect(member).To(Equal("pool-cs-stemcell.1"))
+			Expect(vmName).To(Equal("vm-fake-uuid-0"))
+
+			configureVmId, _ := govcClient.ConfigureVMArgsForCall(0)
+			Expect(configureVmId).To(Equal("vm-fake-uuid-0"))
+		})
+
+		It("clones the stemcell when the pool is empty", func() {
+			govcClient.ListVMsReturns([]string{"cs-stemcell"}, nil)
+
+			Expect(createVM(`{}`)).To(Succeed())
+			Expect(govcClient.CloneVMCallCount()).To(Equal(1))
+		})
+
+		It("clones the stemcell when claiming fails", func() {
+			govcClient.RenameVMReturns(errors.New("move failed"))
+
+			Expect(createVM(`{}`)).To(Succeed())
+			Expect(govcClient.CloneVMCallCount()).To(Equal(1))
+			Expect(logger.WarnCallCount()).To(Equal(1))
+		})
+
+		It("clones VMs placed on another datastore", func() {
+			Expect(createVM(`{"datastore": "datastore2"}`)).To(Succeed())
+
+			Expect(govcClient.RenameVMCallCount()).To(Equal(0))
+			_, _, datastore := govcClient.CloneVMArgsForCall(0)
+			Expect(datastore).To(Equal("datastore2"))
+		})
 	})
 })
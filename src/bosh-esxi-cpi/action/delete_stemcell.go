@@ -2,32 +2,81 @@ package action
 
 import (
 	"fmt"
+	"strings"
 
 	boshlog "github.com/cloudfoundry/bosh-utils/logger"
 	"github.com/cppforlife/bosh-cpi-go/apiv1"
 
 	"bosh-esxi-cpi/govc"
+	"bosh-esxi-cpi/pool"
 )
 
 type DeleteStemcellMethod struct {
 	govcClient govc.GovcClient
+	vmPool     pool.Pool
 	logger     boshlog.Logger
 }
 
-func NewDeleteStemcellMethod(govcClient govc.GovcClient, logger boshlog.Logger) DeleteStemcellMethod {
+func NewDeleteStemcellMethod(govcClient govc.GovcClient, vmPool pool.Pool, logger boshlog.Logger) DeleteStemcellMethod {
 	return DeleteStemcellMethod{
 		govcClient: govcClient,
+		vmPool:     vmPool,
 		logger:     logger,
 	}
 }
 
+// DeleteStemcell releases the CID's reference to its template and destroys the template once unreferenced.
+// Templates imported before references were recorded have none and are destroyed right away.
 func (c DeleteStemcellMethod) DeleteStemcell(stemcellCid apiv1.StemcellCID) error {
-	stemcellId := "cs-" + stemcellCid.AsString()
-	_, err := c.govcClient.DestroyVM(stemcellId)
+	stemcellId := stemcellTemplateId(stemcellCid)
+
+	// held until the template is destroyed, so create_stemcell cannot add a reference to a template being destroyed
+	lock, err := lockTemplate(c.govcClient.Host(), stemcellId)
+	if err != nil {
+		return err
+	}
+	defer lock.Unlock()
+
+	extraConfig, err := c.govcClient.GetVMExtraConfig(stemcellId)
+	if err != nil && !govc.IsVMNotFound(err) {
+		c.logger.Error("delete-stemcell", fmt.Sprintf("failed to delete stemcell. cid: %s", stemcellCid))
+		return hostError(err)
+	}
+
+	var remaining []string
+	for _, cid := range splitList(extraConfig[stemcellCIDsKey]) {
+		if cid != stemcellCid.AsString() {
+			remaining = append(remaining, cid)
+		}
+	}
+
+	if len(remaining) > 0 {
+		c.logger.Info("delete-stemcell", "Keeping stemcell template '%s' used by %s", stemcellId, strings.Join(remaining, ", "))
+
+		return c.govcClient.SetVMExtraConfig(stemcellId, map[string]string{
+			stemcellCIDsKey: strings.Join(remaining, ","),
+		})
+	}
+
+	_, err = c.govcClient.DestroyVM(stemcellId)
 	if err != nil {
 		c.logger.Error("delete-stemcell", fmt.Sprintf("failed to delete stemcell. cid: %s", stemcellCid))
 		return err
 	}
 
+	// create_vm replicates the template to the datastores VMs were placed on
+	err = c.govcClient.DestroyVMReplicas(stemcellId)
+	if err != nil {
+		c.logger.Error("delete-stemcell", "Deleting replicas of stemcell '%s': %s", stemcellId, err)
+		return hostError(err)
+	}
+
+	// drained even with no pool configured, in case one was before
+	_, err = c.vmPool.Drain(stemcellId)
+	if err != nil {
+		c.logger.Error("delete-stemcell", "Draining the VM pool of stemcell '%s': %s", stemcellId, err)
+		return hostError(err)
+	}
+
 	return nil
 }
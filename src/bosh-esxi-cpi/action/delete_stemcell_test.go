@@ -0,0 +1,132 @@
+package action_test
+
+import (
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/cppforlife/bosh-cpi-go/apiv1"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	fakegovc "bosh-esxi-cpi/govc/fakes"
+
+	fakelogger "github.com/cloudfoundry/bosh-utils/logger/loggerfakes"
+	fakeuuid "github.com/cloudfoundry/bosh-utils/uuid/fakes"
+
+	"bosh-esxi-cpi/action"
+	"bosh-esxi-cpi/config"
+	"bosh-esxi-cpi/pool"
+)
+
+var _ = Describe("DeleteStemcell", func() {
+	var govcClient *fakegovc.FakeGovcClient
+	var m action.DeleteStemcellMethod
+
+	BeforeEach(func() {
+		govcClient = &fakegovc.FakeGovcClient{}
+		logger := &fakelogger.FakeLogger{}
+		m = action.NewDeleteStemcellMethod(govcClient, pool.NewPool(govcClient, config.VmPool{}, &fakeuuid.FakeGenerator{}, logger), logger)
+	})
+
+	It("destroys templates imported before references were recorded", func() {
+		govcClient.GetVMExtraConfigReturns(map[string]string{}, nil)
+
+		err := m.DeleteStemcell(apiv1.NewStemcellCID("uuid"))
+		Expect(err).ToNot(HaveOccurred())
+
+		Expect(govcClient.GetVMExtraConfigArgsForCall(0)).To(Equal("cs-uuid"))
+		Expect(govcClient.DestroyVMArgsForCall(0)).To(Equal("cs-uuid"))
+	})
+
+	It("keeps the template while other stemcells use it", func() {
+		govcClient.GetVMExtraConfigReturns(map[string]string{"bosh.stemcell_cids": "template,template:uuid"}, nil)
+
+		err := m.DeleteStemcell(apiv1.NewStemcellCID("template"))
+		Expect(err).ToNot(HaveOccurred())
+
+		Expect(govcClient.DestroyVMCallCount()).To(Equal(0))
+		Expect(govcClient.DestroyVMReplicasCallCount()).To(Equal(0))
+
+		vmName, extraConfig := govcClient.SetVMExtraConfigArgsForCall(0)
+		Expect(vmName).To(Equal("cs-template"))
+		Expect(extraConfig).To(Equal(map[string]string{"bosh.stemcell_cids": "template:uuid"}))
+	})
+
+	It("destroys the template with its last stemcell", func() {
+		govcClient.GetVMExtraConfigReturns(map[string]string{"bosh.stemcell_cids": "template:uuid"}, nil)
+
+		err := m.DeleteStemcell(apiv1.NewStemcellCID("template:uuid"))
+		Expect(err).ToNot(HaveOccurred())
+
+		Expect(govcClient.GetVMExtraConfigArgsForCall(0)).To(Equal("cs-template"))
+		Expect(govcClient.SetVMExtraConfigCallCount()).To(Equal(0))
+		Expect(govcClient.DestroyVMArgsForCall(0)).To(Equal("cs-template"))
+		Expect(govcClient.DestroyVMReplicasArgsForCall(0)).To(Equal("cs-template"))
+	})
+
+	It("drains the VM pool of the template", func() {
+		govcClient.GetVMExtraConfigReturns(map[string]string{"bosh.stemcell_cids": "template"}, nil)
+		govcClient.ListVMsReturns([]string{"cs-template", "pool-cs-template.1", "pool-cs-other.1"}, nil)
+
+		err := m.DeleteStemcell(apiv1.NewStemcellCID("template"))
+		Expect(err).ToNot(HaveOccurred())
+
+		Expect(govcClient.DestroyVMCallCount()).To(Equal(2))
+		Expect(govcClient.DestroyVMArgsForCall(0)).To(Equal("cs-template"))
+		Expect(govcClient.DestroyVMArgsForCall(1)).To(Equal("pool-cs-template.1"))
+	})
+
+	It("keeps the VM pool while other stemcells use the template", func() {
+		govcClient.GetVMExtraConfigReturns(map[string]string{"bosh.stemcell_cids": "template,template:uuid"}, nil)
+
+		err := m.DeleteStemcell(apiv1.NewStemcellCID("template"))
+		Expect(err).ToNot(HaveOccurred())
+		Expect(govcClient.ListVMsCallCount()).To(Equal(0))
+	})
+
+	It("destroys the template once stemcells deleted concurrently released it", func() {
+		var mutex sync.Mutex
+		templateConfig := map[string]string{"bosh.stemcell_cids": "template,template:uuid-0,template:uuid-1"}
+
+		govcClient.GetVMExtraConfigStub = func(vmName string) (map[string]string, error) {
+			mutex.Lock()
+			defer mutex.Unlock()
+
+			return map[string]string{"bosh.stemcell_cids": templateConfig["bosh.stemcell_cids"]}, nil
+		}
+		govcClient.SetVMExtraConfigStub = func(vmName string, extraConfig map[string]string) error {
+			// widens the window between reading the references and writing them back
+			time.Sleep(10 * time.Millisecond)
+
+			mutex.Lock()
+			defer mutex.Unlock()
+
+			templateConfig["bosh.stemcell_cids"] = extraConfig["bosh.stemcell_cids"]
+			return nil
+		}
+
+		var wg sync.WaitGroup
+		for _, cid := range []string{"template", "template:uuid-0", "template:uuid-1"} {
+			wg.Add(1)
+			go func(cid string) {
+				defer GinkgoRecover()
+				defer wg.Done()
+
+				Expect(m.DeleteStemcell(apiv1.NewStemcellCID(cid))).To(Succeed())
+			}(cid)
+		}
+		wg.Wait()
+
+		Expect(govcClient.DestroyVMCallCount()).To(Equal(1))
+		Expect(govcClient.DestroyVMArgsForCall(0)).To(Equal("cs-template"))
+	})
+
+	It("keeps the template when its references cannot be read", func() {
+		govcClient.GetVMExtraConfigReturns(nil, errors.New("vm.info failed"))
+
+		err := m.DeleteStemcell(apiv1.NewStemcellCID("template:uuid"))
+		Expect(err).To(MatchError("vm.info failed"))
+		Expect(govcClient.DestroyVMCallCount()).To(Equal(0))
+	})
+})
@@ -1,30 +1,79 @@
 package action
 
 import (
-	"fmt"
+	"strings"
 
+	boshlog "github.com/cloudfoundry/bosh-utils/logger"
 	"github.com/cppforlife/bosh-cpi-go/apiv1"
 
 	"bosh-esxi-cpi/govc"
+	"bosh-esxi-cpi/vm"
 )
 
 type DeleteVMMethod struct {
-	govcClient govc.GovcClient
+	govcClient        govc.GovcClient
+	agentSettings     vm.AgentSettings
+	cleanupPortGroups bool
+	logger            boshlog.Logger
 }
 
-func NewDeleteVMMethod(govcClient govc.GovcClient) DeleteVMMethod {
+func NewDeleteVMMethod(govcClient govc.GovcClient, agentSettings vm.AgentSettings, cleanupPortGroups bool, logger boshlog.Logger) DeleteVMMethod {
 	return DeleteVMMethod{
-		govcClient: govcClient,
+		govcClient:        govcClient,
+		agentSettings:     agentSettings,
+		cleanupPortGroups: cleanupPortGroups,
+		logger:            logger,
 	}
 }
 
 func (c DeleteVMMethod) DeleteVM(vmCid apiv1.VMCID) error {
 	vmId := "vm-" + vmCid.AsString()
+
+	var createdPortGroups []string
+	if c.cleanupPortGroups {
+		// a VM which is already gone has nothing left to clean up but its env ISO
+		extraConfig, err := c.govcClient.GetVMExtraConfig(vmId)
+		if err != nil && !govc.IsVMNotFound(err) {
+			return err
+		}
+		createdPortGroups = splitList(extraConfig[createdPortGroupsKey])
+	}
+
 	_, err := c.govcClient.DestroyVM(vmId)
 	if err != nil {
-		fmt.Printf("%+v\n", err)
+		return vmError(err, vmCid)
+	}
+
+	// the env ISO lives outside the VM folder, so destroying the VM leaves it behind
+	err = c.govcClient.DestroyVMIso(vmId)
+	if err != nil {
 		return err
 	}
 
+	// the env kept for attach_disk and detach_disk holds the agent's credentials
+	err = c.agentSettings.DeleteAgentEnv(vmId)
+	if err != nil {
+		c.logger.Warn("delete-vm", "Keeping agent env of '%s': %s", vmId, err)
+	}
+
+	// port groups still used by other VMs cannot be removed and are kept
+	for _, portGroup := range createdPortGroups {
+		err = c.govcClient.RemovePortGroup(portGroup)
+		if err != nil {
+			c.logger.Warn("delete-vm", "Keeping port group '%s': %s", portGroup, err)
+		}
+	}
+
 	return nil
 }
+
+// splitList splits a comma separated extra config value
+func splitList(value string) []string {
+	var items []string
+	for _, item := range strings.Split(value, ",") {
+		if item != "" {
+			items = append(items, item)
+		}
+	}
+	return items
+}
@@ -0,0 +1,104 @@
+package action_test
+
+import (
+	"errors"
+
+	"github.com/cppforlife/bosh-cpi-go/apiv1"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	fakegovc "bosh-esxi-cpi/govc/fakes"
+	fakevm "bosh-esxi-cpi/vm/fakes"
+
+	fakelogger "github.com/cloudfoundry/bosh-utils/logger/loggerfakes"
+
+	"bosh-esxi-cpi/action"
+)
+
+var _ = Describe("DeleteVM", func() {
+	var govcClient *fakegovc.FakeGovcClient
+	var agentSettings *fakevm.FakeAgentSettings
+	var logger *fakelogger.FakeLogger
+
+	BeforeEach(func() {
+		govcClient = &fakegovc.FakeGovcClient{}
+		agentSettings = &fakevm.FakeAgentSettings{}
+		logger = &fakelogger.FakeLogger{}
+	})
+
+	It("destroys the vm without touching port groups by default", func() {
+		m := action.NewDeleteVMMethod(govcClient, agentSettings, false, logger)
+
+		err := m.DeleteVM(apiv1.NewVMCID("uuid"))
+		Expect(err).ToNot(HaveOccurred())
+
+		Expect(govcClient.DestroyVMArgsForCall(0)).To(Equal("vm-uuid"))
+		Expect(govcClient.GetVMExtraConfigCallCount()).To(Equal(0))
+		Expect(govcClient.RemovePortGroupCallCount()).To(Equal(0))
+	})
+
+	It("deletes the env ISO left outside the vm folder", func() {
+		m := action.NewDeleteVMMethod(govcClient, agentSettings, false, logger)
+
+		err := m.DeleteVM(apiv1.NewVMCID("uuid"))
+		Expect(err).ToNot(HaveOccurred())
+
+		Expect(govcClient.DestroyVMIsoCallCount()).To(Equal(1))
+		Expect(govcClient.DestroyVMIsoArgsForCall(0)).To(Equal("vm-uuid"))
+	})
+
+	It("deletes the agent env kept for the vm", func() {
+		m := action.NewDeleteVMMethod(govcClient, agentSettings, false, logger)
+
+		err := m.DeleteVM(apiv1.NewVMCID("uuid"))
+		Expect(err).ToNot(HaveOccurred())
+
+		Expect(agentSettings.DeleteAgentEnvCallCount()).To(Equal(1))
+		Expect(agentSettings.DeleteAgentEnvArgsForCall(0)).To(Equal("vm-uuid"))
+	})
+
+	It("fails when the env ISO cannot be deleted", func() {
+		m := action.NewDeleteVMMethod(govcClient, agentSettings, false, logger)
+		govcClient.DestroyVMIsoReturns(errors.New("rm failed"))
+
+		err := m.DeleteVM(apiv1.NewVMCID("uuid"))
+		Expect(err).To(MatchError("rm failed"))
+	})
+
+	It("removes port groups created for the vm when cleanup is enabled", func() {
+		m := action.NewDeleteVMMethod(govcClient, agentSettings, true, logger)
+		govcClient.GetVMExtraConfigReturns(map[string]string{"bosh.created_port_groups": "VLAN 10,VLAN 20"}, nil)
+		govcClient.RemovePortGroupReturnsOnCall(0, errors.New("The resource 'VLAN 10' is in use."))
+
+		err := m.DeleteVM(apiv1.NewVMCID("uuid"))
+		Expect(err).ToNot(HaveOccurred())
+
+		Expect(govcClient.GetVMExtraConfigArgsForCall(0)).To(Equal("vm-uuid"))
+		Expect(govcClient.RemovePortGroupCallCount()).To(Equal(2))
+		Expect(govcClient.RemovePortGroupArgsForCall(0)).To(Equal("VLAN 10"))
+		Expect(govcClient.RemovePortGroupArgsForCall(1)).To(Equal("VLAN 20"))
+		Expect(logger.WarnCallCount()).To(Equal(1))
+	})
+
+	It("deletes the env ISO of a vm which is already gone when cleanup is enabled", func() {
+		m := action.NewDeleteVMMethod(govcClient, agentSettings, true, logger)
+		govcClient.GetVMExtraConfigReturns(nil, vmNotFoundError("vm-uuid"))
+
+		err := m.DeleteVM(apiv1.NewVMCID("uuid"))
+		Expect(err).ToNot(HaveOccurred())
+
+		Expect(govcClient.DestroyVMIsoArgsForCall(0)).To(Equal("vm-uuid"))
+		Expect(govcClient.RemovePortGroupCallCount()).To(Equal(0))
+	})
+
+	It("keeps port groups when destroying the vm fails", func() {
+		m := action.NewDeleteVMMethod(govcClient, agentSettings, true, logger)
+		govcClient.GetVMExtraConfigReturns(map[string]string{"bosh.created_port_groups": "VLAN 10"}, nil)
+		govcClient.DestroyVMReturns("", errors.New("destroy failed"))
+
+		err := m.DeleteVM(apiv1.NewVMCID("uuid"))
+		Expect(err).To(MatchError("destroy failed"))
+		Expect(govcClient.RemovePortGroupCallCount()).To(Equal(0))
+		Expect(govcClient.DestroyVMIsoCallCount()).To(Equal(0))
+	})
+})
@@ -11,13 +11,17 @@ type DetachDiskMethod struct {
 	govcClient      govc.GovcClient
 	agentSettings   vm.AgentSettings
 	agentEnvFactory apiv1.AgentEnvFactory
+	updateEnvIso    bool
 }
 
-func NewDetachDiskMethod(govcClient govc.GovcClient, agentSettings vm.AgentSettings, agentEnvFactory apiv1.AgentEnvFactory) DetachDiskMethod {
+// NewDetachDiskMethod returns a method which removes the disk from the agent settings in the VM's env ISO
+// when updateEnvIso is set, see NewAttachDiskMethod
+func NewDetachDiskMethod(govcClient govc.GovcClient, agentSettings vm.AgentSettings, agentEnvFactory apiv1.AgentEnvFactory, updateEnvIso bool) DetachDiskMethod {
 	return DetachDiskMethod{
 		govcClient:      govcClient,
 		agentSettings:   agentSettings,
 		agentEnvFactory: agentEnvFactory,
+		updateEnvIso:    updateEnvIso,
 	}
 }
 
@@ -27,10 +31,17 @@ func (c DetachDiskMethod) DetachDisk(vmCID apiv1.VMCID, diskCID apiv1.DiskCID) e
 
 	err := c.govcClient.DetachDisk(vmId, diskId)
 	if err != nil {
-		return err
+		return detachDiskError(err, vmCID, diskCID)
+	}
+
+	if !c.updateEnvIso {
+		return nil
 	}
 
-	agentEnvBytes := c.agentSettings.AgentEnvBytesFromFile()
+	agentEnvBytes, err := c.agentSettings.AgentEnvBytes(vmId)
+	if err != nil {
+		return err
+	}
 	agentEnv, err := c.agentEnvFactory.FromBytes(agentEnvBytes)
 	if err != nil {
 		return err
@@ -38,14 +49,14 @@ func (c DetachDiskMethod) DetachDisk(vmCID apiv1.VMCID, diskCID apiv1.DiskCID) e
 
 	agentEnv.DetachPersistentDisk(diskCID)
 
-	envIsoPath, err := c.agentSettings.GenerateAgentEnvIso(agentEnv)
+	envIsoPath, err := c.agentSettings.GenerateAgentEnvIso(vmId, agentEnv)
 	if err != nil {
 		return err
 	}
 
 	_, err = c.govcClient.UpdateVMIso(vmId, envIsoPath)
 	if err != nil {
-		return err
+		return vmError(err, vmCID)
 	}
 	c.agentSettings.Cleanup()
 
@@ -0,0 +1,98 @@
+package action
+
+import (
+	"fmt"
+
+	"github.com/cppforlife/bosh-cpi-go/apiv1"
+	"github.com/cppforlife/bosh-cpi-go/rpc"
+
+	"bosh-esxi-cpi/govc"
+)
+
+const notLicensedMessage = "The ESXi host license does not allow this operation; the free ESXi license is read-only for the vSphere API"
+
+// cloudError is a typed CPI error, see rpc.CloudError and rpc.RetryableError
+type cloudError struct {
+	errType  string
+	message  string
+	canRetry bool
+}
+
+var _ rpc.CloudError = cloudError{}
+var _ rpc.RetryableError = cloudError{}
+
+func (e cloudError) Error() string  { return e.message }
+func (e cloudError) Type() string   { return e.errType }
+func (e cloudError) CanRetry() bool { return e.canRetry }
+
+func newVMNotFoundError(vmCID apiv1.VMCID) error {
+	return cloudError{"Bosh::Clouds::VMNotFound", fmt.Sprintf("VM '%s' not found", vmCID.AsString()), false}
+}
+
+func newDiskNotFoundError(diskCID apiv1.DiskCID) error {
+	return cloudError{"Bosh::Clouds::DiskNotFound", fmt.Sprintf("Disk '%s' not found", diskCID.AsString()), false}
+}
+
+func newDiskNotAttachedError(vmCID apiv1.VMCID, diskCID apiv1.DiskCID, reason error) error {
+	message := fmt.Sprintf("Disk '%s' not attached to VM '%s'", diskCID.AsString(), vmCID.AsString())
+	if reason != nil {
+		message = fmt.Sprintf("%s: %s", message, reason)
+	}
+	return cloudError{"Bosh::Clouds::DiskNotAttached", message, false}
+}
+
+func newVMCreationFailedError(reason error) error {
+	return cloudError{"Bosh::Clouds::VMCreationFailed", fmt.Sprintf("VM creation failed: %s", reason), true}
+}
+
+func newNotLicensedError(reason error) error {
+	return cloudError{"Bosh::Clouds::CloudError", fmt.Sprintf("%s: %s", notLicensedMessage, reason), false}
+}
+
+// hostError explains faults any write to the host may raise
+func hostError(err error) error {
+	if govc.IsNotLicensed(err) {
+		return newNotLicensedError(err)
+	}
+
+	return err
+}
+
+func vmError(err error, vmCID apiv1.VMCID) error {
+	if govc.IsVMNotFound(err) {
+		return newVMNotFoundError(vmCID)
+	}
+
+	return hostError(err)
+}
+
+// createVMError lets the director retry the creation, possibly elsewhere, when the host is out of resources
+func createVMError(err error) error {
+	if govc.IsInsufficientResources(err) {
+		return newVMCreationFailedError(err)
+	}
+
+	return hostError(err)
+}
+
+func attachDiskError(err error, vmCID apiv1.VMCID, diskCID apiv1.DiskCID) error {
+	switch {
+	case govc.IsVMNotFound(err):
+		return newVMNotFoundError(vmCID)
+	case govc.IsFileNotFound(err):
+		return newDiskNotFoundError(diskCID)
+	case govc.IsNotLicensed(err):
+		return newNotLicensedError(err)
+	}
+
+	return newDiskNotAttachedError(vmCID, diskCID, err)
+}
+
+// detachDiskError reports a disk which is not attached as not retryable, since detaching it again cannot succeed
+func detachDiskError(err error, vmCID apiv1.VMCID, diskCID apiv1.DiskCID) error {
+	if err == govc.ErrDiskNotAttached {
+		return newDiskNotAttachedError(vmCID, diskCID, nil)
+	}
+
+	return vmError(err, vmCID)
+}
@@ -0,0 +1,139 @@
+package action_test
+
+import (
+	"encoding/json"
+	"errors"
+
+	"github.com/cppforlife/bosh-cpi-go/apiv1"
+	"github.com/cppforlife/bosh-cpi-go/rpc"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	"github.com/vmware/govmomi/task"
+	"github.com/vmware/govmomi/vim25/soap"
+	"github.com/vmware/govmomi/vim25/types"
+
+	fakegovc "bosh-esxi-cpi/govc/fakes"
+	fakevm "bosh-esxi-cpi/vm/fakes"
+
+	fakelogger "github.com/cloudfoundry/bosh-utils/logger/loggerfakes"
+	fakeuuid "github.com/cloudfoundry/bosh-utils/uuid/fakes"
+
+	"bosh-esxi-cpi/action"
+	"bosh-esxi-cpi/govc"
+	"bosh-esxi-cpi/pool"
+)
+
+var _ = Describe("vSphere fault translation", func() {
+	var (
+		govcClient    *fakegovc.FakeGovcClient
+		agentSettings *fakevm.FakeAgentSettings
+		vmCID         apiv1.VMCID
+		diskCID       apiv1.DiskCID
+	)
+
+	taskFault := func(fault types.BaseMethodFault, message string) error {
+		return task.Error{LocalizedMethodFault: &types.LocalizedMethodFault{Fault: fault, LocalizedMessage: message}}
+	}
+
+	expectCloudError := func(err error, errType string, canRetry bool) {
+		Expect(err).To(HaveOccurred())
+
+		cloudErr, ok := err.(rpc.CloudError)
+		Expect(ok).To(BeTrue(), "expected a CloudError, got %#v", err)
+		Expect(cloudErr.Type()).To(Equal(errType))
+
+		retryableErr, ok := err.(rpc.RetryableError)
+		Expect(ok).To(BeTrue())
+		Expect(retryableErr.CanRetry()).To(Equal(canRetry))
+	}
+
+	BeforeEach(func() {
+		govcClient = &fakegovc.FakeGovcClient{}
+		agentSettings = &fakevm.FakeAgentSettings{}
+		vmCID = apiv1.NewVMCID("vm-uuid")
+		diskCID = apiv1.NewDiskCID("disk-uuid")
+	})
+
+	Describe("AttachDisk", func() {
+		var m action.AttachDiskMethod
+
+		BeforeEach(func() {
+			m = action.NewAttachDiskMethod(govcClient, agentSettings, apiv1.NewAgentEnvFactory(), true)
+		})
+
+		It("reports a missing VM as VMNotFound", func() {
+			govcClient.AttachDiskReturns(soap.WrapVimFault(&types.ManagedObjectNotFound{}))
+
+			err := m.AttachDisk(vmCID, diskCID)
+			expectCloudError(err, "Bosh::Clouds::VMNotFound", false)
+			Expect(err).To(MatchError("VM 'vm-uuid' not found"))
+		})
+
+		It("reports a missing disk as DiskNotFound", func() {
+			govcClient.AttachDiskReturns(taskFault(&types.FileNotFound{}, "File [datastore1] disk-disk-uuid.vmdk was not found"))
+
+			err := m.AttachDisk(vmCID, diskCID)
+			expectCloudError(err, "Bosh::Clouds::DiskNotFound", false)
+			Expect(err).To(MatchError("Disk 'disk-uuid' not found"))
+		})
+
+		It("reports other attach failures as DiskNotAttached", func() {
+			govcClient.AttachDiskReturns(errors.New("too many disks"))
+
+			err := m.AttachDisk(vmCID, diskCID)
+			expectCloudError(err, "Bosh::Clouds::DiskNotAttached", false)
+			Expect(err).To(MatchError("Disk 'disk-uuid' not attached to VM 'vm-uuid': too many disks"))
+			Expect(agentSettings.GenerateAgentEnvIsoCallCount()).To(Equal(0))
+		})
+	})
+
+	Describe("DetachDisk", func() {
+		It("reports a disk which is not attached as DiskNotAttached which cannot be retried", func() {
+			govcClient.DetachDiskReturns(govc.ErrDiskNotAttached)
+
+			m := action.NewDetachDiskMethod(govcClient, agentSettings, apiv1.NewAgentEnvFactory(), true)
+			err := m.DetachDisk(vmCID, diskCID)
+			expectCloudError(err, "Bosh::Clouds::DiskNotAttached", false)
+			Expect(govcClient.UpdateVMIsoCallCount()).To(Equal(0))
+		})
+	})
+
+	Describe("CreateVM", func() {
+		var m action.CreateVMMethod
+
+		BeforeEach(func() {
+			m = action.NewCreateVMMethod(govcClient, nil, agentSettings, apiv1.AgentOptions{}, apiv1.NewAgentEnvFactory(), pool.Pool{}, false, &fakeuuid.FakeGenerator{}, &fakelogger.FakeLogger{})
+		})
+
+		createVM := func() error {
+			var resourceCloudProps apiv1.CloudPropsImpl
+			json.Unmarshal([]byte(`{}`), &resourceCloudProps)
+
+			_, err := m.CreateVM(apiv1.AgentID{}, apiv1.NewStemcellCID("stemcell"), resourceCloudProps, apiv1.Networks{}, []apiv1.DiskCID{}, apiv1.VMEnv{})
+			return err
+		}
+
+		It("lets the director retry when the host is out of resources", func() {
+			govcClient.StartVMReturns("", taskFault(&types.InsufficientMemoryResourcesFault{}, "Insufficient memory resources."))
+
+			err := createVM()
+			expectCloudError(err, "Bosh::Clouds::VMCreationFailed", true)
+			Expect(err).To(MatchError("VM creation failed: Insufficient memory resources."))
+		})
+
+		It("explains API writes rejected by the free ESXi license", func() {
+			govcClient.CloneVMReturns("", soap.WrapVimFault(&types.RestrictedVersion{}))
+
+			err := createVM()
+			expectCloudError(err, "Bosh::Clouds::CloudError", false)
+			Expect(err).To(MatchError(ContainSubstring("the free ESXi license is read-only")))
+		})
+
+		It("passes other errors through", func() {
+			govcClient.CloneVMReturns("", errors.New("datastore unavailable"))
+
+			Expect(createVM()).To(MatchError("datastore unavailable"))
+		})
+	})
+})
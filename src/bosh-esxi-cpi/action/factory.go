@@ -1,9 +1,7 @@
 package action
 
 import (
-	"fmt"
-	"os"
-
+	bosherr "github.com/cloudfoundry/bosh-utils/errors"
 	boshlog "github.com/cloudfoundry/bosh-utils/logger"
 	boshsys "github.com/cloudfoundry/bosh-utils/system"
 	boshuuid "github.com/cloudfoundry/bosh-utils/uuid"
@@ -11,14 +9,14 @@ import (
 
 	"bosh-esxi-cpi/config"
 	"bosh-esxi-cpi/govc"
-	"bosh-esxi-cpi/stemcell"
+	"bosh-esxi-cpi/nsxt"
+	"bosh-esxi-cpi/pool"
 	"bosh-esxi-cpi/vm"
 )
 
 type Factory struct {
-	govcClient      govc.GovcClient
-	stemcellClient  stemcell.StemcellClient
-	agentSettings   vm.AgentSettings
+	govcRunner      govc.GovcRunner
+	nsxtClient      nsxt.NsxtClient
 	agentEnvFactory apiv1.AgentEnvFactory
 	config          config.Config
 	fs              boshsys.FileSystem
@@ -32,20 +30,22 @@ type CPI struct {
 	CreateVMMethod
 	DeleteVMMethod
 	HasVMMethod
+	RebootVMMethod
 	CreateDiskMethod
 	AttachDiskMethod
 	DetachDiskMethod
 	DeleteDiskMethod
 	MiscMethod
+
+	logger boshlog.Logger
 }
 
 var _ apiv1.CPIFactory = Factory{}
 var _ apiv1.CPI = CPI{}
 
 func NewFactory(
-	govcClient govc.GovcClient,
-	stemcellClient stemcell.StemcellClient,
-	agentSettings vm.AgentSettings,
+	govcRunner govc.GovcRunner,
+	nsxtClient nsxt.NsxtClient,
 	agentEnvFactory apiv1.AgentEnvFactory,
 	config config.Config,
 	fs boshsys.FileSystem,
@@ -53,9 +53,8 @@ func NewFactory(
 	logger boshlog.Logger,
 ) Factory {
 	return Factory{
-		govcClient,
-		stemcellClient,
-		agentSettings,
+		govcRunner,
+		nsxtClient,
 		agentEnvFactory,
 		config,
 		fs,
@@ -64,18 +63,60 @@ func NewFactory(
 	}
 }
 
-func (f Factory) New(_ apiv1.CallContext) (apiv1.CPI, error) {
+// New builds the methods for one request of API v1, see newCPI
+func (f Factory) New(context apiv1.CallContext) (apiv1.CPI, error) {
+	cpi, err := f.newCPI(context, 1)
+	if err != nil {
+		return nil, err
+	}
+	return cpi, nil
+}
+
+// newCPI builds the methods for one request, with the job's config overlaid by the properties of the
+// director's cpi config passed in the context. One director can so drive several hosts as separate
+// CPIs, which share the runner and its sessions. A daemon serves requests concurrently, so each
+// request gets its own agent settings, generating env ISOs in a temp dir of their own, and the
+// env of each VM is kept in a file of its own.
+func (f Factory) newCPI(context apiv1.CallContext, apiVersion int) (CPI, error) {
+	overlay, err := config.NewCPIPropertiesFromContext(context)
+	if err != nil {
+		return CPI{}, bosherr.WrapError(err, "Reading CPI properties from context")
+	}
+
+	cpiConfig := f.config.Overlay(overlay)
+	err = cpiConfig.Validate()
+	if err != nil {
+		return CPI{}, bosherr.WrapError(err, "Validating configuration from context")
+	}
+
+	nsxtClient := f.nsxtClient
+	if nsxtConfig := cpiConfig.NSXT(); nsxtConfig != nil && nsxtConfig != f.config.NSXT() {
+		nsxtClient, err = nsxt.NewClient(*nsxtConfig, f.logger)
+		if err != nil {
+			return CPI{}, bosherr.WrapError(err, "Creating NSX-T client from context")
+		}
+	}
+
+	govcClient := govc.NewClient(govc.RunnerWithLogger(f.govcRunner, f.logger), govc.NewGovcConfig(cpiConfig), f.logger)
+	agentSettings := vm.NewAgentSettings(f.fs, f.logger)
+	vmPool := pool.NewPool(govcClient, cpiConfig.VMPool(), f.uuidGen, f.logger)
+
+	// directors with API v2 pass disk hints to agents of stemcells with API v2 themselves
+	updateEnvIso := apiVersion < 2 || stemcellAPIVersion(context) < 2
+
 	return CPI{
-		NewCreateStemcellMethod(f.govcClient, f.stemcellClient, f.uuidGen, f.logger),
-		NewDeleteStemcellMethod(f.govcClient, f.logger),
-		NewCreateVMMethod(f.govcClient, f.agentSettings, f.config.GetAgentOptions(), f.agentEnvFactory, f.uuidGen, f.logger),
-		NewDeleteVMMethod(f.govcClient),
-		NewHasVMMethod(f.govcClient),
-		NewCreateDiskMethod(f.govcClient, f.uuidGen),
-		NewAttachDiskMethod(f.govcClient, f.agentSettings, f.agentEnvFactory),
-		NewDetachDiskMethod(f.govcClient, f.agentSettings, f.agentEnvFactory),
-		NewDeleteDiskMethod(f.govcClient, f.logger),
-		NewMiscMethod(f.govcClient),
+		NewCreateStemcellMethod(govcClient, f.uuidGen, f.logger),
+		NewDeleteStemcellMethod(govcClient, vmPool, f.logger),
+		NewCreateVMMethod(govcClient, nsxtClient, agentSettings, cpiConfig.GetAgentOptions(), f.agentEnvFactory, vmPool, cpiConfig.CleanupPortGroups(), f.uuidGen, f.logger),
+		NewDeleteVMMethod(govcClient, agentSettings, cpiConfig.CleanupPortGroups(), f.logger),
+		NewHasVMMethod(govcClient),
+		NewRebootVMMethod(govcClient),
+		NewCreateDiskMethod(govcClient, f.uuidGen),
+		NewAttachDiskMethod(govcClient, agentSettings, f.agentEnvFactory, updateEnvIso),
+		NewDetachDiskMethod(govcClient, agentSettings, f.agentEnvFactory, updateEnvIso),
+		NewDeleteDiskMethod(govcClient, f.logger),
+		NewMiscMethod(govcClient),
+		f.logger,
 	}, nil
 }
 
@@ -86,18 +127,13 @@ func (c CPI) CalculateVMCloudProperties(res apiv1.VMResources) (apiv1.VMCloudPro
 
 func (c CPI) SetVMMetadata(cid apiv1.VMCID, metadata apiv1.VMMeta) error {
 	//NOOP is sufficient for now
-	fmt.Fprintf(os.Stderr, "metadata: %s\n", metadata)
+	c.logger.Debug("cpi", "metadata: %s", metadata)
 	return nil
 }
 
 func (c CPI) SetDiskMetadata(cid apiv1.VMCID, metadata apiv1.VMMeta) error {
 	//NOOP is sufficient for now
-	fmt.Fprintf(os.Stderr, "metadata: %s\n", metadata)
-	return nil
-}
-
-func (c CPI) RebootVM(cid apiv1.VMCID) error {
-	panic("RebootVM")
+	c.logger.Debug("cpi", "metadata: %s", metadata)
 	return nil
 }
 
@@ -12,8 +12,23 @@ func NewMiscMethod(govcClient govc.GovcClient) MiscMethod {
 	return MiscMethod{}
 }
 
+var stemcellFormats = []string{"general-ovf", "vsphere-ovf", "vsphere-ova", "vsphere-light"}
+
+// InfoV2 is apiv1.Info with the highest API version, which directors choose the version they speak by
+type InfoV2 struct {
+	StemcellFormats []string `json:"stemcell_formats"`
+	APIVersion      int      `json:"api_version"`
+}
+
 func (c MiscMethod) Info() (apiv1.Info, error) {
 	return apiv1.Info{
-		StemcellFormats: []string{"general-ovf", "vsphere-ovf"},
+		StemcellFormats: stemcellFormats,
+	}, nil
+}
+
+func (c MiscMethod) InfoV2() (InfoV2, error) {
+	return InfoV2{
+		StemcellFormats: stemcellFormats,
+		APIVersion:      APIVersion,
 	}, nil
 }
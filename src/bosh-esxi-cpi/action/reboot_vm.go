@@ -0,0 +1,28 @@
+package action
+
+import (
+	"github.com/cppforlife/bosh-cpi-go/apiv1"
+
+	"bosh-esxi-cpi/govc"
+)
+
+type RebootVMMethod struct {
+	govcClient govc.GovcClient
+}
+
+func NewRebootVMMethod(govcClient govc.GovcClient) RebootVMMethod {
+	return RebootVMMethod{
+		govcClient: govcClient,
+	}
+}
+
+func (c RebootVMMethod) RebootVM(vmCid apiv1.VMCID) error {
+	vmId := "vm-" + vmCid.AsString()
+
+	err := c.govcClient.RebootVM(vmId)
+	if err != nil {
+		return vmError(err, vmCid)
+	}
+
+	return nil
+}
@@ -0,0 +1,44 @@
+package action_test
+
+import (
+	"errors"
+
+	"github.com/cppforlife/bosh-cpi-go/apiv1"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"github.com/vmware/govmomi/vim25/soap"
+	"github.com/vmware/govmomi/vim25/types"
+
+	fakegovc "bosh-esxi-cpi/govc/fakes"
+
+	"bosh-esxi-cpi/action"
+)
+
+var _ = Describe("RebootVM", func() {
+	var govcClient *fakegovc.FakeGovcClient
+
+	BeforeEach(func() {
+		govcClient = &fakegovc.FakeGovcClient{}
+	})
+
+	It("reboots the VM", func() {
+		err := action.NewRebootVMMethod(govcClient).RebootVM(apiv1.NewVMCID("uuid"))
+		Expect(err).ToNot(HaveOccurred())
+
+		Expect(govcClient.RebootVMArgsForCall(0)).To(Equal("vm-uuid"))
+	})
+
+	It("reports VMs which do not exist", func() {
+		govcClient.RebootVMReturns(soap.WrapVimFault(&types.ManagedObjectNotFound{}))
+
+		err := action.NewRebootVMMethod(govcClient).RebootVM(apiv1.NewVMCID("uuid"))
+		Expect(err).To(MatchError("VM 'uuid' not found"))
+	})
+
+	It("returns other errors", func() {
+		govcClient.RebootVMReturns(errors.New("timed out"))
+
+		err := action.NewRebootVMMethod(govcClient).RebootVM(apiv1.NewVMCID("uuid"))
+		Expect(err).To(MatchError("timed out"))
+	})
+})
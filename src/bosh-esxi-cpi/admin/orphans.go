@@ -0,0 +1,233 @@
+package admin
+
+import (
+	"bufio"
+	"io"
+	"sort"
+	"strings"
+
+	bosherr "github.com/cloudfoundry/bosh-utils/errors"
+	boshlog "github.com/cloudfoundry/bosh-utils/logger"
+
+	"bosh-esxi-cpi/govc"
+	"bosh-esxi-cpi/pool"
+)
+
+type ObjectKind string
+
+// kinds are listed in the order they are deleted, VMs release their disks first
+const (
+	KindVM       ObjectKind = "vm"
+	KindStemcell ObjectKind = "stemcell"
+	KindDisk     ObjectKind = "disk"
+	KindEnvIso   ObjectKind = "env-iso"
+)
+
+var kindOrder = map[ObjectKind]int{KindVM: 0, KindStemcell: 1, KindDisk: 2, KindEnvIso: 3}
+
+const (
+	vmPrefix       = "vm-"
+	stemcellPrefix = "cs-"
+	diskPrefix     = "disk-"
+	envIsoDir      = "env/env-"
+)
+
+// Object is something the CPI created, named after the CID the director knows it by
+type Object struct {
+	Kind       ObjectKind
+	Name       string
+	CID        string
+	Size       int64
+	Registered bool
+}
+
+type OrphanSweeper struct {
+	govcClient govc.GovcClient
+	vmPool     pool.Pool
+	logger     boshlog.Logger
+}
+
+func NewOrphanSweeper(govcClient govc.GovcClient, vmPool pool.Pool, logger boshlog.Logger) OrphanSweeper {
+	return OrphanSweeper{govcClient: govcClient, vmPool: vmPool, logger: logger}
+}
+
+// Inventory finds the VMs, stemcells, disks and env ISOs the CPI created on the host and its datastore.
+// An env ISO is part of its VM unless the VM is gone.
+func (s OrphanSweeper) Inventory() ([]Object, error) {
+	objects := map[string]*Object{}
+
+	vmNames, err := s.govcClient.ListVMs()
+	if err != nil {
+		return nil, bosherr.WrapError(err, "Listing VMs")
+	}
+
+	for _, name := range vmNames {
+		if object, ok := newVMObject(name); ok {
+			object.Registered = true
+			objects[object.Name] = &object
+		}
+	}
+
+	files, err := s.govcClient.ListDatastoreFiles()
+	if err != nil {
+		return nil, bosherr.WrapError(err, "Listing datastore files")
+	}
+
+	var envIsos []govc.DatastoreFile
+	for _, file := range files {
+		if strings.HasPrefix(file.Path, envIsoDir) {
+			envIsos = append(envIsos, file)
+			continue
+		}
+
+		object, ok := newFileObject(file.Path)
+		if !ok {
+			continue
+		}
+
+		if existing, found := objects[object.Name]; found {
+			existing.Size += file.Size
+			continue
+		}
+
+		object.Size = file.Size
+		objects[object.Name] = &object
+	}
+
+	for _, file := range envIsos {
+		vmName := strings.TrimSuffix(strings.TrimPrefix(file.Path, envIsoDir), ".iso")
+		if !strings.HasPrefix(vmName, vmPrefix) || strings.Contains(vmName, "/") {
+			continue
+		}
+
+		if vm, found := objects[vmName]; found {
+			vm.Size += file.Size
+			continue
+		}
+
+		objects[file.Path] = &Object{
+			Kind: KindEnvIso,
+			Name: vmName,
+			CID:  strings.TrimPrefix(vmName, vmPrefix),
+			Size: file.Size,
+		}
+	}
+
+	var inventory []Object
+	for _, object := range objects {
+		inventory = append(inventory, *object)
+	}
+	sortObjects(inventory)
+
+	return inventory, nil
+}
+
+// Orphans returns the objects whose CID is not known to the director. Stemcells are
+// not listed by 'bosh vms' or 'bosh disks', so they are only included when asked for.
+func Orphans(objects []Object, knownCIDs map[string]bool, includeStemcells bool) []Object {
+	var orphans []Object
+	for _, object := range objects {
+		if object.Kind == KindStemcell && !includeStemcells {
+			continue
+		}
+
+		if knownCIDs[object.CID] || knownCIDs[object.Name] {
+			continue
+		}
+
+		orphans = append(orphans, object)
+	}
+	return orphans
+}
+
+// Delete removes every artifact of the object, the same way the delete_* CPI methods do
+func (s OrphanSweeper) Delete(object Object) error {
+	s.logger.Info("admin", "Deleting %s '%s'", object.Kind, object.Name)
+
+	switch object.Kind {
+	case KindVM:
+		_, err := s.govcClient.DestroyVM(object.Name)
+		if err != nil {
+			return err
+		}
+		return s.govcClient.DestroyVMIso(object.Name)
+	case KindStemcell:
+		_, err := s.govcClient.DestroyVM(object.Name)
+		if err != nil {
+			return err
+		}
+
+		err = s.govcClient.DestroyVMReplicas(object.Name)
+		if err != nil {
+			return bosherr.WrapErrorf(err, "Deleting replicas of '%s'", object.Name)
+		}
+
+		_, err = s.vmPool.Drain(object.Name)
+		if err != nil {
+			return bosherr.WrapErrorf(err, "Draining the VM pool of '%s'", object.Name)
+		}
+		return nil
+	case KindDisk:
+		return s.govcClient.DestroyDisk(object.Name)
+	case KindEnvIso:
+		return s.govcClient.DestroyVMIso(object.Name)
+	}
+
+	return bosherr.Errorf("Unknown object kind '%s'", object.Kind)
+}
+
+// ParseCIDs reads CIDs separated by whitespace, such as a CID column cut from 'bosh vms' or 'bosh disks'.
+// A stemcell CID sharing a template, '<template>:<uuid>', also marks the template as known.
+func ParseCIDs(reader io.Reader) (map[string]bool, error) {
+	cids := map[string]bool{}
+
+	scanner := bufio.NewScanner(reader)
+	scanner.Split(bufio.ScanWords)
+	for scanner.Scan() {
+		cid := scanner.Text()
+		cids[cid] = true
+		cids[strings.SplitN(cid, ":", 2)[0]] = true
+	}
+
+	if err := scanner.Err(); err != nil {
+		return nil, bosherr.WrapError(err, "Reading CIDs")
+	}
+
+	return cids, nil
+}
+
+func newVMObject(name string) (Object, bool) {
+	switch {
+	case strings.HasPrefix(name, vmPrefix):
+		return Object{Kind: KindVM, Name: name, CID: strings.TrimPrefix(name, vmPrefix)}, true
+	case strings.HasPrefix(name, stemcellPrefix):
+		return Object{Kind: KindStemcell, Name: name, CID: strings.TrimPrefix(name, stemcellPrefix)}, true
+	}
+	return Object{}, false
+}
+
+// newFileObject maps a datastore file to its object: the folder of a VM or stemcell, or a persistent disk
+func newFileObject(filePath string) (Object, bool) {
+	parts := strings.SplitN(filePath, "/", 2)
+	if len(parts) == 2 {
+		return newVMObject(parts[0])
+	}
+
+	if !strings.HasPrefix(filePath, diskPrefix) || !strings.HasSuffix(filePath, ".vmdk") {
+		return Object{}, false
+	}
+
+	name := strings.TrimSuffix(filePath, ".vmdk")
+	name = strings.TrimSuffix(name, "-flat")
+
+	return Object{Kind: KindDisk, Name: name, CID: strings.TrimPrefix(name, diskPrefix)}, true
+}
+
+func sortObjects(objects []Object) {
+	sort.Slice(objects, func(i, j int) bool {
+		if objects[i].Kind != objects[j].Kind {
+			return kindOrder[objects[i].Kind] < kindOrder[objects[j].Kind]
+		}
+		return objects[i].Name < objects[j].Name
+	})
+}
@@ -0,0 +1,106 @@
+package admin
+
+import (
+	"fmt"
+	"io"
+	"strings"
+	"text/tabwriter"
+
+	bosherr "github.com/cloudfoundry/bosh-utils/errors"
+	boshsys "github.com/cloudfoundry/bosh-utils/system"
+	"github.com/vmware/govmomi/units"
+)
+
+type OrphansOpts struct {
+	CIDsPath         string
+	Apply            bool
+	IncludeStemcells bool
+}
+
+type OrphansCommand struct {
+	sweeper OrphanSweeper
+	fs      boshsys.FileSystem
+	out     io.Writer
+}
+
+func NewOrphansCommand(sweeper OrphanSweeper, fs boshsys.FileSystem, out io.Writer) OrphansCommand {
+	return OrphansCommand{sweeper: sweeper, fs: fs, out: out}
+}
+
+// Run reports the objects the CPI created, or only the orphans when CIDs are given.
+// Nothing is deleted unless Apply is set, which requires the CIDs to diff against.
+func (c OrphansCommand) Run(opts OrphansOpts) error {
+	if opts.Apply && opts.CIDsPath == "" {
+		return bosherr.Error("Refusing to delete without the CIDs known to the director, pass -cids")
+	}
+
+	objects, err := c.sweeper.Inventory()
+	if err != nil {
+		return err
+	}
+
+	status := "unchecked"
+	if opts.CIDsPath != "" {
+		knownCIDs, err := c.readCIDs(opts.CIDsPath)
+		if err != nil {
+			return err
+		}
+
+		objects = Orphans(objects, knownCIDs, opts.IncludeStemcells)
+		status = "orphan"
+	}
+
+	table := tabwriter.NewWriter(c.out, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(table, "KIND\tNAME\tSIZE\tSTATUS")
+
+	var totalSize int64
+	var failed []string
+	for _, object := range objects {
+		totalSize += object.Size
+
+		objectStatus := status
+		if opts.Apply {
+			objectStatus = "deleted"
+			if err := c.sweeper.Delete(object); err != nil {
+				objectStatus = fmt.Sprintf("failed: %s", err)
+				failed = append(failed, object.Name)
+			}
+		}
+
+		fmt.Fprintf(table, "%s\t%s\t%s\t%s\n", object.Kind, object.Name, units.ByteSize(object.Size), objectStatus)
+	}
+
+	if err := table.Flush(); err != nil {
+		return err
+	}
+
+	fmt.Fprintf(c.out, "\n%d objects, %s\n", len(objects), units.ByteSize(totalSize))
+	if opts.CIDsPath != "" && !opts.Apply && len(objects) > 0 {
+		fmt.Fprintln(c.out, "Dry run, pass -apply to delete the orphans")
+	}
+
+	if len(failed) > 0 {
+		return bosherr.Errorf("Deleting orphans failed for %s", strings.Join(failed, ", "))
+	}
+
+	return nil
+}
+
+func (c OrphansCommand) readCIDs(path string) (map[string]bool, error) {
+	contents, err := c.fs.ReadFileString(path)
+	if err != nil {
+		return nil, bosherr.WrapErrorf(err, "Reading CIDs file '%s'", path)
+	}
+
+	knownCIDs, err := ParseCIDs(strings.NewReader(contents))
+	if err != nil {
+		return nil, err
+	}
+
+	// an empty list would make everything an orphan
+	if len(knownCIDs) == 0 {
+		return nil, bosherr.Errorf("No CIDs found in '%s'", path)
+	}
+
+	return knownCIDs, nil
+}
@@ -0,0 +1,103 @@
+package admin_test
+
+import (
+	"bytes"
+	"errors"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	fakegovc "bosh-esxi-cpi/govc/fakes"
+
+	fakelogger "github.com/cloudfoundry/bosh-utils/logger/loggerfakes"
+	fakesys "github.com/cloudfoundry/bosh-utils/system/fakes"
+	fakeuuid "github.com/cloudfoundry/bosh-utils/uuid/fakes"
+
+	"bosh-esxi-cpi/admin"
+	"bosh-esxi-cpi/config"
+	"bosh-esxi-cpi/govc"
+	"bosh-esxi-cpi/pool"
+)
+
+var _ = Describe("OrphansCommand", func() {
+	var (
+		govcClient *fakegovc.FakeGovcClient
+		fs         *fakesys.FakeFileSystem
+		out        *bytes.Buffer
+		command    admin.OrphansCommand
+	)
+
+	BeforeEach(func() {
+		govcClient = &fakegovc.FakeGovcClient{}
+		govcClient.ListVMsReturns([]string{"vm-known", "vm-orphan"}, nil)
+		govcClient.ListDatastoreFilesReturns([]govc.DatastoreFile{
+			{Path: "vm-known/vm-known.vmx", Size: 1024},
+			{Path: "vm-orphan/vm-orphan.vmx", Size: 2048},
+			{Path: "disk-orphan.vmdk", Size: 1024},
+		}, nil)
+
+		fs = fakesys.NewFakeFileSystem()
+		fs.WriteFileString("/cids", "known\n")
+
+		out = &bytes.Buffer{}
+		command = admin.NewOrphansCommand(admin.NewOrphanSweeper(govcClient, pool.NewPool(govcClient, config.VmPool{}, &fakeuuid.FakeGenerator{}, &fakelogger.FakeLogger{}), &fakelogger.FakeLogger{}), fs, out)
+	})
+
+	It("reports the inventory without CIDs", func() {
+		err := command.Run(admin.OrphansOpts{})
+		Expect(err).ToNot(HaveOccurred())
+
+		Expect(out.String()).To(ContainSubstring("vm-known"))
+		Expect(out.String()).To(ContainSubstring("unchecked"))
+		Expect(out.String()).To(ContainSubstring("3 objects, 4.0KB"))
+	})
+
+	It("reports orphans in a dry run by default", func() {
+		err := command.Run(admin.OrphansOpts{CIDsPath: "/cids"})
+		Expect(err).ToNot(HaveOccurred())
+
+		Expect(out.String()).ToNot(ContainSubstring("vm-known"))
+		Expect(out.String()).To(MatchRegexp(`vm\s+vm-orphan\s+2.0KB\s+orphan`))
+		Expect(out.String()).To(MatchRegexp(`disk\s+disk-orphan\s+1.0KB\s+orphan`))
+		Expect(out.String()).To(ContainSubstring("Dry run"))
+
+		Expect(govcClient.DestroyVMCallCount()).To(Equal(0))
+		Expect(govcClient.DestroyDiskCallCount()).To(Equal(0))
+	})
+
+	It("deletes orphans with apply", func() {
+		err := command.Run(admin.OrphansOpts{CIDsPath: "/cids", Apply: true})
+		Expect(err).ToNot(HaveOccurred())
+
+		Expect(govcClient.DestroyVMCallCount()).To(Equal(1))
+		Expect(govcClient.DestroyVMArgsForCall(0)).To(Equal("vm-orphan"))
+		Expect(govcClient.DestroyDiskArgsForCall(0)).To(Equal("disk-orphan"))
+		Expect(out.String()).To(MatchRegexp(`vm-orphan\s+2.0KB\s+deleted`))
+	})
+
+	It("continues past failed deletes and reports them", func() {
+		govcClient.DestroyVMReturns("", errors.New("destroy failed"))
+
+		err := command.Run(admin.OrphansOpts{CIDsPath: "/cids", Apply: true})
+		Expect(err).To(MatchError("Deleting orphans failed for vm-orphan"))
+
+		Expect(govcClient.DestroyDiskCallCount()).To(Equal(1))
+		Expect(out.String()).To(ContainSubstring("failed: destroy failed"))
+	})
+
+	It("refuses to apply without CIDs", func() {
+		err := command.Run(admin.OrphansOpts{Apply: true})
+		Expect(err).To(HaveOccurred())
+
+		Expect(govcClient.ListVMsCallCount()).To(Equal(0))
+	})
+
+	It("refuses an empty CIDs file", func() {
+		fs.WriteFileString("/cids", "\n")
+
+		err := command.Run(admin.OrphansOpts{CIDsPath: "/cids", Apply: true})
+		Expect(err).To(MatchError("No CIDs found in '/cids'"))
+
+		Expect(govcClient.DestroyVMCallCount()).To(Equal(0))
+	})
+})
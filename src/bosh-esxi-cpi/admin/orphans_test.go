@@ -0,0 +1,154 @@
+package admin_test
+
+import (
+	"errors"
+	"strings"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	fakegovc "bosh-esxi-cpi/govc/fakes"
+
+	fakelogger "github.com/cloudfoundry/bosh-utils/logger/loggerfakes"
+	fakeuuid "github.com/cloudfoundry/bosh-utils/uuid/fakes"
+
+	"bosh-esxi-cpi/admin"
+	"bosh-esxi-cpi/config"
+	"bosh-esxi-cpi/govc"
+	"bosh-esxi-cpi/pool"
+)
+
+var _ = Describe("OrphanSweeper", func() {
+	var govcClient *fakegovc.FakeGovcClient
+	var sweeper admin.OrphanSweeper
+
+	BeforeEach(func() {
+		govcClient = &fakegovc.FakeGovcClient{}
+		vmPool := pool.NewPool(govcClient, config.VmPool{}, &fakeuuid.FakeGenerator{}, &fakelogger.FakeLogger{})
+		sweeper = admin.NewOrphanSweeper(govcClient, vmPool, &fakelogger.FakeLogger{})
+	})
+
+	Describe("Inventory", func() {
+		It("groups CPI-named VMs and datastore files into objects", func() {
+			govcClient.ListVMsReturns([]string{"vm-1", "cs-1", "other-vm"}, nil)
+			govcClient.ListDatastoreFilesReturns([]govc.DatastoreFile{
+				{Path: "vm-1/vm-1.vmx", Size: 1},
+				{Path: "vm-1/ephemeral.vmdk", Size: 10},
+				{Path: "vm-2/vm-2.vmx", Size: 100},
+				{Path: "cs-1/image.vmdk", Size: 1000},
+				{Path: "disk-1.vmdk", Size: 10000},
+				{Path: "disk-1-flat.vmdk", Size: 100000},
+				{Path: "env/env-vm-1.iso", Size: 1000000},
+				{Path: "env/env-vm-3.iso", Size: 10000000},
+				{Path: "other-vm/other-vm.vmx", Size: 5},
+				{Path: "iso/ubuntu.iso", Size: 5},
+			}, nil)
+
+			objects, err := sweeper.Inventory()
+			Expect(err).ToNot(HaveOccurred())
+			Expect(objects).To(Equal([]admin.Object{
+				{Kind: admin.KindVM, Name: "vm-1", CID: "1", Size: 1000011, Registered: true},
+				{Kind: admin.KindVM, Name: "vm-2", CID: "2", Size: 100},
+				{Kind: admin.KindStemcell, Name: "cs-1", CID: "1", Size: 1000, Registered: true},
+				{Kind: admin.KindDisk, Name: "disk-1", CID: "1", Size: 110000},
+				{Kind: admin.KindEnvIso, Name: "vm-3", CID: "3", Size: 10000000},
+			}))
+		})
+
+		It("returns errors listing the datastore", func() {
+			govcClient.ListDatastoreFilesReturns(nil, errors.New("ls failed"))
+
+			_, err := sweeper.Inventory()
+			Expect(err).To(MatchError("Listing datastore files: ls failed"))
+		})
+	})
+
+	Describe("Orphans", func() {
+		objects := []admin.Object{
+			{Kind: admin.KindVM, Name: "vm-1", CID: "1"},
+			{Kind: admin.KindVM, Name: "vm-2", CID: "2"},
+			{Kind: admin.KindStemcell, Name: "cs-3", CID: "3"},
+			{Kind: admin.KindDisk, Name: "disk-4", CID: "4"},
+			{Kind: admin.KindEnvIso, Name: "vm-5", CID: "5"},
+		}
+
+		It("returns objects whose CID is unknown, skipping stemcells", func() {
+			orphans := admin.Orphans(objects, map[string]bool{"1": true, "disk-4": true}, false)
+			Expect(orphans).To(Equal([]admin.Object{objects[1], objects[4]}))
+		})
+
+		It("includes stemcells when asked to", func() {
+			orphans := admin.Orphans(objects, map[string]bool{"1": true, "2": true, "4": true, "5": true}, true)
+			Expect(orphans).To(Equal([]admin.Object{objects[2]}))
+		})
+	})
+
+	Describe("Delete", func() {
+		It("destroys a VM and its env ISO", func() {
+			err := sweeper.Delete(admin.Object{Kind: admin.KindVM, Name: "vm-1"})
+			Expect(err).ToNot(HaveOccurred())
+
+			Expect(govcClient.DestroyVMArgsForCall(0)).To(Equal("vm-1"))
+			Expect(govcClient.DestroyVMIsoArgsForCall(0)).To(Equal("vm-1"))
+		})
+
+		It("keeps the env ISO when the VM cannot be destroyed", func() {
+			govcClient.DestroyVMReturns("", errors.New("destroy failed"))
+
+			err := sweeper.Delete(admin.Object{Kind: admin.KindVM, Name: "vm-1"})
+			Expect(err).To(MatchError("destroy failed"))
+			Expect(govcClient.DestroyVMIsoCallCount()).To(Equal(0))
+		})
+
+		It("destroys a stemcell with its replicas and pool", func() {
+			govcClient.ListVMsReturns([]string{"cs-1", "pool-cs-1.uuid-1", "pool-cs-2.uuid-2"}, nil)
+
+			err := sweeper.Delete(admin.Object{Kind: admin.KindStemcell, Name: "cs-1"})
+			Expect(err).ToNot(HaveOccurred())
+
+			Expect(govcClient.DestroyVMCallCount()).To(Equal(2))
+			Expect(govcClient.DestroyVMArgsForCall(0)).To(Equal("cs-1"))
+			Expect(govcClient.DestroyVMArgsForCall(1)).To(Equal("pool-cs-1.uuid-1"))
+			Expect(govcClient.DestroyVMReplicasArgsForCall(0)).To(Equal("cs-1"))
+			Expect(govcClient.DestroyVMIsoCallCount()).To(Equal(0))
+		})
+
+		It("keeps the replicas and pool of a stemcell which cannot be destroyed", func() {
+			govcClient.DestroyVMReturns("", errors.New("destroy failed"))
+
+			err := sweeper.Delete(admin.Object{Kind: admin.KindStemcell, Name: "cs-1"})
+			Expect(err).To(MatchError("destroy failed"))
+			Expect(govcClient.DestroyVMReplicasCallCount()).To(Equal(0))
+			Expect(govcClient.ListVMsCallCount()).To(Equal(0))
+		})
+
+		It("destroys a disk", func() {
+			err := sweeper.Delete(admin.Object{Kind: admin.KindDisk, Name: "disk-1"})
+			Expect(err).ToNot(HaveOccurred())
+
+			Expect(govcClient.DestroyDiskArgsForCall(0)).To(Equal("disk-1"))
+		})
+
+		It("deletes an env ISO left by a VM that is gone", func() {
+			err := sweeper.Delete(admin.Object{Kind: admin.KindEnvIso, Name: "vm-1"})
+			Expect(err).ToNot(HaveOccurred())
+
+			Expect(govcClient.DestroyVMIsoArgsForCall(0)).To(Equal("vm-1"))
+			Expect(govcClient.DestroyVMCallCount()).To(Equal(0))
+		})
+	})
+
+	Describe("ParseCIDs", func() {
+		It("reads whitespace separated CIDs", func() {
+			cids, err := admin.ParseCIDs(strings.NewReader("uuid-1\n  uuid-2 uuid-3\n\n"))
+			Expect(err).ToNot(HaveOccurred())
+			Expect(cids).To(Equal(map[string]bool{"uuid-1": true, "uuid-2": true, "uuid-3": true}))
+		})
+
+		It("marks the template of a stemcell sharing one as known", func() {
+			cids, err := admin.ParseCIDs(strings.NewReader("template:uuid-1"))
+			Expect(err).ToNot(HaveOccurred())
+			Expect(cids).To(Equal(map[string]bool{"template:uuid-1": true, "template": true}))
+		})
+	})
+})
@@ -0,0 +1,46 @@
+package admin
+
+import (
+	"fmt"
+	"io"
+	"text/tabwriter"
+
+	"bosh-esxi-cpi/pool"
+)
+
+type PoolCommand struct {
+	vmPool pool.Pool
+	out    io.Writer
+}
+
+func NewPoolCommand(vmPool pool.Pool, out io.Writer) PoolCommand {
+	return PoolCommand{vmPool: vmPool, out: out}
+}
+
+// Run replenishes the VM pools and reports them. It is meant to run from cron or monit,
+// so finding another run in progress is not an error.
+func (c PoolCommand) Run() error {
+	statuses, err := c.vmPool.Replenish()
+	if err == pool.ErrReplenishing {
+		fmt.Fprintln(c.out, "VM pools are being replenished by another process")
+		return nil
+	}
+
+	table := tabwriter.NewWriter(c.out, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(table, "TEMPLATE\tSTEMCELL\tSIZE\tMEMBERS\tCREATED\tDESTROYED")
+
+	for _, status := range statuses {
+		stemcellName := status.StemcellName
+		if stemcellName == "" {
+			stemcellName = "-"
+		}
+
+		fmt.Fprintf(table, "%s\t%s\t%d\t%d\t%d\t%d\n", status.Template, stemcellName, status.Size, status.Members, status.Created, status.Destroyed)
+	}
+
+	if flushErr := table.Flush(); flushErr != nil && err == nil {
+		err = flushErr
+	}
+
+	return err
+}
@@ -0,0 +1,51 @@
+package admin_test
+
+import (
+	"bytes"
+	"errors"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	fakegovc "bosh-esxi-cpi/govc/fakes"
+
+	fakelogger "github.com/cloudfoundry/bosh-utils/logger/loggerfakes"
+	fakeuuid "github.com/cloudfoundry/bosh-utils/uuid/fakes"
+
+	"bosh-esxi-cpi/admin"
+	"bosh-esxi-cpi/config"
+	"bosh-esxi-cpi/pool"
+)
+
+var _ = Describe("PoolCommand", func() {
+	var (
+		govcClient *fakegovc.FakeGovcClient
+		out        *bytes.Buffer
+		command    admin.PoolCommand
+	)
+
+	BeforeEach(func() {
+		govcClient = &fakegovc.FakeGovcClient{}
+		govcClient.ListVMsReturns([]string{"cs-xenial", "pool-cs-xenial.1"}, nil)
+		govcClient.GetVMExtraConfigReturns(map[string]string{pool.StemcellNameKey: "xenial"}, nil)
+
+		out = &bytes.Buffer{}
+		vmPool := pool.NewPool(govcClient, config.VmPool{Size: 3}, &fakeuuid.FakeGenerator{}, &fakelogger.FakeLogger{})
+		command = admin.NewPoolCommand(vmPool, out)
+	})
+
+	It("replenishes the pools and reports them", func() {
+		Expect(command.Run()).To(Succeed())
+
+		Expect(govcClient.CloneVMCallCount()).To(Equal(2))
+		Expect(out.String()).To(MatchRegexp(`cs-xenial\s+xenial\s+3\s+3\s+2\s+0`))
+	})
+
+	It("reports the pools replenished before a failure", func() {
+		govcClient.CloneVMReturnsOnCall(1, "", errors.New("copy failed"))
+
+		err := command.Run()
+		Expect(err).To(MatchError(ContainSubstring("copy failed")))
+		Expect(out.String()).To(MatchRegexp(`cs-xenial\s+xenial\s+3\s+2\s+1\s+0`))
+	})
+})
@@ -0,0 +1,108 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"os/signal"
+	"strconv"
+	"strings"
+	"syscall"
+
+	boshlog "github.com/cloudfoundry/bosh-utils/logger"
+
+	"bosh-esxi-cpi/esxisim"
+)
+
+const usage = `Usage:
+  esxi-sim -datastore-dir PATH [-listen ADDRESS] [-datastore NAME] [-state PATH] [-port-group NAME[:VSWITCH[:VLAN]]]...
+
+Simulates a standalone ESXi host for the CPI, e.g. to run bosh create-env without one. VMs are
+created and configured, but nothing boots in them, so agents never respond.`
+
+// portGroups collects the repeated -port-group flag
+type portGroups []esxisim.PortGroup
+
+func (p *portGroups) String() string {
+	return fmt.Sprintf("%v", *p)
+}
+
+func (p *portGroups) Set(value string) error {
+	parts := strings.Split(value, ":")
+	if parts[0] == "" || len(parts) > 3 {
+		return fmt.Errorf("expected NAME[:VSWITCH[:VLAN]], got '%s'", value)
+	}
+
+	pg := esxisim.PortGroup{Name: parts[0], VSwitch: "vSwitch0"}
+	if len(parts) > 1 && parts[1] != "" {
+		pg.VSwitch = parts[1]
+	}
+	if len(parts) > 2 {
+		vlan, err := strconv.Atoi(parts[2])
+		if err != nil {
+			return fmt.Errorf("invalid VLAN in '%s'", value)
+		}
+		pg.VLAN = int32(vlan)
+	}
+
+	*p = append(*p, pg)
+	return nil
+}
+
+func main() {
+	var pgs portGroups
+
+	flags := flag.NewFlagSet("esxi-sim", flag.ExitOnError)
+	flags.Usage = func() {
+		fmt.Fprintln(os.Stderr, usage)
+		flags.PrintDefaults()
+	}
+	address := flags.String("listen", "127.0.0.1:8989", "Address to serve the SDK on over HTTPS")
+	datastoreDir := flags.String("datastore-dir", "", "Directory holding the datastore's files")
+	datastoreName := flags.String("datastore", "datastore1", "Name of the datastore")
+	statePath := flags.String("state", "", "File to keep the inventory in across restarts, defaults to the datastore directory with .json appended")
+	flags.Var(&pgs, "port-group", "Port group to add unless it exists, as NAME[:VSWITCH[:VLAN]], may be repeated")
+	flags.Parse(os.Args[1:])
+
+	if *datastoreDir == "" {
+		flags.Usage()
+		os.Exit(2)
+	}
+
+	if *statePath == "" {
+		*statePath = strings.TrimSuffix(*datastoreDir, string(os.PathSeparator)) + ".json"
+	}
+
+	logger := boshlog.NewWriterLogger(boshlog.LevelInfo, os.Stderr)
+
+	sim, err := esxisim.New(esxisim.Config{
+		Address:       *address,
+		DatastoreName: *datastoreName,
+		DatastoreDir:  *datastoreDir,
+		StatePath:     *statePath,
+		PortGroups:    pgs,
+	}, logger)
+	if err != nil {
+		logger.Error("main", "Starting simulator: %s", err)
+		os.Exit(1)
+	}
+
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGTERM, syscall.SIGINT)
+	go func() {
+		sig := <-signals
+		logger.Info("main", "Received %s, stopping", sig)
+		sim.Close()
+	}()
+
+	u := sim.URL()
+	password, _ := u.User.Password()
+	logger.Info("main", "Serving ESXi host at %s, user '%s', password '%s', datastore '%s'", u.Host, u.User.Username(), password, *datastoreName)
+	fmt.Println(u.String())
+
+	err = sim.Serve()
+	if err != nil {
+		logger.Error("main", "Serving: %s", err)
+		os.Exit(1)
+	}
+}
@@ -4,6 +4,7 @@ import (
 	"encoding/json"
 
 	bosherr "github.com/cloudfoundry/bosh-utils/errors"
+	boshlog "github.com/cloudfoundry/bosh-utils/logger"
 	boshsys "github.com/cloudfoundry/bosh-utils/system"
 	"github.com/cppforlife/bosh-cpi-go/apiv1"
 )
@@ -20,13 +21,18 @@ type Cloud struct {
 type CPIProperties struct {
 	Vcenters []Vcenter
 	Agent    apiv1.AgentOptions
+	Logging  Logging
 }
 
 type Vcenter struct {
-	Host        string
-	User        string
-	Password    string
-	Datacenters []Datacenter
+	Host                string
+	User                string
+	Password            string
+	Datacenters         []Datacenter
+	Cleanup_Port_Groups bool
+	Nsx                 *Nsx
+	Nsxt                *Nsxt
+	Vm_Pool             VmPool
 }
 
 type Datacenter struct {
@@ -37,6 +43,58 @@ type Datacenter struct {
 	Datastore_Pattern string
 }
 
+type Nsx struct {
+	Address  string
+	User     string
+	Password string
+	Ca_Cert  string
+}
+
+type Nsxt struct {
+	Host             string
+	Username         string
+	Password         string
+	Ca_Cert          string
+	Default_Vif_Type string
+}
+
+// VmPool sizes the pools of powered off clones create_vm claims VMs from, see the pool package.
+// Stemcells are keyed by name, and fall back to Size.
+type VmPool struct {
+	Size      int
+	Stemcells map[string]int
+}
+
+// SizeFor returns how many clones to keep of each stemcell with the name
+func (p VmPool) SizeFor(stemcellName string) int {
+	if size, found := p.Stemcells[stemcellName]; found {
+		return size
+	}
+	return p.Size
+}
+
+// Enabled reports whether any stemcell has a pool
+func (p VmPool) Enabled() bool {
+	if p.Size > 0 {
+		return true
+	}
+	for _, size := range p.Stemcells {
+		if size > 0 {
+			return true
+		}
+	}
+	return false
+}
+
+// Logging configures the CPI's log on stderr. Level is one of debug, info, warn, error or none,
+// debug when empty, and Format is text or json, text when empty.
+type Logging struct {
+	Level  string
+	Format string
+}
+
+const NsxtVifTypeParent = "PARENT"
+
 func NewConfigFromPath(path string, fs boshsys.FileSystem) (Config, error) {
 	var config Config
 
@@ -62,6 +120,91 @@ func (c Config) GetAgentOptions() apiv1.AgentOptions {
 	return c.Cloud.Properties.Agent
 }
 
+func (c Config) CleanupPortGroups() bool {
+	if len(c.Cloud.Properties.Vcenters) == 0 {
+		return false
+	}
+	return c.Cloud.Properties.Vcenters[0].Cleanup_Port_Groups
+}
+
+func (c Config) Datacenter() string {
+	if len(c.Cloud.Properties.Vcenters) == 0 || len(c.Cloud.Properties.Vcenters[0].Datacenters) == 0 {
+		return ""
+	}
+	return c.Cloud.Properties.Vcenters[0].Datacenters[0].Name
+}
+
+// NSXT returns the NSX-T manager settings, or nil when NSX-T is not configured
+func (c Config) NSXT() *Nsxt {
+	if len(c.Cloud.Properties.Vcenters) == 0 {
+		return nil
+	}
+	return c.Cloud.Properties.Vcenters[0].Nsxt
+}
+
+// VMPool returns the pool sizes, which are all zero unless configured
+func (c Config) VMPool() VmPool {
+	if len(c.Cloud.Properties.Vcenters) == 0 {
+		return VmPool{}
+	}
+	return c.Cloud.Properties.Vcenters[0].Vm_Pool
+}
+
+// LogLevel returns the level to log at, debug unless configured
+func (c Config) LogLevel() boshlog.LogLevel {
+	if c.Cloud.Properties.Logging.Level == "" {
+		return boshlog.LevelDebug
+	}
+	level, _ := boshlog.Levelify(c.Cloud.Properties.Logging.Level)
+	return level
+}
+
+// LogFormat returns the format to log in, text or json
+func (c Config) LogFormat() string {
+	if c.Cloud.Properties.Logging.Format == "" {
+		return "text"
+	}
+	return c.Cloud.Properties.Logging.Format
+}
+
 func (c Config) Validate() error {
+	logging := c.Cloud.Properties.Logging
+	if logging.Level != "" {
+		if _, err := boshlog.Levelify(logging.Level); err != nil {
+			return bosherr.WrapError(err, "logging.level")
+		}
+	}
+
+	switch logging.Format {
+	case "", "text", "json":
+	default:
+		return bosherr.Errorf("logging.format must be 'text' or 'json', got '%s'", logging.Format)
+	}
+
+	vmPool := c.VMPool()
+	if vmPool.Size < 0 {
+		return bosherr.Errorf("vm_pool.size must not be negative, got %d", vmPool.Size)
+	}
+	for name, size := range vmPool.Stemcells {
+		if size < 0 {
+			return bosherr.Errorf("vm_pool.stemcells.%s must not be negative, got %d", name, size)
+		}
+	}
+
+	nsxt := c.NSXT()
+	if nsxt == nil {
+		return nil
+	}
+
+	if nsxt.Host == "" {
+		return bosherr.Error("nsxt.host must be set")
+	}
+
+	switch nsxt.Default_Vif_Type {
+	case "", NsxtVifTypeParent:
+	default:
+		return bosherr.Errorf("nsxt.default_vif_type must be '%s', got '%s'", NsxtVifTypeParent, nsxt.Default_Vif_Type)
+	}
+
 	return nil
 }
@@ -0,0 +1,100 @@
+package config
+
+import (
+	bosherr "github.com/cloudfoundry/bosh-utils/errors"
+	"github.com/cppforlife/bosh-cpi-go/apiv1"
+)
+
+// contextProperties are the properties of a CPI in the director's cpi config, which the director
+// passes in each request's context. They are either structured like the job's properties, or, like
+// the vSphere CPI takes them, the first vcenter's host, user, password and datacenters at the top.
+type contextProperties struct {
+	CPIProperties
+	Vcenter
+}
+
+// NewCPIPropertiesFromContext decodes the properties passed in a request's context, see Overlay.
+// Requests without a context, e.g. from directors without a cpi config, overlay nothing.
+func NewCPIPropertiesFromContext(context apiv1.CallContext) (CPIProperties, error) {
+	if context == nil {
+		return CPIProperties{}, nil
+	}
+	if props, ok := context.(apiv1.CloudPropsImpl); ok && len(props.RawMessage) == 0 {
+		return CPIProperties{}, nil
+	}
+
+	var props contextProperties
+	err := context.As(&props)
+	if err != nil {
+		return CPIProperties{}, bosherr.WrapError(err, "Unmarshalling context")
+	}
+
+	overlay := props.CPIProperties
+	if props.Vcenter.Host != "" || props.Vcenter.User != "" || props.Vcenter.Password != "" || len(props.Vcenter.Datacenters) > 0 {
+		if len(overlay.Vcenters) == 0 {
+			overlay.Vcenters = []Vcenter{props.Vcenter}
+		} else {
+			overlay.Vcenters[0] = mergeVcenter(overlay.Vcenters[0], props.Vcenter)
+		}
+	}
+
+	return overlay, nil
+}
+
+// Overlay returns the config with the properties set in the overlay replacing the job's.
+// Vcenters are merged by index, and a flag such as cleanup_port_groups can only be turned on.
+func (c Config) Overlay(overlay CPIProperties) Config {
+	merged := c
+	properties := &merged.Cloud.Properties
+
+	vcenters := make([]Vcenter, len(properties.Vcenters))
+	copy(vcenters, properties.Vcenters)
+	for i, vcenter := range overlay.Vcenters {
+		if i < len(vcenters) {
+			vcenters[i] = mergeVcenter(vcenters[i], vcenter)
+		} else {
+			vcenters = append(vcenters, vcenter)
+		}
+	}
+	properties.Vcenters = vcenters
+
+	if overlay.Agent.Mbus != "" {
+		properties.Agent.Mbus = overlay.Agent.Mbus
+	}
+	if len(overlay.Agent.NTP) > 0 {
+		properties.Agent.NTP = overlay.Agent.NTP
+	}
+	if overlay.Agent.Blobstore.Type != "" {
+		properties.Agent.Blobstore = overlay.Agent.Blobstore
+	}
+
+	return merged
+}
+
+func mergeVcenter(vcenter Vcenter, overlay Vcenter) Vcenter {
+	if overlay.Host != "" {
+		vcenter.Host = overlay.Host
+	}
+	if overlay.User != "" {
+		vcenter.User = overlay.User
+	}
+	if overlay.Password != "" {
+		vcenter.Password = overlay.Password
+	}
+	if len(overlay.Datacenters) > 0 {
+		vcenter.Datacenters = overlay.Datacenters
+	}
+	if overlay.Cleanup_Port_Groups {
+		vcenter.Cleanup_Port_Groups = true
+	}
+	if overlay.Nsx != nil {
+		vcenter.Nsx = overlay.Nsx
+	}
+	if overlay.Nsxt != nil {
+		vcenter.Nsxt = overlay.Nsxt
+	}
+	if overlay.Vm_Pool.Size != 0 || overlay.Vm_Pool.Stemcells != nil {
+		vcenter.Vm_Pool = overlay.Vm_Pool
+	}
+	return vcenter
+}
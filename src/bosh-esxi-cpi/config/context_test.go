@@ -0,0 +1,77 @@
+package config_test
+
+import (
+	"encoding/json"
+
+	"github.com/cppforlife/bosh-cpi-go/apiv1"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	"bosh-esxi-cpi/config"
+)
+
+var _ = Describe("Context overlay", func() {
+	var cpiConfig config.Config
+
+	BeforeEach(func() {
+		cpiConfig = config.Config{Cloud: config.Cloud{Properties: config.CPIProperties{
+			Vcenters: []config.Vcenter{{
+				Host:        "esxi-1",
+				User:        "root",
+				Password:    "secret",
+				Datacenters: []config.Datacenter{{Name: "ha-datacenter"}},
+				Nsxt:        &config.Nsxt{Host: "nsxt-1"},
+			}},
+			Agent: apiv1.AgentOptions{Mbus: "https://mbus-1", NTP: []string{"ntp-1"}},
+		}}}
+	})
+
+	overlay := func(raw string) config.Config {
+		props, err := config.NewCPIPropertiesFromContext(apiv1.CloudPropsImpl{RawMessage: json.RawMessage(raw)})
+		Expect(err).ToNot(HaveOccurred())
+		return cpiConfig.Overlay(props)
+	}
+
+	It("keeps the job's config when the context has no CPI properties", func() {
+		Expect(overlay(`{"director_uuid":"abc","request_id":"cpi-1"}`)).To(Equal(cpiConfig))
+	})
+
+	It("keeps the job's config without a context", func() {
+		props, err := config.NewCPIPropertiesFromContext(apiv1.CloudPropsImpl{})
+		Expect(err).ToNot(HaveOccurred())
+		Expect(cpiConfig.Overlay(props)).To(Equal(cpiConfig))
+	})
+
+	It("overlays the first vcenter with properties at the top, like the vSphere CPI takes them", func() {
+		merged := overlay(`{"host":"esxi-2","password":"other","datacenters":[{"name":"dc-2"}]}`)
+
+		vcenter := merged.Cloud.Properties.Vcenters[0]
+		Expect(vcenter.Host).To(Equal("esxi-2"))
+		Expect(vcenter.User).To(Equal("root"))
+		Expect(vcenter.Password).To(Equal("other"))
+		Expect(merged.Datacenter()).To(Equal("dc-2"))
+		Expect(merged.NSXT().Host).To(Equal("nsxt-1"))
+	})
+
+	It("overlays vcenters and agent structured like the job's properties", func() {
+		merged := overlay(`{"vcenters":[{"host":"esxi-2","cleanup_port_groups":true,"vm_pool":{"size":2}}],"agent":{"ntp":["ntp-2"]}}`)
+
+		vcenter := merged.Cloud.Properties.Vcenters[0]
+		Expect(vcenter.Host).To(Equal("esxi-2"))
+		Expect(vcenter.Password).To(Equal("secret"))
+		Expect(merged.CleanupPortGroups()).To(BeTrue())
+		Expect(merged.VMPool().Size).To(Equal(2))
+		Expect(merged.GetAgentOptions().Mbus).To(Equal("https://mbus-1"))
+		Expect(merged.GetAgentOptions().NTP).To(ConsistOf("ntp-2"))
+	})
+
+	It("leaves the job's config unchanged", func() {
+		overlay(`{"host":"esxi-2"}`)
+		Expect(cpiConfig.Cloud.Properties.Vcenters[0].Host).To(Equal("esxi-1"))
+	})
+
+	It("fails on a malformed context", func() {
+		_, err := config.NewCPIPropertiesFromContext(apiv1.CloudPropsImpl{RawMessage: json.RawMessage(`{"vcenters":{}}`)})
+		Expect(err).To(MatchError(ContainSubstring("Unmarshalling context")))
+	})
+})
@@ -0,0 +1,148 @@
+package daemon
+
+import (
+	"io"
+	"io/ioutil"
+	"net"
+	"os"
+	"runtime/debug"
+	"sync"
+
+	bosherr "github.com/cloudfoundry/bosh-utils/errors"
+	boshlog "github.com/cloudfoundry/bosh-utils/logger"
+	"github.com/cppforlife/bosh-cpi-go/rpc"
+)
+
+// Server serves CPI requests over a Unix socket, one request per connection: the client writes the
+// request, closes its end for writing and reads the response. It keeps the govc runner, and so its
+// ESXi sessions, across requests, where bin/cpi would log in again for each one.
+type Server struct {
+	listener   *net.UnixListener
+	dispatcher rpc.Dispatcher
+	logger     boshlog.Logger
+
+	mutex    sync.Mutex
+	closed   bool
+	requests sync.WaitGroup
+}
+
+// NewServer listens on the socket, replacing the socket a daemon which is gone left behind.
+// It fails when another daemon still answers on it.
+func NewServer(socketPath string, dispatcher rpc.Dispatcher, logger boshlog.Logger) (*Server, error) {
+	if _, err := os.Stat(socketPath); err == nil {
+		conn, err := net.Dial("unix", socketPath)
+		if err == nil {
+			conn.Close()
+			return nil, bosherr.Errorf("Another daemon is listening on '%s'", socketPath)
+		}
+
+		logger.Info("daemon", "Removing stale socket '%s'", socketPath)
+		if err = os.Remove(socketPath); err != nil {
+			return nil, bosherr.WrapErrorf(err, "Removing stale socket '%s'", socketPath)
+		}
+	}
+
+	listener, err := net.ListenUnix("unix", &net.UnixAddr{Name: socketPath, Net: "unix"})
+	if err != nil {
+		return nil, bosherr.WrapErrorf(err, "Listening on '%s'", socketPath)
+	}
+
+	// requests carry the director's credentials for the agents
+	if err = os.Chmod(socketPath, 0600); err != nil {
+		listener.Close()
+		return nil, bosherr.WrapErrorf(err, "Restricting access to '%s'", socketPath)
+	}
+
+	return &Server{listener: listener, dispatcher: dispatcher, logger: logger}, nil
+}
+
+// Serve handles requests concurrently until Close is called, which makes it return nil
+func (s *Server) Serve() error {
+	for {
+		conn, err := s.listener.AcceptUnix()
+		if err != nil {
+			if s.isClosed() {
+				return nil
+			}
+			return bosherr.WrapError(err, "Accepting connection")
+		}
+
+		s.requests.Add(1)
+		go func() {
+			defer s.requests.Done()
+			s.handle(conn)
+		}()
+	}
+}
+
+// Close stops accepting connections, removes the socket and waits for the requests in flight
+func (s *Server) Close() error {
+	s.mutex.Lock()
+	s.closed = true
+	s.mutex.Unlock()
+
+	err := s.listener.Close()
+	s.requests.Wait()
+
+	return err
+}
+
+func (s *Server) isClosed() bool {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	return s.closed
+}
+
+func (s *Server) handle(conn *net.UnixConn) {
+	defer conn.Close()
+
+	// a dispatcher which panics fails the request, closing the connection without a response, rather than the daemon
+	defer func() {
+		if r := recover(); r != nil {
+			s.logger.Error("daemon", "Panic serving request: %v\n%s", r, debug.Stack())
+		}
+	}()
+
+	request, err := ioutil.ReadAll(conn)
+	if err != nil {
+		s.logger.Error("daemon", "Reading request: %s", err)
+		return
+	}
+
+	response := s.dispatcher.Dispatch(request)
+
+	if _, err = conn.Write(response); err != nil {
+		s.logger.Error("daemon", "Writing response: %s", err)
+	}
+}
+
+// Forward sends the request to the daemon listening on the socket and copies its response to out.
+// It returns false, without writing anything, when no daemon is listening.
+func Forward(socketPath string, request []byte, out io.Writer) (bool, error) {
+	conn, err := net.DialUnix("unix", nil, &net.UnixAddr{Name: socketPath, Net: "unix"})
+	if err != nil {
+		return false, nil
+	}
+	defer conn.Close()
+
+	if _, err = conn.Write(request); err != nil {
+		return true, bosherr.WrapError(err, "Writing request to daemon")
+	}
+
+	if err = conn.CloseWrite(); err != nil {
+		return true, bosherr.WrapError(err, "Closing request to daemon")
+	}
+
+	written, err := io.Copy(out, conn)
+	if err != nil {
+		return true, bosherr.WrapError(err, "Reading response from daemon")
+	}
+
+	// the daemon went away, e.g. monit restarted it, before it responded
+	if written == 0 {
+		return true, bosherr.Error("Daemon closed the connection without a response")
+	}
+
+	return true, nil
+}
@@ -0,0 +1,191 @@
+package daemon_test
+
+import (
+	"bytes"
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	fakelogger "github.com/cloudfoundry/bosh-utils/logger/loggerfakes"
+
+	"bosh-esxi-cpi/daemon"
+)
+
+// echoDispatcher responds with the request, once as many requests as it waits for arrived
+type echoDispatcher struct {
+	arrived sync.WaitGroup
+}
+
+func (d *echoDispatcher) Dispatch(request []byte) []byte {
+	d.arrived.Done()
+	d.arrived.Wait()
+	return append([]byte("response to "), request...)
+}
+
+// panicDispatcher panics serving the request 'panic' and echoes the others
+type panicDispatcher struct{}
+
+func (d panicDispatcher) Dispatch(request []byte) []byte {
+	if string(request) == "panic" {
+		panic("dispatching failed")
+	}
+	return request
+}
+
+var _ = Describe("Server", func() {
+	var (
+		tempDir    string
+		socketPath string
+		dispatcher *echoDispatcher
+		server     *daemon.Server
+		served     chan error
+	)
+
+	BeforeEach(func() {
+		var err error
+		tempDir, err = ioutil.TempDir("", "daemon-test-")
+		Expect(err).ToNot(HaveOccurred())
+		socketPath = filepath.Join(tempDir, "cpi.sock")
+
+		dispatcher = &echoDispatcher{}
+		served = make(chan error, 1)
+	})
+
+	serve := func() {
+		var err error
+		server, err = daemon.NewServer(socketPath, dispatcher, &fakelogger.FakeLogger{})
+		Expect(err).ToNot(HaveOccurred())
+
+		go func() { served <- server.Serve() }()
+	}
+
+	AfterEach(func() {
+		if server != nil {
+			Expect(server.Close()).To(Succeed())
+			Eventually(served).Should(Receive(BeNil()))
+			server = nil
+		}
+		os.RemoveAll(tempDir)
+	})
+
+	It("forwards requests to the daemon", func() {
+		dispatcher.arrived.Add(1)
+		serve()
+
+		out := &bytes.Buffer{}
+		forwarded, err := daemon.Forward(socketPath, []byte(`{"method":"info"}`), out)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(forwarded).To(BeTrue())
+		Expect(out.String()).To(Equal(`response to {"method":"info"}`))
+	})
+
+	It("serves requests concurrently", func() {
+		dispatcher.arrived.Add(3)
+		serve()
+
+		var wg sync.WaitGroup
+		for i := 0; i < 3; i++ {
+			wg.Add(1)
+			go func() {
+				defer GinkgoRecover()
+				defer wg.Done()
+
+				out := &bytes.Buffer{}
+				_, err := daemon.Forward(socketPath, []byte("request"), out)
+				Expect(err).ToNot(HaveOccurred())
+				Expect(out.String()).To(Equal("response to request"))
+			}()
+		}
+
+		done := make(chan struct{})
+		go func() { wg.Wait(); close(done) }()
+		Eventually(done, 5*time.Second).Should(BeClosed())
+	})
+
+	It("restricts the socket to its owner", func() {
+		serve()
+
+		info, err := os.Stat(socketPath)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(info.Mode().Perm()).To(Equal(os.FileMode(0600)))
+	})
+
+	It("reports when no daemon is listening", func() {
+		out := &bytes.Buffer{}
+		forwarded, err := daemon.Forward(socketPath, []byte("request"), out)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(forwarded).To(BeFalse())
+		Expect(out.Len()).To(BeZero())
+	})
+
+	It("fails when the daemon closes the connection without a response", func() {
+		listener, err := net.Listen("unix", socketPath)
+		Expect(err).ToNot(HaveOccurred())
+		defer listener.Close()
+
+		go func() {
+			conn, err := listener.Accept()
+			if err == nil {
+				conn.Close()
+			}
+		}()
+
+		forwarded, err := daemon.Forward(socketPath, []byte("request"), &bytes.Buffer{})
+		Expect(forwarded).To(BeTrue())
+		Expect(err).To(HaveOccurred())
+	})
+
+	It("keeps serving after a request panics", func() {
+		var err error
+		server, err = daemon.NewServer(socketPath, panicDispatcher{}, &fakelogger.FakeLogger{})
+		Expect(err).ToNot(HaveOccurred())
+		go func() { served <- server.Serve() }()
+
+		forwarded, err := daemon.Forward(socketPath, []byte("panic"), &bytes.Buffer{})
+		Expect(forwarded).To(BeTrue())
+		Expect(err).To(MatchError("Daemon closed the connection without a response"))
+
+		out := &bytes.Buffer{}
+		_, err = daemon.Forward(socketPath, []byte("request"), out)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(out.String()).To(Equal("request"))
+	})
+
+	It("replaces a stale socket", func() {
+		listener, err := net.ListenUnix("unix", &net.UnixAddr{Name: socketPath, Net: "unix"})
+		Expect(err).ToNot(HaveOccurred())
+		listener.SetUnlinkOnClose(false)
+		listener.Close()
+		Expect(socketPath).To(BeAnExistingFile())
+
+		dispatcher.arrived.Add(1)
+		serve()
+
+		forwarded, err := daemon.Forward(socketPath, []byte("request"), &bytes.Buffer{})
+		Expect(err).ToNot(HaveOccurred())
+		Expect(forwarded).To(BeTrue())
+	})
+
+	It("refuses to start while another daemon is listening", func() {
+		serve()
+
+		_, err := daemon.NewServer(socketPath, dispatcher, &fakelogger.FakeLogger{})
+		Expect(err).To(MatchError(ContainSubstring("Another daemon is listening")))
+	})
+
+	It("removes the socket when closed", func() {
+		serve()
+
+		Expect(server.Close()).To(Succeed())
+		Eventually(served).Should(Receive(BeNil()))
+		server = nil
+
+		Expect(socketPath).ToNot(BeAnExistingFile())
+	})
+})
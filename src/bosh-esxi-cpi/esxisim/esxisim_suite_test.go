@@ -0,0 +1,13 @@
+package esxisim_test
+
+import (
+	"testing"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+func TestEsxisim(t *testing.T) {
+	RegisterFailHandler(Fail)
+	RunSpecs(t, "Esxisim Suite")
+}
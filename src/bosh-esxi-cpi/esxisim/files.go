@@ -0,0 +1,190 @@
+package esxisim
+
+import (
+	"context"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/vmware/govmomi/object"
+	"github.com/vmware/govmomi/simulator"
+	"github.com/vmware/govmomi/vim25/methods"
+	"github.com/vmware/govmomi/vim25/soap"
+	"github.com/vmware/govmomi/vim25/types"
+)
+
+// copyDatastoreDirectory copies folders, such as a stemcell's, which govmomi's simulator can only
+// copy files of. Other copies are left to the simulator.
+func (s *Simulator) copyDatastoreDirectory(req *types.CopyDatastoreFile_Task) soap.HasFault {
+	src, ok := s.datastorePath(req.SourceName)
+	if !ok {
+		return nil
+	}
+
+	info, err := os.Stat(src)
+	if err != nil || !info.IsDir() {
+		return nil
+	}
+
+	dst, ok := s.datastorePath(req.DestinationName)
+	if !ok {
+		return nil
+	}
+
+	task := simulator.CreateTask(simulator.Map.FileManager(), "copyDatastoreFile", func(*simulator.Task) (types.AnyType, types.BaseMethodFault) {
+		if req.Force == nil || !*req.Force {
+			if _, err := os.Stat(dst); err == nil {
+				return nil, &types.FileAlreadyExists{FileFault: types.FileFault{File: req.DestinationName}}
+			}
+		}
+
+		err := copyDirectory(src, dst)
+		if err != nil {
+			return nil, &types.CannotAccessFile{FileFault: types.FileFault{File: req.DestinationName}}
+		}
+
+		return nil, nil
+	})
+
+	return &methods.CopyDatastoreFile_TaskBody{
+		Res: &types.CopyDatastoreFile_TaskResponse{Returnval: task.Run()},
+	}
+}
+
+// upgradeVM sets the hardware version, which is all ESXi changes of a VM the simulator runs
+func (s *Simulator) upgradeVM(req *types.UpgradeVM_Task) soap.HasFault {
+	vm, ok := simulator.Map.Get(req.This).(*simulator.VirtualMachine)
+	if !ok {
+		return nil
+	}
+
+	task := simulator.CreateTask(vm, "upgradeVm", func(*simulator.Task) (types.AnyType, types.BaseMethodFault) {
+		if req.Version != "" {
+			simulator.Map.WithLock(vm, func() {
+				vm.Config.Version = req.Version
+			})
+		}
+		return nil, nil
+	})
+
+	return &methods.UpgradeVM_TaskBody{
+		Res: &types.UpgradeVM_TaskResponse{Returnval: task.Run()},
+	}
+}
+
+// prepareRegistration adds the .nvram the simulator expects a VM registered under a new name to have,
+// since it names it after the VM rather than keeping the one in the folder
+func (s *Simulator) prepareRegistration(req *types.RegisterVM_Task) {
+	vmxPath, ok := s.datastorePath(req.Path)
+	if !ok {
+		return
+	}
+
+	name := req.Name
+	if name == "" {
+		name = strings.TrimSuffix(filepath.Base(vmxPath), filepath.Ext(vmxPath))
+	}
+
+	nvramPath := filepath.Join(filepath.Dir(vmxPath), name+".nvram")
+	if _, err := os.Stat(nvramPath); os.IsNotExist(err) {
+		ioutil.WriteFile(nvramPath, nil, 0600)
+	}
+}
+
+// reconfigureVM places new disks given only a datastore, such as linked clones of persistent disks, in
+// the VM's folder as ESXi does, rather than at the datastore's root. Other reconfigurations are left
+// to the simulator.
+func (s *Simulator) reconfigureVM(req *types.ReconfigVM_Task) soap.HasFault {
+	placed := false
+	for _, change := range req.Spec.DeviceChange {
+		spec := change.GetVirtualDeviceConfigSpec()
+		if spec.FileOperation != types.VirtualDeviceConfigSpecFileOperationCreate {
+			continue
+		}
+
+		disk, ok := spec.Device.(*types.VirtualDisk)
+		if !ok {
+			continue
+		}
+
+		backing, ok := disk.Backing.(types.BaseVirtualDeviceFileBackingInfo)
+		if !ok {
+			continue
+		}
+
+		info := backing.GetVirtualDeviceFileBackingInfo()
+		var p object.DatastorePath
+		if p.FromString(info.FileName) && p.Path == "" {
+			info.FileName = ""
+			placed = true
+		}
+	}
+	if !placed {
+		return nil
+	}
+
+	body := &methods.ReconfigVM_TaskBody{}
+
+	res, err := methods.ReconfigVM_Task(context.Background(), s.client, req)
+	if err != nil {
+		body.Fault_ = toFault(err)
+		return body
+	}
+
+	body.Res = res
+	return body
+}
+
+// serveDatastore serves datastore files like the simulator, but creates the folders of uploads as ESXi does
+func (s *Simulator) serveDatastore(w http.ResponseWriter, r *http.Request) {
+	if (r.Method == "PUT" || r.Method == "POST") && r.URL.Query().Get("dsName") == s.config.DatastoreName {
+		localPath := filepath.Join(s.config.DatastoreDir, filepath.FromSlash(strings.TrimPrefix(r.URL.Path, "/folder/")))
+		os.MkdirAll(filepath.Dir(localPath), 0700)
+	}
+
+	s.model.Service.ServeDatastore(w, r)
+}
+
+func copyDirectory(src, dst string) error {
+	return filepath.Walk(src, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+
+		rel, err := filepath.Rel(src, path)
+		if err != nil {
+			return err
+		}
+		target := filepath.Join(dst, rel)
+
+		if info.IsDir() {
+			return os.MkdirAll(target, 0700)
+		}
+
+		return copyFile(path, target)
+	})
+}
+
+func copyFile(src, dst string) error {
+	r, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer r.Close()
+
+	w, err := os.Create(dst)
+	if err != nil {
+		return err
+	}
+
+	_, err = io.Copy(w, r)
+	if err != nil {
+		w.Close()
+		return err
+	}
+
+	return w.Close()
+}
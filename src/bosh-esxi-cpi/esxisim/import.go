@@ -0,0 +1,460 @@
+package esxisim
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path"
+	"strconv"
+	"strings"
+
+	"github.com/vmware/govmomi/object"
+	"github.com/vmware/govmomi/ovf"
+	"github.com/vmware/govmomi/simulator"
+	"github.com/vmware/govmomi/simulator/esx"
+	"github.com/vmware/govmomi/task"
+	"github.com/vmware/govmomi/vim25/methods"
+	"github.com/vmware/govmomi/vim25/mo"
+	"github.com/vmware/govmomi/vim25/soap"
+	"github.com/vmware/govmomi/vim25/types"
+)
+
+const nfcPrefix = "/nfc/"
+
+// Devices of imported VMs get fixed keys, so the disk behind a file item can be found once the VM exists
+const (
+	controllerKeyBase = 1000
+	diskKeyBase       = 2000
+	cdromKeyBase      = 3000
+	nicKeyBase        = 4000
+)
+
+// CIM resource types of the OVF hardware items, see DSP0243
+const (
+	resourceCPU      = 3
+	resourceMemory   = 4
+	resourceIDE      = 5
+	resourceSCSI     = 6
+	resourceEthernet = 10
+	resourceCDDrive  = 15
+	resourceDVDDrive = 16
+	resourceDisk     = 17
+)
+
+// scsiControllerTypes maps OVF SCSI subtypes to the names govmomi creates controllers by
+var scsiControllerTypes = map[string]string{
+	"lsilogic":    "lsilogic",
+	"lsilogicsas": "lsilogic-sas",
+	"virtualscsi": "pvscsi",
+	"buslogic":    "buslogic",
+}
+
+// ovfManager turns the OVF of a single VM into an import spec, which govmomi's simulator cannot
+type ovfManager struct {
+	mo.OvfManager
+
+	simulator *Simulator
+}
+
+func (m *ovfManager) CreateImportSpec(req *types.CreateImportSpec) soap.HasFault {
+	body := &methods.CreateImportSpecBody{}
+
+	envelope, err := ovf.Unmarshal(strings.NewReader(req.OvfDescriptor))
+	if err != nil {
+		body.Fault_ = simulator.Fault(fmt.Sprintf("failed to parse ovf: %s", err), &types.InvalidArgument{InvalidProperty: "ovfDescriptor"})
+		return body
+	}
+
+	result, err := m.simulator.importSpec(envelope, req.Cisp)
+	if err != nil {
+		body.Fault_ = simulator.Fault(err.Error(), &types.InvalidArgument{InvalidProperty: "ovfDescriptor"})
+		return body
+	}
+
+	body.Res = &types.CreateImportSpecResponse{Returnval: *result}
+	return body
+}
+
+// importSpec builds the VM described by the OVF's hardware section. Each disk backed by a file in
+// the OVF gets a file item, whose device id names the disk's key.
+func (s *Simulator) importSpec(envelope *ovf.Envelope, params types.OvfCreateImportSpecParams) (*types.OvfCreateImportSpecResult, error) {
+	if envelope.VirtualSystem == nil || len(envelope.VirtualSystem.VirtualHardware) == 0 {
+		return nil, fmt.Errorf("ovf does not describe a virtual machine")
+	}
+
+	name := params.EntityName
+	spec := types.VirtualMachineConfigSpec{
+		Name:     name,
+		GuestId:  string(types.VirtualMachineGuestOsIdentifierOtherGuest64),
+		NumCPUs:  1,
+		MemoryMB: 512,
+		Files:    &types.VirtualMachineFileInfo{VmPathName: fmt.Sprintf("[%s]", s.config.DatastoreName)},
+	}
+
+	if systems := envelope.VirtualSystem.OperatingSystem; len(systems) > 0 && systems[0].OSType != nil {
+		spec.GuestId = *systems[0].OSType
+	}
+
+	files := map[string]ovf.File{}
+	for _, file := range envelope.References {
+		files[file.ID] = file
+	}
+
+	disks := map[string]ovf.VirtualDiskDesc{}
+	if envelope.Disk != nil {
+		for _, disk := range envelope.Disk.Disks {
+			disks[disk.DiskID] = disk
+		}
+	}
+
+	// the host's IDE controllers, which every VM has, stand in for those of the OVF
+	defaults := object.VirtualDeviceList(esx.VirtualDevice)
+	ideControllers := defaults.SelectByType((*types.VirtualIDEController)(nil))
+
+	var result types.OvfCreateImportSpecResult
+	var devices object.VirtualDeviceList
+	controllers := map[string]types.BaseVirtualDevice{}
+	capacityKB := int64(0)
+
+	for _, item := range envelope.VirtualSystem.VirtualHardware[0].Item {
+		if item.ResourceType == nil {
+			continue
+		}
+
+		switch *item.ResourceType {
+		case resourceCPU:
+			if item.VirtualQuantity != nil {
+				spec.NumCPUs = int32(*item.VirtualQuantity)
+			}
+
+		case resourceMemory:
+			if item.VirtualQuantity != nil {
+				spec.MemoryMB = int64(*item.VirtualQuantity)
+			}
+
+		case resourceIDE:
+			if len(ideControllers) > 0 {
+				controllers[item.InstanceID] = ideControllers[0]
+				ideControllers = ideControllers[1:]
+			}
+
+		case resourceSCSI:
+			controllerType := "lsilogic"
+			if item.ResourceSubType != nil {
+				if t, found := scsiControllerTypes[strings.ToLower(*item.ResourceSubType)]; found {
+					controllerType = t
+				}
+			}
+
+			controller, err := devices.CreateSCSIController(controllerType)
+			if err != nil {
+				return nil, err
+			}
+			controller.GetVirtualDevice().Key = controllerKeyBase + int32(len(controllers))
+			controllers[item.InstanceID] = controller
+			devices = append(devices, controller)
+
+		case resourceEthernet:
+			adapterType := "e1000"
+			if item.ResourceSubType != nil {
+				adapterType = strings.ToLower(*item.ResourceSubType)
+			}
+
+			network := "VM Network"
+			if len(item.Connection) > 0 {
+				network = mappedNetwork(item.Connection[0], params.NetworkMapping, network)
+			}
+
+			nic, err := devices.CreateEthernetCard(adapterType, &types.VirtualEthernetCardNetworkBackingInfo{
+				VirtualDeviceDeviceBackingInfo: types.VirtualDeviceDeviceBackingInfo{DeviceName: network},
+			})
+			if err != nil {
+				return nil, err
+			}
+			nic.GetVirtualDevice().Key = nicKeyBase + int32(len(devices.SelectByType((*types.VirtualEthernetCard)(nil))))
+			devices = append(devices, nic)
+
+		case resourceCDDrive, resourceDVDDrive:
+			controller, ok := controllerOf(item, controllers).(*types.VirtualIDEController)
+			if !ok {
+				continue
+			}
+
+			cdrom, err := append(defaults, devices...).CreateCdrom(controller)
+			if err != nil {
+				return nil, err
+			}
+			cdrom.Key = cdromKeyBase + int32(len(devices.SelectByType((*types.VirtualCdrom)(nil))))
+			devices = append(devices, cdrom)
+
+		case resourceDisk:
+			controller, ok := controllerOf(item, controllers).(types.BaseVirtualController)
+			if !ok || len(item.HostResource) == 0 {
+				return nil, fmt.Errorf("disk '%s' is not attached to a controller", item.ElementName)
+			}
+
+			n := len(devices.SelectByType((*types.VirtualDisk)(nil)))
+			disk := disks[path.Base(item.HostResource[0])]
+
+			fileName := fmt.Sprintf("%s_%d.vmdk", name, n)
+			var file *ovf.File
+			if disk.FileRef != nil {
+				if f, found := files[*disk.FileRef]; found {
+					file = &f
+					fileName = path.Base(f.Href)
+				}
+			}
+
+			device := append(defaults, devices...).CreateDisk(controller, s.datastoreRef, fmt.Sprintf("[%s] %s/%s", s.config.DatastoreName, name, fileName))
+			device.Key = diskKeyBase + int32(n)
+			device.CapacityInKB = diskCapacity(disk) / 1024
+			capacityKB += device.CapacityInKB
+			devices = append(devices, device)
+
+			if file != nil {
+				result.FileItem = append(result.FileItem, types.OvfFileItem{
+					DeviceId: importKey(name, device.Key),
+					Path:     file.Href,
+					Size:     int64(file.Size),
+				})
+			}
+		}
+	}
+
+	for _, device := range devices {
+		operation := types.VirtualDeviceConfigSpecFileOperation("")
+		if _, ok := device.(*types.VirtualDisk); ok {
+			operation = types.VirtualDeviceConfigSpecFileOperationCreate
+		}
+
+		spec.DeviceChange = append(spec.DeviceChange, &types.VirtualDeviceConfigSpec{
+			Operation:     types.VirtualDeviceConfigSpecOperationAdd,
+			FileOperation: operation,
+			Device:        device,
+		})
+	}
+
+	result.ImportSpec = &types.VirtualMachineImportSpec{ConfigSpec: spec}
+	return &result, nil
+}
+
+// importVApp creates the VM of an import spec and leases its disks for upload
+func (s *Simulator) importVApp(req *types.ImportVApp) soap.HasFault {
+	body := &methods.ImportVAppBody{}
+
+	spec, ok := req.Spec.(*types.VirtualMachineImportSpec)
+	if !ok {
+		body.Fault_ = simulator.Fault("only virtual machines can be imported", &types.InvalidArgument{InvalidProperty: "spec"})
+		return body
+	}
+
+	folderRef := simulator.Map.Any("Datacenter").(*simulator.Datacenter).VmFolder
+	if req.Folder != nil {
+		folderRef = *req.Folder
+	}
+
+	var host *object.HostSystem
+	if req.Host != nil {
+		host = object.NewHostSystem(s.client, *req.Host)
+	}
+
+	ctx := context.Background()
+
+	createTask, err := object.NewFolder(s.client, folderRef).CreateVM(ctx, spec.ConfigSpec, object.NewResourcePool(s.client, req.This), host)
+	if err == nil {
+		var info *types.TaskInfo
+		info, err = createTask.WaitForResult(ctx, nil)
+		if err == nil {
+			vmRef := info.Result.(types.ManagedObjectReference)
+
+			s.mutex.Lock()
+			s.known[vmRef] = true
+			s.mutex.Unlock()
+
+			lease := s.newLease(vmRef)
+			body.Res = &types.ImportVAppResponse{Returnval: lease.Self}
+			return body
+		}
+	}
+
+	body.Fault_ = toFault(err)
+	return body
+}
+
+// httpNfcLease accepts the uploads of an imported VM's disks at /nfc/<lease>/<disk key>
+type httpNfcLease struct {
+	mo.HttpNfcLease
+
+	simulator *Simulator
+	files     map[string]string
+}
+
+func (s *Simulator) newLease(vmRef types.ManagedObjectReference) *httpNfcLease {
+	vm := simulator.Map.Get(vmRef).(*simulator.VirtualMachine)
+
+	lease := &httpNfcLease{simulator: s, files: map[string]string{}}
+	lease.Self = types.ManagedObjectReference{Type: "HttpNfcLease", Value: "nfc-lease-" + vmRef.Value}
+	lease.State = types.HttpNfcLeaseStateReady
+	lease.Info = &types.HttpNfcLeaseInfo{Lease: lease.Self, Entity: vmRef, LeaseTimeout: 300}
+
+	simulator.Map.WithLock(vm, func() {
+		for _, device := range object.VirtualDeviceList(vm.Config.Hardware.Device).SelectByType((*types.VirtualDisk)(nil)) {
+			disk := device.(*types.VirtualDisk)
+			backing, ok := disk.Backing.(types.BaseVirtualDeviceFileBackingInfo)
+			if !ok {
+				continue
+			}
+
+			localPath, ok := s.datastorePath(backing.GetVirtualDeviceFileBackingInfo().FileName)
+			if !ok {
+				continue
+			}
+
+			key := strconv.Itoa(int(disk.Key))
+			lease.files[key] = localPath
+			lease.Info.TotalDiskCapacityInKB += disk.CapacityInKB
+			lease.Info.DeviceUrl = append(lease.Info.DeviceUrl, types.HttpNfcLeaseDeviceUrl{
+				Key:       key,
+				ImportKey: importKey(vm.Name, disk.Key),
+				Url:       fmt.Sprintf("https://*%s%s/%s", nfcPrefix, lease.Self.Value, key),
+				Disk:      types.NewBool(true),
+				TargetId:  path.Base(localPath),
+			})
+		}
+	})
+
+	simulator.Map.Put(lease)
+	return lease
+}
+
+func (l *httpNfcLease) HttpNfcLeaseProgress(req *types.HttpNfcLeaseProgress) soap.HasFault {
+	l.InitializeProgress = req.Percent
+	return &methods.HttpNfcLeaseProgressBody{Res: &types.HttpNfcLeaseProgressResponse{}}
+}
+
+func (l *httpNfcLease) HttpNfcLeaseComplete(req *types.HttpNfcLeaseComplete) soap.HasFault {
+	l.State = types.HttpNfcLeaseStateDone
+	simulator.Map.Remove(l.Self)
+	return &methods.HttpNfcLeaseCompleteBody{Res: &types.HttpNfcLeaseCompleteResponse{}}
+}
+
+// HttpNfcLeaseAbort removes the partially imported VM, as ESXi does
+func (l *httpNfcLease) HttpNfcLeaseAbort(req *types.HttpNfcLeaseAbort) soap.HasFault {
+	body := &methods.HttpNfcLeaseAbortBody{}
+
+	l.State = types.HttpNfcLeaseStateError
+	l.Error = req.Fault
+	simulator.Map.Remove(l.Self)
+
+	ctx := context.Background()
+	destroyTask, err := object.NewVirtualMachine(l.simulator.client, l.Info.Entity).Destroy(ctx)
+	if err == nil {
+		err = destroyTask.Wait(ctx)
+	}
+	if err != nil {
+		body.Fault_ = toFault(err)
+		return body
+	}
+
+	body.Res = &types.HttpNfcLeaseAbortResponse{}
+	return body
+}
+
+// serveNFC writes an uploaded disk over the disk the import created
+func (s *Simulator) serveNFC(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "PUT" && r.Method != "POST" {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+
+	parts := strings.Split(strings.TrimPrefix(r.URL.Path, nfcPrefix), "/")
+	if len(parts) != 2 {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+
+	lease, ok := simulator.Map.Get(types.ManagedObjectReference{Type: "HttpNfcLease", Value: parts[0]}).(*httpNfcLease)
+	if !ok {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+
+	localPath, found := lease.files[parts[1]]
+	if !found {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+
+	file, err := os.Create(localPath)
+	if err != nil {
+		s.logger.Error(logTag, "Creating uploaded disk '%s': %s", localPath, err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	defer file.Close()
+
+	_, err = io.Copy(file, r.Body)
+	if err != nil {
+		s.logger.Error(logTag, "Writing uploaded disk '%s': %s", localPath, err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+}
+
+func importKey(vmName string, diskKey int32) string {
+	return fmt.Sprintf("/%s/disk-%d", vmName, diskKey)
+}
+
+func controllerOf(item ovf.ResourceAllocationSettingData, controllers map[string]types.BaseVirtualDevice) types.BaseVirtualDevice {
+	if item.Parent == nil {
+		return nil
+	}
+	return controllers[*item.Parent]
+}
+
+// mappedNetwork returns the name of the network an OVF network is mapped to
+func mappedNetwork(name string, mapping []types.OvfNetworkMapping, fallback string) string {
+	for _, m := range mapping {
+		if m.Name != name {
+			continue
+		}
+		if network, ok := simulator.Map.Get(m.Network).(mo.Entity); ok {
+			return network.Entity().Name
+		}
+	}
+	return fallback
+}
+
+// diskCapacity returns the capacity of an OVF disk in bytes, e.g. given the units "byte * 2^30"
+func diskCapacity(disk ovf.VirtualDiskDesc) int64 {
+	capacity, err := strconv.ParseInt(disk.Capacity, 10, 64)
+	if err != nil {
+		return 0
+	}
+
+	if disk.CapacityAllocationUnits != nil {
+		units := strings.Replace(*disk.CapacityAllocationUnits, " ", "", -1)
+		if strings.HasPrefix(units, "byte*2^") {
+			if exponent, err := strconv.Atoi(strings.TrimPrefix(units, "byte*2^")); err == nil {
+				capacity <<= uint(exponent)
+			}
+		}
+	}
+
+	return capacity
+}
+
+// toFault returns the fault of a failed task, or a system error
+func toFault(err error) *soap.Fault {
+	if taskErr, ok := err.(task.Error); ok {
+		return simulator.Fault(err.Error(), taskErr.Fault())
+	}
+	if soap.IsSoapFault(err) {
+		return soap.ToSoapFault(err)
+	}
+	return simulator.Fault(err.Error(), &types.SystemError{Reason: err.Error()})
+}
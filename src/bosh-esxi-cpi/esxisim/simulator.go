@@ -0,0 +1,385 @@
+package esxisim
+
+import (
+	"bytes"
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"io/ioutil"
+	"math/big"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	bosherr "github.com/cloudfoundry/bosh-utils/errors"
+	boshlog "github.com/cloudfoundry/bosh-utils/logger"
+	"github.com/vmware/govmomi/object"
+	"github.com/vmware/govmomi/simulator"
+	"github.com/vmware/govmomi/vim25"
+	"github.com/vmware/govmomi/vim25/mo"
+	"github.com/vmware/govmomi/vim25/soap"
+	"github.com/vmware/govmomi/vim25/types"
+	"github.com/vmware/govmomi/vim25/xml"
+)
+
+const logTag = "esxi-sim"
+
+// Config describes the host to simulate
+type Config struct {
+	// Address is the address to serve the SDK on over HTTPS, e.g. 127.0.0.1:8989
+	Address string
+
+	// DatastoreName is the name of the host's only datastore, which lives in DatastoreDir
+	DatastoreName string
+	DatastoreDir  string
+
+	// StatePath is the file the inventory is kept in across restarts
+	StatePath string
+
+	// PortGroups are added to the host's standard switches unless they exist
+	PortGroups []PortGroup
+}
+
+// PortGroup is a port group on a standard switch, which is created along with the port group if needed
+type PortGroup struct {
+	Name    string `json:"name"`
+	VSwitch string `json:"vswitch"`
+	VLAN    int32  `json:"vlan"`
+}
+
+// Simulator serves a standalone ESXi host built on govmomi's simulator, extended with what the CPI
+// relies on: OVF imports, copying VM folders, registering VMs from their .vmx and port group VLANs.
+// VMs keep their hardware in their .vmx, and the inventory is saved after each request, so VMs and
+// port groups survive restarts. govmomi keeps the inventory in a global registry, so only one
+// Simulator can run in a process.
+type Simulator struct {
+	config Config
+	logger boshlog.Logger
+
+	model    *simulator.Model
+	client   *vim25.Client
+	listener net.Listener
+	server   *http.Server
+	url      *url.URL
+
+	mutex        sync.Mutex
+	known        map[types.ManagedObjectReference]bool
+	written      map[string][]byte
+	datastoreRef types.ManagedObjectReference
+}
+
+// New builds the host, restores the inventory saved in the state file and listens on the address
+func New(config Config, logger boshlog.Logger) (*Simulator, error) {
+	if config.DatastoreName == "" {
+		config.DatastoreName = "datastore1"
+	}
+
+	datastoreDir, err := filepath.Abs(config.DatastoreDir)
+	if err != nil {
+		return nil, bosherr.WrapErrorf(err, "Resolving datastore directory '%s'", config.DatastoreDir)
+	}
+	config.DatastoreDir = datastoreDir
+
+	err = os.MkdirAll(config.DatastoreDir, 0700)
+	if err != nil {
+		return nil, bosherr.WrapErrorf(err, "Creating datastore directory '%s'", config.DatastoreDir)
+	}
+
+	s := &Simulator{
+		config:  config,
+		logger:  logger,
+		known:   map[types.ManagedObjectReference]bool{},
+		written: map[string][]byte{},
+	}
+
+	err = s.build()
+	if err != nil {
+		return nil, err
+	}
+
+	err = s.listen()
+	if err != nil {
+		return nil, err
+	}
+
+	err = s.restore()
+	if err != nil {
+		s.listener.Close()
+		return nil, err
+	}
+
+	return s, nil
+}
+
+// URL is the SDK URL of the host, with credentials the simulator accepts
+func (s *Simulator) URL() *url.URL {
+	u := *s.url
+	return &u
+}
+
+// Serve handles requests until Close is called, which makes it return nil
+func (s *Simulator) Serve() error {
+	err := s.server.Serve(tls.NewListener(s.listener, s.server.TLSConfig))
+	if err == http.ErrServerClosed {
+		return nil
+	}
+	return err
+}
+
+// Close stops serving requests. The inventory was saved after the last change.
+func (s *Simulator) Close() error {
+	err := s.server.Close()
+	s.listener.Close()
+	return err
+}
+
+// build creates the host and its datastore, without the VMs govmomi's ESX model comes with
+func (s *Simulator) build() error {
+	model := simulator.ESX()
+	model.Datastore = 0
+	model.Machine = 0
+
+	err := model.Create()
+	if err != nil {
+		return bosherr.WrapError(err, "Creating host")
+	}
+	s.model = model
+
+	ctx := context.Background()
+
+	s.client, err = vim25.NewClient(ctx, model.Service)
+	if err != nil {
+		return bosherr.WrapError(err, "Connecting to host")
+	}
+
+	host := s.host()
+	datastoreSystem := object.NewHostDatastoreSystem(s.client, *host.ConfigManager.DatastoreSystem)
+
+	datastore, err := datastoreSystem.CreateLocalDatastore(ctx, s.config.DatastoreName, s.config.DatastoreDir)
+	if err != nil {
+		return bosherr.WrapErrorf(err, "Creating datastore '%s'", s.config.DatastoreName)
+	}
+	s.datastoreRef = datastore.Reference()
+
+	// the host's only port group, which govmomi's host network system leaves out of its network info
+	s.recordPortGroup(types.HostPortGroupSpec{Name: "VM Network", VswitchName: "vSwitch0"})
+
+	simulator.Map.Put(&ovfManager{OvfManager: mo.OvfManager{Self: *s.model.ServiceContent.OvfManager}, simulator: s})
+
+	return nil
+}
+
+func (s *Simulator) listen() error {
+	listener, err := net.Listen("tcp", s.config.Address)
+	if err != nil {
+		return bosherr.WrapErrorf(err, "Listening on '%s'", s.config.Address)
+	}
+	s.listener = listener
+
+	certificate, err := newCertificate()
+	if err != nil {
+		listener.Close()
+		return err
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/sdk", s.serveSDK)
+	mux.HandleFunc("/sdk/vimServiceVersions.xml", s.model.Service.ServiceVersions)
+	mux.HandleFunc("/folder/", s.serveDatastore)
+	mux.HandleFunc(nfcPrefix, s.serveNFC)
+
+	s.server = &http.Server{
+		Handler:   mux,
+		TLSConfig: &tls.Config{Certificates: []tls.Certificate{certificate}},
+	}
+
+	s.url = &url.URL{
+		Scheme: "https",
+		Host:   listener.Addr().String(),
+		Path:   "/sdk",
+		User:   url.UserPassword("root", "password"),
+	}
+
+	// hands out URLs for datastore files on this server rather than the host's name
+	simulator.Map.SessionManager().ServiceHostName = s.url.Host
+
+	return nil
+}
+
+// serveSDK serves the methods the simulator lacks itself, and otherwise passes the request on to
+// the simulator. The response is held back until the inventory caught up with the request.
+func (s *Simulator) serveSDK(w http.ResponseWriter, r *http.Request) {
+	body, err := ioutil.ReadAll(r.Body)
+	r.Body.Close()
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+
+	method, err := simulator.UnmarshalBody(body)
+	if err != nil {
+		method = nil
+	}
+
+	if method != nil {
+		if res := s.intercept(method); res != nil {
+			s.sync(method, res.Fault() == nil)
+			writeResponse(w, res)
+			return
+		}
+	}
+
+	r.Body = ioutil.NopCloser(bytes.NewReader(body))
+	recorder := httptest.NewRecorder()
+	s.model.Service.ServeSDK(recorder, r)
+
+	if method != nil {
+		s.sync(method, recorder.Code == http.StatusOK)
+	}
+
+	for name, values := range recorder.HeaderMap {
+		w.Header()[name] = values
+	}
+	w.WriteHeader(recorder.Code)
+	w.Write(recorder.Body.Bytes())
+}
+
+// intercept serves the methods the simulator lacks or which do not behave like ESXi for the CPI,
+// and returns nil for the simulator to serve the others
+func (s *Simulator) intercept(method *simulator.Method) soap.HasFault {
+	switch req := method.Body.(type) {
+	case *types.ImportVApp:
+		return s.importVApp(req)
+	case *types.CopyDatastoreFile_Task:
+		return s.copyDatastoreDirectory(req)
+	case *types.UpgradeVM_Task:
+		return s.upgradeVM(req)
+	case *types.RegisterVM_Task:
+		s.prepareRegistration(req)
+	case *types.ReconfigVM_Task:
+		return s.reconfigureVM(req)
+	}
+	return nil
+}
+
+// sync loads the .vmx of VMs registered by the request and saves the inventory
+func (s *Simulator) sync(method *simulator.Method, succeeded bool) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if succeeded {
+		switch req := method.Body.(type) {
+		case *types.RegisterVM_Task:
+			s.loadRegisteredVMs()
+		case *types.AddPortGroup:
+			s.recordPortGroup(req.Portgrp)
+		case *types.RemovePortGroup:
+			s.forgetPortGroup(req.PgName)
+		}
+	}
+
+	err := s.save()
+	if err != nil {
+		s.logger.Error(logTag, "Saving inventory after %s: %s", method.Name, err)
+	}
+}
+
+func (s *Simulator) host() *simulator.HostSystem {
+	return simulator.Map.Any("HostSystem").(*simulator.HostSystem)
+}
+
+func (s *Simulator) networkSystem() *simulator.HostNetworkSystem {
+	return simulator.Map.Get(*s.host().ConfigManager.NetworkSystem).(*simulator.HostNetworkSystem)
+}
+
+// datastorePath returns the local path of a datastore path such as "[datastore1] vm/vm.vmx"
+func (s *Simulator) datastorePath(name string) (string, bool) {
+	var p object.DatastorePath
+	if !p.FromString(name) || p.Datastore != s.config.DatastoreName {
+		return "", false
+	}
+	return filepath.Join(s.config.DatastoreDir, filepath.FromSlash(p.Path)), true
+}
+
+// soapEnvelope and soapFault encode responses like the simulator does
+type soapEnvelope struct {
+	XMLName xml.Name    `xml:"soapenv:Envelope"`
+	Enc     string      `xml:"xmlns:soapenc,attr"`
+	Env     string      `xml:"xmlns:soapenv,attr"`
+	XSD     string      `xml:"xmlns:xsd,attr"`
+	XSI     string      `xml:"xmlns:xsi,attr"`
+	Body    interface{} `xml:"soapenv:Body"`
+}
+
+type soapFault struct {
+	XMLName xml.Name `xml:"soapenv:Fault"`
+	Code    string   `xml:"faultcode"`
+	String  string   `xml:"faultstring"`
+	Detail  struct {
+		Fault types.AnyType `xml:",any,typeattr"`
+	} `xml:"detail"`
+}
+
+func writeResponse(w http.ResponseWriter, res soap.HasFault) {
+	var body interface{} = res
+
+	status := http.StatusOK
+	if f := res.Fault(); f != nil {
+		status = http.StatusInternalServerError
+
+		fault := &soapFault{Code: f.Code, String: f.String}
+		fault.Detail.Fault = f.Detail.Fault
+		body = struct{ Fault *soapFault }{fault}
+	}
+
+	var out bytes.Buffer
+	out.WriteString(xml.Header)
+	err := xml.NewEncoder(&out).Encode(&soapEnvelope{
+		Enc:  "http://schemas.xmlsoap.org/soap/encoding/",
+		Env:  "http://schemas.xmlsoap.org/soap/envelope/",
+		XSD:  "http://www.w3.org/2001/XMLSchema",
+		XSI:  "http://www.w3.org/2001/XMLSchema-instance",
+		Body: body,
+	})
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/xml")
+	w.WriteHeader(status)
+	w.Write(out.Bytes())
+}
+
+// newCertificate returns a self-signed certificate; the CPI does not verify the host's
+func newCertificate() (tls.Certificate, error) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return tls.Certificate{}, bosherr.WrapError(err, "Generating key")
+	}
+
+	template := x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: "esxi-sim"},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(10 * 365 * 24 * time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature | x509.KeyUsageKeyEncipherment,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, &template, &template, &key.PublicKey, key)
+	if err != nil {
+		return tls.Certificate{}, bosherr.WrapError(err, "Creating certificate")
+	}
+
+	return tls.Certificate{Certificate: [][]byte{der}, PrivateKey: key}, nil
+}
@@ -0,0 +1,190 @@
+package esxisim_test
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+
+	"github.com/mholt/archiver"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	fakelogger "github.com/cloudfoundry/bosh-utils/logger/loggerfakes"
+
+	"bosh-esxi-cpi/esxisim"
+	"bosh-esxi-cpi/govc"
+	fakegovc "bosh-esxi-cpi/govc/fakes"
+	"bosh-esxi-cpi/vm"
+)
+
+var _ = Describe("Simulator", func() {
+	var (
+		tempDir   string
+		imagePath string
+		config    esxisim.Config
+		sim       *esxisim.Simulator
+		runner    govc.GovcRunner
+		client    govc.GovcClient
+	)
+
+	start := func() {
+		var err error
+		sim, err = esxisim.New(config, &fakelogger.FakeLogger{})
+		Expect(err).ToNot(HaveOccurred())
+		go sim.Serve()
+
+		u := sim.URL()
+		u.Path = ""
+
+		govcConfig := &fakegovc.FakeGovcConfig{}
+		govcConfig.EsxUrlReturns(u.String())
+		govcConfig.DatacenterReturns("ha-datacenter")
+
+		runner = govc.NewGovcRunner(&fakelogger.FakeLogger{})
+		client = govc.NewClient(runner, govcConfig, &fakelogger.FakeLogger{})
+	}
+
+	// deviceFiles returns the files backing the VM's devices by device name
+	deviceFiles := func(vmName string) map[string]string {
+		output, err := runner.CliCommand("device.info", map[string]string{"json": "true", "vm": vmName, "u": sim.URL().String(), "k": "true"}, nil)
+		Expect(err).ToNot(HaveOccurred())
+
+		var response struct {
+			Devices []struct {
+				Name    string
+				Backing struct{ FileName string }
+			}
+		}
+		Expect(json.Unmarshal([]byte(output), &response)).To(Succeed())
+
+		files := map[string]string{}
+		for _, device := range response.Devices {
+			files[device.Name] = device.Backing.FileName
+		}
+		return files
+	}
+
+	// hardware returns the VM's hardware settings which have a config spec field of their own
+	hardware := func(vmName string) map[string]interface{} {
+		output, err := runner.CliCommand("vm.info", map[string]string{"json": "true", "u": sim.URL().String(), "k": "true"}, []string{vmName})
+		Expect(err).ToNot(HaveOccurred())
+
+		var response struct {
+			VirtualMachines []struct {
+				Config struct {
+					Firmware            string
+					CpuHotAddEnabled    bool
+					MemoryHotAddEnabled bool
+					Hardware            struct{ NumCPU, NumCoresPerSocket int }
+				}
+			}
+		}
+		Expect(json.Unmarshal([]byte(output), &response)).To(Succeed())
+		Expect(response.VirtualMachines).To(HaveLen(1))
+
+		config := response.VirtualMachines[0].Config
+		return map[string]interface{}{
+			"firmware":         config.Firmware,
+			"cpu_hot_add":      config.CpuHotAddEnabled,
+			"memory_hot_add":   config.MemoryHotAddEnabled,
+			"cpu":              config.Hardware.NumCPU,
+			"cores_per_socket": config.Hardware.NumCoresPerSocket,
+		}
+	}
+
+	BeforeEach(func() {
+		var err error
+		tempDir, err = ioutil.TempDir("", "esxisim-")
+		Expect(err).ToNot(HaveOccurred())
+
+		imagePath = filepath.Join(tempDir, "image")
+		Expect(archiver.TarGz.Make(imagePath, []string{"../test/fixtures/test.ovf", "../test/fixtures/test.vmdk"})).To(Succeed())
+
+		config = esxisim.Config{
+			Address:      "127.0.0.1:0",
+			DatastoreDir: filepath.Join(tempDir, "datastore1"),
+			StatePath:    filepath.Join(tempDir, "datastore1.json"),
+			PortGroups:   []esxisim.PortGroup{{Name: "bosh", VSwitch: "vSwitch1", VLAN: 10}},
+		}
+
+		start()
+	})
+
+	AfterEach(func() {
+		sim.Close()
+		os.RemoveAll(tempDir)
+	})
+
+	It("imports stemcells, and clones, configures and starts VMs, which survive a restart", func() {
+		_, err := client.ImportStemcell(imagePath, "cs-stemcell")
+		Expect(err).ToNot(HaveOccurred())
+
+		uploaded, err := ioutil.ReadFile(filepath.Join(config.DatastoreDir, "cs-stemcell", "test.vmdk"))
+		Expect(err).ToNot(HaveOccurred())
+		fixture, err := ioutil.ReadFile("../test/fixtures/test.vmdk")
+		Expect(err).ToNot(HaveOccurred())
+		Expect(uploaded).To(Equal(fixture))
+
+		_, err = client.CloneVM("cs-stemcell", "vm-1", "")
+		Expect(err).ToNot(HaveOccurred())
+		Expect(deviceFiles("vm-1")).To(HaveKeyWithValue("disk-1000-0", "[datastore1] vm-1/test.vmdk"))
+
+		Expect(client.EnsurePortGroup("bosh", "vSwitch1", 10)).To(BeFalse())
+		Expect(client.EnsurePortGroup("bosh-private", "vSwitch0", 20)).To(BeTrue())
+
+		err = client.ConfigureVM("vm-1", govc.VMSpec{
+			Props:      vm.VMProps{CPU: 2, RAM: 1024, Disk: 2048, CoresPerSocket: 2, CPUHotAdd: true, Firmware: vm.FirmwareEFI},
+			NICs:       []govc.NIC{{Network: "bosh-private", MACAddress: "00:50:56:3f:00:00", AdapterType: "vmxnet3"}},
+			EnvIsoPath: "../test/fixtures/env.iso",
+		})
+		Expect(err).ToNot(HaveOccurred())
+
+		Expect(client.CreateDisk("disk-1", 16)).To(Succeed())
+		Expect(client.AttachDisk("vm-1", "disk-1")).To(Succeed())
+
+		_, err = client.StartVM("vm-1")
+		Expect(err).ToNot(HaveOccurred())
+
+		devices := deviceFiles("vm-1")
+		Expect(hardware("vm-1")).To(Equal(map[string]interface{}{
+			"firmware":         "efi",
+			"cpu_hot_add":      true,
+			"memory_hot_add":   false,
+			"cpu":              2,
+			"cores_per_socket": 2,
+		}))
+		configured := hardware("vm-1")
+
+		Expect(sim.Close()).To(Succeed())
+		start()
+
+		Expect(client.HasVM("cs-stemcell")).To(BeTrue())
+		Expect(client.HasVM("vm-1")).To(BeTrue())
+		Expect(deviceFiles("vm-1")).To(Equal(devices))
+		Expect(hardware("vm-1")).To(Equal(configured))
+		Expect(client.EnsurePortGroup("bosh-private", "vSwitch0", 20)).To(BeFalse())
+
+		Expect(client.DetachDisk("vm-1", "disk-1")).To(Succeed())
+		_, err = client.DestroyVM("vm-1")
+		Expect(err).ToNot(HaveOccurred())
+		Expect(client.HasVM("vm-1")).To(BeFalse())
+		Expect(client.DestroyDisk("disk-1")).To(Succeed())
+
+		Expect(sim.Close()).To(Succeed())
+		start()
+
+		Expect(client.ListVMs()).To(Equal([]string{"cs-stemcell"}))
+	})
+
+	It("keeps the port groups it was configured with, with their VLAN", func() {
+		output, err := runner.CliCommand("host.portgroup.info", map[string]string{"json": "true", "u": sim.URL().String(), "k": "true"}, nil)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(output).To(ContainSubstring(`"VlanId":10`))
+		Expect(output).To(ContainSubstring(`"VswitchName":"vSwitch1"`))
+
+		_, err = client.EnsurePortGroup("bosh", "vSwitch1", 20)
+		Expect(err).To(MatchError(ContainSubstring(fmt.Sprintf("port group '%s' already exists on vswitch '%s' with VLAN 10", "bosh", "vSwitch1"))))
+	})
+})
@@ -0,0 +1,262 @@
+package esxisim
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+
+	bosherr "github.com/cloudfoundry/bosh-utils/errors"
+	"github.com/vmware/govmomi/object"
+	"github.com/vmware/govmomi/simulator"
+	"github.com/vmware/govmomi/simulator/esx"
+	"github.com/vmware/govmomi/vim25/types"
+)
+
+// state is the inventory kept in the state file. The hardware of each VM is kept in its .vmx.
+type state struct {
+	VMs        []vmState   `json:"vms"`
+	PortGroups []PortGroup `json:"port_groups"`
+}
+
+type vmState struct {
+	Name       string `json:"name"`
+	Path       string `json:"path"`
+	PowerState string `json:"power_state"`
+}
+
+// save writes the .vmx of each VM and the inventory, unless it did not change
+func (s *Simulator) save() error {
+	var current state
+
+	for _, ref := range s.vms() {
+		vm, ok := simulator.Map.Get(ref).(*simulator.VirtualMachine)
+		if !ok {
+			continue
+		}
+
+		err := s.writeVMX(vm)
+		if err != nil {
+			return err
+		}
+
+		simulator.Map.WithLock(vm, func() {
+			current.VMs = append(current.VMs, vmState{
+				Name:       vm.Name,
+				Path:       vm.Config.Files.VmPathName,
+				PowerState: string(vm.Runtime.PowerState),
+			})
+		})
+	}
+
+	networkSystem := s.networkSystem()
+	simulator.Map.WithLock(networkSystem, func() {
+		for _, pg := range networkSystem.NetworkInfo.Portgroup {
+			current.PortGroups = append(current.PortGroups, PortGroup{
+				Name:    pg.Spec.Name,
+				VSwitch: pg.Spec.VswitchName,
+				VLAN:    pg.Spec.VlanId,
+			})
+		}
+	})
+
+	if s.config.StatePath == "" {
+		return nil
+	}
+
+	contents, err := json.MarshalIndent(current, "", "  ")
+	if err != nil {
+		return bosherr.WrapError(err, "Marshalling inventory")
+	}
+
+	if bytes.Equal(s.written[s.config.StatePath], contents) {
+		return nil
+	}
+
+	err = writeFileAtomically(s.config.StatePath, contents)
+	if err != nil {
+		return bosherr.WrapErrorf(err, "Writing inventory to '%s'", s.config.StatePath)
+	}
+
+	s.written[s.config.StatePath] = contents
+	return nil
+}
+
+// restore registers the VMs and adds the port groups of the state file, then adds the configured
+// port groups the host lacks
+func (s *Simulator) restore() error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	var saved state
+
+	if s.config.StatePath != "" {
+		contents, err := ioutil.ReadFile(s.config.StatePath)
+		if err != nil && !os.IsNotExist(err) {
+			return bosherr.WrapErrorf(err, "Reading inventory from '%s'", s.config.StatePath)
+		}
+
+		if err == nil {
+			err = json.Unmarshal(contents, &saved)
+			if err != nil {
+				return bosherr.WrapErrorf(err, "Unmarshalling inventory from '%s'", s.config.StatePath)
+			}
+		}
+	}
+
+	for _, pg := range append(saved.PortGroups, s.config.PortGroups...) {
+		err := s.addPortGroup(pg)
+		if err != nil {
+			return err
+		}
+	}
+
+	for _, vm := range saved.VMs {
+		err := s.registerVM(vm)
+		if err != nil {
+			s.logger.Error(logTag, "Restoring VM '%s': %s", vm.Name, err)
+		}
+	}
+
+	return s.save()
+}
+
+func (s *Simulator) registerVM(saved vmState) error {
+	ctx := context.Background()
+
+	task, err := object.NewFolder(s.client, esx.Datacenter.VmFolder).RegisterVM(ctx, saved.Path, saved.Name, false, object.NewResourcePool(s.client, esx.ResourcePool.Self), nil)
+	if err != nil {
+		return err
+	}
+
+	info, err := task.WaitForResult(ctx, nil)
+	if err != nil {
+		return err
+	}
+
+	ref := info.Result.(types.ManagedObjectReference)
+	s.known[ref] = true
+
+	err = s.loadVMX(ref)
+	if err != nil {
+		return err
+	}
+
+	if saved.PowerState != string(types.VirtualMachinePowerStatePoweredOn) {
+		return nil
+	}
+
+	task, err = object.NewVirtualMachine(s.client, ref).PowerOn(ctx)
+	if err != nil {
+		return err
+	}
+
+	return task.Wait(ctx)
+}
+
+// addPortGroup adds a port group unless the host has it, and its switch unless the host has that
+func (s *Simulator) addPortGroup(pg PortGroup) error {
+	if pg.VSwitch == "" {
+		pg.VSwitch = "vSwitch0"
+	}
+
+	networkSystem := s.networkSystem()
+
+	var hasPortGroup, hasVSwitch bool
+	simulator.Map.WithLock(networkSystem, func() {
+		for _, existing := range networkSystem.NetworkInfo.Portgroup {
+			hasPortGroup = hasPortGroup || existing.Spec.Name == pg.Name
+		}
+		for _, vswitch := range networkSystem.NetworkInfo.Vswitch {
+			hasVSwitch = hasVSwitch || vswitch.Name == pg.VSwitch
+		}
+	})
+	if hasPortGroup {
+		return nil
+	}
+
+	ctx := context.Background()
+	system := object.NewHostNetworkSystem(s.client, networkSystem.Self)
+
+	if !hasVSwitch {
+		err := system.AddVirtualSwitch(ctx, pg.VSwitch, nil)
+		if err != nil {
+			return bosherr.WrapErrorf(err, "Adding virtual switch '%s'", pg.VSwitch)
+		}
+	}
+
+	spec := types.HostPortGroupSpec{Name: pg.Name, VswitchName: pg.VSwitch, VlanId: pg.VLAN}
+	err := system.AddPortGroup(ctx, spec)
+	if err != nil {
+		return bosherr.WrapErrorf(err, "Adding port group '%s'", pg.Name)
+	}
+
+	s.recordPortGroup(spec)
+	return nil
+}
+
+// recordPortGroup adds a port group, with its VLAN, to the network info, which govmomi's host network
+// system only adds its name to
+func (s *Simulator) recordPortGroup(spec types.HostPortGroupSpec) {
+	networkSystem := s.networkSystem()
+
+	simulator.Map.WithLock(networkSystem, func() {
+		info := networkSystem.NetworkInfo
+		for i, pg := range info.Portgroup {
+			if pg.Spec.Name == spec.Name {
+				info.Portgroup = append(info.Portgroup[:i], info.Portgroup[i+1:]...)
+				break
+			}
+		}
+
+		info.Portgroup = append(info.Portgroup, types.HostPortGroup{
+			Key:  "key-vim.host.PortGroup-" + spec.Name,
+			Spec: spec,
+		})
+	})
+}
+
+func (s *Simulator) forgetPortGroup(name string) {
+	networkSystem := s.networkSystem()
+
+	simulator.Map.WithLock(networkSystem, func() {
+		info := networkSystem.NetworkInfo
+		for i, pg := range info.Portgroup {
+			if pg.Spec.Name == name {
+				info.Portgroup = append(info.Portgroup[:i], info.Portgroup[i+1:]...)
+				return
+			}
+		}
+	})
+}
+
+func (s *Simulator) vms() []types.ManagedObjectReference {
+	host := s.host()
+
+	var refs []types.ManagedObjectReference
+	simulator.Map.WithLock(host, func() {
+		refs = append(refs, host.Vm...)
+	})
+	return refs
+}
+
+// writeFileAtomically replaces a file, so a crash leaves either the old or the new contents
+func writeFileAtomically(path string, contents []byte) error {
+	file, err := ioutil.TempFile(filepath.Dir(path), "."+filepath.Base(path))
+	if err != nil {
+		return err
+	}
+
+	_, err = file.Write(contents)
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		os.Remove(file.Name())
+		return err
+	}
+
+	return os.Rename(file.Name(), path)
+}
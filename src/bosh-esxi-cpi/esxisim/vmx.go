@@ -0,0 +1,187 @@
+package esxisim
+
+import (
+	"bytes"
+	"context"
+	"io/ioutil"
+	"path"
+	"strings"
+
+	bosherr "github.com/cloudfoundry/bosh-utils/errors"
+	"github.com/vmware/govmomi/object"
+	"github.com/vmware/govmomi/simulator"
+	"github.com/vmware/govmomi/simulator/esx"
+	"github.com/vmware/govmomi/vim25/types"
+	"github.com/vmware/govmomi/vim25/xml"
+)
+
+// vmx is what a VM's .vmx holds in place of the real thing: the spec that rebuilds the VM's hardware
+// on top of the devices every VM has. Folder is the datastore folder the VM lived in when it was
+// written, so a copy of the folder refers to its own files.
+type vmx struct {
+	XMLName xml.Name                       `xml:"vmx"`
+	Folder  string                         `xml:"folder"`
+	Spec    types.VirtualMachineConfigSpec `xml:"spec"`
+}
+
+// writeVMX writes the VM's hardware to its .vmx, unless it did not change since it was last written
+func (s *Simulator) writeVMX(vm *simulator.VirtualMachine) error {
+	var contents []byte
+	var localPath string
+	var err error
+
+	simulator.Map.WithLock(vm, func() {
+		var ok bool
+		localPath, ok = s.datastorePath(vm.Config.Files.VmPathName)
+		if !ok {
+			return
+		}
+
+		contents, err = xml.Marshal(vmxOf(vm))
+	})
+	if err != nil {
+		return bosherr.WrapErrorf(err, "Marshalling hardware of VM '%s'", vm.Name)
+	}
+	if localPath == "" || bytes.Equal(s.written[localPath], contents) {
+		return nil
+	}
+
+	err = writeFileAtomically(localPath, contents)
+	if err != nil {
+		return bosherr.WrapErrorf(err, "Writing '%s'", localPath)
+	}
+
+	s.written[localPath] = contents
+	return nil
+}
+
+func vmxOf(vm *simulator.VirtualMachine) vmx {
+	defaults := map[int32]bool{}
+	for _, device := range esx.VirtualDevice {
+		defaults[device.GetVirtualDevice().Key] = true
+	}
+
+	spec := types.VirtualMachineConfigSpec{
+		GuestId:    vm.Config.GuestId,
+		Uuid:       vm.Config.Uuid,
+		Version:    vm.Config.Version,
+		Annotation: vm.Config.Annotation,
+		NumCPUs:    vm.Config.Hardware.NumCPU,
+		MemoryMB:   int64(vm.Config.Hardware.MemoryMB),
+
+		NumCoresPerSocket:   vm.Config.Hardware.NumCoresPerSocket,
+		CpuHotAddEnabled:    vm.Config.CpuHotAddEnabled,
+		MemoryHotAddEnabled: vm.Config.MemoryHotAddEnabled,
+		Firmware:            vm.Config.Firmware,
+	}
+
+	for _, option := range vm.Config.ExtraConfig {
+		if option.GetOptionValue().Key != "govcsim" {
+			spec.ExtraConfig = append(spec.ExtraConfig, option)
+		}
+	}
+
+	for _, device := range vm.Config.Hardware.Device {
+		if defaults[device.GetVirtualDevice().Key] {
+			continue
+		}
+
+		spec.DeviceChange = append(spec.DeviceChange, &types.VirtualDeviceConfigSpec{
+			Operation: types.VirtualDeviceConfigSpecOperationAdd,
+			Device:    device,
+		})
+	}
+
+	return vmx{Folder: vmFolder(vm.Config.Files.VmPathName), Spec: spec}
+}
+
+// loadVMX reconfigures a VM from its .vmx. A .vmx the simulator did not write, such as the empty one
+// of a VM being created, is left alone.
+func (s *Simulator) loadVMX(ref types.ManagedObjectReference) error {
+	vm, ok := simulator.Map.Get(ref).(*simulator.VirtualMachine)
+	if !ok {
+		return nil
+	}
+
+	var vmPathName string
+	simulator.Map.WithLock(vm, func() {
+		vmPathName = vm.Config.Files.VmPathName
+	})
+
+	localPath, ok := s.datastorePath(vmPathName)
+	if !ok {
+		return nil
+	}
+
+	contents, err := ioutil.ReadFile(localPath)
+	if err != nil {
+		return bosherr.WrapErrorf(err, "Reading '%s'", localPath)
+	}
+
+	var config vmx
+	decoder := xml.NewDecoder(bytes.NewReader(contents))
+	decoder.TypeFunc = types.TypeFunc()
+	if decoder.Decode(&config) != nil {
+		return nil
+	}
+
+	// a copied VM gets its own identity, as ESXi gives VMs it is told were copied
+	folder := vmFolder(vmPathName)
+	copied := config.Folder != folder
+	if copied {
+		config.Spec.Uuid = ""
+	}
+
+	for _, change := range config.Spec.DeviceChange {
+		if nic, ok := change.GetVirtualDeviceConfigSpec().Device.(types.BaseVirtualEthernetCard); ok && copied {
+			nic.GetVirtualEthernetCard().MacAddress = ""
+		}
+
+		device := change.GetVirtualDeviceConfigSpec().Device.GetVirtualDevice()
+		if backing, ok := device.Backing.(types.BaseVirtualDeviceFileBackingInfo); ok {
+			info := backing.GetVirtualDeviceFileBackingInfo()
+			if strings.HasPrefix(info.FileName, config.Folder+"/") {
+				info.FileName = folder + strings.TrimPrefix(info.FileName, config.Folder)
+			}
+		}
+	}
+
+	ctx := context.Background()
+	task, err := object.NewVirtualMachine(s.client, ref).Reconfigure(ctx, config.Spec)
+	if err == nil {
+		err = task.Wait(ctx)
+	}
+	if err != nil {
+		return bosherr.WrapErrorf(err, "Configuring VM '%s' from '%s'", vm.Name, localPath)
+	}
+
+	s.written[localPath] = contents
+	return nil
+}
+
+// loadRegisteredVMs loads the .vmx of the VMs the simulator has not seen yet, that is those registered
+// from a folder, such as a copy of a stemcell's
+func (s *Simulator) loadRegisteredVMs() {
+	for _, ref := range s.vms() {
+		if s.known[ref] {
+			continue
+		}
+		s.known[ref] = true
+
+		err := s.loadVMX(ref)
+		if err != nil {
+			s.logger.Error(logTag, "Loading registered VM: %s", err)
+		}
+	}
+}
+
+// vmFolder returns the folder of a datastore path such as "[datastore1] vm/vm.vmx"
+func vmFolder(vmPathName string) string {
+	var p object.DatastorePath
+	if !p.FromString(vmPathName) {
+		return ""
+	}
+
+	p.Path = path.Dir(p.Path)
+	return p.String()
+}
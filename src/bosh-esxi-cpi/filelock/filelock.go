@@ -0,0 +1,67 @@
+package filelock
+
+import (
+	"errors"
+	"fmt"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"syscall"
+
+	bosherr "github.com/cloudfoundry/bosh-utils/errors"
+)
+
+// ErrLocked is returned by TryAcquire while the lock is held
+var ErrLocked = errors.New("lock is held")
+
+// Lock is held on a name across the CPI processes and the goroutines of a daemon. Its file in the temp dir
+// is kept rather than removed on unlock, so every holder locks the same file.
+type Lock struct {
+	file *os.File
+}
+
+// Acquire blocks until no other process, or goroutine, holds the lock named by the parts
+func Acquire(name ...string) (Lock, error) {
+	return acquire(name, syscall.LOCK_EX)
+}
+
+// TryAcquire takes the lock without waiting, it returns ErrLocked while the lock is held
+func TryAcquire(name ...string) (Lock, error) {
+	return acquire(name, syscall.LOCK_EX|syscall.LOCK_NB)
+}
+
+func acquire(name []string, how int) (Lock, error) {
+	lockPath := filepath.Join(os.TempDir(), fmt.Sprintf("bosh-esxi-cpi-%s.lock", fileName(name)))
+
+	file, err := os.OpenFile(lockPath, os.O_CREATE|os.O_RDWR, 0600)
+	if err != nil {
+		return Lock{}, bosherr.WrapErrorf(err, "Opening lock '%s'", lockPath)
+	}
+
+	// flock locks belong to the open file, so they also exclude goroutines which opened the file separately
+	err = syscall.Flock(int(file.Fd()), how)
+	if err == syscall.EWOULDBLOCK {
+		file.Close()
+		return Lock{}, ErrLocked
+	}
+	if err != nil {
+		file.Close()
+		return Lock{}, bosherr.WrapErrorf(err, "Locking '%s'", lockPath)
+	}
+
+	return Lock{file: file}, nil
+}
+
+// fileName joins the parts of a name, escaped so that they may hold any character, e.g. the '/' of a path
+func fileName(name []string) string {
+	escaped := make([]string, len(name))
+	for i, part := range name {
+		escaped[i] = url.PathEscape(part)
+	}
+	return strings.Join(escaped, "-")
+}
+
+func (l Lock) Unlock() {
+	l.file.Close()
+}
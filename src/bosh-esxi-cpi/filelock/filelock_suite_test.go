@@ -0,0 +1,13 @@
+package filelock_test
+
+import (
+	"testing"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+func TestFilelock(t *testing.T) {
+	RegisterFailHandler(Fail)
+	RunSpecs(t, "Filelock Suite")
+}
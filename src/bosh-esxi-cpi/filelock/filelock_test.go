@@ -0,0 +1,68 @@
+package filelock_test
+
+import (
+	"time"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	"bosh-esxi-cpi/filelock"
+)
+
+var _ = Describe("Lock", func() {
+	It("blocks until the holder unlocks", func() {
+		lock, err := filelock.Acquire("test-acquire")
+		Expect(err).ToNot(HaveOccurred())
+
+		acquired := make(chan filelock.Lock)
+		go func() {
+			defer GinkgoRecover()
+
+			lock, err := filelock.Acquire("test-acquire")
+			Expect(err).ToNot(HaveOccurred())
+			acquired <- lock
+		}()
+
+		Consistently(acquired, 50*time.Millisecond).ShouldNot(Receive())
+
+		lock.Unlock()
+
+		var second filelock.Lock
+		Eventually(acquired).Should(Receive(&second))
+		second.Unlock()
+	})
+
+	It("does not wait for a held lock when trying to acquire it", func() {
+		lock, err := filelock.Acquire("test-try")
+		Expect(err).ToNot(HaveOccurred())
+
+		_, err = filelock.TryAcquire("test-try")
+		Expect(err).To(Equal(filelock.ErrLocked))
+
+		lock.Unlock()
+
+		lock, err = filelock.TryAcquire("test-try")
+		Expect(err).ToNot(HaveOccurred())
+		lock.Unlock()
+	})
+
+	It("escapes the parts of a name", func() {
+		lock, err := filelock.Acquire("test", "esxi-1")
+		Expect(err).ToNot(HaveOccurred())
+		defer lock.Unlock()
+
+		other, err := filelock.TryAcquire("test", "esxi-1:443/sdk")
+		Expect(err).ToNot(HaveOccurred())
+		other.Unlock()
+	})
+
+	It("locks names independently", func() {
+		lock, err := filelock.Acquire("test-one")
+		Expect(err).ToNot(HaveOccurred())
+		defer lock.Unlock()
+
+		other, err := filelock.TryAcquire("test-other")
+		Expect(err).ToNot(HaveOccurred())
+		other.Unlock()
+	})
+})
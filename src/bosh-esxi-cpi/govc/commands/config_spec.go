@@ -0,0 +1,105 @@
+package commands
+
+import (
+	"sort"
+
+	"github.com/vmware/govmomi/object"
+	"github.com/vmware/govmomi/vim25/types"
+)
+
+// configSpecBuilder accumulates changes to a VM's config and devices, so they can be
+// applied in a single ReconfigVM_Task rather than one task per change
+type configSpecBuilder struct {
+	spec    types.VirtualMachineConfigSpec
+	devices object.VirtualDeviceList
+	key     int32
+}
+
+// newConfigSpecBuilder starts from the VM's current devices, which new devices are assigned unit numbers next to
+func newConfigSpecBuilder(devices object.VirtualDeviceList) *configSpecBuilder {
+	return &configSpecBuilder{devices: devices}
+}
+
+func (b *configSpecBuilder) SetResources(cpu int, ram int, cpuReservation int, memoryReservation int) {
+	b.spec.NumCPUs = int32(cpu)
+	b.spec.MemoryMB = int64(ram)
+
+	if cpuReservation > 0 {
+		b.spec.CpuAllocation = &types.ResourceAllocationInfo{Reservation: types.NewInt64(int64(cpuReservation))}
+	}
+	if memoryReservation > 0 {
+		b.spec.MemoryAllocation = &types.ResourceAllocationInfo{Reservation: types.NewInt64(int64(memoryReservation))}
+	}
+}
+
+// SetCoresPerSocket leaves the VM's topology as it is when coresPerSocket is 0
+func (b *configSpecBuilder) SetCoresPerSocket(coresPerSocket int) {
+	b.spec.NumCoresPerSocket = int32(coresPerSocket)
+}
+
+// SetHotAdd enables CPU and memory hot-add, leaving them as they are when false
+func (b *configSpecBuilder) SetHotAdd(cpu bool, memory bool) {
+	if cpu {
+		b.spec.CpuHotAddEnabled = types.NewBool(true)
+	}
+	if memory {
+		b.spec.MemoryHotAddEnabled = types.NewBool(true)
+	}
+}
+
+// SetFirmware leaves the VM's firmware as it is when firmware is empty
+func (b *configSpecBuilder) SetFirmware(firmware string) {
+	b.spec.Firmware = firmware
+}
+
+func (b *configSpecBuilder) SetNestedHV(enabled bool) {
+	b.spec.NestedHVEnabled = types.NewBool(enabled)
+}
+
+func (b *configSpecBuilder) SetSyncTimeWithHost(enabled bool) {
+	b.spec.Tools = &types.ToolsConfigInfo{SyncTimeWithHost: types.NewBool(enabled)}
+}
+
+// SetExtraConfig adds options in key order, so the same config always yields the same spec
+func (b *configSpecBuilder) SetExtraConfig(extraConfig map[string]string) {
+	keys := make([]string, 0, len(extraConfig))
+	for key := range extraConfig {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	for _, key := range keys {
+		b.spec.ExtraConfig = append(b.spec.ExtraConfig, &types.OptionValue{Key: key, Value: extraConfig[key]})
+	}
+}
+
+// AddDevice adds a new device, giving it a temporary key of its own. Every device added in the
+// same spec needs a distinct negative key, which the device constructors all default to -1.
+func (b *configSpecBuilder) AddDevice(device types.BaseVirtualDevice, fileOperation types.VirtualDeviceConfigSpecFileOperation) {
+	b.key--
+	device.GetVirtualDevice().Key = b.key
+
+	b.devices = append(b.devices, device)
+	b.spec.DeviceChange = append(b.spec.DeviceChange, &types.VirtualDeviceConfigSpec{
+		Operation:     types.VirtualDeviceConfigSpecOperationAdd,
+		FileOperation: fileOperation,
+		Device:        device,
+	})
+}
+
+// EditDevice changes an existing device
+func (b *configSpecBuilder) EditDevice(device types.BaseVirtualDevice) {
+	b.spec.DeviceChange = append(b.spec.DeviceChange, &types.VirtualDeviceConfigSpec{
+		Operation: types.VirtualDeviceConfigSpecOperationEdit,
+		Device:    device,
+	})
+}
+
+// Devices returns the VM's devices including those added so far
+func (b *configSpecBuilder) Devices() object.VirtualDeviceList {
+	return b.devices
+}
+
+func (b *configSpecBuilder) Spec() types.VirtualMachineConfigSpec {
+	return b.spec
+}
@@ -0,0 +1,207 @@
+package commands
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"path"
+
+	"github.com/vmware/govmomi/govc/cli"
+	"github.com/vmware/govmomi/govc/flags"
+	"github.com/vmware/govmomi/nfc"
+	"github.com/vmware/govmomi/ovf"
+	"github.com/vmware/govmomi/vim25/soap"
+	"github.com/vmware/govmomi/vim25/types"
+
+	"bosh-esxi-cpi/stemcell"
+)
+
+// importStemcell behaves like govc's import.ovf, but reads the OVF and its
+// disks straight from the stemcell image tarball and streams them into the
+// NFC lease, so nothing is written to local disk. The disks are verified
+// against the OVF manifest before anything is imported.
+type importStemcell struct {
+	*flags.DatastoreFlag
+	*flags.ResourcePoolFlag
+	*flags.FolderFlag
+
+	name string
+}
+
+func init() {
+	cli.Register("cpi.import.stemcell", &importStemcell{})
+}
+
+func (cmd *importStemcell) Register(ctx context.Context, f *flag.FlagSet) {
+	cmd.DatastoreFlag, ctx = flags.NewDatastoreFlag(ctx)
+	cmd.DatastoreFlag.Register(ctx, f)
+	cmd.ResourcePoolFlag, ctx = flags.NewResourcePoolFlag(ctx)
+	cmd.ResourcePoolFlag.Register(ctx, f)
+	cmd.FolderFlag, ctx = flags.NewFolderFlag(ctx)
+	cmd.FolderFlag.Register(ctx, f)
+
+	f.StringVar(&cmd.name, "name", "", "Name to use for the imported VM")
+}
+
+func (cmd *importStemcell) Description() string {
+	return `Import the OVF in a stemcell image tarball without extracting it.`
+}
+
+func (cmd *importStemcell) Usage() string {
+	return "PATH_TO_STEMCELL_IMAGE"
+}
+
+func (cmd *importStemcell) Process(ctx context.Context) error {
+	if err := cmd.DatastoreFlag.Process(ctx); err != nil {
+		return err
+	}
+	if err := cmd.ResourcePoolFlag.Process(ctx); err != nil {
+		return err
+	}
+	if err := cmd.FolderFlag.Process(ctx); err != nil {
+		return err
+	}
+	return nil
+}
+
+func (cmd *importStemcell) Run(ctx context.Context, f *flag.FlagSet) error {
+	if f.NArg() != 1 {
+		return errors.New("no stemcell image specified")
+	}
+
+	if cmd.name == "" {
+		return errors.New("please specify a name")
+	}
+
+	archive := stemcell.NewArchive(f.Arg(0))
+
+	descriptor, err := readOvf(archive)
+	if err != nil {
+		return err
+	}
+
+	envelope, err := ovf.Unmarshal(bytes.NewReader(descriptor))
+	if err != nil {
+		return fmt.Errorf("failed to parse ovf: %s", err)
+	}
+
+	// a corrupt disk would only surface once the VM boots
+	err = archive.VerifyManifest()
+	if err != nil {
+		return err
+	}
+
+	client, err := cmd.DatastoreFlag.Client()
+	if err != nil {
+		return err
+	}
+
+	datastore, err := cmd.DatastoreFlag.Datastore()
+	if err != nil {
+		return err
+	}
+
+	pool, err := cmd.ResourcePoolFlag.ResourcePool()
+	if err != nil {
+		return err
+	}
+
+	folder, err := cmd.FolderOrDefault("vm")
+	if err != nil {
+		return err
+	}
+
+	params := types.OvfCreateImportSpecParams{
+		EntityName:             cmd.name,
+		OvfManagerCommonParams: types.OvfManagerCommonParams{Locale: "US"},
+		NetworkMapping:         cmd.networkMapping(ctx, envelope),
+	}
+
+	spec, err := ovf.NewManager(client).CreateImportSpec(ctx, string(descriptor), pool, datastore, params)
+	if err != nil {
+		return err
+	}
+	if spec.Error != nil {
+		return errors.New(spec.Error[0].LocalizedMessage)
+	}
+
+	logger := loggerFrom(ctx)
+	for _, warning := range spec.Warning {
+		logger.Warn("import-stemcell", "Importing '%s': %s", cmd.name, warning.LocalizedMessage)
+	}
+
+	lease, err := pool.ImportVApp(ctx, spec.ImportSpec, folder, nil)
+	if err != nil {
+		return err
+	}
+
+	info, err := lease.Wait(ctx, spec.FileItem)
+	if err != nil {
+		return err
+	}
+
+	updater := lease.StartUpdater(ctx, info)
+	defer updater.Done()
+
+	for _, item := range info.Items {
+		err = upload(ctx, lease, archive, item, newProgressLogger(logger, path.Base(item.Path)))
+		if err != nil {
+			// aborting the lease removes the partially imported VM
+			if abortErr := lease.Abort(ctx, nil); abortErr != nil {
+				logger.Error("import-stemcell", "Aborting import of '%s': %s", cmd.name, abortErr)
+			}
+			return err
+		}
+	}
+
+	return lease.Complete(ctx)
+}
+
+// networkMapping maps the networks named in the OVF to networks of the same name, when they exist
+func (cmd *importStemcell) networkMapping(ctx context.Context, envelope *ovf.Envelope) []types.OvfNetworkMapping {
+	if envelope.Network == nil {
+		return nil
+	}
+
+	finder, err := cmd.DatastoreFlag.Finder()
+	if err != nil {
+		return nil
+	}
+
+	var mapping []types.OvfNetworkMapping
+	for _, network := range envelope.Network.Networks {
+		if ref, err := finder.Network(ctx, network.Name); err == nil {
+			mapping = append(mapping, types.OvfNetworkMapping{Name: network.Name, Network: ref.Reference()})
+		}
+	}
+
+	return mapping
+}
+
+func readOvf(archive stemcell.Archive) ([]byte, error) {
+	reader, _, err := archive.Open("*.ovf")
+	if err != nil {
+		return nil, err
+	}
+	defer reader.Close()
+
+	return ioutil.ReadAll(reader)
+}
+
+func upload(ctx context.Context, lease *nfc.Lease, archive stemcell.Archive, item nfc.FileItem, progress *progressLogger) error {
+	reader, size, err := archive.Open(path.Base(item.Path))
+	if err != nil {
+		return err
+	}
+	defer reader.Close()
+
+	defer progress.Wait()
+
+	return lease.Upload(ctx, item, reader, soap.Upload{
+		ContentLength: size,
+		Progress:      progress,
+	})
+}
@@ -0,0 +1,21 @@
+package commands
+
+import (
+	"context"
+
+	boshlog "github.com/cloudfoundry/bosh-utils/logger"
+)
+
+type loggerKey struct{}
+
+// WithLogger passes the CPI logger to commands, whose output is otherwise limited to their JSON result
+func WithLogger(ctx context.Context, logger boshlog.Logger) context.Context {
+	return context.WithValue(ctx, loggerKey{}, logger)
+}
+
+func loggerFrom(ctx context.Context) boshlog.Logger {
+	if logger, ok := ctx.Value(loggerKey{}).(boshlog.Logger); ok {
+		return logger
+	}
+	return boshlog.NewLogger(boshlog.LevelNone)
+}
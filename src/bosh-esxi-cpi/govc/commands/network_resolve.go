@@ -0,0 +1,95 @@
+package commands
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/vmware/govmomi/find"
+	"github.com/vmware/govmomi/object"
+	"github.com/vmware/govmomi/property"
+	"github.com/vmware/govmomi/vim25/mo"
+)
+
+// resolveNetwork finds the standard, distributed or opaque network a NIC should
+// be backed by. Distributed port groups can be qualified by their switch as
+// "switch/portgroup" when several switches carry a port group of the same name.
+func resolveNetwork(ctx context.Context, finder *find.Finder, name string) (object.NetworkReference, error) {
+	if isSwitchQualified(name) {
+		parts := strings.SplitN(name, "/", 2)
+
+		// otherwise the name is a relative inventory path, such as folder/portgroup
+		if dvs, ok := findSwitch(ctx, finder, parts[0]); ok {
+			return switchPortgroup(ctx, dvs, parts[0], parts[1])
+		}
+	}
+
+	nets, err := finder.NetworkList(ctx, name)
+	if err != nil {
+		return nil, err
+	}
+
+	var backable []object.NetworkReference
+	for _, net := range nets {
+		// a switch itself cannot back a NIC, only its port groups can
+		if _, ok := net.(*object.DistributedVirtualSwitch); ok {
+			continue
+		}
+		backable = append(backable, net)
+	}
+
+	switch len(backable) {
+	case 0:
+		return nil, fmt.Errorf("network '%s' is a distributed switch, use 'switch/portgroup' to select one of its port groups", name)
+	case 1:
+		return backable[0], nil
+	}
+
+	var paths []string
+	for _, net := range backable {
+		paths = append(paths, fmt.Sprint(net))
+	}
+
+	return nil, fmt.Errorf("network '%s' is ambiguous, found %s", name, strings.Join(paths, ", "))
+}
+
+func isSwitchQualified(name string) bool {
+	return !strings.HasPrefix(name, "/") && strings.Count(name, "/") == 1
+}
+
+func findSwitch(ctx context.Context, finder *find.Finder, switchName string) (*object.DistributedVirtualSwitch, bool) {
+	net, err := finder.Network(ctx, switchName)
+	if err != nil {
+		return nil, false
+	}
+
+	dvs, ok := net.(*object.DistributedVirtualSwitch)
+	return dvs, ok
+}
+
+func switchPortgroup(ctx context.Context, dvs *object.DistributedVirtualSwitch, switchName string, portgroupName string) (object.NetworkReference, error) {
+	var dvsProps mo.DistributedVirtualSwitch
+	if err := dvs.Properties(ctx, dvs.Reference(), []string{"portgroup"}, &dvsProps); err != nil {
+		return nil, err
+	}
+
+	if len(dvsProps.Portgroup) == 0 {
+		return nil, fmt.Errorf("port group '%s' not found on DVS '%s'", portgroupName, switchName)
+	}
+
+	var portgroups []mo.DistributedVirtualPortgroup
+	pc := property.DefaultCollector(dvs.Client())
+	if err := pc.Retrieve(ctx, dvsProps.Portgroup, []string{"name"}, &portgroups); err != nil {
+		return nil, err
+	}
+
+	for _, portgroup := range portgroups {
+		if portgroup.Name == portgroupName {
+			dvpg := object.NewDistributedVirtualPortgroup(dvs.Client(), portgroup.Reference())
+			dvpg.InventoryPath = switchName + "/" + portgroupName
+			return dvpg, nil
+		}
+	}
+
+	return nil, fmt.Errorf("port group '%s' not found on DVS '%s'", portgroupName, switchName)
+}
@@ -0,0 +1,49 @@
+package commands
+
+import (
+	"github.com/vmware/govmomi/vim25/progress"
+
+	boshlog "github.com/cloudfoundry/bosh-utils/logger"
+)
+
+// progressStep is how far an upload advances, in percent, between two log lines
+const progressStep = 10
+
+// progressLogger logs the progress of an upload to the CPI log
+type progressLogger struct {
+	logger boshlog.Logger
+	name   string
+	done   chan struct{}
+}
+
+func newProgressLogger(logger boshlog.Logger, name string) *progressLogger {
+	return &progressLogger{logger: logger, name: name, done: make(chan struct{})}
+}
+
+func (p *progressLogger) Sink() chan<- progress.Report {
+	reports := make(chan progress.Report)
+
+	go func() {
+		defer close(p.done)
+
+		logged := -1
+		for report := range reports {
+			if report.Error() != nil {
+				continue
+			}
+
+			step := int(report.Percentage()) / progressStep
+			if step > logged {
+				logged = step
+				p.logger.Info("import-stemcell", "Uploading %s: %d%%", p.name, step*progressStep)
+			}
+		}
+	}()
+
+	return reports
+}
+
+// Wait blocks until the upload closed the sink
+func (p *progressLogger) Wait() {
+	<-p.done
+}
@@ -0,0 +1,256 @@
+package commands
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"path"
+
+	"github.com/vmware/govmomi/govc/cli"
+	"github.com/vmware/govmomi/govc/flags"
+	"github.com/vmware/govmomi/object"
+	"github.com/vmware/govmomi/vim25/mo"
+	"github.com/vmware/govmomi/vim25/types"
+)
+
+// ephemeralDiskName is the name of the ephemeral disk in the VM's folder
+const ephemeralDiskName = "ephemeral.vmdk"
+
+// VMConfig is the JSON given to cpi.vm.configure: everything create_vm changes on a cloned VM
+type VMConfig struct {
+	CPU                          int               `json:"cpu"`
+	RAM                          int               `json:"ram"`
+	CPUReservation               int               `json:"cpu_reservation,omitempty"`
+	MemoryReservation            int               `json:"memory_reservation,omitempty"`
+	CoresPerSocket               int               `json:"cores_per_socket,omitempty"`
+	CPUHotAdd                    bool              `json:"cpu_hot_add,omitempty"`
+	MemoryHotAdd                 bool              `json:"memory_hot_add,omitempty"`
+	Firmware                     string            `json:"firmware,omitempty"`
+	NestedHardwareVirtualization bool              `json:"nested_hardware_virtualization"`
+	SyncTimeWithHost             bool              `json:"sync_time_with_host"`
+	ExtraConfig                  map[string]string `json:"extra_config,omitempty"`
+	NICs                         []VMConfigNIC     `json:"nics,omitempty"`
+	EphemeralDiskMB              int               `json:"ephemeral_disk_mb,omitempty"`
+
+	// Iso is the path of an ISO on the default datastore to insert in the CD-ROM drive
+	Iso string `json:"iso,omitempty"`
+}
+
+// VMConfigNIC is a network adapter to add, see resolveNetwork for how the network is found
+type VMConfigNIC struct {
+	Network    string `json:"network"`
+	Adapter    string `json:"adapter"`
+	MACAddress string `json:"mac_address"`
+}
+
+// vmConfigure applies what govc's vm.change, vm.network.add, vm.disk.create and
+// device.cdrom.insert do to a VM in one ReconfigVM_Task, so configuring a new
+// VM takes one task on hostd instead of one per device
+type vmConfigure struct {
+	*flags.VirtualMachineFlag
+	*flags.DatastoreFlag
+
+	config string
+}
+
+func init() {
+	cli.Register("cpi.vm.configure", &vmConfigure{})
+}
+
+func (cmd *vmConfigure) Register(ctx context.Context, f *flag.FlagSet) {
+	cmd.VirtualMachineFlag, ctx = flags.NewVirtualMachineFlag(ctx)
+	cmd.VirtualMachineFlag.Register(ctx, f)
+	cmd.DatastoreFlag, ctx = flags.NewDatastoreFlag(ctx)
+	cmd.DatastoreFlag.Register(ctx, f)
+
+	f.StringVar(&cmd.config, "config", "", "VM config as JSON")
+}
+
+func (cmd *vmConfigure) Description() string {
+	return `Change resources, extra config, network adapters, ephemeral disk and ISO of a VM at once.`
+}
+
+func (cmd *vmConfigure) Process(ctx context.Context) error {
+	if err := cmd.VirtualMachineFlag.Process(ctx); err != nil {
+		return err
+	}
+	if err := cmd.DatastoreFlag.Process(ctx); err != nil {
+		return err
+	}
+	return nil
+}
+
+func (cmd *vmConfigure) Run(ctx context.Context, f *flag.FlagSet) error {
+	vm, err := cmd.VirtualMachineFlag.VirtualMachine()
+	if err != nil {
+		return err
+	}
+
+	if vm == nil {
+		return errors.New("please specify a vm")
+	}
+
+	var config VMConfig
+	err = json.Unmarshal([]byte(cmd.config), &config)
+	if err != nil {
+		return fmt.Errorf("parsing VM config: %s", err)
+	}
+
+	spec, err := cmd.configSpec(ctx, vm, config)
+	if err != nil {
+		return err
+	}
+
+	loggerFrom(ctx).Debug("vm-configure", "Reconfiguring VM '%s' with %d device changes", vm.Name(), len(spec.DeviceChange))
+
+	task, err := vm.Reconfigure(ctx, spec)
+	if err != nil {
+		return err
+	}
+
+	return task.Wait(ctx)
+}
+
+func (cmd *vmConfigure) configSpec(ctx context.Context, vm *object.VirtualMachine, config VMConfig) (types.VirtualMachineConfigSpec, error) {
+	var mvm mo.VirtualMachine
+	err := vm.Properties(ctx, vm.Reference(), []string{"config.hardware.device", "config.files.vmPathName"}, &mvm)
+	if err != nil {
+		return types.VirtualMachineConfigSpec{}, err
+	}
+
+	builder := newConfigSpecBuilder(mvm.Config.Hardware.Device)
+	builder.SetResources(config.CPU, config.RAM, config.CPUReservation, config.MemoryReservation)
+	builder.SetCoresPerSocket(config.CoresPerSocket)
+	builder.SetHotAdd(config.CPUHotAdd, config.MemoryHotAdd)
+	builder.SetFirmware(config.Firmware)
+	builder.SetNestedHV(config.NestedHardwareVirtualization)
+	builder.SetSyncTimeWithHost(config.SyncTimeWithHost)
+	builder.SetExtraConfig(config.ExtraConfig)
+
+	for _, nic := range config.NICs {
+		err = cmd.addNIC(ctx, builder, nic)
+		if err != nil {
+			return types.VirtualMachineConfigSpec{}, err
+		}
+	}
+
+	if config.EphemeralDiskMB > 0 {
+		err = cmd.addEphemeralDisk(ctx, builder, mvm.Config.Files.VmPathName, config.EphemeralDiskMB)
+		if err != nil {
+			return types.VirtualMachineConfigSpec{}, err
+		}
+	}
+
+	if config.Iso != "" {
+		err = cmd.insertIso(builder, config.Iso)
+		if err != nil {
+			return types.VirtualMachineConfigSpec{}, err
+		}
+	}
+
+	return builder.Spec(), nil
+}
+
+func (cmd *vmConfigure) addNIC(ctx context.Context, builder *configSpecBuilder, nic VMConfigNIC) error {
+	finder, err := cmd.VirtualMachineFlag.Finder()
+	if err != nil {
+		return err
+	}
+
+	net, err := resolveNetwork(ctx, finder, nic.Network)
+	if err != nil {
+		return err
+	}
+
+	backing, err := net.EthernetCardBackingInfo(ctx)
+	if err != nil {
+		return err
+	}
+
+	device, err := ethernetCard(nic.Adapter, backing, nic.MACAddress)
+	if err != nil {
+		return err
+	}
+
+	builder.AddDevice(device, "")
+	return nil
+}
+
+// addEphemeralDisk creates the disk in the VM's folder, on whichever datastore the VM was cloned to
+func (cmd *vmConfigure) addEphemeralDisk(ctx context.Context, builder *configSpecBuilder, vmxPath string, diskMB int) error {
+	var vmx object.DatastorePath
+	if !vmx.FromString(vmxPath) {
+		return fmt.Errorf("unexpected VM path '%s'", vmxPath)
+	}
+
+	finder, err := cmd.VirtualMachineFlag.Finder()
+	if err != nil {
+		return err
+	}
+
+	ds, err := finder.Datastore(ctx, vmx.Datastore)
+	if err != nil {
+		return err
+	}
+
+	controller, err := builder.Devices().FindDiskController("")
+	if err != nil {
+		return err
+	}
+
+	disk := builder.Devices().CreateDisk(controller, ds.Reference(), ds.Path(path.Join(path.Dir(vmx.Path), ephemeralDiskName)))
+	disk.CapacityInKB = int64(diskMB) * 1024
+
+	builder.AddDevice(disk, types.VirtualDeviceConfigSpecFileOperationCreate)
+	return nil
+}
+
+func (cmd *vmConfigure) insertIso(builder *configSpecBuilder, iso string) error {
+	isoPath, err := cmd.DatastoreFlag.DatastorePath(iso)
+	if err != nil {
+		return err
+	}
+
+	cdrom, err := builder.Devices().FindCdrom("")
+	if err != nil {
+		return err
+	}
+
+	device := builder.Devices().InsertIso(cdrom, isoPath)
+	err = builder.Devices().Connect(device)
+	if err != nil {
+		return err
+	}
+
+	builder.EditDevice(device)
+	return nil
+}
+
+func ethernetCard(adapter string, backing types.BaseVirtualDeviceBackingInfo, address string) (types.BaseVirtualDevice, error) {
+	card := types.VirtualEthernetCard{
+		VirtualDevice: types.VirtualDevice{
+			Key:     -1,
+			Backing: backing,
+		},
+	}
+
+	if address != "" {
+		card.AddressType = string(types.VirtualEthernetCardMacTypeManual)
+		card.MacAddress = address
+	}
+
+	switch adapter {
+	case "e1000":
+		return &types.VirtualE1000{VirtualEthernetCard: card}, nil
+	case "e1000e":
+		return &types.VirtualE1000e{VirtualEthernetCard: card}, nil
+	case "vmxnet3":
+		return &types.VirtualVmxnet3{VirtualVmxnet: types.VirtualVmxnet{VirtualEthernetCard: card}}, nil
+	case "pcnet32":
+		return &types.VirtualPCNet32{VirtualEthernetCard: card}, nil
+	}
+
+	return nil, fmt.Errorf("unknown ethernet card type '%s'", adapter)
+}
@@ -0,0 +1,166 @@
+package commands
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"time"
+
+	"github.com/vmware/govmomi/govc/cli"
+	"github.com/vmware/govmomi/govc/flags"
+	"github.com/vmware/govmomi/object"
+	"github.com/vmware/govmomi/vim25/types"
+)
+
+const toolsRunning = string(types.VirtualMachineToolsRunningStatusGuestToolsRunning)
+
+// vmPower behaves like govc's vm.power, but waits for the VM itself rather than only for the task,
+// with a deadline. Powering on answers the question a copied VM asks before it can boot.
+type vmPower struct {
+	*flags.VirtualMachineFlag
+
+	on      bool
+	off     bool
+	reboot  bool
+	answer  string
+	timeout time.Duration
+}
+
+func init() {
+	cli.Register("cpi.vm.power", &vmPower{})
+}
+
+func (cmd *vmPower) Register(ctx context.Context, f *flag.FlagSet) {
+	cmd.VirtualMachineFlag, ctx = flags.NewVirtualMachineFlag(ctx)
+	cmd.VirtualMachineFlag.Register(ctx, f)
+
+	f.BoolVar(&cmd.on, "on", false, "Power on")
+	f.BoolVar(&cmd.off, "off", false, "Power off")
+	f.BoolVar(&cmd.reboot, "reboot", false, "Reboot the guest, or reset the VM when tools are not running")
+	f.StringVar(&cmd.answer, "answer", "", "Choice to answer a question the VM asks while powering on")
+	f.DurationVar(&cmd.timeout, "timeout", 10*time.Minute, "How long to wait for each state change")
+}
+
+func (cmd *vmPower) Description() string {
+	return `Power on, power off or reboot a VM and wait until it is in the new state.`
+}
+
+func (cmd *vmPower) Process(ctx context.Context) error {
+	return cmd.VirtualMachineFlag.Process(ctx)
+}
+
+func (cmd *vmPower) Run(ctx context.Context, f *flag.FlagSet) error {
+	vm, err := cmd.VirtualMachineFlag.VirtualMachine()
+	if err != nil {
+		return err
+	}
+
+	if vm == nil {
+		return errors.New("please specify a vm")
+	}
+
+	client, err := cmd.VirtualMachineFlag.Client()
+	if err != nil {
+		return err
+	}
+
+	waiter := newVMWaiter(client, vm, cmd.timeout)
+
+	switch {
+	case cmd.on:
+		return cmd.powerOn(ctx, vm, waiter)
+	case cmd.off:
+		return cmd.powerOff(ctx, vm, waiter)
+	case cmd.reboot:
+		return cmd.rebootVM(ctx, vm, waiter)
+	}
+
+	return errors.New("please specify -on, -off or -reboot")
+}
+
+func (cmd *vmPower) powerOn(ctx context.Context, vm *object.VirtualMachine, waiter vmWaiter) error {
+	task, err := vm.PowerOn(ctx)
+	if err != nil {
+		return err
+	}
+
+	status, err := waiter.Wait(ctx, task, func(status vmStatus) bool {
+		return status.PowerState == types.VirtualMachinePowerStatePoweredOn || status.Question != nil
+	})
+	if err != nil {
+		return err
+	}
+
+	if status.Question != nil {
+		if cmd.answer == "" {
+			return fmt.Errorf("VM '%s' asks '%s'", vm.Name(), status.Question.Text)
+		}
+
+		loggerFrom(ctx).Debug("vm-power", "Answering '%s' to '%s' on VM '%s'", cmd.answer, status.Question.Text, vm.Name())
+
+		err = vm.Answer(ctx, status.Question.Id, cmd.answer)
+		if err != nil {
+			return err
+		}
+
+		_, err = waiter.Wait(ctx, task, func(status vmStatus) bool {
+			return status.PowerState == types.VirtualMachinePowerStatePoweredOn
+		})
+		if err != nil {
+			return err
+		}
+	}
+
+	return task.Wait(ctx)
+}
+
+func (cmd *vmPower) powerOff(ctx context.Context, vm *object.VirtualMachine, waiter vmWaiter) error {
+	task, err := vm.PowerOff(ctx)
+	if err != nil {
+		return err
+	}
+
+	_, err = waiter.Wait(ctx, task, func(status vmStatus) bool {
+		return status.PowerState == types.VirtualMachinePowerStatePoweredOff
+	})
+	return err
+}
+
+// rebootVM restarts the guest through VMware Tools, so it shuts down cleanly, and resets the VM otherwise
+func (cmd *vmPower) rebootVM(ctx context.Context, vm *object.VirtualMachine, waiter vmWaiter) error {
+	status, err := waiter.Status(ctx)
+	if err != nil {
+		return err
+	}
+
+	if status.ToolsRunningStatus != toolsRunning {
+		task, err := vm.Reset(ctx)
+		if err != nil {
+			return err
+		}
+
+		ctx, cancel := context.WithTimeout(ctx, cmd.timeout)
+		defer cancel()
+
+		return task.Wait(ctx)
+	}
+
+	err = vm.RebootGuest(ctx)
+	if err != nil {
+		return err
+	}
+
+	// tools stop as the guest shuts down, and start again once it booted
+	_, err = waiter.Wait(ctx, nil, func(status vmStatus) bool {
+		return status.ToolsRunningStatus != toolsRunning
+	})
+	if err != nil {
+		return err
+	}
+
+	_, err = waiter.Wait(ctx, nil, func(status vmStatus) bool {
+		return status.ToolsRunningStatus == toolsRunning
+	})
+	return err
+}
@@ -0,0 +1,106 @@
+package commands
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/vmware/govmomi/object"
+	"github.com/vmware/govmomi/property"
+	govmomitask "github.com/vmware/govmomi/task"
+	"github.com/vmware/govmomi/vim25"
+	"github.com/vmware/govmomi/vim25/types"
+)
+
+// vmStatus holds the VM properties power operations wait on
+type vmStatus struct {
+	PowerState         types.VirtualMachinePowerState
+	Question           *types.VirtualMachineQuestionInfo
+	ToolsRunningStatus string
+}
+
+var vmStatusProperties = []string{"runtime.powerState", "runtime.question", "guest.toolsRunningStatus"}
+
+func (s *vmStatus) apply(change types.PropertyChange) {
+	switch change.Name {
+	case "runtime.powerState":
+		s.PowerState, _ = change.Val.(types.VirtualMachinePowerState)
+	case "runtime.question":
+		question, _ := change.Val.(types.VirtualMachineQuestionInfo)
+		s.Question = nil
+		if change.Op != types.PropertyChangeOpRemove && change.Val != nil {
+			s.Question = &question
+		}
+	case "guest.toolsRunningStatus":
+		s.ToolsRunningStatus, _ = change.Val.(string)
+	}
+}
+
+// vmWaiter blocks until a predicate holds on a VM's status. It is told about every change by a
+// property collector, so nothing is polled.
+type vmWaiter struct {
+	client  *vim25.Client
+	vm      *object.VirtualMachine
+	timeout time.Duration
+}
+
+func newVMWaiter(client *vim25.Client, vm *object.VirtualMachine, timeout time.Duration) vmWaiter {
+	return vmWaiter{client: client, vm: vm, timeout: timeout}
+}
+
+// Status returns the current status of the VM
+func (w vmWaiter) Status(ctx context.Context) (vmStatus, error) {
+	return w.Wait(ctx, nil, func(vmStatus) bool { return true })
+}
+
+// Wait returns the status of the VM once the predicate holds. A task, when given, ends the wait
+// if it fails, e.g. a power on which will never reach poweredOn.
+func (w vmWaiter) Wait(ctx context.Context, task *object.Task, predicate func(vmStatus) bool) (vmStatus, error) {
+	ctx, cancel := context.WithTimeout(ctx, w.timeout)
+	defer cancel()
+
+	filter := new(property.WaitFilter).Add(w.vm.Reference(), "VirtualMachine", vmStatusProperties)
+	if task != nil {
+		filter.Add(task.Reference(), "Task", []string{"info.error"})
+	}
+
+	var status vmStatus
+	var taskErr error
+
+	err := property.WaitForUpdates(ctx, property.DefaultCollector(w.client), filter, func(updates []types.ObjectUpdate) bool {
+		for _, update := range updates {
+			for _, change := range update.ChangeSet {
+				if update.Obj.Type != "Task" {
+					status.apply(change)
+				} else if fault := taskFault(change.Val); fault != nil {
+					// keeps the fault type, which callers tell e.g. insufficient resources by
+					taskErr = govmomitask.Error{LocalizedMethodFault: fault}
+				}
+			}
+		}
+
+		return taskErr != nil || predicate(status)
+	})
+
+	if taskErr != nil {
+		return status, taskErr
+	}
+
+	if ctx.Err() == context.DeadlineExceeded {
+		return status, fmt.Errorf("timed out after %s waiting on VM '%s', power state '%s'", w.timeout, w.vm.Name(), status.PowerState)
+	}
+
+	return status, err
+}
+
+// taskFault returns the fault of a task's info.error, which arrives as a value or a pointer depending on the endpoint
+func taskFault(val types.AnyType) *types.LocalizedMethodFault {
+	switch fault := val.(type) {
+	case types.LocalizedMethodFault:
+		return &fault
+	case *types.LocalizedMethodFault:
+		return fault
+	}
+
+	return nil
+}
@@ -0,0 +1,204 @@
+package govc_test
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/vmware/govmomi"
+	"github.com/vmware/govmomi/find"
+	"github.com/vmware/govmomi/object"
+	"github.com/vmware/govmomi/property"
+	"github.com/vmware/govmomi/simulator"
+	"github.com/vmware/govmomi/vim25/mo"
+
+	fakegovc "bosh-esxi-cpi/govc/fakes"
+
+	fakelogger "github.com/cloudfoundry/bosh-utils/logger/loggerfakes"
+
+	"bosh-esxi-cpi/govc"
+	"bosh-esxi-cpi/vm"
+)
+
+// BenchmarkConfigureVM compares configuring a cloned VM the way create_vm used to, with one govc
+// command and task per change, to ConfigureVM. Each op configures a fresh clone of a simulator VM:
+//
+//	go test ./govc/ -run NONE -bench ConfigureVM
+func BenchmarkConfigureVM(b *testing.B) {
+	model := simulator.VPX()
+	if err := model.Create(); err != nil {
+		b.Fatal(err)
+	}
+	defer model.Remove()
+
+	server := model.Service.NewServer()
+	defer server.Close()
+
+	// ESXi creates the folders an upload goes to, the simulator does not
+	_, err := govc.NewGovcRunner(&fakelogger.FakeLogger{}).CliCommand("datastore.mkdir", map[string]string{"u": server.URL.String(), "k": "true"}, []string{"env"})
+	if err != nil {
+		b.Fatal(err)
+	}
+
+	iso, err := ioutil.TempFile("", "env-iso-")
+	if err != nil {
+		b.Fatal(err)
+	}
+	defer os.Remove(iso.Name())
+	iso.Close()
+
+	b.Run("separate", func(b *testing.B) {
+		benchmarkConfigureVM(b, server, func(runner govc.GovcRunner, _ govc.GovcClient, vmName string) error {
+			return configureVMSeparately(runner, server.URL.String(), vmName, iso.Name())
+		})
+	})
+
+	b.Run("batched", func(b *testing.B) {
+		benchmarkConfigureVM(b, server, func(_ govc.GovcRunner, client govc.GovcClient, vmName string) error {
+			return client.ConfigureVM(vmName, govc.VMSpec{
+				Props:       vm.VMProps{CPU: 2, RAM: 2048, Disk: 16, CPUHotAdd: true, SyncTimeWithHost: true},
+				ExtraConfig: map[string]string{"bosh.created_port_groups": "VLAN 10"},
+				NICs: []govc.NIC{
+					{Network: "VM Network", MACAddress: "00:50:56:3f:00:01", AdapterType: "vmxnet3"},
+					{Network: "DC0_DVPG0", MACAddress: "00:50:56:3f:00:02", AdapterType: "e1000e"},
+				},
+				EnvIsoPath: iso.Name(),
+			})
+		})
+	})
+}
+
+func benchmarkConfigureVM(b *testing.B, server *simulator.Server, configure func(govc.GovcRunner, govc.GovcClient, string) error) {
+	ctx := context.Background()
+
+	logger := &fakelogger.FakeLogger{}
+	runner := &countingRunner{runner: govc.NewGovcRunner(logger)}
+
+	config := &fakegovc.FakeGovcConfig{}
+	config.EsxUrlReturns(server.URL.String())
+	client := govc.NewClient(runner, config, logger)
+
+	c, err := govmomi.NewClient(ctx, server.URL, true)
+	if err != nil {
+		b.Fatal(err)
+	}
+	finder := find.NewFinder(c.Client, true)
+	datacenter, err := finder.Datacenter(ctx, "DC0")
+	if err != nil {
+		b.Fatal(err)
+	}
+	finder.SetDatacenter(datacenter)
+
+	var tasks int
+	runner.calls = 0
+
+	for i := 0; i < b.N; i++ {
+		b.StopTimer()
+		vmName := fmt.Sprintf("vm-%d", time.Now().UnixNano())
+		_, err := runner.runner.CliCommand("vm.clone", map[string]string{"vm": "DC0_H0_VM0", "host": "DC0_H0", "on": "false", "u": server.URL.String(), "k": "true"}, []string{vmName})
+		if err != nil {
+			b.Fatal(err)
+		}
+		b.StartTimer()
+
+		err = configure(runner, client, vmName)
+		if err != nil {
+			b.Fatal(err)
+		}
+
+		b.StopTimer()
+		clone, err := finder.VirtualMachine(ctx, vmName)
+		if err != nil {
+			b.Fatal(err)
+		}
+		cloneTasks, err := vmTasks(ctx, clone)
+		if err != nil {
+			b.Fatal(err)
+		}
+		tasks += len(cloneTasks)
+		b.StartTimer()
+	}
+
+	b.ReportMetric(float64(runner.calls)/float64(b.N), "commands/op")
+	b.ReportMetric(float64(tasks)/float64(b.N), "tasks/op")
+}
+
+// configureVMSeparately runs the commands create_vm ran before ConfigureVM. The simulator names
+// the CD-ROM drive cdrom-203, where ESXi names it cdrom-3000.
+func configureVMSeparately(runner govc.GovcRunner, url string, vmName string, isoPath string) error {
+	flags := func(extra map[string]string) map[string]string {
+		flags := map[string]string{"u": url, "k": "true"}
+		for k, v := range extra {
+			flags[k] = v
+		}
+		return flags
+	}
+	vmFlag := map[string]string{"vm": vmName}
+	datastoreIsoPath := fmt.Sprintf("env/env-%s.iso", vmName)
+
+	commands := []struct {
+		name  string
+		flags map[string]string
+		args  []string
+	}{
+		{"vm.change", flags(map[string]string{"vm": vmName, "c": "2", "m": "2048", "nested-hv-enabled": "false", "sync-time-with-host": "true"}), []string{"-e=vcpu.hotadd=TRUE"}},
+		{"vm.change", flags(vmFlag), []string{"-e=bosh.created_port_groups=VLAN 10"}},
+		{"vm.network.add", flags(map[string]string{"vm": vmName, "net": "VM Network", "net.adapter": "vmxnet3", "net.address": "00:50:56:3f:00:01"}), nil},
+		{"vm.network.add", flags(map[string]string{"vm": vmName, "net": "DC0_DVPG0", "net.adapter": "e1000e", "net.address": "00:50:56:3f:00:02"}), nil},
+		{"vm.disk.create", flags(map[string]string{"vm": vmName, "name": vmName + "/ephemeral.vmdk", "size": "16MB"}), nil},
+		{"device.disconnect", flags(vmFlag), []string{"cdrom-203"}},
+		{"device.cdrom.eject", flags(vmFlag), nil},
+		{"datastore.upload", flags(nil), []string{isoPath, datastoreIsoPath}},
+		{"device.cdrom.insert", flags(vmFlag), []string{datastoreIsoPath}},
+		{"device.connect", flags(vmFlag), []string{"cdrom-203"}},
+	}
+
+	for _, command := range commands {
+		_, err := runner.CliCommand(command.name, command.flags, command.args)
+		if err != nil {
+			return fmt.Errorf("%s: %s", command.name, err)
+		}
+	}
+
+	return nil
+}
+
+// countingRunner counts the govc commands run through it
+type countingRunner struct {
+	runner govc.GovcRunner
+	calls  int
+}
+
+func (r *countingRunner) CliCommand(command string, flags map[string]string, args []string) (string, error) {
+	r.calls++
+	return r.runner.CliCommand(command, flags, args)
+}
+
+// vmTasks returns the description ids of the recent tasks on a VM, oldest first
+func vmTasks(ctx context.Context, vm *object.VirtualMachine) ([]string, error) {
+	collector := property.DefaultCollector(vm.Client())
+
+	var taskManager mo.TaskManager
+	err := collector.RetrieveOne(ctx, *vm.Client().ServiceContent.TaskManager, []string{"recentTask"}, &taskManager)
+	if err != nil || len(taskManager.RecentTask) == 0 {
+		return nil, err
+	}
+
+	var tasks []mo.Task
+	err = collector.Retrieve(ctx, taskManager.RecentTask, []string{"info"}, &tasks)
+	if err != nil {
+		return nil, err
+	}
+
+	var ids []string
+	for _, task := range tasks {
+		if task.Info.Entity != nil && *task.Info.Entity == vm.Reference() {
+			ids = append(ids, task.Info.DescriptionId)
+		}
+	}
+
+	return ids, nil
+}
@@ -7,25 +7,26 @@ import (
 )
 
 type FakeGovcClient struct {
-	ImportOvfStub        func(string, string) (string, error)
-	importOvfMutex       sync.RWMutex
-	importOvfArgsForCall []struct {
+	ImportStemcellStub        func(string, string) (string, error)
+	importStemcellMutex       sync.RWMutex
+	importStemcellArgsForCall []struct {
 		arg1 string
 		arg2 string
 	}
-	importOvfReturns struct {
+	importStemcellReturns struct {
 		result1 string
 		result2 error
 	}
-	importOvfReturnsOnCall map[int]struct {
+	importStemcellReturnsOnCall map[int]struct {
 		result1 string
 		result2 error
 	}
-	CloneVMStub        func(string, string) (string, error)
+	CloneVMStub        func(string, string, string) (string, error)
 	cloneVMMutex       sync.RWMutex
 	cloneVMArgsForCall []struct {
 		arg1 string
 		arg2 string
+		arg3 string
 	}
 	cloneVMReturns struct {
 		result1 string
@@ -35,6 +36,32 @@ type FakeGovcClient struct {
 		result1 string
 		result2 error
 	}
+	CloneVMXStub        func(string, string) (string, error)
+	cloneVMXMutex       sync.RWMutex
+	cloneVMXArgsForCall []struct {
+		arg1 string
+		arg2 string
+	}
+	cloneVMXReturns struct {
+		result1 string
+		result2 error
+	}
+	cloneVMXReturnsOnCall map[int]struct {
+		result1 string
+		result2 error
+	}
+	RenameVMStub        func(string, string) error
+	renameVMMutex       sync.RWMutex
+	renameVMArgsForCall []struct {
+		arg1 string
+		arg2 string
+	}
+	renameVMReturns struct {
+		result1 error
+	}
+	renameVMReturnsOnCall map[int]struct {
+		result1 error
+	}
 	UpdateVMIsoStub        func(string, string) (string, error)
 	updateVMIsoMutex       sync.RWMutex
 	updateVMIsoArgsForCall []struct {
@@ -62,6 +89,17 @@ type FakeGovcClient struct {
 		result1 string
 		result2 error
 	}
+	RebootVMStub        func(string) error
+	rebootVMMutex       sync.RWMutex
+	rebootVMArgsForCall []struct {
+		arg1 string
+	}
+	rebootVMReturns struct {
+		result1 error
+	}
+	rebootVMReturnsOnCall map[int]struct {
+		result1 error
+	}
 	HasVMStub        func(string) (bool, error)
 	hasVMMutex       sync.RWMutex
 	hasVMArgsForCall []struct {
@@ -75,44 +113,82 @@ type FakeGovcClient struct {
 		result1 bool
 		result2 error
 	}
-	SetVMNetworkAdapterStub        func(string, string, string) error
-	setVMNetworkAdapterMutex       sync.RWMutex
-	setVMNetworkAdapterArgsForCall []struct {
+	ConfigureVMStub        func(string, govc.VMSpec) error
+	configureVMMutex       sync.RWMutex
+	configureVMArgsForCall []struct {
 		arg1 string
-		arg2 string
-		arg3 string
+		arg2 govc.VMSpec
 	}
-	setVMNetworkAdapterReturns struct {
+	configureVMReturns struct {
 		result1 error
 	}
-	setVMNetworkAdapterReturnsOnCall map[int]struct {
+	configureVMReturnsOnCall map[int]struct {
 		result1 error
 	}
-	SetVMResourcesStub        func(string, int, int) error
-	setVMResourcesMutex       sync.RWMutex
-	setVMResourcesArgsForCall []struct {
+	SetVMExtraConfigStub        func(string, map[string]string) error
+	setVMExtraConfigMutex       sync.RWMutex
+	setVMExtraConfigArgsForCall []struct {
 		arg1 string
-		arg2 int
-		arg3 int
+		arg2 map[string]string
 	}
-	setVMResourcesReturns struct {
+	setVMExtraConfigReturns struct {
 		result1 error
 	}
-	setVMResourcesReturnsOnCall map[int]struct {
+	setVMExtraConfigReturnsOnCall map[int]struct {
 		result1 error
 	}
-	CreateEphemeralDiskStub        func(string, int) error
-	createEphemeralDiskMutex       sync.RWMutex
-	createEphemeralDiskArgsForCall []struct {
+	GetVMExtraConfigStub        func(string) (map[string]string, error)
+	getVMExtraConfigMutex       sync.RWMutex
+	getVMExtraConfigArgsForCall []struct {
 		arg1 string
-		arg2 int
 	}
-	createEphemeralDiskReturns struct {
+	getVMExtraConfigReturns struct {
+		result1 map[string]string
+		result2 error
+	}
+	getVMExtraConfigReturnsOnCall map[int]struct {
+		result1 map[string]string
+		result2 error
+	}
+	EnsurePortGroupStub        func(string, string, int) (bool, error)
+	ensurePortGroupMutex       sync.RWMutex
+	ensurePortGroupArgsForCall []struct {
+		arg1 string
+		arg2 string
+		arg3 int
+	}
+	ensurePortGroupReturns struct {
+		result1 bool
+		result2 error
+	}
+	ensurePortGroupReturnsOnCall map[int]struct {
+		result1 bool
+		result2 error
+	}
+	RemovePortGroupStub        func(string) error
+	removePortGroupMutex       sync.RWMutex
+	removePortGroupArgsForCall []struct {
+		arg1 string
+	}
+	removePortGroupReturns struct {
 		result1 error
 	}
-	createEphemeralDiskReturnsOnCall map[int]struct {
+	removePortGroupReturnsOnCall map[int]struct {
 		result1 error
 	}
+	GetVMNetworkAttachmentIDsStub        func(string) ([]string, error)
+	getVMNetworkAttachmentIDsMutex       sync.RWMutex
+	getVMNetworkAttachmentIDsArgsForCall []struct {
+		arg1 string
+	}
+	getVMNetworkAttachmentIDsReturns struct {
+		result1 []string
+		result2 error
+	}
+	getVMNetworkAttachmentIDsReturnsOnCall map[int]struct {
+		result1 []string
+		result2 error
+	}
 	CreateDiskStub        func(string, int) error
 	createDiskMutex       sync.RWMutex
 	createDiskArgsForCall []struct {
@@ -173,73 +249,127 @@ type FakeGovcClient struct {
 		result1 string
 		result2 error
 	}
+	DestroyVMIsoStub        func(string) error
+	destroyVMIsoMutex       sync.RWMutex
+	destroyVMIsoArgsForCall []struct {
+		arg1 string
+	}
+	destroyVMIsoReturns struct {
+		result1 error
+	}
+	destroyVMIsoReturnsOnCall map[int]struct {
+		result1 error
+	}
+	DestroyVMReplicasStub        func(string) error
+	destroyVMReplicasMutex       sync.RWMutex
+	destroyVMReplicasArgsForCall []struct {
+		arg1 string
+	}
+	destroyVMReplicasReturns struct {
+		result1 error
+	}
+	destroyVMReplicasReturnsOnCall map[int]struct {
+		result1 error
+	}
+	ListVMsStub        func() ([]string, error)
+	listVMsMutex       sync.RWMutex
+	listVMsArgsForCall []struct{}
+	listVMsReturns     struct {
+		result1 []string
+		result2 error
+	}
+	listVMsReturnsOnCall map[int]struct {
+		result1 []string
+		result2 error
+	}
+	ListDatastoreFilesStub        func() ([]govc.DatastoreFile, error)
+	listDatastoreFilesMutex       sync.RWMutex
+	listDatastoreFilesArgsForCall []struct{}
+	listDatastoreFilesReturns     struct {
+		result1 []govc.DatastoreFile
+		result2 error
+	}
+	listDatastoreFilesReturnsOnCall map[int]struct {
+		result1 []govc.DatastoreFile
+		result2 error
+	}
+	HostStub        func() string
+	hostMutex       sync.RWMutex
+	hostArgsForCall []struct{}
+	hostReturns     struct {
+		result1 string
+	}
+	hostReturnsOnCall map[int]struct {
+		result1 string
+	}
 	invocations      map[string][][]interface{}
 	invocationsMutex sync.RWMutex
 }
 
-func (fake *FakeGovcClient) ImportOvf(arg1 string, arg2 string) (string, error) {
-	fake.importOvfMutex.Lock()
-	ret, specificReturn := fake.importOvfReturnsOnCall[len(fake.importOvfArgsForCall)]
-	fake.importOvfArgsForCall = append(fake.importOvfArgsForCall, struct {
+func (fake *FakeGovcClient) ImportStemcell(arg1 string, arg2 string) (string, error) {
+	fake.importStemcellMutex.Lock()
+	ret, specificReturn := fake.importStemcellReturnsOnCall[len(fake.importStemcellArgsForCall)]
+	fake.importStemcellArgsForCall = append(fake.importStemcellArgsForCall, struct {
 		arg1 string
 		arg2 string
 	}{arg1, arg2})
-	fake.recordInvocation("ImportOvf", []interface{}{arg1, arg2})
-	fake.importOvfMutex.Unlock()
-	if fake.ImportOvfStub != nil {
-		return fake.ImportOvfStub(arg1, arg2)
+	fake.recordInvocation("ImportStemcell", []interface{}{arg1, arg2})
+	fake.importStemcellMutex.Unlock()
+	if fake.ImportStemcellStub != nil {
+		return fake.ImportStemcellStub(arg1, arg2)
 	}
 	if specificReturn {
 		return ret.result1, ret.result2
 	}
-	return fake.importOvfReturns.result1, fake.importOvfReturns.result2
+	return fake.importStemcellReturns.result1, fake.importStemcellReturns.result2
 }
 
-func (fake *FakeGovcClient) ImportOvfCallCount() int {
-	fake.importOvfMutex.RLock()
-	defer fake.importOvfMutex.RUnlock()
-	return len(fake.importOvfArgsForCall)
+func (fake *FakeGovcClient) ImportStemcellCallCount() int {
+	fake.importStemcellMutex.RLock()
+	defer fake.importStemcellMutex.RUnlock()
+	return len(fake.importStemcellArgsForCall)
 }
 
-func (fake *FakeGovcClient) ImportOvfArgsForCall(i int) (string, string) {
-	fake.importOvfMutex.RLock()
-	defer fake.importOvfMutex.RUnlock()
-	return fake.importOvfArgsForCall[i].arg1, fake.importOvfArgsForCall[i].arg2
+func (fake *FakeGovcClient) ImportStemcellArgsForCall(i int) (string, string) {
+	fake.importStemcellMutex.RLock()
+	defer fake.importStemcellMutex.RUnlock()
+	return fake.importStemcellArgsForCall[i].arg1, fake.importStemcellArgsForCall[i].arg2
 }
 
-func (fake *FakeGovcClient) ImportOvfReturns(result1 string, result2 error) {
-	fake.ImportOvfStub = nil
-	fake.importOvfReturns = struct {
+func (fake *FakeGovcClient) ImportStemcellReturns(result1 string, result2 error) {
+	fake.ImportStemcellStub = nil
+	fake.importStemcellReturns = struct {
 		result1 string
 		result2 error
 	}{result1, result2}
 }
 
-func (fake *FakeGovcClient) ImportOvfReturnsOnCall(i int, result1 string, result2 error) {
-	fake.ImportOvfStub = nil
-	if fake.importOvfReturnsOnCall == nil {
-		fake.importOvfReturnsOnCall = make(map[int]struct {
+func (fake *FakeGovcClient) ImportStemcellReturnsOnCall(i int, result1 string, result2 error) {
+	fake.ImportStemcellStub = nil
+	if fake.importStemcellReturnsOnCall == nil {
+		fake.importStemcellReturnsOnCall = make(map[int]struct {
 			result1 string
 			result2 error
 		})
 	}
-	fake.importOvfReturnsOnCall[i] = struct {
+	fake.importStemcellReturnsOnCall[i] = struct {
 		result1 string
 		result2 error
 	}{result1, result2}
 }
 
-func (fake *FakeGovcClient) CloneVM(arg1 string, arg2 string) (string, error) {
+func (fake *FakeGovcClient) CloneVM(arg1 string, arg2 string, arg3 string) (string, error) {
 	fake.cloneVMMutex.Lock()
 	ret, specificReturn := fake.cloneVMReturnsOnCall[len(fake.cloneVMArgsForCall)]
 	fake.cloneVMArgsForCall = append(fake.cloneVMArgsForCall, struct {
 		arg1 string
 		arg2 string
-	}{arg1, arg2})
-	fake.recordInvocation("CloneVM", []interface{}{arg1, arg2})
+		arg3 string
+	}{arg1, arg2, arg3})
+	fake.recordInvocation("CloneVM", []interface{}{arg1, arg2, arg3})
 	fake.cloneVMMutex.Unlock()
 	if fake.CloneVMStub != nil {
-		return fake.CloneVMStub(arg1, arg2)
+		return fake.CloneVMStub(arg1, arg2, arg3)
 	}
 	if specificReturn {
 		return ret.result1, ret.result2
@@ -253,10 +383,10 @@ func (fake *FakeGovcClient) CloneVMCallCount() int {
 	return len(fake.cloneVMArgsForCall)
 }
 
-func (fake *FakeGovcClient) CloneVMArgsForCall(i int) (string, string) {
+func (fake *FakeGovcClient) CloneVMArgsForCall(i int) (string, string, string) {
 	fake.cloneVMMutex.RLock()
 	defer fake.cloneVMMutex.RUnlock()
-	return fake.cloneVMArgsForCall[i].arg1, fake.cloneVMArgsForCall[i].arg2
+	return fake.cloneVMArgsForCall[i].arg1, fake.cloneVMArgsForCall[i].arg2, fake.cloneVMArgsForCall[i].arg3
 }
 
 func (fake *FakeGovcClient) CloneVMReturns(result1 string, result2 error) {
@@ -281,6 +411,107 @@ func (fake *FakeGovcClient) CloneVMReturnsOnCall(i int, result1 string, result2
 	}{result1, result2}
 }
 
+func (fake *FakeGovcClient) CloneVMX(arg1 string, arg2 string) (string, error) {
+	fake.cloneVMXMutex.Lock()
+	ret, specificReturn := fake.cloneVMXReturnsOnCall[len(fake.cloneVMXArgsForCall)]
+	fake.cloneVMXArgsForCall = append(fake.cloneVMXArgsForCall, struct {
+		arg1 string
+		arg2 string
+	}{arg1, arg2})
+	fake.recordInvocation("CloneVMX", []interface{}{arg1, arg2})
+	fake.cloneVMXMutex.Unlock()
+	if fake.CloneVMXStub != nil {
+		return fake.CloneVMXStub(arg1, arg2)
+	}
+	if specificReturn {
+		return ret.result1, ret.result2
+	}
+	return fake.cloneVMXReturns.result1, fake.cloneVMXReturns.result2
+}
+
+func (fake *FakeGovcClient) CloneVMXCallCount() int {
+	fake.cloneVMXMutex.RLock()
+	defer fake.cloneVMXMutex.RUnlock()
+	return len(fake.cloneVMXArgsForCall)
+}
+
+func (fake *FakeGovcClient) CloneVMXArgsForCall(i int) (string, string) {
+	fake.cloneVMXMutex.RLock()
+	defer fake.cloneVMXMutex.RUnlock()
+	return fake.cloneVMXArgsForCall[i].arg1, fake.cloneVMXArgsForCall[i].arg2
+}
+
+func (fake *FakeGovcClient) CloneVMXReturns(result1 string, result2 error) {
+	fake.CloneVMXStub = nil
+	fake.cloneVMXReturns = struct {
+		result1 string
+		result2 error
+	}{result1, result2}
+}
+
+func (fake *FakeGovcClient) CloneVMXReturnsOnCall(i int, result1 string, result2 error) {
+	fake.CloneVMXStub = nil
+	if fake.cloneVMXReturnsOnCall == nil {
+		fake.cloneVMXReturnsOnCall = make(map[int]struct {
+			result1 string
+			result2 error
+		})
+	}
+	fake.cloneVMXReturnsOnCall[i] = struct {
+		result1 string
+		result2 error
+	}{result1, result2}
+}
+
+func (fake *FakeGovcClient) RenameVM(arg1 string, arg2 string) error {
+	fake.renameVMMutex.Lock()
+	ret, specificReturn := fake.renameVMReturnsOnCall[len(fake.renameVMArgsForCall)]
+	fake.renameVMArgsForCall = append(fake.renameVMArgsForCall, struct {
+		arg1 string
+		arg2 string
+	}{arg1, arg2})
+	fake.recordInvocation("RenameVM", []interface{}{arg1, arg2})
+	fake.renameVMMutex.Unlock()
+	if fake.RenameVMStub != nil {
+		return fake.RenameVMStub(arg1, arg2)
+	}
+	if specificReturn {
+		return ret.result1
+	}
+	return fake.renameVMReturns.result1
+}
+
+func (fake *FakeGovcClient) RenameVMCallCount() int {
+	fake.renameVMMutex.RLock()
+	defer fake.renameVMMutex.RUnlock()
+	return len(fake.renameVMArgsForCall)
+}
+
+func (fake *FakeGovcClient) RenameVMArgsForCall(i int) (string, string) {
+	fake.renameVMMutex.RLock()
+	defer fake.renameVMMutex.RUnlock()
+	return fake.renameVMArgsForCall[i].arg1, fake.renameVMArgsForCall[i].arg2
+}
+
+func (fake *FakeGovcClient) RenameVMReturns(result1 error) {
+	fake.RenameVMStub = nil
+	fake.renameVMReturns = struct {
+		result1 error
+	}{result1}
+}
+
+func (fake *FakeGovcClient) RenameVMReturnsOnCall(i int, result1 error) {
+	fake.RenameVMStub = nil
+	if fake.renameVMReturnsOnCall == nil {
+		fake.renameVMReturnsOnCall = make(map[int]struct {
+			result1 error
+		})
+	}
+	fake.renameVMReturnsOnCall[i] = struct {
+		result1 error
+	}{result1}
+}
+
 func (fake *FakeGovcClient) UpdateVMIso(arg1 string, arg2 string) (string, error) {
 	fake.updateVMIsoMutex.Lock()
 	ret, specificReturn := fake.updateVMIsoReturnsOnCall[len(fake.updateVMIsoArgsForCall)]
@@ -384,6 +615,54 @@ func (fake *FakeGovcClient) StartVMReturnsOnCall(i int, result1 string, result2
 	}{result1, result2}
 }
 
+func (fake *FakeGovcClient) RebootVM(arg1 string) error {
+	fake.rebootVMMutex.Lock()
+	ret, specificReturn := fake.rebootVMReturnsOnCall[len(fake.rebootVMArgsForCall)]
+	fake.rebootVMArgsForCall = append(fake.rebootVMArgsForCall, struct {
+		arg1 string
+	}{arg1})
+	fake.recordInvocation("RebootVM", []interface{}{arg1})
+	fake.rebootVMMutex.Unlock()
+	if fake.RebootVMStub != nil {
+		return fake.RebootVMStub(arg1)
+	}
+	if specificReturn {
+		return ret.result1
+	}
+	return fake.rebootVMReturns.result1
+}
+
+func (fake *FakeGovcClient) RebootVMCallCount() int {
+	fake.rebootVMMutex.RLock()
+	defer fake.rebootVMMutex.RUnlock()
+	return len(fake.rebootVMArgsForCall)
+}
+
+func (fake *FakeGovcClient) RebootVMArgsForCall(i int) string {
+	fake.rebootVMMutex.RLock()
+	defer fake.rebootVMMutex.RUnlock()
+	return fake.rebootVMArgsForCall[i].arg1
+}
+
+func (fake *FakeGovcClient) RebootVMReturns(result1 error) {
+	fake.RebootVMStub = nil
+	fake.rebootVMReturns = struct {
+		result1 error
+	}{result1}
+}
+
+func (fake *FakeGovcClient) RebootVMReturnsOnCall(i int, result1 error) {
+	fake.RebootVMStub = nil
+	if fake.rebootVMReturnsOnCall == nil {
+		fake.rebootVMReturnsOnCall = make(map[int]struct {
+			result1 error
+		})
+	}
+	fake.rebootVMReturnsOnCall[i] = struct {
+		result1 error
+	}{result1}
+}
+
 func (fake *FakeGovcClient) HasVM(arg1 string) (bool, error) {
 	fake.hasVMMutex.Lock()
 	ret, specificReturn := fake.hasVMReturnsOnCall[len(fake.hasVMArgsForCall)]
@@ -435,155 +714,307 @@ func (fake *FakeGovcClient) HasVMReturnsOnCall(i int, result1 bool, result2 erro
 	}{result1, result2}
 }
 
-func (fake *FakeGovcClient) SetVMNetworkAdapter(arg1 string, arg2 string, arg3 string) error {
-	fake.setVMNetworkAdapterMutex.Lock()
-	ret, specificReturn := fake.setVMNetworkAdapterReturnsOnCall[len(fake.setVMNetworkAdapterArgsForCall)]
-	fake.setVMNetworkAdapterArgsForCall = append(fake.setVMNetworkAdapterArgsForCall, struct {
+func (fake *FakeGovcClient) ConfigureVM(arg1 string, arg2 govc.VMSpec) error {
+	fake.configureVMMutex.Lock()
+	ret, specificReturn := fake.configureVMReturnsOnCall[len(fake.configureVMArgsForCall)]
+	fake.configureVMArgsForCall = append(fake.configureVMArgsForCall, struct {
 		arg1 string
-		arg2 string
-		arg3 string
-	}{arg1, arg2, arg3})
-	fake.recordInvocation("SetVMNetworkAdapter", []interface{}{arg1, arg2, arg3})
-	fake.setVMNetworkAdapterMutex.Unlock()
-	if fake.SetVMNetworkAdapterStub != nil {
-		return fake.SetVMNetworkAdapterStub(arg1, arg2, arg3)
+		arg2 govc.VMSpec
+	}{arg1, arg2})
+	fake.recordInvocation("ConfigureVM", []interface{}{arg1, arg2})
+	fake.configureVMMutex.Unlock()
+	if fake.ConfigureVMStub != nil {
+		return fake.ConfigureVMStub(arg1, arg2)
 	}
 	if specificReturn {
 		return ret.result1
 	}
-	return fake.setVMNetworkAdapterReturns.result1
+	return fake.configureVMReturns.result1
 }
 
-func (fake *FakeGovcClient) SetVMNetworkAdapterCallCount() int {
-	fake.setVMNetworkAdapterMutex.RLock()
-	defer fake.setVMNetworkAdapterMutex.RUnlock()
-	return len(fake.setVMNetworkAdapterArgsForCall)
+func (fake *FakeGovcClient) ConfigureVMCallCount() int {
+	fake.configureVMMutex.RLock()
+	defer fake.configureVMMutex.RUnlock()
+	return len(fake.configureVMArgsForCall)
 }
 
-func (fake *FakeGovcClient) SetVMNetworkAdapterArgsForCall(i int) (string, string, string) {
-	fake.setVMNetworkAdapterMutex.RLock()
-	defer fake.setVMNetworkAdapterMutex.RUnlock()
-	return fake.setVMNetworkAdapterArgsForCall[i].arg1, fake.setVMNetworkAdapterArgsForCall[i].arg2, fake.setVMNetworkAdapterArgsForCall[i].arg3
+func (fake *FakeGovcClient) ConfigureVMArgsForCall(i int) (string, govc.VMSpec) {
+	fake.configureVMMutex.RLock()
+	defer fake.configureVMMutex.RUnlock()
+	return fake.configureVMArgsForCall[i].arg1, fake.configureVMArgsForCall[i].arg2
 }
 
-func (fake *FakeGovcClient) SetVMNetworkAdapterReturns(result1 error) {
-	fake.SetVMNetworkAdapterStub = nil
-	fake.setVMNetworkAdapterReturns = struct {
+func (fake *FakeGovcClient) ConfigureVMReturns(result1 error) {
+	fake.ConfigureVMStub = nil
+	fake.configureVMReturns = struct {
 		result1 error
 	}{result1}
 }
 
-func (fake *FakeGovcClient) SetVMNetworkAdapterReturnsOnCall(i int, result1 error) {
-	fake.SetVMNetworkAdapterStub = nil
-	if fake.setVMNetworkAdapterReturnsOnCall == nil {
-		fake.setVMNetworkAdapterReturnsOnCall = make(map[int]struct {
+func (fake *FakeGovcClient) ConfigureVMReturnsOnCall(i int, result1 error) {
+	fake.ConfigureVMStub = nil
+	if fake.configureVMReturnsOnCall == nil {
+		fake.configureVMReturnsOnCall = make(map[int]struct {
 			result1 error
 		})
 	}
-	fake.setVMNetworkAdapterReturnsOnCall[i] = struct {
+	fake.configureVMReturnsOnCall[i] = struct {
 		result1 error
 	}{result1}
 }
 
-func (fake *FakeGovcClient) SetVMResources(arg1 string, arg2 int, arg3 int) error {
-	fake.setVMResourcesMutex.Lock()
-	ret, specificReturn := fake.setVMResourcesReturnsOnCall[len(fake.setVMResourcesArgsForCall)]
-	fake.setVMResourcesArgsForCall = append(fake.setVMResourcesArgsForCall, struct {
+func (fake *FakeGovcClient) SetVMExtraConfig(arg1 string, arg2 map[string]string) error {
+	fake.setVMExtraConfigMutex.Lock()
+	ret, specificReturn := fake.setVMExtraConfigReturnsOnCall[len(fake.setVMExtraConfigArgsForCall)]
+	fake.setVMExtraConfigArgsForCall = append(fake.setVMExtraConfigArgsForCall, struct {
 		arg1 string
-		arg2 int
-		arg3 int
-	}{arg1, arg2, arg3})
-	fake.recordInvocation("SetVMResources", []interface{}{arg1, arg2, arg3})
-	fake.setVMResourcesMutex.Unlock()
-	if fake.SetVMResourcesStub != nil {
-		return fake.SetVMResourcesStub(arg1, arg2, arg3)
+		arg2 map[string]string
+	}{arg1, arg2})
+	fake.recordInvocation("SetVMExtraConfig", []interface{}{arg1, arg2})
+	fake.setVMExtraConfigMutex.Unlock()
+	if fake.SetVMExtraConfigStub != nil {
+		return fake.SetVMExtraConfigStub(arg1, arg2)
 	}
 	if specificReturn {
 		return ret.result1
 	}
-	return fake.setVMResourcesReturns.result1
+	return fake.setVMExtraConfigReturns.result1
 }
 
-func (fake *FakeGovcClient) SetVMResourcesCallCount() int {
-	fake.setVMResourcesMutex.RLock()
-	defer fake.setVMResourcesMutex.RUnlock()
-	return len(fake.setVMResourcesArgsForCall)
+func (fake *FakeGovcClient) SetVMExtraConfigCallCount() int {
+	fake.setVMExtraConfigMutex.RLock()
+	defer fake.setVMExtraConfigMutex.RUnlock()
+	return len(fake.setVMExtraConfigArgsForCall)
 }
 
-func (fake *FakeGovcClient) SetVMResourcesArgsForCall(i int) (string, int, int) {
-	fake.setVMResourcesMutex.RLock()
-	defer fake.setVMResourcesMutex.RUnlock()
-	return fake.setVMResourcesArgsForCall[i].arg1, fake.setVMResourcesArgsForCall[i].arg2, fake.setVMResourcesArgsForCall[i].arg3
+func (fake *FakeGovcClient) SetVMExtraConfigArgsForCall(i int) (string, map[string]string) {
+	fake.setVMExtraConfigMutex.RLock()
+	defer fake.setVMExtraConfigMutex.RUnlock()
+	return fake.setVMExtraConfigArgsForCall[i].arg1, fake.setVMExtraConfigArgsForCall[i].arg2
 }
 
-func (fake *FakeGovcClient) SetVMResourcesReturns(result1 error) {
-	fake.SetVMResourcesStub = nil
-	fake.setVMResourcesReturns = struct {
+func (fake *FakeGovcClient) SetVMExtraConfigReturns(result1 error) {
+	fake.SetVMExtraConfigStub = nil
+	fake.setVMExtraConfigReturns = struct {
 		result1 error
 	}{result1}
 }
 
-func (fake *FakeGovcClient) SetVMResourcesReturnsOnCall(i int, result1 error) {
-	fake.SetVMResourcesStub = nil
-	if fake.setVMResourcesReturnsOnCall == nil {
-		fake.setVMResourcesReturnsOnCall = make(map[int]struct {
+func (fake *FakeGovcClient) SetVMExtraConfigReturnsOnCall(i int, result1 error) {
+	fake.SetVMExtraConfigStub = nil
+	if fake.setVMExtraConfigReturnsOnCall == nil {
+		fake.setVMExtraConfigReturnsOnCall = make(map[int]struct {
 			result1 error
 		})
 	}
-	fake.setVMResourcesReturnsOnCall[i] = struct {
+	fake.setVMExtraConfigReturnsOnCall[i] = struct {
 		result1 error
 	}{result1}
 }
 
-func (fake *FakeGovcClient) CreateEphemeralDisk(arg1 string, arg2 int) error {
-	fake.createEphemeralDiskMutex.Lock()
-	ret, specificReturn := fake.createEphemeralDiskReturnsOnCall[len(fake.createEphemeralDiskArgsForCall)]
-	fake.createEphemeralDiskArgsForCall = append(fake.createEphemeralDiskArgsForCall, struct {
+func (fake *FakeGovcClient) GetVMExtraConfig(arg1 string) (map[string]string, error) {
+	fake.getVMExtraConfigMutex.Lock()
+	ret, specificReturn := fake.getVMExtraConfigReturnsOnCall[len(fake.getVMExtraConfigArgsForCall)]
+	fake.getVMExtraConfigArgsForCall = append(fake.getVMExtraConfigArgsForCall, struct {
 		arg1 string
-		arg2 int
-	}{arg1, arg2})
-	fake.recordInvocation("CreateEphemeralDisk", []interface{}{arg1, arg2})
-	fake.createEphemeralDiskMutex.Unlock()
-	if fake.CreateEphemeralDiskStub != nil {
-		return fake.CreateEphemeralDiskStub(arg1, arg2)
+	}{arg1})
+	fake.recordInvocation("GetVMExtraConfig", []interface{}{arg1})
+	fake.getVMExtraConfigMutex.Unlock()
+	if fake.GetVMExtraConfigStub != nil {
+		return fake.GetVMExtraConfigStub(arg1)
+	}
+	if specificReturn {
+		return ret.result1, ret.result2
+	}
+	return fake.getVMExtraConfigReturns.result1, fake.getVMExtraConfigReturns.result2
+}
+
+func (fake *FakeGovcClient) GetVMExtraConfigCallCount() int {
+	fake.getVMExtraConfigMutex.RLock()
+	defer fake.getVMExtraConfigMutex.RUnlock()
+	return len(fake.getVMExtraConfigArgsForCall)
+}
+
+func (fake *FakeGovcClient) GetVMExtraConfigArgsForCall(i int) string {
+	fake.getVMExtraConfigMutex.RLock()
+	defer fake.getVMExtraConfigMutex.RUnlock()
+	return fake.getVMExtraConfigArgsForCall[i].arg1
+}
+
+func (fake *FakeGovcClient) GetVMExtraConfigReturns(result1 map[string]string, result2 error) {
+	fake.GetVMExtraConfigStub = nil
+	fake.getVMExtraConfigReturns = struct {
+		result1 map[string]string
+		result2 error
+	}{result1, result2}
+}
+
+func (fake *FakeGovcClient) GetVMExtraConfigReturnsOnCall(i int, result1 map[string]string, result2 error) {
+	fake.GetVMExtraConfigStub = nil
+	if fake.getVMExtraConfigReturnsOnCall == nil {
+		fake.getVMExtraConfigReturnsOnCall = make(map[int]struct {
+			result1 map[string]string
+			result2 error
+		})
+	}
+	fake.getVMExtraConfigReturnsOnCall[i] = struct {
+		result1 map[string]string
+		result2 error
+	}{result1, result2}
+}
+
+func (fake *FakeGovcClient) EnsurePortGroup(arg1 string, arg2 string, arg3 int) (bool, error) {
+	fake.ensurePortGroupMutex.Lock()
+	ret, specificReturn := fake.ensurePortGroupReturnsOnCall[len(fake.ensurePortGroupArgsForCall)]
+	fake.ensurePortGroupArgsForCall = append(fake.ensurePortGroupArgsForCall, struct {
+		arg1 string
+		arg2 string
+		arg3 int
+	}{arg1, arg2, arg3})
+	fake.recordInvocation("EnsurePortGroup", []interface{}{arg1, arg2, arg3})
+	fake.ensurePortGroupMutex.Unlock()
+	if fake.EnsurePortGroupStub != nil {
+		return fake.EnsurePortGroupStub(arg1, arg2, arg3)
+	}
+	if specificReturn {
+		return ret.result1, ret.result2
+	}
+	return fake.ensurePortGroupReturns.result1, fake.ensurePortGroupReturns.result2
+}
+
+func (fake *FakeGovcClient) EnsurePortGroupCallCount() int {
+	fake.ensurePortGroupMutex.RLock()
+	defer fake.ensurePortGroupMutex.RUnlock()
+	return len(fake.ensurePortGroupArgsForCall)
+}
+
+func (fake *FakeGovcClient) EnsurePortGroupArgsForCall(i int) (string, string, int) {
+	fake.ensurePortGroupMutex.RLock()
+	defer fake.ensurePortGroupMutex.RUnlock()
+	return fake.ensurePortGroupArgsForCall[i].arg1, fake.ensurePortGroupArgsForCall[i].arg2, fake.ensurePortGroupArgsForCall[i].arg3
+}
+
+func (fake *FakeGovcClient) EnsurePortGroupReturns(result1 bool, result2 error) {
+	fake.EnsurePortGroupStub = nil
+	fake.ensurePortGroupReturns = struct {
+		result1 bool
+		result2 error
+	}{result1, result2}
+}
+
+func (fake *FakeGovcClient) EnsurePortGroupReturnsOnCall(i int, result1 bool, result2 error) {
+	fake.EnsurePortGroupStub = nil
+	if fake.ensurePortGroupReturnsOnCall == nil {
+		fake.ensurePortGroupReturnsOnCall = make(map[int]struct {
+			result1 bool
+			result2 error
+		})
+	}
+	fake.ensurePortGroupReturnsOnCall[i] = struct {
+		result1 bool
+		result2 error
+	}{result1, result2}
+}
+
+func (fake *FakeGovcClient) RemovePortGroup(arg1 string) error {
+	fake.removePortGroupMutex.Lock()
+	ret, specificReturn := fake.removePortGroupReturnsOnCall[len(fake.removePortGroupArgsForCall)]
+	fake.removePortGroupArgsForCall = append(fake.removePortGroupArgsForCall, struct {
+		arg1 string
+	}{arg1})
+	fake.recordInvocation("RemovePortGroup", []interface{}{arg1})
+	fake.removePortGroupMutex.Unlock()
+	if fake.RemovePortGroupStub != nil {
+		return fake.RemovePortGroupStub(arg1)
 	}
 	if specificReturn {
 		return ret.result1
 	}
-	return fake.createEphemeralDiskReturns.result1
+	return fake.removePortGroupReturns.result1
 }
 
-func (fake *FakeGovcClient) CreateEphemeralDiskCallCount() int {
-	fake.createEphemeralDiskMutex.RLock()
-	defer fake.createEphemeralDiskMutex.RUnlock()
-	return len(fake.createEphemeralDiskArgsForCall)
+func (fake *FakeGovcClient) RemovePortGroupCallCount() int {
+	fake.removePortGroupMutex.RLock()
+	defer fake.removePortGroupMutex.RUnlock()
+	return len(fake.removePortGroupArgsForCall)
 }
 
-func (fake *FakeGovcClient) CreateEphemeralDiskArgsForCall(i int) (string, int) {
-	fake.createEphemeralDiskMutex.RLock()
-	defer fake.createEphemeralDiskMutex.RUnlock()
-	return fake.createEphemeralDiskArgsForCall[i].arg1, fake.createEphemeralDiskArgsForCall[i].arg2
+func (fake *FakeGovcClient) RemovePortGroupArgsForCall(i int) string {
+	fake.removePortGroupMutex.RLock()
+	defer fake.removePortGroupMutex.RUnlock()
+	return fake.removePortGroupArgsForCall[i].arg1
 }
 
-func (fake *FakeGovcClient) CreateEphemeralDiskReturns(result1 error) {
-	fake.CreateEphemeralDiskStub = nil
-	fake.createEphemeralDiskReturns = struct {
+func (fake *FakeGovcClient) RemovePortGroupReturns(result1 error) {
+	fake.RemovePortGroupStub = nil
+	fake.removePortGroupReturns = struct {
 		result1 error
 	}{result1}
 }
 
-func (fake *FakeGovcClient) CreateEphemeralDiskReturnsOnCall(i int, result1 error) {
-	fake.CreateEphemeralDiskStub = nil
-	if fake.createEphemeralDiskReturnsOnCall == nil {
-		fake.createEphemeralDiskReturnsOnCall = make(map[int]struct {
+func (fake *FakeGovcClient) RemovePortGroupReturnsOnCall(i int, result1 error) {
+	fake.RemovePortGroupStub = nil
+	if fake.removePortGroupReturnsOnCall == nil {
+		fake.removePortGroupReturnsOnCall = make(map[int]struct {
 			result1 error
 		})
 	}
-	fake.createEphemeralDiskReturnsOnCall[i] = struct {
+	fake.removePortGroupReturnsOnCall[i] = struct {
 		result1 error
 	}{result1}
 }
 
+func (fake *FakeGovcClient) GetVMNetworkAttachmentIDs(arg1 string) ([]string, error) {
+	fake.getVMNetworkAttachmentIDsMutex.Lock()
+	ret, specificReturn := fake.getVMNetworkAttachmentIDsReturnsOnCall[len(fake.getVMNetworkAttachmentIDsArgsForCall)]
+	fake.getVMNetworkAttachmentIDsArgsForCall = append(fake.getVMNetworkAttachmentIDsArgsForCall, struct {
+		arg1 string
+	}{arg1})
+	fake.recordInvocation("GetVMNetworkAttachmentIDs", []interface{}{arg1})
+	fake.getVMNetworkAttachmentIDsMutex.Unlock()
+	if fake.GetVMNetworkAttachmentIDsStub != nil {
+		return fake.GetVMNetworkAttachmentIDsStub(arg1)
+	}
+	if specificReturn {
+		return ret.result1, ret.result2
+	}
+	return fake.getVMNetworkAttachmentIDsReturns.result1, fake.getVMNetworkAttachmentIDsReturns.result2
+}
+
+func (fake *FakeGovcClient) GetVMNetworkAttachmentIDsCallCount() int {
+	fake.getVMNetworkAttachmentIDsMutex.RLock()
+	defer fake.getVMNetworkAttachmentIDsMutex.RUnlock()
+	return len(fake.getVMNetworkAttachmentIDsArgsForCall)
+}
+
+func (fake *FakeGovcClient) GetVMNetworkAttachmentIDsArgsForCall(i int) string {
+	fake.getVMNetworkAttachmentIDsMutex.RLock()
+	defer fake.getVMNetworkAttachmentIDsMutex.RUnlock()
+	return fake.getVMNetworkAttachmentIDsArgsForCall[i].arg1
+}
+
+func (fake *FakeGovcClient) GetVMNetworkAttachmentIDsReturns(result1 []string, result2 error) {
+	fake.GetVMNetworkAttachmentIDsStub = nil
+	fake.getVMNetworkAttachmentIDsReturns = struct {
+		result1 []string
+		result2 error
+	}{result1, result2}
+}
+
+func (fake *FakeGovcClient) GetVMNetworkAttachmentIDsReturnsOnCall(i int, result1 []string, result2 error) {
+	fake.GetVMNetworkAttachmentIDsStub = nil
+	if fake.getVMNetworkAttachmentIDsReturnsOnCall == nil {
+		fake.getVMNetworkAttachmentIDsReturnsOnCall = make(map[int]struct {
+			result1 []string
+			result2 error
+		})
+	}
+	fake.getVMNetworkAttachmentIDsReturnsOnCall[i] = struct {
+		result1 []string
+		result2 error
+	}{result1, result2}
+}
+
 func (fake *FakeGovcClient) CreateDisk(arg1 string, arg2 int) error {
 	fake.createDiskMutex.Lock()
 	ret, specificReturn := fake.createDiskReturnsOnCall[len(fake.createDiskArgsForCall)]
@@ -830,25 +1261,259 @@ func (fake *FakeGovcClient) DestroyVMReturnsOnCall(i int, result1 string, result
 	}{result1, result2}
 }
 
+func (fake *FakeGovcClient) DestroyVMIso(arg1 string) error {
+	fake.destroyVMIsoMutex.Lock()
+	ret, specificReturn := fake.destroyVMIsoReturnsOnCall[len(fake.destroyVMIsoArgsForCall)]
+	fake.destroyVMIsoArgsForCall = append(fake.destroyVMIsoArgsForCall, struct {
+		arg1 string
+	}{arg1})
+	fake.recordInvocation("DestroyVMIso", []interface{}{arg1})
+	fake.destroyVMIsoMutex.Unlock()
+	if fake.DestroyVMIsoStub != nil {
+		return fake.DestroyVMIsoStub(arg1)
+	}
+	if specificReturn {
+		return ret.result1
+	}
+	return fake.destroyVMIsoReturns.result1
+}
+
+func (fake *FakeGovcClient) DestroyVMIsoCallCount() int {
+	fake.destroyVMIsoMutex.RLock()
+	defer fake.destroyVMIsoMutex.RUnlock()
+	return len(fake.destroyVMIsoArgsForCall)
+}
+
+func (fake *FakeGovcClient) DestroyVMIsoArgsForCall(i int) string {
+	fake.destroyVMIsoMutex.RLock()
+	defer fake.destroyVMIsoMutex.RUnlock()
+	return fake.destroyVMIsoArgsForCall[i].arg1
+}
+
+func (fake *FakeGovcClient) DestroyVMIsoReturns(result1 error) {
+	fake.DestroyVMIsoStub = nil
+	fake.destroyVMIsoReturns = struct {
+		result1 error
+	}{result1}
+}
+
+func (fake *FakeGovcClient) DestroyVMIsoReturnsOnCall(i int, result1 error) {
+	fake.DestroyVMIsoStub = nil
+	if fake.destroyVMIsoReturnsOnCall == nil {
+		fake.destroyVMIsoReturnsOnCall = make(map[int]struct {
+			result1 error
+		})
+	}
+	fake.destroyVMIsoReturnsOnCall[i] = struct {
+		result1 error
+	}{result1}
+}
+
+func (fake *FakeGovcClient) DestroyVMReplicas(arg1 string) error {
+	fake.destroyVMReplicasMutex.Lock()
+	ret, specificReturn := fake.destroyVMReplicasReturnsOnCall[len(fake.destroyVMReplicasArgsForCall)]
+	fake.destroyVMReplicasArgsForCall = append(fake.destroyVMReplicasArgsForCall, struct {
+		arg1 string
+	}{arg1})
+	fake.recordInvocation("DestroyVMReplicas", []interface{}{arg1})
+	fake.destroyVMReplicasMutex.Unlock()
+	if fake.DestroyVMReplicasStub != nil {
+		return fake.DestroyVMReplicasStub(arg1)
+	}
+	if specificReturn {
+		return ret.result1
+	}
+	return fake.destroyVMReplicasReturns.result1
+}
+
+func (fake *FakeGovcClient) DestroyVMReplicasCallCount() int {
+	fake.destroyVMReplicasMutex.RLock()
+	defer fake.destroyVMReplicasMutex.RUnlock()
+	return len(fake.destroyVMReplicasArgsForCall)
+}
+
+func (fake *FakeGovcClient) DestroyVMReplicasArgsForCall(i int) string {
+	fake.destroyVMReplicasMutex.RLock()
+	defer fake.destroyVMReplicasMutex.RUnlock()
+	return fake.destroyVMReplicasArgsForCall[i].arg1
+}
+
+func (fake *FakeGovcClient) DestroyVMReplicasReturns(result1 error) {
+	fake.DestroyVMReplicasStub = nil
+	fake.destroyVMReplicasReturns = struct {
+		result1 error
+	}{result1}
+}
+
+func (fake *FakeGovcClient) DestroyVMReplicasReturnsOnCall(i int, result1 error) {
+	fake.DestroyVMReplicasStub = nil
+	if fake.destroyVMReplicasReturnsOnCall == nil {
+		fake.destroyVMReplicasReturnsOnCall = make(map[int]struct {
+			result1 error
+		})
+	}
+	fake.destroyVMReplicasReturnsOnCall[i] = struct {
+		result1 error
+	}{result1}
+}
+
+func (fake *FakeGovcClient) ListVMs() ([]string, error) {
+	fake.listVMsMutex.Lock()
+	ret, specificReturn := fake.listVMsReturnsOnCall[len(fake.listVMsArgsForCall)]
+	fake.listVMsArgsForCall = append(fake.listVMsArgsForCall, struct{}{})
+	fake.recordInvocation("ListVMs", []interface{}{})
+	fake.listVMsMutex.Unlock()
+	if fake.ListVMsStub != nil {
+		return fake.ListVMsStub()
+	}
+	if specificReturn {
+		return ret.result1, ret.result2
+	}
+	return fake.listVMsReturns.result1, fake.listVMsReturns.result2
+}
+
+func (fake *FakeGovcClient) ListVMsCallCount() int {
+	fake.listVMsMutex.RLock()
+	defer fake.listVMsMutex.RUnlock()
+	return len(fake.listVMsArgsForCall)
+}
+
+func (fake *FakeGovcClient) ListVMsReturns(result1 []string, result2 error) {
+	fake.ListVMsStub = nil
+	fake.listVMsReturns = struct {
+		result1 []string
+		result2 error
+	}{result1, result2}
+}
+
+func (fake *FakeGovcClient) ListVMsReturnsOnCall(i int, result1 []string, result2 error) {
+	fake.ListVMsStub = nil
+	if fake.listVMsReturnsOnCall == nil {
+		fake.listVMsReturnsOnCall = make(map[int]struct {
+			result1 []string
+			result2 error
+		})
+	}
+	fake.listVMsReturnsOnCall[i] = struct {
+		result1 []string
+		result2 error
+	}{result1, result2}
+}
+
+func (fake *FakeGovcClient) ListDatastoreFiles() ([]govc.DatastoreFile, error) {
+	fake.listDatastoreFilesMutex.Lock()
+	ret, specificReturn := fake.listDatastoreFilesReturnsOnCall[len(fake.listDatastoreFilesArgsForCall)]
+	fake.listDatastoreFilesArgsForCall = append(fake.listDatastoreFilesArgsForCall, struct{}{})
+	fake.recordInvocation("ListDatastoreFiles", []interface{}{})
+	fake.listDatastoreFilesMutex.Unlock()
+	if fake.ListDatastoreFilesStub != nil {
+		return fake.ListDatastoreFilesStub()
+	}
+	if specificReturn {
+		return ret.result1, ret.result2
+	}
+	return fake.listDatastoreFilesReturns.result1, fake.listDatastoreFilesReturns.result2
+}
+
+func (fake *FakeGovcClient) ListDatastoreFilesCallCount() int {
+	fake.listDatastoreFilesMutex.RLock()
+	defer fake.listDatastoreFilesMutex.RUnlock()
+	return len(fake.listDatastoreFilesArgsForCall)
+}
+
+func (fake *FakeGovcClient) ListDatastoreFilesReturns(result1 []govc.DatastoreFile, result2 error) {
+	fake.ListDatastoreFilesStub = nil
+	fake.listDatastoreFilesReturns = struct {
+		result1 []govc.DatastoreFile
+		result2 error
+	}{result1, result2}
+}
+
+func (fake *FakeGovcClient) ListDatastoreFilesReturnsOnCall(i int, result1 []govc.DatastoreFile, result2 error) {
+	fake.ListDatastoreFilesStub = nil
+	if fake.listDatastoreFilesReturnsOnCall == nil {
+		fake.listDatastoreFilesReturnsOnCall = make(map[int]struct {
+			result1 []govc.DatastoreFile
+			result2 error
+		})
+	}
+	fake.listDatastoreFilesReturnsOnCall[i] = struct {
+		result1 []govc.DatastoreFile
+		result2 error
+	}{result1, result2}
+}
+
+func (fake *FakeGovcClient) Host() string {
+	fake.hostMutex.Lock()
+	ret, specificReturn := fake.hostReturnsOnCall[len(fake.hostArgsForCall)]
+	fake.hostArgsForCall = append(fake.hostArgsForCall, struct{}{})
+	fake.recordInvocation("Host", []interface{}{})
+	fake.hostMutex.Unlock()
+	if fake.HostStub != nil {
+		return fake.HostStub()
+	}
+	if specificReturn {
+		return ret.result1
+	}
+	return fake.hostReturns.result1
+}
+
+func (fake *FakeGovcClient) HostCallCount() int {
+	fake.hostMutex.RLock()
+	defer fake.hostMutex.RUnlock()
+	return len(fake.hostArgsForCall)
+}
+
+func (fake *FakeGovcClient) HostReturns(result1 string) {
+	fake.HostStub = nil
+	fake.hostReturns = struct {
+		result1 string
+	}{result1}
+}
+
+func (fake *FakeGovcClient) HostReturnsOnCall(i int, result1 string) {
+	fake.HostStub = nil
+	if fake.hostReturnsOnCall == nil {
+		fake.hostReturnsOnCall = make(map[int]struct {
+			result1 string
+		})
+	}
+	fake.hostReturnsOnCall[i] = struct {
+		result1 string
+	}{result1}
+}
+
 func (fake *FakeGovcClient) Invocations() map[string][][]interface{} {
 	fake.invocationsMutex.RLock()
 	defer fake.invocationsMutex.RUnlock()
-	fake.importOvfMutex.RLock()
-	defer fake.importOvfMutex.RUnlock()
+	fake.importStemcellMutex.RLock()
+	defer fake.importStemcellMutex.RUnlock()
 	fake.cloneVMMutex.RLock()
 	defer fake.cloneVMMutex.RUnlock()
+	fake.cloneVMXMutex.RLock()
+	defer fake.cloneVMXMutex.RUnlock()
+	fake.renameVMMutex.RLock()
+	defer fake.renameVMMutex.RUnlock()
 	fake.updateVMIsoMutex.RLock()
 	defer fake.updateVMIsoMutex.RUnlock()
 	fake.startVMMutex.RLock()
 	defer fake.startVMMutex.RUnlock()
+	fake.rebootVMMutex.RLock()
+	defer fake.rebootVMMutex.RUnlock()
 	fake.hasVMMutex.RLock()
 	defer fake.hasVMMutex.RUnlock()
-	fake.setVMNetworkAdapterMutex.RLock()
-	defer fake.setVMNetworkAdapterMutex.RUnlock()
-	fake.setVMResourcesMutex.RLock()
-	defer fake.setVMResourcesMutex.RUnlock()
-	fake.createEphemeralDiskMutex.RLock()
-	defer fake.createEphemeralDiskMutex.RUnlock()
+	fake.configureVMMutex.RLock()
+	defer fake.configureVMMutex.RUnlock()
+	fake.setVMExtraConfigMutex.RLock()
+	defer fake.setVMExtraConfigMutex.RUnlock()
+	fake.getVMExtraConfigMutex.RLock()
+	defer fake.getVMExtraConfigMutex.RUnlock()
+	fake.ensurePortGroupMutex.RLock()
+	defer fake.ensurePortGroupMutex.RUnlock()
+	fake.removePortGroupMutex.RLock()
+	defer fake.removePortGroupMutex.RUnlock()
+	fake.getVMNetworkAttachmentIDsMutex.RLock()
+	defer fake.getVMNetworkAttachmentIDsMutex.RUnlock()
 	fake.createDiskMutex.RLock()
 	defer fake.createDiskMutex.RUnlock()
 	fake.attachDiskMutex.RLock()
@@ -859,6 +1524,16 @@ func (fake *FakeGovcClient) Invocations() map[string][][]interface{} {
 	defer fake.destroyDiskMutex.RUnlock()
 	fake.destroyVMMutex.RLock()
 	defer fake.destroyVMMutex.RUnlock()
+	fake.destroyVMIsoMutex.RLock()
+	defer fake.destroyVMIsoMutex.RUnlock()
+	fake.destroyVMReplicasMutex.RLock()
+	defer fake.destroyVMReplicasMutex.RUnlock()
+	fake.listVMsMutex.RLock()
+	defer fake.listVMsMutex.RUnlock()
+	fake.listDatastoreFilesMutex.RLock()
+	defer fake.listDatastoreFilesMutex.RUnlock()
+	fake.hostMutex.RLock()
+	defer fake.hostMutex.RUnlock()
 	copiedInvocations := map[string][][]interface{}{}
 	for key, value := range fake.invocations {
 		copiedInvocations[key] = value
@@ -16,6 +16,15 @@ type FakeGovcConfig struct {
 	esxUrlReturnsOnCall map[int]struct {
 		result1 string
 	}
+	DatacenterStub        func() string
+	datacenterMutex       sync.RWMutex
+	datacenterArgsForCall []struct{}
+	datacenterReturns     struct {
+		result1 string
+	}
+	datacenterReturnsOnCall map[int]struct {
+		result1 string
+	}
 	invocations      map[string][][]interface{}
 	invocationsMutex sync.RWMutex
 }
@@ -60,11 +69,53 @@ func (fake *FakeGovcConfig) EsxUrlReturnsOnCall(i int, result1 string) {
 	}{result1}
 }
 
+func (fake *FakeGovcConfig) Datacenter() string {
+	fake.datacenterMutex.Lock()
+	ret, specificReturn := fake.datacenterReturnsOnCall[len(fake.datacenterArgsForCall)]
+	fake.datacenterArgsForCall = append(fake.datacenterArgsForCall, struct{}{})
+	fake.recordInvocation("Datacenter", []interface{}{})
+	fake.datacenterMutex.Unlock()
+	if fake.DatacenterStub != nil {
+		return fake.DatacenterStub()
+	}
+	if specificReturn {
+		return ret.result1
+	}
+	return fake.datacenterReturns.result1
+}
+
+func (fake *FakeGovcConfig) DatacenterCallCount() int {
+	fake.datacenterMutex.RLock()
+	defer fake.datacenterMutex.RUnlock()
+	return len(fake.datacenterArgsForCall)
+}
+
+func (fake *FakeGovcConfig) DatacenterReturns(result1 string) {
+	fake.DatacenterStub = nil
+	fake.datacenterReturns = struct {
+		result1 string
+	}{result1}
+}
+
+func (fake *FakeGovcConfig) DatacenterReturnsOnCall(i int, result1 string) {
+	fake.DatacenterStub = nil
+	if fake.datacenterReturnsOnCall == nil {
+		fake.datacenterReturnsOnCall = make(map[int]struct {
+			result1 string
+		})
+	}
+	fake.datacenterReturnsOnCall[i] = struct {
+		result1 string
+	}{result1}
+}
+
 func (fake *FakeGovcConfig) Invocations() map[string][][]interface{} {
 	fake.invocationsMutex.RLock()
 	defer fake.invocationsMutex.RUnlock()
 	fake.esxUrlMutex.RLock()
 	defer fake.esxUrlMutex.RUnlock()
+	fake.datacenterMutex.RLock()
+	defer fake.datacenterMutex.RUnlock()
 	copiedInvocations := map[string][][]interface{}{}
 	for key, value := range fake.invocations {
 		copiedInvocations[key] = value
@@ -0,0 +1,127 @@
+package govc
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/url"
+	"reflect"
+	"strings"
+
+	"github.com/vmware/govmomi/find"
+	"github.com/vmware/govmomi/task"
+	"github.com/vmware/govmomi/vim25/soap"
+	"github.com/vmware/govmomi/vim25/types"
+)
+
+// faultName returns the vSphere fault type carried by err, e.g. "TaskInProgress",
+// or "" when err is not a vSphere fault
+func faultName(err error) string {
+	var fault interface{}
+
+	switch {
+	case soap.IsSoapFault(err):
+		fault = soap.ToSoapFault(err).VimFault()
+	case soap.IsVimFault(err):
+		fault = soap.ToVimFault(err)
+	default:
+		switch taskErr := err.(type) {
+		case task.Error:
+			fault = taskErr.Fault()
+		case *task.Error:
+			fault = taskErr.Fault()
+		}
+	}
+
+	if fault == nil {
+		return ""
+	}
+
+	if methodFault, ok := fault.(types.BaseMethodFault); ok {
+		fault = methodFault
+	}
+
+	typ := reflect.TypeOf(fault)
+	for typ.Kind() == reflect.Ptr {
+		typ = typ.Elem()
+	}
+
+	return typ.Name()
+}
+
+// ErrDiskNotAttached is returned by DetachDisk when the VM has no device backed by the disk
+var ErrDiskNotAttached = errors.New("disk is not attached")
+
+// vmNotFoundError is returned where govc reports a missing VM as an empty list, e.g. by vm.info
+type vmNotFoundError struct {
+	vmName string
+}
+
+func (e vmNotFoundError) Error() string {
+	return fmt.Sprintf("vm '%s' not found", e.vmName)
+}
+
+// IsVMNotFound is true when govc could not find the VM an operation refers to
+func IsVMNotFound(err error) bool {
+	if _, ok := err.(vmNotFoundError); ok {
+		return true
+	}
+
+	if notFound, ok := err.(*find.NotFoundError); ok {
+		return strings.HasPrefix(notFound.Error(), "vm ")
+	}
+
+	return faultName(err) == "ManagedObjectNotFound"
+}
+
+// IsFileNotFound is true when a datastore file, such as a disk, does not exist
+func IsFileNotFound(err error) bool {
+	return faultName(err) == "FileNotFound"
+}
+
+// IsInsufficientResources is true when the host lacks the memory, cpu or storage to run or place a VM
+func IsInsufficientResources(err error) bool {
+	name := faultName(err)
+	return strings.HasPrefix(name, "Insufficient") || name == "NoDiskSpace"
+}
+
+// IsNotLicensed is true when the host license forbids the operation, as the free ESXi license does for all API writes
+func IsNotLicensed(err error) bool {
+	return faultName(err) == "RestrictedVersion"
+}
+
+var connectionErrorMessages = []string{
+	"connection reset by peer",
+	"broken pipe",
+	"connection refused",
+	"TLS handshake timeout",
+	"unexpected EOF",
+}
+
+// isConnectionError is true for transport failures talking to hostd
+func isConnectionError(err error) bool {
+	if soap.IsRegularError(err) {
+		err = soap.ToRegularError(err)
+	}
+
+	if urlErr, ok := err.(*url.Error); ok {
+		err = urlErr.Err
+	}
+
+	if err == io.EOF || err == io.ErrUnexpectedEOF {
+		return true
+	}
+
+	if netErr, ok := err.(net.Error); ok && netErr.Timeout() {
+		return true
+	}
+
+	for _, message := range connectionErrorMessages {
+		if strings.Contains(err.Error(), message) {
+			return true
+		}
+	}
+
+	return false
+}
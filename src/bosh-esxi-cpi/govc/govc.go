@@ -1,20 +1,60 @@
 package govc
 
+import (
+	"bosh-esxi-cpi/vm"
+)
+
 //go:generate counterfeiter -o fakes/fake_govc_client.go $GOPATH/src/bosh-esxi-cpi/govc/govc.go GovcClient
 type GovcClient interface {
-	ImportOvf(string, string) (string, error)
-	CloneVM(string, string) (string, error)
+	ImportStemcell(string, string) (string, error)
+	CloneVM(string, string, string) (string, error)
+	CloneVMX(string, string) (string, error)
+	RenameVM(string, string) error
 	UpdateVMIso(string, string) (string, error)
 	StartVM(string) (string, error)
+	RebootVM(string) error
 	HasVM(string) (bool, error)
-	SetVMNetworkAdapter(string, string, string) error
-	SetVMResources(string, int, int) error
-	CreateEphemeralDisk(string, int) error
+	ConfigureVM(string, VMSpec) error
+	SetVMExtraConfig(string, map[string]string) error
+	GetVMExtraConfig(string) (map[string]string, error)
+	EnsurePortGroup(string, string, int) (bool, error)
+	RemovePortGroup(string) error
+	GetVMNetworkAttachmentIDs(string) ([]string, error)
 	CreateDisk(string, int) error
 	AttachDisk(string, string) error
 	DetachDisk(string, string) error
 	DestroyDisk(string) error
 	DestroyVM(string) (string, error)
+	DestroyVMIso(string) error
+	DestroyVMReplicas(string) error
+	ListVMs() ([]string, error)
+	ListDatastoreFiles() ([]DatastoreFile, error)
+	Host() string
+}
+
+// VMSpec is what create_vm sets up on a cloned VM before its first boot
+type VMSpec struct {
+	Props vm.VMProps
+
+	// ExtraConfig is added to the extra config derived from Props
+	ExtraConfig map[string]string
+	NICs        []NIC
+
+	// EnvIsoPath is the local path of the agent env ISO to insert
+	EnvIsoPath string
+}
+
+// NIC is a network adapter to add to a VM
+type NIC struct {
+	Network     string
+	MACAddress  string
+	AdapterType string
+}
+
+// DatastoreFile is a file on the datastore, its path relative to the datastore root
+type DatastoreFile struct {
+	Path string
+	Size int64
 }
 
 //go:generate counterfeiter -o fakes/fake_govc_runner.go $GOPATH/src/bosh-esxi-cpi/govc/govc.go GovcRunner
@@ -25,4 +65,5 @@ type GovcRunner interface {
 //go:generate counterfeiter -o fakes/fake_govc_config.go $GOPATH/src/bosh-esxi-cpi/govc/govc.go GovcConfig
 type GovcConfig interface {
 	EsxUrl() string
+	Datacenter() string
 }
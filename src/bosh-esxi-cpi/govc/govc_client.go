@@ -3,11 +3,17 @@ package govc
 import (
 	"encoding/json"
 	"fmt"
+	"net/url"
+	"path"
+	"regexp"
+	"sort"
 	"strconv"
 	"strings"
-	"time"
 
 	boshlog "github.com/cloudfoundry/bosh-utils/logger"
+	"github.com/vmware/govmomi/object"
+
+	"bosh-esxi-cpi/govc/commands"
 )
 
 type GovcClientImpl struct {
@@ -23,72 +29,222 @@ var (
 	STATE_BLOCKING_QUESTION = "state-blocking-question"
 )
 
+const opaqueNetworkTypeNSXT = "nsx.LogicalSwitch"
+
+// copiedVMAnswer answers "I Copied It" to the question a VM registered from copied files asks on power on,
+// so the VM gets a new UUID and MAC addresses
+const copiedVMAnswer = "2"
+
+// persistentDiskPattern matches the VMDKs CreateDisk puts in the datastore root, e.g. "[datastore1] disk-<uuid>.vmdk"
+var persistentDiskPattern = regexp.MustCompile(`^\[[^\]]*\] ?disk-[^/]+\.vmdk$`)
+
 func NewClient(runner GovcRunner, config GovcConfig, logger boshlog.Logger) GovcClient {
 	return GovcClientImpl{runner: runner, config: config, logger: logger}
 }
 
-func (c GovcClientImpl) ImportOvf(ovfPath string, vmName string) (string, error) {
+// ImportStemcell imports the OVF in a stemcell image tarball, streaming its disks from the tarball
+func (c GovcClientImpl) ImportStemcell(imagePath string, vmName string) (string, error) {
 	flags := map[string]string{
 		"name": vmName,
 		"u":    c.config.EsxUrl(),
 		"k":    "true",
 	}
-	args := []string{ovfPath}
+	args := []string{imagePath}
 
-	result, err := c.runner.CliCommand("import.ovf", flags, args)
+	result, err := c.runner.CliCommand("cpi.import.stemcell", flags, args)
 	if err != nil {
-		c.logger.ErrorWithDetails("govc", "import ovf", err, result)
+		c.logger.ErrorWithDetails("govc", "import stemcell", err, result)
 		return result, err
 	}
 
 	return result, nil
 }
 
-func (c GovcClientImpl) CloneVM(sourceVmName string, cloneVmName string) (string, error) {
+// CloneVM copies a VM on the default datastore. Given another datastore, the clone is placed there,
+// from a replica of the source VM made on first use.
+func (c GovcClientImpl) CloneVM(sourceVmName string, cloneVmName string, datastore string) (string, error) {
+	if datastore != "" {
+		err := c.replicateVM(sourceVmName, datastore)
+		if err != nil {
+			c.logger.ErrorWithDetails("govc", "replicating VM", err, sourceVmName, datastore)
+			return "", err
+		}
+	}
+
+	// a light stemcell keeps the .vmx name of the template it was cloned from
+	vmxPath, err := c.vmxPath(sourceVmName)
+	if err != nil {
+		c.logger.ErrorWithDetails("govc", "finding VM configuration file", err, sourceVmName)
+		return "", err
+	}
+
+	return c.cloneVMX(path.Join(sourceVmName, path.Base(vmxPath)), cloneVmName, datastore)
+}
+
+// CloneVMX copies the folder of a VM on the datastore, given the path of its .vmx, and registers the copy
+func (c GovcClientImpl) CloneVMX(vmxPath string, cloneVmName string) (string, error) {
+	return c.cloneVMX(vmxPath, cloneVmName, "")
+}
+
+// RenameVM renames a powered off VM on the default datastore along with its folder, which
+// DestroyVM expects to share the VM's name. The VM is unregistered while its folder is moved.
+func (c GovcClientImpl) RenameVM(vmName string, newVmName string) error {
+	vmxPath, err := c.vmxPath(vmName)
+	if err != nil {
+		c.logger.ErrorWithDetails("govc", "finding VM configuration file", err, vmName)
+		return err
+	}
+
+	result, err := c.unregisterVM(vmName)
+	if err != nil {
+		c.logger.ErrorWithDetails("govc", "unregistering VM", err, result)
+		return err
+	}
+
+	result, err = c.moveDatastoreObject("", vmName, newVmName)
+	if err != nil {
+		c.logger.ErrorWithDetails("govc", "moving VM folder", err, result)
+		return err
+	}
+
+	result, err = c.registerDatastoreVm(path.Base(vmxPath), newVmName, "")
+	if err != nil {
+		c.logger.ErrorWithDetails("govc", "registering VM", err, result)
+		return err
+	}
+
+	return nil
+}
+
+func (c GovcClientImpl) cloneVMX(vmxPath string, cloneVmName string, datastore string) (string, error) {
 	var result string
 	var err error
 
-	result, err = c.copyDatastoreStemcell(sourceVmName, cloneVmName)
+	result, err = c.copyDatastoreStemcell(path.Dir(vmxPath), cloneVmName, datastore)
 	if err != nil {
 		c.logger.ErrorWithDetails("govc", "copying datastore", err, result)
 		return result, err
 	}
 
-	result, err = c.registerDatastoreVm(sourceVmName, cloneVmName)
+	result, err = c.registerDatastoreVm(path.Base(vmxPath), cloneVmName, datastore)
 	if err != nil {
 		c.logger.ErrorWithDetails("govc", "registering VM", err, result)
 		return result, err
 	}
 
-	result, err = c.configVMHardware(cloneVmName)
+	return result, nil
+}
+
+// ConfigureVM sets up a cloned VM before its first boot. The hardware upgrade and the env ISO upload
+// are separate tasks, everything else is applied in a single reconfigure task, see govc/commands.
+func (c GovcClientImpl) ConfigureVM(vmName string, spec VMSpec) error {
+	if spec.Props.Version > 0 {
+		result, err := c.upgradeVMHardware(vmName, spec.Props.Version)
+		if err != nil {
+			c.logger.ErrorWithDetails("govc", "upgrading vm hardware version", err, result)
+			return err
+		}
+	}
+
+	config := vmConfig(spec)
+
+	if spec.EnvIsoPath != "" {
+		config.Iso = envIsoDatastorePath(vmName)
+
+		result, err := c.upload(vmName, spec.EnvIsoPath, config.Iso)
+		if err != nil {
+			c.logger.ErrorWithDetails("govc", "uploading ENV cdrom", err, result)
+			return err
+		}
+	}
+
+	result, err := c.configureVM(vmName, config)
 	if err != nil {
-		c.logger.ErrorWithDetails("govc", "configuring vm hardware", err, result)
-		return result, err
+		c.logger.ErrorWithDetails("govc", "configuring vm", err, result)
+		return err
 	}
 
-	return result, nil
+	return nil
 }
 
-func (c GovcClientImpl) SetVMNetworkAdapter(vmName string, networkName string, macAddress string) error {
-	result, err := c.addNetwork(vmName, networkName, macAddress)
+func (c GovcClientImpl) SetVMExtraConfig(vmName string, extraConfig map[string]string) error {
+	result, err := c.setVMExtraConfig(vmName, extraConfig)
 	if err != nil {
-		c.logger.ErrorWithDetails("govc", "adding network", err, result, vmName, networkName, macAddress)
+		c.logger.ErrorWithDetails("govc", "setting vm extra config", err, result)
 		return err
 	}
 
 	return nil
 }
 
-func (c GovcClientImpl) SetVMResources(vmName string, cpus int, ram int) error {
-	result, err := c.setVMResources(vmName, cpus, ram)
+func (c GovcClientImpl) GetVMExtraConfig(vmName string) (map[string]string, error) {
+	extraConfig, err := c.vmExtraConfig(vmName)
 	if err != nil {
-		c.logger.ErrorWithDetails("govc", "setting vm cpu and ram", err, result)
+		c.logger.ErrorWithDetails("govc", "getting vm extra config", err, vmName)
+		return nil, err
+	}
+
+	return extraConfig, nil
+}
+
+// EnsurePortGroup adds a standard switch port group unless a matching one exists; it returns true when the port group was created
+func (c GovcClientImpl) EnsurePortGroup(portGroupName string, vswitchName string, vlanID int) (bool, error) {
+	found, err := c.matchingPortGroupExists(portGroupName, vswitchName, vlanID)
+	if err != nil {
+		c.logger.ErrorWithDetails("govc", "finding port group", err, portGroupName)
+		return false, err
+	}
+
+	if found {
+		return false, nil
+	}
+
+	found, err = c.vswitchExists(vswitchName)
+	if err != nil {
+		c.logger.ErrorWithDetails("govc", "finding vswitch", err, vswitchName)
+		return false, err
+	}
+
+	if !found {
+		return false, fmt.Errorf("vswitch '%s' not found on host, cannot add port group '%s'", vswitchName, portGroupName)
+	}
+
+	result, err := c.addPortGroup(portGroupName, vswitchName, vlanID)
+	if err != nil {
+		// another create_vm may have added the same port group concurrently
+		found, findErr := c.matchingPortGroupExists(portGroupName, vswitchName, vlanID)
+		if findErr == nil && found {
+			return false, nil
+		}
+
+		c.logger.ErrorWithDetails("govc", "adding port group", err, result)
+		return false, err
+	}
+
+	return true, nil
+}
+
+func (c GovcClientImpl) RemovePortGroup(portGroupName string) error {
+	result, err := c.removePortGroup(portGroupName)
+	if err != nil {
+		c.logger.ErrorWithDetails("govc", "removing port group", err, result)
 		return err
 	}
 
 	return nil
 }
 
+// GetVMNetworkAttachmentIDs returns the NSX-T attachment ids of the VM's NICs on opaque networks
+func (c GovcClientImpl) GetVMNetworkAttachmentIDs(vmName string) ([]string, error) {
+	attachmentIDs, err := c.vmNetworkAttachmentIDs(vmName)
+	if err != nil {
+		c.logger.ErrorWithDetails("govc", "getting vm network attachments", err, vmName)
+		return nil, err
+	}
+
+	return attachmentIDs, nil
+}
+
 func (c GovcClientImpl) UpdateVMIso(vmName string, localIsoPath string) (string, error) {
 	result, err := c.disconnectCdrom(vmName)
 	if err != nil {
@@ -102,7 +258,7 @@ func (c GovcClientImpl) UpdateVMIso(vmName string, localIsoPath string) (string,
 		return result, err
 	}
 
-	datastoreIsoPath := fmt.Sprintf("/env/env-%s.iso", vmName)
+	datastoreIsoPath := envIsoDatastorePath(vmName)
 	result, err = c.upload(vmName, localIsoPath, datastoreIsoPath)
 	if err != nil {
 		c.logger.ErrorWithDetails("govc", "uploading ENV cdrom", err, result)
@@ -124,40 +280,27 @@ func (c GovcClientImpl) UpdateVMIso(vmName string, localIsoPath string) (string,
 	return result, nil
 }
 
+// StartVM powers the VM on, answering that it was copied when asked, and returns once it is powered on
 func (c GovcClientImpl) StartVM(vmName string) (string, error) {
-	go func() {
-		// blocks until question is answered
-		result, err := c.powerOnVm(vmName)
-		if err != nil {
-			c.logger.ErrorWithDetails("govc", "powering on VM", err, result)
-			return
-		}
-	}()
-
-	// continually wait for then answer start-blocking question
-	for {
-		time.Sleep(300 * time.Millisecond)
-
-		vmState, err := c.vmState(vmName)
-		if err != nil {
-			c.logger.ErrorWithDetails("govc", "fetching question state for VM", err, vmState)
-			return "", err
-		}
-
-		if vmState == STATE_BLOCKING_QUESTION {
-			break
-		}
+	result, err := c.powerVM(vmName, "on", map[string]string{"answer": copiedVMAnswer})
+	if err != nil {
+		c.logger.ErrorWithDetails("govc", "powering on VM", err, result)
+		return result, err
 	}
 
-	result, err := c.answerCopyQuestion(vmName)
+	return "success", nil
+}
+
+// RebootVM restarts the guest, or resets the VM when VMware Tools are not running, and returns once it is back
+func (c GovcClientImpl) RebootVM(vmName string) error {
+	result, err := c.powerVM(vmName, "reboot", nil)
 	if err != nil {
-		c.logger.ErrorWithDetails("govc", "answering question state for VM", err, result)
-		return "", err
+		c.logger.ErrorWithDetails("govc", "rebooting VM", err, result)
+		return err
 	}
 
-	return "success", err
+	return nil
 }
-
 func (c GovcClientImpl) HasVM(vmName string) (bool, error) {
 	result, err := c.vmState(vmName)
 	found := (result != STATE_NOT_FOUND)
@@ -168,15 +311,6 @@ func (c GovcClientImpl) HasVM(vmName string) (bool, error) {
 	return found, nil
 }
 
-func (c GovcClientImpl) CreateEphemeralDisk(vmName string, diskMB int) error {
-	result, err := c.createEphemeralDisk(vmName, diskMB)
-	if err != nil {
-		c.logger.ErrorWithDetails("govc", "CreateEphemeralDisk", err, result)
-		return err
-	}
-	return nil
-}
-
 func (c GovcClientImpl) CreateDisk(diskId string, diskMB int) error {
 	result, err := c.createDisk(diskId, diskMB)
 	if err != nil {
@@ -202,6 +336,10 @@ func (c GovcClientImpl) DetachDisk(vmName string, diskId string) error {
 		return err
 	}
 
+	if diskDeviceName == "" {
+		return ErrDiskNotAttached
+	}
+
 	result, err := c.detachDisk(vmName, diskDeviceName)
 	if err != nil {
 		c.logger.ErrorWithDetails("govc", "DetachDisk", err, result)
@@ -212,14 +350,14 @@ func (c GovcClientImpl) DetachDisk(vmName string, diskId string) error {
 
 func (c GovcClientImpl) DestroyDisk(diskName string) error {
 	diskPath := fmt.Sprintf(`%s.vmdk`, diskName)
-	pathFound, err := c.datastorePathExists(diskPath)
+	pathFound, err := c.datastorePathExists("", diskPath)
 	if err != nil {
 		c.logger.ErrorWithDetails("govc", "finding Path", err, pathFound)
 		return err
 	}
 
 	if pathFound {
-		result, err := c.deleteDatastoreObject(diskPath)
+		result, err := c.deleteDatastoreObject("", diskPath)
 		if err != nil {
 			c.logger.ErrorWithDetails("govc", "delete VM files", err, result)
 			return err
@@ -247,7 +385,31 @@ func (c GovcClientImpl) DestroyVM(vmName string) (string, error) {
 		}
 	}
 
+	// vm.destroy deletes every disk still attached, including persistent ones
 	if vmState != STATE_NOT_FOUND {
+		disks, err := c.vmPersistentDisks(vmName)
+		if err != nil {
+			c.logger.ErrorWithDetails("govc", "finding persistent disks", err, vmName)
+			return result, err
+		}
+
+		for _, disk := range disks {
+			result, err = c.detachDisk(vmName, disk.Name)
+			if err != nil {
+				c.logger.ErrorWithDetails("govc", "detaching persistent disk before destroy", err, result, disk.Name)
+				return result, err
+			}
+
+			// the delta disk of a linked attachment lives in the VM folder deleted below
+			if disk.Parent != "" {
+				result, err = c.consolidateDisk(disk.FileName, disk.Parent)
+				if err != nil {
+					c.logger.ErrorWithDetails("govc", "consolidating persistent disk before destroy", err, result, disk.Name)
+					return result, err
+				}
+			}
+		}
+
 		result, err = c.destroyVm(vmName)
 		if err != nil {
 			c.logger.ErrorWithDetails("govc", "destroy VM", err, result)
@@ -255,14 +417,14 @@ func (c GovcClientImpl) DestroyVM(vmName string) (string, error) {
 		}
 	}
 
-	pathFound, err := c.datastorePathExists(vmName)
+	pathFound, err := c.datastorePathExists("", vmName)
 	if err != nil {
 		c.logger.ErrorWithDetails("govc", "finding Path", err, pathFound)
 		return result, err
 	}
 
 	if pathFound {
-		result, err = c.deleteDatastoreObject(vmName)
+		result, err = c.deleteDatastoreObject("", vmName)
 		if err != nil {
 			c.logger.ErrorWithDetails("govc", "delete VM files", err, result)
 			return result, err
@@ -272,75 +434,414 @@ func (c GovcClientImpl) DestroyVM(vmName string) (string, error) {
 	return result, nil
 }
 
-func (c GovcClientImpl) copyDatastoreStemcell(stemcellVmName string, cloneVmName string) (string, error) {
+// DestroyVMIso removes the env ISO uploaded by UpdateVMIso; a missing ISO is not an error
+func (c GovcClientImpl) DestroyVMIso(vmName string) error {
+	result, err := c.deleteDatastoreObject("", envIsoDatastorePath(vmName))
+	if err != nil {
+		c.logger.ErrorWithDetails("govc", "delete ENV cdrom", err, result)
+		return err
+	}
+
+	return nil
+}
+
+// DestroyVMReplicas deletes the replicas CloneVM made of a VM on other datastores
+func (c GovcClientImpl) DestroyVMReplicas(vmName string) error {
+	datastores, err := c.datastores()
+	if err != nil {
+		c.logger.ErrorWithDetails("govc", "listing datastores", err)
+		return err
+	}
+
+	for _, datastore := range datastores {
+		lock, err := lockReplica(c.Host(), vmName, datastore)
+		if err != nil {
+			return err
+		}
+
+		err = c.deleteReplica(vmName, datastore)
+		lock.Unlock()
+		if err != nil {
+			c.logger.ErrorWithDetails("govc", "deleting replica", err, vmName, datastore)
+			return err
+		}
+	}
+
+	return nil
+}
+
+// Host returns the ESXi host the client manages, without the credentials of its URL
+func (c GovcClientImpl) Host() string {
+	esxUrl, err := url.Parse(c.config.EsxUrl())
+	if err != nil {
+		return ""
+	}
+	return esxUrl.Host
+}
+
+// ListVMs returns the names of all VMs registered on the host
+func (c GovcClientImpl) ListVMs() ([]string, error) {
+	result, err := c.listVMs()
+	if err != nil {
+		c.logger.ErrorWithDetails("govc", "ListVMs", err, result)
+		return nil, err
+	}
+
+	var response struct {
+		Elements []struct{ Path string } `json:"elements"`
+	}
+	err = json.Unmarshal([]byte(result), &response)
+	if err != nil {
+		return nil, fmt.Errorf("error: %+v\nresult: %s\n", err, result)
+	}
+
+	var names []string
+	for _, element := range response.Elements {
+		names = append(names, path.Base(element.Path))
+	}
+
+	return names, nil
+}
+
+// ListDatastoreFiles returns every file on the datastore; folders are not included themselves
+func (c GovcClientImpl) ListDatastoreFiles() ([]DatastoreFile, error) {
+	result, err := c.listDatastoreFiles()
+	if err != nil {
+		c.logger.ErrorWithDetails("govc", "ListDatastoreFiles", err, result)
+		return nil, err
+	}
+
+	var response []struct {
+		FolderPath string
+		File       []struct {
+			Path     string
+			FileSize int64
+		}
+	}
+	err = json.Unmarshal([]byte(result), &response)
+	if err != nil {
+		return nil, fmt.Errorf("error: %+v\nresult: %s\n", err, result)
+	}
+
+	folders := map[string]bool{}
+	for _, folder := range response {
+		folders[datastoreRelativePath(folder.FolderPath)] = true
+	}
+
+	var files []DatastoreFile
+	for _, folder := range response {
+		folderPath := datastoreRelativePath(folder.FolderPath)
+		for _, file := range folder.File {
+			filePath := path.Join(folderPath, file.Path)
+			if folders[filePath] {
+				continue
+			}
+			files = append(files, DatastoreFile{Path: filePath, Size: file.FileSize})
+		}
+	}
+
+	return files, nil
+}
+
+// datastoreRelativePath strips the datastore from a path such as "[datastore1] vm-uuid"
+func datastoreRelativePath(datastorePath string) string {
+	if i := strings.Index(datastorePath, "]"); i >= 0 {
+		datastorePath = datastorePath[i+1:]
+	}
+	return strings.TrimLeft(datastorePath, " /")
+}
+
+// envIsoDatastorePath is relative to the datastore root, a leading slash ends up doubled in the upload URL
+func envIsoDatastorePath(vmName string) string {
+	return fmt.Sprintf("env/env-%s.iso", vmName)
+}
+
+func (c GovcClientImpl) copyDatastoreStemcell(stemcellVmName string, cloneVmName string, datastore string) (string, error) {
 	flags := map[string]string{
 		"u": c.config.EsxUrl(),
 		"k": "true",
 	}
+	if datastore != "" {
+		flags["ds"] = datastore
+	}
 	args := []string{stemcellVmName, cloneVmName}
 
 	return c.runner.CliCommand("datastore.cp", flags, args)
 }
 
-func (c GovcClientImpl) registerDatastoreVm(stemcellVmName string, cloneVmName string) (string, error) {
-	vmxPath := fmt.Sprintf("%s/%s.vmx", cloneVmName, stemcellVmName)
+func (c GovcClientImpl) registerDatastoreVm(vmxName string, cloneVmName string, datastore string) (string, error) {
+	vmxPath := path.Join(cloneVmName, vmxName)
 	flags := map[string]string{
 		"name": cloneVmName,
 		"u":    c.config.EsxUrl(),
 		"k":    "true",
 	}
+	if datastore != "" {
+		flags["ds"] = datastore
+	}
 	args := []string{vmxPath}
 
 	return c.runner.CliCommand("vm.register", flags, args)
 }
 
-func (c GovcClientImpl) configVMHardware(cloneVmName string) (string, error) {
+func (c GovcClientImpl) unregisterVM(vmName string) (string, error) {
 	flags := map[string]string{
-		"vm":                  cloneVmName,
-		"nested-hv-enabled":   "true",
-		"sync-time-with-host": "true",
 		"u": c.config.EsxUrl(),
 		"k": "true",
 	}
+	args := []string{vmName}
 
-	return c.runner.CliCommand("vm.change", flags, nil)
+	return c.runner.CliCommand("vm.unregister", flags, args)
 }
 
-func (c GovcClientImpl) addNetwork(vmName string, networkName string, macAddress string) (string, error) {
+func (c GovcClientImpl) configureVM(vmName string, config commands.VMConfig) (string, error) {
+	configJSON, err := json.Marshal(config)
+	if err != nil {
+		return "", err
+	}
+
 	flags := map[string]string{
-		"vm":          vmName,
-		"net":         networkName,
-		"net.adapter": "vmxnet3",
-		"net.address": macAddress,
-		"u":           c.config.EsxUrl(),
-		"k":           "true",
+		"vm":     vmName,
+		"config": string(configJSON),
+		"u":      c.config.EsxUrl(),
+		"k":      "true",
 	}
 
-	return c.runner.CliCommand("vm.network.add", flags, nil)
+	// a vCenter may hold several datacenters, each with their own network folder
+	if datacenter := c.config.Datacenter(); datacenter != "" {
+		flags["dc"] = datacenter
+	}
+
+	return c.runner.CliCommand("cpi.vm.configure", flags, nil)
 }
 
-func (c GovcClientImpl) upload(cloneVmName string, localPath string, datastorePath string) (string, error) {
+// vmConfig translates a spec to the config cpi.vm.configure applies
+func vmConfig(spec VMSpec) commands.VMConfig {
+	extraConfig := spec.Props.ExtraConfig()
+	for key, value := range spec.ExtraConfig {
+		extraConfig[key] = value
+	}
+
+	config := commands.VMConfig{
+		CPU:                          spec.Props.CPU,
+		RAM:                          spec.Props.RAM,
+		CPUReservation:               spec.Props.CPUReservation,
+		MemoryReservation:            spec.Props.MemoryReservation,
+		CoresPerSocket:               spec.Props.CoresPerSocket,
+		CPUHotAdd:                    spec.Props.CPUHotAdd,
+		MemoryHotAdd:                 spec.Props.MemoryHotAdd,
+		Firmware:                     spec.Props.Firmware,
+		NestedHardwareVirtualization: spec.Props.NestedHardwareVirtualization,
+		SyncTimeWithHost:             spec.Props.SyncTimeWithHost,
+		ExtraConfig:                  extraConfig,
+		EphemeralDiskMB:              spec.Props.Disk,
+	}
+
+	for _, nic := range spec.NICs {
+		config.NICs = append(config.NICs, commands.VMConfigNIC{
+			Network:    nic.Network,
+			Adapter:    nic.AdapterType,
+			MACAddress: nic.MACAddress,
+		})
+	}
+
+	return config
+}
+
+func (c GovcClientImpl) hostPortGroups() ([]hostPortGroup, error) {
 	flags := map[string]string{
 		"u": c.config.EsxUrl(),
 		"k": "true",
 	}
-	args := []string{localPath, datastorePath}
 
-	return c.runner.CliCommand("datastore.upload", flags, args)
+	result, err := c.runner.CliCommand("host.portgroup.info", flags, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	var response struct {
+		Portgroup []hostPortGroup
+	}
+	err = json.Unmarshal([]byte(result), &response)
+	if err != nil {
+		return nil, fmt.Errorf("error: %+v\nresult: %s\n", err, result)
+	}
+
+	return response.Portgroup, nil
+}
+
+type hostPortGroup struct {
+	Spec struct {
+		Name        string
+		VlanId      int
+		VswitchName string
+	}
+}
+
+func (c GovcClientImpl) matchingPortGroupExists(portGroupName string, vswitchName string, vlanID int) (bool, error) {
+	portGroups, err := c.hostPortGroups()
+	if err != nil {
+		return false, err
+	}
+
+	for _, portGroup := range portGroups {
+		if portGroup.Spec.Name != portGroupName {
+			continue
+		}
+
+		if portGroup.Spec.VswitchName != vswitchName || portGroup.Spec.VlanId != vlanID {
+			return false, fmt.Errorf(
+				"port group '%s' already exists on vswitch '%s' with VLAN %d, expected vswitch '%s' with VLAN %d",
+				portGroupName, portGroup.Spec.VswitchName, portGroup.Spec.VlanId, vswitchName, vlanID,
+			)
+		}
+
+		return true, nil
+	}
+
+	return false, nil
+}
+
+func (c GovcClientImpl) vswitchExists(vswitchName string) (bool, error) {
+	flags := map[string]string{
+		"u": c.config.EsxUrl(),
+		"k": "true",
+	}
+
+	result, err := c.runner.CliCommand("host.vswitch.info", flags, nil)
+	if err != nil {
+		return false, err
+	}
+
+	var response struct {
+		Vswitch []struct {
+			Name string
+		}
+	}
+	err = json.Unmarshal([]byte(result), &response)
+	if err != nil {
+		return false, fmt.Errorf("error: %+v\nresult: %s\n", err, result)
+	}
+
+	for _, vswitch := range response.Vswitch {
+		if vswitch.Name == vswitchName {
+			return true, nil
+		}
+	}
+
+	return false, nil
+}
+
+func (c GovcClientImpl) addPortGroup(portGroupName string, vswitchName string, vlanID int) (string, error) {
+	flags := map[string]string{
+		"vswitch": vswitchName,
+		"vlan":    strconv.Itoa(vlanID),
+		"u":       c.config.EsxUrl(),
+		"k":       "true",
+	}
+	args := []string{portGroupName}
+
+	return c.runner.CliCommand("host.portgroup.add", flags, args)
 }
 
-func (c GovcClientImpl) setVMResources(vmName string, cpuCount int, ramMB int) (string, error) {
-	cpu := strconv.Itoa(cpuCount)
-	mem := strconv.Itoa(ramMB)
+func (c GovcClientImpl) removePortGroup(portGroupName string) (string, error) {
+	flags := map[string]string{
+		"u": c.config.EsxUrl(),
+		"k": "true",
+	}
+	args := []string{portGroupName}
+
+	return c.runner.CliCommand("host.portgroup.remove", flags, args)
+}
+
+func (c GovcClientImpl) setVMExtraConfig(vmName string, extraConfig map[string]string) (string, error) {
 	flags := map[string]string{
 		"vm": vmName,
-		"c":  cpu,
-		"m":  mem,
 		"u":  c.config.EsxUrl(),
 		"k":  "true",
 	}
 
-	return c.runner.CliCommand("vm.change", flags, nil)
+	return c.runner.CliCommand("vm.change", flags, extraConfigArgs(extraConfig))
+}
+
+func (c GovcClientImpl) vmExtraConfig(vmName string) (map[string]string, error) {
+	flags := map[string]string{
+		"e": "true",
+		"u": c.config.EsxUrl(),
+		"k": "true",
+	}
+	args := []string{vmName}
+
+	result, err := c.runner.CliCommand("vm.info", flags, args)
+	if err != nil {
+		return nil, err
+	}
+
+	var response struct {
+		VirtualMachines []struct {
+			Config struct {
+				ExtraConfig []struct {
+					Key   string
+					Value string
+				}
+			}
+		}
+	}
+	err = json.Unmarshal([]byte(result), &response)
+	if err != nil {
+		return nil, fmt.Errorf("error: %+v\nresult: %s\n", err, result)
+	}
+
+	// vm.info lists nothing for a VM which does not exist
+	if len(response.VirtualMachines) == 0 {
+		return nil, vmNotFoundError{vmName}
+	}
+
+	extraConfig := map[string]string{}
+
+	for _, option := range response.VirtualMachines[0].Config.ExtraConfig {
+		extraConfig[option.Key] = option.Value
+	}
+
+	return extraConfig, nil
+}
+
+func (c GovcClientImpl) upload(cloneVmName string, localPath string, datastorePath string) (string, error) {
+	flags := map[string]string{
+		"u": c.config.EsxUrl(),
+		"k": "true",
+	}
+	args := []string{localPath, datastorePath}
+
+	return c.runner.CliCommand("datastore.upload", flags, args)
+}
+
+// extraConfigArgs formats vm.change's repeatable -e flag as args to be parsed by the flagset
+func extraConfigArgs(extraConfig map[string]string) []string {
+	var args []string
+
+	keys := make([]string, 0, len(extraConfig))
+	for key := range extraConfig {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	for _, key := range keys {
+		args = append(args, fmt.Sprintf("-e=%s=%s", key, extraConfig[key]))
+	}
+
+	return args
+}
+
+func (c GovcClientImpl) upgradeVMHardware(vmName string, version int) (string, error) {
+	flags := map[string]string{
+		"vm":      vmName,
+		"version": strconv.Itoa(version),
+		"u":       c.config.EsxUrl(),
+		"k":       "true",
+	}
+
+	return c.runner.CliCommand("vm.upgrade", flags, nil)
 }
 
 func (c GovcClientImpl) ejectCdrom(cloneVmName string) (string, error) {
@@ -386,39 +887,24 @@ func (c GovcClientImpl) disconnectCdrom(cloneVmName string) (string, error) {
 	return c.runner.CliCommand("device.disconnect", flags, args)
 }
 
-func (c GovcClientImpl) powerOnVm(cloneVmName string) (string, error) {
-	flags := map[string]string{
-		"on": "true",
-		"u":  c.config.EsxUrl(),
-		"k":  "true",
-	}
-	args := []string{cloneVmName}
-
-	return c.runner.CliCommand("vm.power", flags, args)
+func (c GovcClientImpl) stopVM(cloneVmName string) (string, error) {
+	return c.powerVM(cloneVmName, "off", nil)
 }
 
-func (c GovcClientImpl) answerCopyQuestion(cloneVmName string) (string, error) {
+// powerVM runs a power operation which waits until the VM reached the new state, see govc/commands
+func (c GovcClientImpl) powerVM(vmName string, operation string, extraFlags map[string]string) (string, error) {
 	flags := map[string]string{
-		"vm":     cloneVmName,
-		"answer": "2",
-		"u":      c.config.EsxUrl(),
-		"k":      "true",
+		"vm":      vmName,
+		operation: "true",
+		"u":       c.config.EsxUrl(),
+		"k":       "true",
 	}
-
-	return c.runner.CliCommand("vm.question", flags, nil)
-}
-
-func (c GovcClientImpl) stopVM(cloneVmName string) (string, error) {
-	flags := map[string]string{
-		"off": "true",
-		"u":   c.config.EsxUrl(),
-		"k":   "true",
+	for k, v := range extraFlags {
+		flags[k] = v
 	}
-	args := []string{cloneVmName}
 
-	return c.runner.CliCommand("vm.power", flags, args)
+	return c.runner.CliCommand("cpi.vm.power", flags, nil)
 }
-
 func (c GovcClientImpl) destroyVm(vmName string) (string, error) {
 	flags := map[string]string{
 		"u": c.config.EsxUrl(),
@@ -429,17 +915,54 @@ func (c GovcClientImpl) destroyVm(vmName string) (string, error) {
 	return c.runner.CliCommand("vm.destroy", flags, args)
 }
 
-func (c GovcClientImpl) deleteDatastoreObject(datastorePath string) (string, error) {
+func (c GovcClientImpl) deleteDatastoreObject(datastore string, datastorePath string) (string, error) {
 	flags := map[string]string{
 		"f": "true",
 		"u": c.config.EsxUrl(),
 		"k": "true",
 	}
+	if datastore != "" {
+		flags["ds"] = datastore
+	}
 	args := []string{datastorePath}
 
 	return c.runner.CliCommand("datastore.rm", flags, args)
 }
 
+// vmxPath returns the datastore path of the VM's configuration file, e.g. "[datastore1] cs-uuid/xenial.vmx"
+func (c GovcClientImpl) vmxPath(vmName string) (string, error) {
+	flags := map[string]string{
+		"u": c.config.EsxUrl(),
+		"k": "true",
+	}
+	args := []string{vmName}
+
+	result, err := c.runner.CliCommand("vm.info", flags, args)
+	if err != nil {
+		return "", err
+	}
+
+	var response struct {
+		VirtualMachines []struct {
+			Summary struct {
+				Config struct {
+					VmPathName string
+				}
+			}
+		}
+	}
+	err = json.Unmarshal([]byte(result), &response)
+	if err != nil {
+		return "", fmt.Errorf("error: %+v\nresult: %s\n", err, result)
+	}
+
+	if len(response.VirtualMachines) == 0 {
+		return "", vmNotFoundError{vmName}
+	}
+
+	return response.VirtualMachines[0].Summary.Config.VmPathName, nil
+}
+
 func (c GovcClientImpl) vmState(vmName string) (string, error) {
 	flags := map[string]string{
 		"u": c.config.EsxUrl(),
@@ -481,11 +1004,40 @@ func (c GovcClientImpl) vmState(vmName string) (string, error) {
 	return STATE_POWER_OFF, nil
 }
 
-func (c GovcClientImpl) datastorePathExists(datastorePath string) (bool, error) {
+func (c GovcClientImpl) listVMs() (string, error) {
+	flags := map[string]string{
+		"t": "VirtualMachine",
+		"u": c.config.EsxUrl(),
+		"k": "true",
+	}
+
+	if datacenter := c.config.Datacenter(); datacenter != "" {
+		flags["dc"] = datacenter
+	}
+
+	return c.runner.CliCommand("ls", flags, []string{"vm"})
+}
+
+func (c GovcClientImpl) listDatastoreFiles() (string, error) {
+	flags := map[string]string{
+		"l": "true",
+		"R": "true",
+		"u": c.config.EsxUrl(),
+		"k": "true",
+	}
+
+	return c.runner.CliCommand("datastore.ls", flags, nil)
+}
+
+// datastorePathExists looks for the path in the root of the datastore, the default one when empty
+func (c GovcClientImpl) datastorePathExists(datastore string, datastorePath string) (bool, error) {
 	flags := map[string]string{
 		"u": c.config.EsxUrl(),
 		"k": "true",
 	}
+	if datastore != "" {
+		flags["ds"] = datastore
+	}
 
 	result, err := c.runner.CliCommand("datastore.ls", flags, nil)
 	if err != nil {
@@ -529,25 +1081,6 @@ func (c GovcClientImpl) createDisk(diskId string, diskMB int) (string, error) {
 	return result, err
 }
 
-func (c GovcClientImpl) createEphemeralDisk(vmName string, diskMB int) (string, error) {
-	diskPath := fmt.Sprintf(`%s/ephemeral.vmdk`, vmName)
-	diskSize := fmt.Sprintf(`%dMB`, diskMB)
-	flags := map[string]string{
-		"vm":   vmName,
-		"name": diskPath,
-		"size": diskSize,
-		"u":    c.config.EsxUrl(),
-		"k":    "true",
-	}
-
-	result, err := c.runner.CliCommand("vm.disk.create", flags, nil)
-	if err != nil {
-		return result, err
-	}
-
-	return result, nil
-}
-
 func (c GovcClientImpl) attachDisk(vmName string, diskId string) (string, error) {
 	diskPath := fmt.Sprintf(`%s.vmdk`, diskId)
 	flags := map[string]string{
@@ -583,7 +1116,8 @@ func (c GovcClientImpl) getVMDiskName(vmName string, diskId string) (string, err
 		Devices []struct {
 			Name    string
 			Backing struct {
-				Parent struct {
+				FileName string
+				Parent   struct {
 					FileName string
 				}
 			}
@@ -594,9 +1128,10 @@ func (c GovcClientImpl) getVMDiskName(vmName string, diskId string) (string, err
 		return result, err
 	}
 
+	// a linked attachment is backed by a delta of the persistent disk, older ones may be backed by the disk itself
 	foundDevice := ""
 	for _, device := range response.Devices {
-		if strings.Contains(device.Backing.Parent.FileName, diskId) {
+		if strings.Contains(device.Backing.FileName, diskId) || strings.Contains(device.Backing.Parent.FileName, diskId) {
 			foundDevice = device.Name
 		}
 	}
@@ -604,6 +1139,123 @@ func (c GovcClientImpl) getVMDiskName(vmName string, diskId string) (string, err
 	return foundDevice, nil
 }
 
+func (c GovcClientImpl) vmNetworkAttachmentIDs(vmName string) ([]string, error) {
+	flags := map[string]string{
+		"json": "true",
+		"vm":   vmName,
+		"u":    c.config.EsxUrl(),
+		"k":    "true",
+	}
+
+	result, err := c.runner.CliCommand("device.info", flags, []string{"ethernet-*"})
+	if err != nil {
+		return nil, err
+	}
+
+	var response struct {
+		Devices []struct {
+			ExternalId string
+			Backing    struct {
+				OpaqueNetworkType string
+			}
+		}
+	}
+	err = json.Unmarshal([]byte(result), &response)
+	if err != nil {
+		return nil, err
+	}
+
+	attachmentIDs := []string{}
+	for _, device := range response.Devices {
+		if device.Backing.OpaqueNetworkType == opaqueNetworkTypeNSXT && device.ExternalId != "" {
+			attachmentIDs = append(attachmentIDs, device.ExternalId)
+		}
+	}
+
+	return attachmentIDs, nil
+}
+
+// vmDisk is a disk device of a VM; Parent is the disk a linked clone's delta disk FileName is backed by
+type vmDisk struct {
+	Name     string
+	FileName string
+	Parent   string
+}
+
+// vmPersistentDisks returns the disks backed, directly or as the parent of a linked clone, by a persistent disk
+func (c GovcClientImpl) vmPersistentDisks(vmName string) ([]vmDisk, error) {
+	flags := map[string]string{
+		"json": "true",
+		"vm":   vmName,
+		"u":    c.config.EsxUrl(),
+		"k":    "true",
+	}
+
+	result, err := c.runner.CliCommand("device.info", flags, []string{"disk-*"})
+	if err != nil {
+		return nil, err
+	}
+
+	var response struct {
+		Devices []struct {
+			Name    string
+			Backing struct {
+				FileName string
+				Parent   struct {
+					FileName string
+				}
+			}
+		}
+	}
+	err = json.Unmarshal([]byte(result), &response)
+	if err != nil {
+		return nil, err
+	}
+
+	disks := []vmDisk{}
+	for _, device := range response.Devices {
+		switch {
+		case persistentDiskPattern.MatchString(device.Backing.FileName):
+			disks = append(disks, vmDisk{Name: device.Name, FileName: device.Backing.FileName})
+		case persistentDiskPattern.MatchString(device.Backing.Parent.FileName):
+			disks = append(disks, vmDisk{Name: device.Name, FileName: device.Backing.FileName, Parent: device.Backing.Parent.FileName})
+		}
+	}
+
+	return disks, nil
+}
+
+// consolidateDisk replaces a persistent disk by a copy of a detached delta of it, which holds the writes
+// made through a linked attachment. The persistent disk is only replaced once the copy is complete.
+func (c GovcClientImpl) consolidateDisk(deltaFileName string, diskFileName string) (string, error) {
+	var delta, disk object.DatastorePath
+	if !delta.FromString(deltaFileName) || !disk.FromString(diskFileName) {
+		return "", fmt.Errorf("cannot consolidate disk '%s' from delta '%s': invalid datastore path", diskFileName, deltaFileName)
+	}
+	consolidatedPath := path.Join(path.Dir(disk.Path), "consolidated-"+path.Base(disk.Path))
+
+	// copying a delta disk copies the whole chain into a single disk
+	flags := map[string]string{
+		"ds":        delta.Datastore,
+		"ds-target": disk.Datastore,
+		"f":         "true",
+		"u":         c.config.EsxUrl(),
+		"k":         "true",
+	}
+	result, err := c.runner.CliCommand("datastore.cp", flags, []string{delta.Path, consolidatedPath})
+	if err != nil {
+		return result, err
+	}
+
+	flags = map[string]string{
+		"ds": disk.Datastore,
+		"f":  "true",
+		"u":  c.config.EsxUrl(),
+		"k":  "true",
+	}
+	return c.runner.CliCommand("datastore.mv", flags, []string{consolidatedPath, disk.Path})
+}
+
 func (c GovcClientImpl) detachDisk(vmName string, diskName string) (string, error) {
 	flags := map[string]string{
 		"vm":   vmName,
@@ -25,3 +25,7 @@ func (c GovcConfigImpl) EsxUrl() string {
 
 	return url.String()
 }
+
+func (c GovcConfigImpl) Datacenter() string {
+	return c.cpiConfig.Datacenter()
+}
@@ -5,12 +5,12 @@ import (
 	"context"
 	"flag"
 	"fmt"
-	"io"
-	"os"
+	"reflect"
 
 	boshlog "github.com/cloudfoundry/bosh-utils/logger"
 	"github.com/vmware/govmomi/govc/cli"
 	"github.com/vmware/govmomi/govc/flags"
+	"github.com/vmware/govmomi/vim25"
 
 	//TOOD: disable all but needed
 	_ "github.com/vmware/govmomi/govc/about"
@@ -71,30 +71,127 @@ import (
 	_ "github.com/vmware/govmomi/govc/vm/network"
 	_ "github.com/vmware/govmomi/govc/vm/rdm"
 	_ "github.com/vmware/govmomi/govc/vm/snapshot"
+
+	"bosh-esxi-cpi/govc/commands"
 )
 
 type GovcRunnerImpl struct {
 	logger      boshlog.Logger
 	cliCommands map[string]cli.Command
+	sessions    *sessionCache
+	inventory   *inventoryCache
 }
 
 func NewGovcRunner(logger boshlog.Logger) GovcRunner {
 	cliCommands := cli.Commands()
-	return &GovcRunnerImpl{logger: logger, cliCommands: cliCommands}
+	return &GovcRunnerImpl{
+		logger:      logger,
+		cliCommands: cliCommands,
+		sessions:    newSessionCache(),
+		inventory:   newInventoryCache(inventoryTTL),
+	}
+}
+
+// loggingRunner is a runner which can log through another logger while sharing its sessions
+type loggingRunner interface {
+	withLogger(boshlog.Logger) GovcRunner
+}
+
+// RunnerWithLogger returns the runner logging through the logger, e.g. one stamping the lines of a request.
+// Runners which cannot are returned as they are.
+func RunnerWithLogger(runner GovcRunner, logger boshlog.Logger) GovcRunner {
+	if runner, ok := runner.(loggingRunner); ok {
+		return runner.withLogger(logger)
+	}
+	return runner
+}
+
+func (c GovcRunnerImpl) withLogger(logger boshlog.Logger) GovcRunner {
+	c.logger = logger
+	return &c
+}
+
+// sessionRunner is a runner which keeps sessions open between commands
+type sessionRunner interface {
+	logout()
+}
+
+// Logout ends the sessions the runner keeps, e.g. once a daemon finished its requests.
+// Runners which keep none are left alone.
+func Logout(runner GovcRunner) {
+	if runner, ok := runner.(sessionRunner); ok {
+		runner.logout()
+	}
+}
+
+func (c GovcRunnerImpl) logout() {
+	err := c.sessions.logout()
+	if err != nil {
+		c.logger.Warn("govc-runner", "Logging out: %s", err)
+	}
 }
 
+// CliCommand runs a govc command in-process and returns its JSON output. It is safe for concurrent use:
+// each invocation gets its own copy of the command and its own output buffer. Commands share one
+// session per ESXi URL, which is logged in again once the host expired it, and reuse the listings
+// of the host's inventory until a command changes it, see inventoryCache.
 func (c GovcRunnerImpl) CliCommand(command string, flagMap map[string]string, args []string) (string, error) {
 	c.logger.Debug("govc-runner", fmt.Sprintf("command: %s, flags: %+v, args: %s", command, flagMap, args))
-	ctx := context.Background()
 
-	cliCommand := c.cliCommands[command]
-	flagSet := flag.NewFlagSet("", flag.ContinueOnError)
+	key := sessionKey(flagMap)
+
+	if !inventoryCommands[command] {
+		// a command which failed may still have changed the inventory
+		if !readOnlyCommands[command] {
+			defer c.inventory.invalidate(key)
+		}
+		return c.runInSession(command, flagMap, args)
+	}
+
+	output, found, generation := c.inventory.get(key, command, flagMap, args)
+	if found {
+		c.logger.Debug("govc-runner", "Reusing the inventory listed by '%s'", command)
+		return output, nil
+	}
 
-	stdoutReader, stdoutWriter, ctx, err := captureOutputFlagStdout(ctx)
+	output, err := c.runInSession(command, flagMap, args)
+	if err == nil {
+		c.inventory.put(key, generation, command, flagMap, args, output)
+	}
+	return output, err
+}
+
+// runInSession runs the command with the cached session, logging in again once the host expired it
+func (c GovcRunnerImpl) runInSession(command string, flagMap map[string]string, args []string) (string, error) {
+	output, client, err := c.run(command, flagMap, args)
+
+	// the host rejects the first call made with an expired session, before the command changed anything
+	if faultName(err) == "NotAuthenticated" && c.sessions.evict(sessionKey(flagMap), client) {
+		c.logger.Info("govc-runner", "Session expired, logging in again")
+		output, _, err = c.run(command, flagMap, args)
+	}
+
+	return output, err
+}
+
+// run returns the client holding the session the command ran with, if it connected
+func (c GovcRunnerImpl) run(command string, flagMap map[string]string, args []string) (string, *vim25.Client, error) {
+	cliCommand, err := c.newCliCommand(command)
 	if err != nil {
-		return "", err
+		return "", nil, err
 	}
 
+	// the output flag is looked up from the context by every flag embedding it, the command's result included
+	var output bytes.Buffer
+	outputFlag, ctx := flags.NewOutputFlag(context.Background())
+	outputFlag.Out = &output
+
+	// so is the client flag, which is created here to join it to the cached session
+	clientFlag, ctx := flags.NewClientFlag(ctx)
+
+	ctx = commands.WithLogger(ctx, c.logger)
+
+	flagSet := flag.NewFlagSet("", flag.ContinueOnError)
 	cliCommand.Register(ctx, flagSet)
 
 	flagSet.Set("json", "true")
@@ -111,43 +208,44 @@ func (c GovcRunnerImpl) CliCommand(command string, flagMap map[string]string, ar
 	}
 
 	if err = cliCommand.Process(ctx); err != nil {
-		return "", err
+		return "", nil, err
 	}
 
-	if err = cliCommand.Run(ctx, flagSet); err != nil {
-		return "", err
+	// commands which do not connect never set the URL
+	var client *vim25.Client
+	if clientFlag.String() != "" {
+		client, err = c.sessions.attach(clientFlag, sessionKey(flagMap))
+		if err != nil {
+			return "", nil, err
+		}
 	}
 
-	result, err := readOutputFlagStdout(stdoutReader, stdoutWriter)
-	if err != nil {
-		return "", err
+	if err = cliCommand.Run(ctx, flagSet); err != nil {
+		return "", client, err
 	}
 
-	return result, nil
+	return output.String(), client, nil
 }
 
-func captureOutputFlagStdout(ctx context.Context) (io.Reader, io.WriteCloser, context.Context, error) {
-	stdoutReader, stdoutWriter, _ := os.Pipe()
-	oldStdout := os.Stdout
-	os.Stdout = stdoutWriter
-	_, ctx = flags.NewOutputFlag(ctx)
-	outputFlagStdout := os.Stdout
-	os.Stdout = oldStdout
+// sessionKey identifies the session for the URL, credentials included, and TLS verification a command is run with
+func sessionKey(flagMap map[string]string) string {
+	return fmt.Sprintf("%s#insecure=%s", flagMap["u"], flagMap["k"])
+}
 
-	if outputFlagStdout != stdoutWriter {
-		panic("could not capture OutputFlag.Out; os.Stdout in unknown state")
+// newCliCommand copies the registered command, as Register stores the command's flags in the command itself
+func (c GovcRunnerImpl) newCliCommand(command string) (cli.Command, error) {
+	registered, found := c.cliCommands[command]
+	if !found {
+		return nil, fmt.Errorf("unknown govc command '%s'", command)
 	}
 
-	return stdoutReader, stdoutWriter, ctx, nil
-}
-
-func readOutputFlagStdout(stdoutReader io.Reader, stdoutWriter io.WriteCloser) (string, error) {
-	stdoutWriter.Close()
-	var output bytes.Buffer
-	_, err := io.Copy(&output, stdoutReader)
-	if err != nil {
-		return "", err
+	value := reflect.ValueOf(registered)
+	if value.Kind() != reflect.Ptr {
+		return registered, nil
 	}
 
-	return output.String(), nil
+	copied := reflect.New(value.Type().Elem())
+	copied.Elem().Set(value.Elem())
+
+	return copied.Interface().(cli.Command), nil
 }
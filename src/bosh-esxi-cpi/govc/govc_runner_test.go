@@ -0,0 +1,275 @@
+package govc_test
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"sync"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	"github.com/vmware/govmomi/govc/cli"
+	"github.com/vmware/govmomi/govc/flags"
+	"github.com/vmware/govmomi/simulator"
+	"github.com/vmware/govmomi/vim25/methods"
+
+	fakelogger "github.com/cloudfoundry/bosh-utils/logger/loggerfakes"
+
+	"bosh-esxi-cpi/govc"
+)
+
+var _ = Describe("GovcRunner", func() {
+	var (
+		model  *simulator.Model
+		server *simulator.Server
+		runner govc.GovcRunner
+	)
+
+	BeforeEach(func() {
+		model = simulator.ESX()
+		Expect(model.Create()).To(Succeed())
+		server = model.Service.NewServer()
+
+		runner = govc.NewGovcRunner(&fakelogger.FakeLogger{})
+	})
+
+	AfterEach(func() {
+		server.Close()
+		model.Remove()
+	})
+
+	type invocation struct {
+		command string
+		flags   map[string]string
+		args    []string
+	}
+
+	invocations := func() []invocation {
+		url := server.URL.String()
+		return []invocation{
+			{"ls", map[string]string{"u": url, "k": "true", "t": "VirtualMachine"}, []string{"vm"}},
+			{"vm.info", map[string]string{"u": url, "k": "true"}, []string{"ha-host_VM0"}},
+			{"datastore.ls", map[string]string{"u": url, "k": "true"}, nil},
+			{"host.vswitch.info", map[string]string{"u": url, "k": "true"}, nil},
+		}
+	}
+
+	It("returns the JSON output of the command", func() {
+		output, err := runner.CliCommand("ls", map[string]string{"u": server.URL.String(), "k": "true", "t": "VirtualMachine"}, []string{"vm"})
+		Expect(err).ToNot(HaveOccurred())
+		Expect(output).To(ContainSubstring(`"Path":"/ha-datacenter/vm/ha-host_VM0"`))
+	})
+
+	Describe("sessions", func() {
+		type sessions struct {
+			CurrentSession struct{ Key string }
+			SessionList    []struct{ Key string }
+		}
+
+		listSessions := func(runner govc.GovcRunner) sessions {
+			output, err := runner.CliCommand("session.ls", map[string]string{"u": server.URL.String(), "k": "true"}, nil)
+			Expect(err).ToNot(HaveOccurred())
+
+			var result sessions
+			Expect(json.Unmarshal([]byte(output), &result)).To(Succeed())
+			return result
+		}
+
+		It("shares one session between commands", func() {
+			for _, i := range invocations() {
+				_, err := runner.CliCommand(i.command, i.flags, i.args)
+				Expect(err).ToNot(HaveOccurred())
+			}
+
+			Expect(listSessions(runner).SessionList).To(HaveLen(1))
+		})
+
+		It("logs in again once the session expired", func() {
+			key := listSessions(runner).CurrentSession.Key
+
+			other := govc.NewGovcRunner(&fakelogger.FakeLogger{})
+			_, err := other.CliCommand("session.rm", map[string]string{"u": server.URL.String(), "k": "true"}, []string{key})
+			Expect(err).ToNot(HaveOccurred())
+
+			_, err = runner.CliCommand("test.time", map[string]string{"u": server.URL.String(), "k": "true"}, nil)
+			Expect(err).ToNot(HaveOccurred())
+
+			Expect(listSessions(runner).CurrentSession.Key).ToNot(Equal(key))
+		})
+
+		It("logs in once when concurrent commands find the session expired", func() {
+			key := listSessions(runner).CurrentSession.Key
+
+			other := govc.NewGovcRunner(&fakelogger.FakeLogger{})
+			_, err := other.CliCommand("session.rm", map[string]string{"u": server.URL.String(), "k": "true"}, []string{key})
+			Expect(err).ToNot(HaveOccurred())
+
+			var wg sync.WaitGroup
+			errs := make(chan error, 8)
+			for n := 0; n < 8; n++ {
+				wg.Add(1)
+				go func() {
+					defer wg.Done()
+					_, err := runner.CliCommand("test.time", map[string]string{"u": server.URL.String(), "k": "true"}, nil)
+					errs <- err
+				}()
+			}
+			wg.Wait()
+			close(errs)
+
+			for err := range errs {
+				Expect(err).ToNot(HaveOccurred())
+			}
+
+			// the other runner's session and the one the commands replaced the expired session by
+			Expect(listSessions(runner).SessionList).To(HaveLen(2))
+		})
+
+		It("logs out of its sessions", func() {
+			key := listSessions(runner).CurrentSession.Key
+
+			govc.Logout(govc.NewRetryingGovcRunner(runner, govc.DefaultRetryPolicy, &fakelogger.FakeLogger{}))
+
+			other := govc.NewGovcRunner(&fakelogger.FakeLogger{})
+			var keys []string
+			for _, session := range listSessions(other).SessionList {
+				keys = append(keys, session.Key)
+			}
+			Expect(keys).ToNot(ContainElement(key))
+
+			// and logs in again when used afterwards
+			Expect(listSessions(runner).CurrentSession.Key).ToNot(Equal(key))
+		})
+
+		It("shares the session with the runner logging through another logger", func() {
+			key := listSessions(runner).CurrentSession.Key
+
+			logger := &fakelogger.FakeLogger{}
+			requestRunner := govc.RunnerWithLogger(govc.NewRetryingGovcRunner(runner, govc.DefaultRetryPolicy, logger), logger)
+
+			Expect(listSessions(requestRunner).CurrentSession.Key).To(Equal(key))
+			Expect(logger.DebugCallCount()).To(Equal(1))
+		})
+	})
+
+	Describe("inventory", func() {
+		listVMs := func(runner govc.GovcRunner) string {
+			output, err := runner.CliCommand("ls", map[string]string{"u": server.URL.String(), "k": "true", "t": "VirtualMachine"}, []string{"vm"})
+			Expect(err).ToNot(HaveOccurred())
+			return output
+		}
+
+		renameVM := func(runner govc.GovcRunner, vmName string, name string) {
+			_, err := runner.CliCommand("vm.change", map[string]string{"u": server.URL.String(), "k": "true", "vm": vmName, "name": name}, nil)
+			Expect(err).ToNot(HaveOccurred())
+		}
+
+		It("reuses listings until one of its own commands changes the inventory", func() {
+			Expect(listVMs(runner)).To(ContainSubstring(`"Path":"/ha-datacenter/vm/ha-host_VM0"`))
+
+			// e.g. 'admin pool' run from cron
+			renameVM(govc.NewGovcRunner(&fakelogger.FakeLogger{}), "ha-host_VM0", "vm-external")
+			Expect(listVMs(runner)).To(ContainSubstring(`"Path":"/ha-datacenter/vm/ha-host_VM0"`))
+
+			renameVM(runner, "vm-external", "vm-own")
+			Expect(listVMs(runner)).To(ContainSubstring(`"Path":"/ha-datacenter/vm/vm-own"`))
+		})
+
+		It("keeps listings when reading commands run", func() {
+			Expect(listVMs(runner)).To(ContainSubstring(`"Path":"/ha-datacenter/vm/ha-host_VM0"`))
+			renameVM(govc.NewGovcRunner(&fakelogger.FakeLogger{}), "ha-host_VM0", "vm-external")
+
+			_, err := runner.CliCommand("vm.info", map[string]string{"u": server.URL.String(), "k": "true"}, []string{"vm-external"})
+			Expect(err).ToNot(HaveOccurred())
+
+			Expect(listVMs(runner)).To(ContainSubstring(`"Path":"/ha-datacenter/vm/ha-host_VM0"`))
+		})
+
+		It("shares listings with the runner logging through another logger", func() {
+			Expect(listVMs(runner)).To(ContainSubstring(`"Path":"/ha-datacenter/vm/ha-host_VM0"`))
+			renameVM(govc.NewGovcRunner(&fakelogger.FakeLogger{}), "ha-host_VM0", "vm-external")
+
+			Expect(listVMs(govc.RunnerWithLogger(runner, &fakelogger.FakeLogger{}))).To(ContainSubstring(`"Path":"/ha-datacenter/vm/ha-host_VM0"`))
+		})
+	})
+
+	It("fails on unknown commands", func() {
+		_, err := runner.CliCommand("vm.unknown", nil, nil)
+		Expect(err).To(MatchError("unknown govc command 'vm.unknown'"))
+	})
+
+	It("keeps the output of concurrent commands apart", func() {
+		expected := map[string]string{}
+		for _, i := range invocations() {
+			output, err := runner.CliCommand(i.command, i.flags, i.args)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(output).ToNot(BeEmpty())
+			expected[i.command] = output
+		}
+
+		const workers = 16
+		const iterations = 10
+
+		var wg sync.WaitGroup
+		failures := make(chan string, workers*iterations)
+
+		for w := 0; w < workers; w++ {
+			wg.Add(1)
+			go func(w int) {
+				defer wg.Done()
+
+				all := invocations()
+				for n := 0; n < iterations; n++ {
+					i := all[(w+n)%len(all)]
+
+					output, err := runner.CliCommand(i.command, i.flags, i.args)
+					if err != nil {
+						failures <- fmt.Sprintf("%s: %s", i.command, err)
+					} else if output != expected[i.command] {
+						failures <- fmt.Sprintf("%s: unexpected output %q", i.command, output)
+					}
+				}
+			}(w)
+		}
+
+		wg.Wait()
+		close(failures)
+
+		var messages []string
+		for failure := range failures {
+			messages = append(messages, failure)
+		}
+		Expect(messages).To(BeEmpty())
+	})
+})
+
+// timeCommand reads the host's clock, which unlike reading properties the simulator
+// refuses with NotAuthenticated when the session is gone
+type timeCommand struct {
+	*flags.ClientFlag
+}
+
+func init() {
+	cli.Register("test.time", &timeCommand{})
+}
+
+func (cmd *timeCommand) Register(ctx context.Context, f *flag.FlagSet) {
+	cmd.ClientFlag, ctx = flags.NewClientFlag(ctx)
+	cmd.ClientFlag.Register(ctx, f)
+}
+
+func (cmd *timeCommand) Process(ctx context.Context) error {
+	return cmd.ClientFlag.Process(ctx)
+}
+
+func (cmd *timeCommand) Run(ctx context.Context, f *flag.FlagSet) error {
+	client, err := cmd.Client()
+	if err != nil {
+		return err
+	}
+
+	_, err = methods.GetCurrentTime(ctx, client)
+	return err
+}
@@ -0,0 +1,329 @@
+package govc_test
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	"github.com/vmware/govmomi"
+	"github.com/vmware/govmomi/find"
+	"github.com/vmware/govmomi/object"
+	"github.com/vmware/govmomi/simulator"
+	"github.com/vmware/govmomi/vim25/methods"
+	"github.com/vmware/govmomi/vim25/mo"
+	"github.com/vmware/govmomi/vim25/soap"
+	"github.com/vmware/govmomi/vim25/types"
+
+	fakegovc "bosh-esxi-cpi/govc/fakes"
+
+	fakelogger "github.com/cloudfoundry/bosh-utils/logger/loggerfakes"
+
+	"bosh-esxi-cpi/govc"
+	"bosh-esxi-cpi/vm"
+)
+
+var _ = Describe("GovcClient against a vCenter simulator", func() {
+	var (
+		model  *simulator.Model
+		server *simulator.Server
+		client govc.GovcClient
+	)
+
+	BeforeEach(func() {
+		model = simulator.VPX()
+		Expect(model.Create()).To(Succeed())
+		server = model.Service.NewServer()
+
+		config := &fakegovc.FakeGovcConfig{}
+		config.EsxUrlReturns(server.URL.String())
+		config.DatacenterReturns("DC0")
+
+		logger := &fakelogger.FakeLogger{}
+		client = govc.NewClient(govc.NewGovcRunner(logger), config, logger)
+	})
+
+	AfterEach(func() {
+		server.Close()
+		model.Remove()
+	})
+
+	findVM := func(vmName string) *object.VirtualMachine {
+		ctx := context.Background()
+
+		c, err := govmomi.NewClient(ctx, server.URL, true)
+		Expect(err).ToNot(HaveOccurred())
+
+		finder := find.NewFinder(c.Client, true)
+		datacenter, err := finder.Datacenter(ctx, "DC0")
+		Expect(err).ToNot(HaveOccurred())
+		finder.SetDatacenter(datacenter)
+
+		vm, err := finder.VirtualMachine(ctx, vmName)
+		Expect(err).ToNot(HaveOccurred())
+		return vm
+	}
+
+	vmNetworkBacking := func(vmName string) types.BaseVirtualDeviceBackingInfo {
+		devices, err := findVM(vmName).Device(context.Background())
+		Expect(err).ToNot(HaveOccurred())
+
+		nics := devices.SelectByType((*types.VirtualEthernetCard)(nil))
+		Expect(nics).ToNot(BeEmpty())
+
+		return nics[len(nics)-1].GetVirtualDevice().Backing
+	}
+
+	addNIC := func(network string, macAddress string, adapterType string) error {
+		return client.ConfigureVM("DC0_H0_VM0", govc.VMSpec{
+			NICs: []govc.NIC{{Network: network, MACAddress: macAddress, AdapterType: adapterType}},
+		})
+	}
+
+	It("configures a VM in a single reconfigure task", func() {
+		before, err := vmTasks(context.Background(), findVM("DC0_H0_VM0"))
+		Expect(err).ToNot(HaveOccurred())
+
+		// ESXi creates the folders an upload goes to, the simulator does not
+		runner := govc.NewGovcRunner(&fakelogger.FakeLogger{})
+		_, err = runner.CliCommand("datastore.mkdir", map[string]string{"u": server.URL.String(), "k": "true"}, []string{"env"})
+		Expect(err).ToNot(HaveOccurred())
+
+		iso, err := ioutil.TempFile("", "env-iso-")
+		Expect(err).ToNot(HaveOccurred())
+		defer os.Remove(iso.Name())
+		iso.Close()
+
+		err = client.ConfigureVM("DC0_H0_VM0", govc.VMSpec{
+			Props: vm.VMProps{CPU: 2, RAM: 2048, Disk: 16, CoresPerSocket: 2, CPUHotAdd: true, MemoryHotAdd: true, Firmware: vm.FirmwareEFI},
+			NICs: []govc.NIC{
+				{Network: "VM Network", MACAddress: "00:50:56:00:00:01", AdapterType: "vmxnet3"},
+				{Network: "DVS0/DC0_DVPG0", MACAddress: "00:50:56:00:00:02", AdapterType: "e1000e"},
+			},
+			ExtraConfig: map[string]string{"bosh.created_port_groups": "VLAN 10"},
+			EnvIsoPath:  iso.Name(),
+		})
+		Expect(err).ToNot(HaveOccurred())
+
+		var mvm mo.VirtualMachine
+		vmObject := findVM("DC0_H0_VM0")
+		Expect(vmObject.Properties(context.Background(), vmObject.Reference(), []string{"config"}, &mvm)).To(Succeed())
+
+		Expect(mvm.Config.Hardware.NumCPU).To(BeEquivalentTo(2))
+		Expect(mvm.Config.Hardware.MemoryMB).To(BeEquivalentTo(2048))
+		Expect(mvm.Config.Hardware.NumCoresPerSocket).To(BeEquivalentTo(2))
+		Expect(mvm.Config.CpuHotAddEnabled).To(Equal(types.NewBool(true)))
+		Expect(mvm.Config.MemoryHotAddEnabled).To(Equal(types.NewBool(true)))
+		Expect(mvm.Config.Firmware).To(Equal("efi"))
+
+		extraConfig := map[string]string{}
+		for _, option := range mvm.Config.ExtraConfig {
+			extraConfig[option.GetOptionValue().Key] = fmt.Sprint(option.GetOptionValue().Value)
+		}
+		Expect(extraConfig).ToNot(HaveKey("vcpu.hotadd"))
+		Expect(extraConfig).ToNot(HaveKey("firmware"))
+		Expect(extraConfig).To(HaveKeyWithValue("bosh.created_port_groups", "VLAN 10"))
+
+		devices := object.VirtualDeviceList(mvm.Config.Hardware.Device)
+		Expect(devices.SelectByType((*types.VirtualEthernetCard)(nil))).To(HaveLen(3))
+
+		var disks []string
+		for _, disk := range devices.SelectByType((*types.VirtualDisk)(nil)) {
+			disks = append(disks, disk.GetVirtualDevice().Backing.(types.BaseVirtualDeviceFileBackingInfo).GetVirtualDeviceFileBackingInfo().FileName)
+		}
+		Expect(disks).To(ContainElement("[LocalDS_0] DC0_H0_VM0/ephemeral.vmdk"))
+
+		cdrom, err := devices.FindCdrom("")
+		Expect(err).ToNot(HaveOccurred())
+		Expect(cdrom.Backing).To(Equal(&types.VirtualCdromIsoBackingInfo{
+			VirtualDeviceFileBackingInfo: types.VirtualDeviceFileBackingInfo{FileName: "[LocalDS_0] env/env-DC0_H0_VM0.iso"},
+		}))
+		Expect(cdrom.Connectable.StartConnected).To(BeTrue())
+
+		after, err := vmTasks(context.Background(), vmObject)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(after[len(before):]).To(Equal([]string{"VirtualMachine.reconfigVm"}))
+	})
+
+	It("backs the NIC by a distributed port group qualified by its switch", func() {
+		err := addNIC("DVS0/DC0_DVPG0", "00:50:56:00:00:01", "vmxnet3")
+		Expect(err).ToNot(HaveOccurred())
+
+		backing, ok := vmNetworkBacking("DC0_H0_VM0").(*types.VirtualEthernetCardDistributedVirtualPortBackingInfo)
+		Expect(ok).To(BeTrue())
+		Expect(backing.Port.PortgroupKey).ToNot(BeEmpty())
+	})
+
+	It("backs the NIC by a distributed port group found by name", func() {
+		err := addNIC("DC0_DVPG0", "00:50:56:00:00:02", "e1000")
+		Expect(err).ToNot(HaveOccurred())
+
+		_, ok := vmNetworkBacking("DC0_H0_VM0").(*types.VirtualEthernetCardDistributedVirtualPortBackingInfo)
+		Expect(ok).To(BeTrue())
+	})
+
+	It("backs the NIC by a standard port group", func() {
+		err := addNIC("VM Network", "00:50:56:00:00:03", "pcnet32")
+		Expect(err).ToNot(HaveOccurred())
+
+		backing, ok := vmNetworkBacking("DC0_H0_VM0").(*types.VirtualEthernetCardNetworkBackingInfo)
+		Expect(ok).To(BeTrue())
+		Expect(backing.DeviceName).To(Equal("VM Network"))
+	})
+
+	It("rejects a distributed switch without a port group", func() {
+		err := addNIC("DVS0", "00:50:56:00:00:04", "vmxnet3")
+		Expect(err).To(MatchError(ContainSubstring("network 'DVS0' is a distributed switch")))
+	})
+
+	It("fails when the switch does not carry the port group", func() {
+		err := addNIC("DVS0/missing", "00:50:56:00:00:05", "vmxnet3")
+		Expect(err).To(MatchError("port group 'missing' not found on DVS 'DVS0'"))
+	})
+
+	It("reports a missing VM as not found", func() {
+		err := client.AttachDisk("vm-missing", "disk-uuid")
+		Expect(err).To(HaveOccurred())
+		Expect(govc.IsVMNotFound(err)).To(BeTrue())
+	})
+
+	It("reports reading the extra config of a missing VM as not found", func() {
+		_, err := client.GetVMExtraConfig("cs-missing")
+		Expect(govc.IsVMNotFound(err)).To(BeTrue())
+	})
+
+	It("reports renaming a missing VM as not found", func() {
+		err := client.RenameVM("vm-missing", "vm-renamed")
+		Expect(govc.IsVMNotFound(err)).To(BeTrue())
+	})
+
+	It("lists registered VMs and datastore files", func() {
+		Expect(client.CreateDisk("disk-uuid", 1)).To(Succeed())
+
+		names, err := client.ListVMs()
+		Expect(err).ToNot(HaveOccurred())
+		Expect(names).To(ContainElement("DC0_H0_VM0"))
+
+		files, err := client.ListDatastoreFiles()
+		Expect(err).ToNot(HaveOccurred())
+
+		var paths []string
+		for _, file := range files {
+			paths = append(paths, file.Path)
+		}
+		Expect(paths).To(ContainElement("disk-uuid.vmdk"))
+		Expect(paths).To(ContainElement("DC0_H0_VM0/DC0_H0_VM0.vmx"))
+	})
+
+	It("keeps the writes to a persistent disk attached as a linked clone when destroying the VM", func() {
+		ctx := context.Background()
+		Expect(client.CreateDisk("disk-uuid", 1)).To(Succeed())
+
+		// AttachDisk attaches a linked clone writing to a delta disk in the VM folder; the simulator cannot create one
+		vmObj := findVM("DC0_H0_VM0")
+		devices, err := vmObj.Device(ctx)
+		Expect(err).ToNot(HaveOccurred())
+		controller, err := devices.FindDiskController("")
+		Expect(err).ToNot(HaveOccurred())
+
+		ds := simulator.Map.Any("Datastore").(*simulator.Datastore)
+		disk := devices.CreateDisk(controller, ds.Reference(), "[LocalDS_0] DC0_H0_VM0/disk-uuid-000001.vmdk")
+		disk.Backing.(*types.VirtualDiskFlatVer2BackingInfo).Parent = &types.VirtualDiskFlatVer2BackingInfo{
+			VirtualDeviceFileBackingInfo: types.VirtualDeviceFileBackingInfo{FileName: "[LocalDS_0] disk-uuid.vmdk"},
+		}
+		Expect(vmObj.AddDevice(ctx, disk)).To(Succeed())
+
+		dsDir := ds.Info.GetDatastoreInfo().Url
+		Expect(ioutil.WriteFile(filepath.Join(dsDir, "DC0_H0_VM0", "disk-uuid-000001.vmdk"), []byte("persistent data"), 0600)).To(Succeed())
+
+		_, err = client.DestroyVM("DC0_H0_VM0")
+		Expect(err).ToNot(HaveOccurred())
+
+		Expect(filepath.Join(dsDir, "DC0_H0_VM0")).ToNot(BeADirectory())
+		Expect(ioutil.ReadFile(filepath.Join(dsDir, "disk-uuid.vmdk"))).To(Equal([]byte("persistent data")))
+		Expect(filepath.Join(dsDir, "consolidated-disk-uuid.vmdk")).ToNot(BeAnExistingFile())
+	})
+
+	Describe("power operations", func() {
+		powerState := func(vmName string) types.VirtualMachinePowerState {
+			ctx := context.Background()
+
+			c, err := govmomi.NewClient(ctx, server.URL, true)
+			Expect(err).ToNot(HaveOccurred())
+
+			finder := find.NewFinder(c.Client, true)
+			datacenter, err := finder.Datacenter(ctx, "DC0")
+			Expect(err).ToNot(HaveOccurred())
+			finder.SetDatacenter(datacenter)
+
+			vm, err := finder.VirtualMachine(ctx, vmName)
+			Expect(err).ToNot(HaveOccurred())
+
+			state, err := vm.PowerState(ctx)
+			Expect(err).ToNot(HaveOccurred())
+			return state
+		}
+
+		It("waits for VMs to power off and on", func() {
+			runner := govc.NewGovcRunner(&fakelogger.FakeLogger{})
+			_, err := runner.CliCommand("cpi.vm.power", map[string]string{"vm": "DC0_H0_VM0", "off": "true", "u": server.URL.String(), "k": "true"}, nil)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(powerState("DC0_H0_VM0")).To(Equal(types.VirtualMachinePowerStatePoweredOff))
+
+			_, err = client.StartVM("DC0_H0_VM0")
+			Expect(err).ToNot(HaveOccurred())
+			Expect(powerState("DC0_H0_VM0")).To(Equal(types.VirtualMachinePowerStatePoweredOn))
+		})
+
+		It("powers VMs off before destroying them", func() {
+			_, err := client.DestroyVM("DC0_H0_VM0")
+			Expect(err).ToNot(HaveOccurred())
+
+			names, err := client.ListVMs()
+			Expect(err).ToNot(HaveOccurred())
+			Expect(names).ToNot(ContainElement("DC0_H0_VM0"))
+		})
+
+		It("keeps the fault of a failed power on", func() {
+			runner := govc.NewGovcRunner(&fakelogger.FakeLogger{})
+			_, err := runner.CliCommand("cpi.vm.power", map[string]string{"vm": "DC0_H0_VM0", "off": "true", "u": server.URL.String(), "k": "true"}, nil)
+			Expect(err).ToNot(HaveOccurred())
+
+			vm := simulator.Map.Get(findVM("DC0_H0_VM0").Reference()).(*simulator.VirtualMachine)
+			simulator.Map.Put(&insufficientResourcesVM{VirtualMachine: vm})
+
+			_, err = client.StartVM("DC0_H0_VM0")
+			Expect(err).To(HaveOccurred())
+			Expect(govc.IsInsufficientResources(err)).To(BeTrue())
+		})
+
+		It("reports a missing VM as not found", func() {
+			_, err := client.StartVM("vm-missing")
+			Expect(govc.IsVMNotFound(err)).To(BeTrue())
+		})
+	})
+})
+
+// insufficientResourcesVM fails to power on like a VM on a host without the memory to run it
+type insufficientResourcesVM struct {
+	*simulator.VirtualMachine
+}
+
+// Get lets the simulator's property collector read the wrapped VM
+func (vm *insufficientResourcesVM) Get() mo.Reference {
+	return vm.VirtualMachine
+}
+
+func (vm *insufficientResourcesVM) PowerOnVMTask(req *types.PowerOnVM_Task) soap.HasFault {
+	task := simulator.CreateTask(vm, "powerOn", func(*simulator.Task) (types.AnyType, types.BaseMethodFault) {
+		return nil, &types.InsufficientMemoryResourcesFault{}
+	})
+
+	return &methods.PowerOnVM_TaskBody{
+		Res: &types.PowerOnVM_TaskResponse{Returnval: task.Run()},
+	}
+}
@@ -0,0 +1,99 @@
+package govc
+
+import (
+	"fmt"
+	"sync"
+	"time"
+)
+
+// inventoryTTL bounds how long a listing is reused, as other processes, such as 'admin pool' run from cron,
+// change the host's inventory without the runner knowing
+const inventoryTTL = 30 * time.Second
+
+// inventoryCommands list the host's inventory, which create_vm, create_stemcell and the pool read on every call
+var inventoryCommands = map[string]bool{
+	"ls":                  true,
+	"host.portgroup.info": true,
+	"host.vswitch.info":   true,
+}
+
+// readOnlyCommands leave the inventory alone, any other command may change it
+var readOnlyCommands = map[string]bool{
+	"datastore.ls": true,
+	"device.info":  true,
+	"session.ls":   true,
+	"vm.info":      true,
+}
+
+// inventoryCache keeps the output of inventory commands per host, as keyed by sessionKey, until a command
+// which may change the host's inventory runs or the output is inventoryTTL old
+type inventoryCache struct {
+	mutex sync.Mutex
+	ttl   time.Duration
+	hosts map[string]*hostInventory
+}
+
+type hostInventory struct {
+	// generation counts the commands which may have changed the inventory
+	generation int
+	listings   map[string]inventoryListing
+}
+
+type inventoryListing struct {
+	output  string
+	expires time.Time
+}
+
+func newInventoryCache(ttl time.Duration) *inventoryCache {
+	return &inventoryCache{ttl: ttl, hosts: map[string]*hostInventory{}}
+}
+
+// get returns the cached output of the command, or the generation to put its output with
+func (c *inventoryCache) get(key string, command string, flagMap map[string]string, args []string) (string, bool, int) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	host := c.host(key)
+	listing, found := host.listings[listingKey(command, flagMap, args)]
+	if !found || time.Now().After(listing.expires) {
+		return "", false, host.generation
+	}
+	return listing.output, true, host.generation
+}
+
+// put caches the output of the command, unless a command which may have changed the inventory ran
+// since get, as the output may predate the change
+func (c *inventoryCache) put(key string, generation int, command string, flagMap map[string]string, args []string, output string) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	host := c.host(key)
+	if host.generation != generation {
+		return
+	}
+	host.listings[listingKey(command, flagMap, args)] = inventoryListing{output: output, expires: time.Now().Add(c.ttl)}
+}
+
+// invalidate forgets the host's listings once a command which may have changed its inventory ran
+func (c *inventoryCache) invalidate(key string) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	host := c.host(key)
+	host.generation++
+	host.listings = map[string]inventoryListing{}
+}
+
+func (c *inventoryCache) host(key string) *hostInventory {
+	host, found := c.hosts[key]
+	if !found {
+		host = &hostInventory{listings: map[string]inventoryListing{}}
+		c.hosts[key] = host
+	}
+	return host
+}
+
+// listingKey identifies a listing by the command and everything it was run with; maps print sorted by key
+func listingKey(command string, flagMap map[string]string, args []string) string {
+	return fmt.Sprintf("%s %v %q", command, flagMap, args)
+}
@@ -0,0 +1,208 @@
+package govc
+
+import (
+	"encoding/json"
+	"net/url"
+	"reflect"
+	"strings"
+	"sync"
+
+	bosherr "github.com/cloudfoundry/bosh-utils/errors"
+	boshsys "github.com/cloudfoundry/bosh-utils/system"
+	"github.com/vmware/govmomi/task"
+	"github.com/vmware/govmomi/vim25/types"
+)
+
+// Interaction is a command a runner ran, as RecordingRunner saves it and ReplayRunner serves it
+type Interaction struct {
+	Command string            `json:"command"`
+	Flags   map[string]string `json:"flags"`
+	Args    []string          `json:"args"`
+	Output  string            `json:"output"`
+	Error   *RecordedError    `json:"error,omitempty"`
+}
+
+// RecordedError keeps what the fault checks, such as IsVMNotFound, look at besides the message
+type RecordedError struct {
+	Message    string `json:"message"`
+	Fault      string `json:"fault,omitempty"`
+	VMNotFound bool   `json:"vm_not_found,omitempty"`
+}
+
+// RecordingRunner runs commands with another runner and records them, to be saved as a golden file for
+// ReplayRunner. It should wrap the runner talking to the host, not a RetryingGovcRunner, so a replay
+// retries like the recording did. The password in the URL flag is left out.
+type RecordingRunner struct {
+	runner GovcRunner
+	fs     boshsys.FileSystem
+	path   string
+
+	mutex        sync.Mutex
+	interactions []Interaction
+}
+
+func NewRecordingRunner(runner GovcRunner, fs boshsys.FileSystem, path string) *RecordingRunner {
+	return &RecordingRunner{runner: runner, fs: fs, path: path}
+}
+
+func (r *RecordingRunner) CliCommand(command string, flagMap map[string]string, args []string) (string, error) {
+	output, err := r.runner.CliCommand(command, flagMap, args)
+
+	interaction := Interaction{
+		Command: command,
+		Flags:   redactFlags(flagMap),
+		Args:    args,
+		Output:  output,
+	}
+	if err != nil {
+		interaction.Error = &RecordedError{
+			Message:    err.Error(),
+			Fault:      faultName(err),
+			VMNotFound: IsVMNotFound(err),
+		}
+	}
+
+	r.mutex.Lock()
+	r.interactions = append(r.interactions, interaction)
+	r.mutex.Unlock()
+
+	return output, err
+}
+
+// Save writes the interactions recorded so far to the golden file
+func (r *RecordingRunner) Save() error {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	contents, err := json.MarshalIndent(r.interactions, "", "  ")
+	if err != nil {
+		return bosherr.WrapError(err, "Marshalling interactions")
+	}
+
+	err = r.fs.WriteFile(r.path, append(contents, '\n'))
+	if err != nil {
+		return bosherr.WrapErrorf(err, "Writing interactions to '%s'", r.path)
+	}
+
+	return nil
+}
+
+// ReplayRunner serves the interactions of a golden file RecordingRunner saved, without a host. Each
+// command is answered by the next interaction recorded with the same flags and arguments. The URL
+// flag is not compared, since a replay serves one host, at whatever URL the test configures.
+type ReplayRunner struct {
+	mutex        sync.Mutex
+	interactions []Interaction
+}
+
+func NewReplayRunner(fs boshsys.FileSystem, path string) (*ReplayRunner, error) {
+	contents, err := fs.ReadFile(path)
+	if err != nil {
+		return nil, bosherr.WrapErrorf(err, "Reading interactions from '%s'", path)
+	}
+
+	var interactions []Interaction
+	err = json.Unmarshal(contents, &interactions)
+	if err != nil {
+		return nil, bosherr.WrapErrorf(err, "Unmarshalling interactions from '%s'", path)
+	}
+
+	return &ReplayRunner{interactions: interactions}, nil
+}
+
+func (r *ReplayRunner) CliCommand(command string, flagMap map[string]string, args []string) (string, error) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	for i, interaction := range r.interactions {
+		if !interaction.matches(command, flagMap, args) {
+			continue
+		}
+
+		r.interactions = append(r.interactions[:i], r.interactions[i+1:]...)
+
+		if interaction.Error != nil {
+			return interaction.Output, interaction.Error.err()
+		}
+		return interaction.Output, nil
+	}
+
+	return "", bosherr.Errorf("No recorded interaction left for command '%s' with flags %v and args %v", command, redactFlags(flagMap), args)
+}
+
+// Unused returns the recorded interactions no command asked for yet
+func (r *ReplayRunner) Unused() []Interaction {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	return append([]Interaction{}, r.interactions...)
+}
+
+func (i Interaction) matches(command string, flagMap map[string]string, args []string) bool {
+	if i.Command != command || len(i.Args) != len(args) {
+		return false
+	}
+
+	for n, arg := range args {
+		if i.Args[n] != arg {
+			return false
+		}
+	}
+
+	flags := map[string]string{}
+	for name, value := range i.Flags {
+		if name != "u" {
+			flags[name] = value
+		}
+	}
+	for name, value := range flagMap {
+		if name == "u" {
+			continue
+		}
+		if recorded, found := flags[name]; !found || recorded != value {
+			return false
+		}
+		delete(flags, name)
+	}
+
+	return len(flags) == 0
+}
+
+// err rebuilds the error so the fault checks classify it as they did the recorded one
+func (e RecordedError) err() error {
+	if e.Fault != "" {
+		if typ, found := types.TypeFunc()(e.Fault); found {
+			if fault, ok := reflect.New(typ).Interface().(types.BaseMethodFault); ok {
+				return task.Error{LocalizedMethodFault: &types.LocalizedMethodFault{Fault: fault, LocalizedMessage: e.Message}}
+			}
+		}
+	}
+
+	// govc and vmNotFoundError word a missing VM alike
+	if e.VMNotFound {
+		return vmNotFoundError{vmName: strings.TrimSuffix(strings.TrimPrefix(e.Message, "vm '"), "' not found")}
+	}
+
+	return replayedError(e.Message)
+}
+
+type replayedError string
+
+func (e replayedError) Error() string {
+	return string(e)
+}
+
+// redactFlags returns the flags with the password left out of the URL
+func redactFlags(flagMap map[string]string) map[string]string {
+	redacted := map[string]string{}
+	for name, value := range flagMap {
+		redacted[name] = value
+	}
+
+	if u, err := url.Parse(flagMap["u"]); err == nil && u.User != nil {
+		u.User = url.User(u.User.Username())
+		redacted["u"] = u.String()
+	}
+
+	return redacted
+}
@@ -0,0 +1,140 @@
+package govc
+
+import (
+	"encoding/json"
+	"fmt"
+	"path"
+
+	"bosh-esxi-cpi/filelock"
+)
+
+// replicaStagingSuffix names the folder a replica is copied to before it is moved in place,
+// so an interrupted copy is never mistaken for a complete replica
+const replicaStagingSuffix = ".replicating"
+
+// replicateVM copies the folder of a VM on the default datastore to the same path on another datastore,
+// unless it is already there. The director runs create_vm calls concurrently, each in its own process,
+// so replicating a VM to a datastore is serialized through a lock file.
+func (c GovcClientImpl) replicateVM(vmName string, datastore string) error {
+	lock, err := lockReplica(c.Host(), vmName, datastore)
+	if err != nil {
+		return err
+	}
+	defer lock.Unlock()
+
+	replicated, err := c.datastorePathExists(datastore, vmName)
+	if err != nil || replicated {
+		return err
+	}
+
+	c.logger.Info("govc", "Replicating '%s' to datastore '%s'", vmName, datastore)
+
+	stagingName := vmName + replicaStagingSuffix
+	err = c.deleteDatastorePathIfExists(datastore, stagingName)
+	if err != nil {
+		return err
+	}
+
+	result, err := c.copyToDatastore(vmName, stagingName, datastore)
+	if err != nil {
+		c.logger.ErrorWithDetails("govc", "copying replica", err, result)
+		return err
+	}
+
+	result, err = c.moveDatastoreObject(datastore, stagingName, vmName)
+	if err != nil {
+		c.logger.ErrorWithDetails("govc", "moving replica in place", err, result)
+		return err
+	}
+
+	return nil
+}
+
+// deleteReplica removes a replica, and the staging copy of an interrupted replication, from the datastore
+func (c GovcClientImpl) deleteReplica(vmName string, datastore string) error {
+	for _, name := range []string{vmName, vmName + replicaStagingSuffix} {
+		err := c.deleteDatastorePathIfExists(datastore, name)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (c GovcClientImpl) deleteDatastorePathIfExists(datastore string, datastorePath string) error {
+	found, err := c.datastorePathExists(datastore, datastorePath)
+	if err != nil || !found {
+		return err
+	}
+
+	result, err := c.deleteDatastoreObject(datastore, datastorePath)
+	if err != nil {
+		c.logger.ErrorWithDetails("govc", "deleting datastore path", err, result, datastore, datastorePath)
+		return err
+	}
+
+	return nil
+}
+
+// datastores returns the names of the datastores in the datacenter
+func (c GovcClientImpl) datastores() ([]string, error) {
+	flags := map[string]string{
+		"t": "Datastore",
+		"u": c.config.EsxUrl(),
+		"k": "true",
+	}
+
+	if datacenter := c.config.Datacenter(); datacenter != "" {
+		flags["dc"] = datacenter
+	}
+
+	result, err := c.runner.CliCommand("ls", flags, []string{"datastore"})
+	if err != nil {
+		return nil, err
+	}
+
+	var response struct {
+		Elements []struct{ Path string } `json:"elements"`
+	}
+	err = json.Unmarshal([]byte(result), &response)
+	if err != nil {
+		return nil, fmt.Errorf("error: %+v\nresult: %s\n", err, result)
+	}
+
+	var names []string
+	for _, element := range response.Elements {
+		names = append(names, path.Base(element.Path))
+	}
+
+	return names, nil
+}
+
+func (c GovcClientImpl) copyToDatastore(sourcePath string, targetPath string, datastore string) (string, error) {
+	flags := map[string]string{
+		"ds-target": datastore,
+		"u":         c.config.EsxUrl(),
+		"k":         "true",
+	}
+	args := []string{sourcePath, targetPath}
+
+	return c.runner.CliCommand("datastore.cp", flags, args)
+}
+
+func (c GovcClientImpl) moveDatastoreObject(datastore string, sourcePath string, targetPath string) (string, error) {
+	flags := map[string]string{
+		"u": c.config.EsxUrl(),
+		"k": "true",
+	}
+	if datastore != "" {
+		flags["ds"] = datastore
+	}
+	args := []string{sourcePath, targetPath}
+
+	return c.runner.CliCommand("datastore.mv", flags, args)
+}
+
+// lockReplica blocks until no other process, or goroutine, holds the lock on replicating the VM to the datastore of the host
+func lockReplica(host string, vmName string, datastore string) (filelock.Lock, error) {
+	return filelock.Acquire("replica", host, datastore, vmName)
+}
@@ -0,0 +1,121 @@
+package govc
+
+import (
+	"math/rand"
+	"strings"
+	"time"
+
+	boshlog "github.com/cloudfoundry/bosh-utils/logger"
+)
+
+type RetryPolicy struct {
+	MaxAttempts  int
+	InitialDelay time.Duration
+	MaxDelay     time.Duration
+}
+
+var DefaultRetryPolicy = RetryPolicy{
+	MaxAttempts:  5,
+	InitialDelay: 1 * time.Second,
+	MaxDelay:     30 * time.Second,
+}
+
+// busyFaults are raised by hostd when another task holds the object, before it applies the operation,
+// so any command may be retried on them
+var busyFaults = map[string]bool{
+	"TaskInProgress": true,
+}
+
+// stateFaults may also be raised by a task which already applied part of the operation, so only
+// idempotentCommands are retried on them
+var stateFaults = map[string]bool{
+	"InvalidState":     true,
+	"ConcurrentAccess": true,
+}
+
+// idempotentCommands can be repeated even when a failed attempt may have been applied, e.g. after
+// a connection reset. Everything else (datastore.cp, vm.register, vm.disk.create, cpi.vm.configure, ...)
+// would copy, register or attach a second time, so those are only retried on busyFaults
+var idempotentCommands = map[string]bool{
+	"datastore.ls":        true,
+	"datastore.rm":        true,
+	"datastore.upload":    true,
+	"device.cdrom.eject":  true,
+	"device.cdrom.insert": true,
+	"device.connect":      true,
+	"device.disconnect":   true,
+	"device.info":         true,
+	"host.portgroup.info": true,
+	"host.vswitch.info":   true,
+	"ls":                  true,
+	"vm.change":           true,
+	"vm.info":             true,
+}
+
+type RetryingGovcRunner struct {
+	runner GovcRunner
+	policy RetryPolicy
+	logger boshlog.Logger
+}
+
+func NewRetryingGovcRunner(runner GovcRunner, policy RetryPolicy, logger boshlog.Logger) GovcRunner {
+	return RetryingGovcRunner{runner: runner, policy: policy, logger: logger}
+}
+
+func (r RetryingGovcRunner) withLogger(logger boshlog.Logger) GovcRunner {
+	r.runner = RunnerWithLogger(r.runner, logger)
+	r.logger = logger
+	return r
+}
+
+func (r RetryingGovcRunner) logout() {
+	Logout(r.runner)
+}
+
+func (r RetryingGovcRunner) CliCommand(command string, flagMap map[string]string, args []string) (string, error) {
+	var result string
+	var err error
+
+	for attempt := 1; ; attempt++ {
+		result, err = r.runner.CliCommand(command, flagMap, args)
+		if err == nil || attempt >= r.policy.MaxAttempts || !isRetryable(command, err) {
+			return result, err
+		}
+
+		delay := r.delay(attempt)
+		r.logger.Warn("govc-runner", "Retrying '%s' in %s (attempt %d of %d): %s", command, delay, attempt+1, r.policy.MaxAttempts, err)
+		time.Sleep(delay)
+	}
+}
+
+// delay backs off exponentially, with jitter so concurrent CPI calls do not retry in lockstep
+func (r RetryingGovcRunner) delay(attempt int) time.Duration {
+	delay := r.policy.InitialDelay
+	for i := 1; i < attempt && delay < r.policy.MaxDelay; i++ {
+		delay *= 2
+	}
+
+	if delay > r.policy.MaxDelay {
+		delay = r.policy.MaxDelay
+	}
+
+	if delay <= 0 {
+		return 0
+	}
+
+	return delay/2 + time.Duration(rand.Int63n(int64(delay/2)+1))
+}
+
+func isRetryable(command string, err error) bool {
+	fault := faultName(err)
+	if busyFaults[fault] {
+		return true
+	}
+
+	// the request never reached hostd
+	if strings.Contains(err.Error(), "connection refused") {
+		return true
+	}
+
+	return idempotentCommands[command] && (stateFaults[fault] || isConnectionError(err))
+}
@@ -0,0 +1,136 @@
+package govc_test
+
+import (
+	"errors"
+	"net/url"
+	"time"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	"github.com/vmware/govmomi/task"
+	"github.com/vmware/govmomi/vim25/soap"
+	"github.com/vmware/govmomi/vim25/types"
+
+	fakegovc "bosh-esxi-cpi/govc/fakes"
+
+	fakelogger "github.com/cloudfoundry/bosh-utils/logger/loggerfakes"
+
+	"bosh-esxi-cpi/govc"
+)
+
+var _ = Describe("RetryingGovcRunner", func() {
+	var (
+		runner *fakegovc.FakeGovcRunner
+		logger *fakelogger.FakeLogger
+		policy govc.RetryPolicy
+	)
+
+	taskInProgress := soap.WrapVimFault(&types.TaskInProgress{})
+	invalidState := task.Error{LocalizedMethodFault: &types.LocalizedMethodFault{
+		Fault:            &types.InvalidState{},
+		LocalizedMessage: "The operation is not allowed in the current state.",
+	}}
+	concurrentAccess := soap.WrapVimFault(&types.ConcurrentAccess{})
+	invalidPowerState := task.Error{LocalizedMethodFault: &types.LocalizedMethodFault{
+		Fault:            &types.InvalidPowerState{},
+		LocalizedMessage: "The attempted operation cannot be performed in the current state (Powered on).",
+	}}
+	connectionReset := soap.WrapRegularError(&url.Error{
+		Op:  "Post",
+		URL: "https://esx/sdk",
+		Err: errors.New("read tcp 10.0.0.1:443: read: connection reset by peer"),
+	})
+	connectionRefused := soap.WrapRegularError(&url.Error{
+		Op:  "Post",
+		URL: "https://esx/sdk",
+		Err: errors.New("dial tcp 10.0.0.1:443: connect: connection refused"),
+	})
+
+	BeforeEach(func() {
+		runner = &fakegovc.FakeGovcRunner{}
+		logger = &fakelogger.FakeLogger{}
+		policy = govc.RetryPolicy{MaxAttempts: 3, InitialDelay: time.Millisecond, MaxDelay: 2 * time.Millisecond}
+	})
+
+	It("returns the first successful result", func() {
+		runner.CliCommandReturns("success", nil)
+
+		result, err := govc.NewRetryingGovcRunner(runner, policy, logger).CliCommand("vm.info", map[string]string{"k": "true"}, []string{"vm-uuid"})
+		Expect(err).ToNot(HaveOccurred())
+		Expect(result).To(Equal("success"))
+		Expect(runner.CliCommandCallCount()).To(Equal(1))
+
+		command, flags, args := runner.CliCommandArgsForCall(0)
+		Expect(command).To(Equal("vm.info"))
+		Expect(flags).To(Equal(map[string]string{"k": "true"}))
+		Expect(args).To(Equal([]string{"vm-uuid"}))
+	})
+
+	retryable := []struct {
+		description string
+		command     string
+		err         error
+	}{
+		{"TaskInProgress on datastore.cp", "datastore.cp", taskInProgress},
+		{"InvalidState task error on vm.change", "vm.change", invalidState},
+		{"connection refused on vm.disk.create", "vm.disk.create", connectionRefused},
+		{"connection reset on vm.info", "vm.info", connectionReset},
+	}
+
+	for _, entry := range retryable {
+		entry := entry
+
+		It("retries "+entry.description, func() {
+			runner.CliCommandReturnsOnCall(0, "", entry.err)
+			runner.CliCommandReturnsOnCall(1, "success", nil)
+
+			result, err := govc.NewRetryingGovcRunner(runner, policy, logger).CliCommand(entry.command, nil, nil)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(result).To(Equal("success"))
+			Expect(runner.CliCommandCallCount()).To(Equal(2))
+			Expect(logger.WarnCallCount()).To(Equal(1))
+		})
+	}
+
+	notRetryable := []struct {
+		description string
+		command     string
+		err         error
+	}{
+		{"connection reset on datastore.cp, which may have copied", "datastore.cp", connectionReset},
+		{"connection reset on cpi.vm.configure, which may have added a NIC", "cpi.vm.configure", connectionReset},
+		{"InvalidState task error on vm.register, which may have registered", "vm.register", invalidState},
+		{"ConcurrentAccess on datastore.cp, which may have copied", "datastore.cp", concurrentAccess},
+		{"InvalidPowerState, which is not InvalidState", "vm.power", invalidPowerState},
+		{"plain errors", "vm.info", errors.New("vm 'vm-uuid' not found")},
+	}
+
+	for _, entry := range notRetryable {
+		entry := entry
+
+		It("does not retry "+entry.description, func() {
+			runner.CliCommandReturns("", entry.err)
+
+			_, err := govc.NewRetryingGovcRunner(runner, policy, logger).CliCommand(entry.command, nil, nil)
+			Expect(err).To(Equal(entry.err))
+			Expect(runner.CliCommandCallCount()).To(Equal(1))
+			Expect(logger.WarnCallCount()).To(Equal(0))
+		})
+	}
+
+	It("gives up after the maximum number of attempts", func() {
+		runner.CliCommandReturns("", taskInProgress)
+
+		_, err := govc.NewRetryingGovcRunner(runner, policy, logger).CliCommand("vm.change", nil, nil)
+		Expect(err).To(Equal(taskInProgress))
+		Expect(runner.CliCommandCallCount()).To(Equal(3))
+		Expect(logger.WarnCallCount()).To(Equal(2))
+
+		tag, msg, args := logger.WarnArgsForCall(1)
+		Expect(tag).To(Equal("govc-runner"))
+		Expect(msg).To(ContainSubstring("Retrying '%s'"))
+		Expect(args[0]).To(Equal("vm.change"))
+		Expect(args[2]).To(Equal(3))
+	})
+})
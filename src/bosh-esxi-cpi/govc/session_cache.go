@@ -0,0 +1,83 @@
+package govc
+
+import (
+	"context"
+	"sync"
+
+	bosherr "github.com/cloudfoundry/bosh-utils/errors"
+	"github.com/vmware/govmomi/govc/flags"
+	"github.com/vmware/govmomi/session"
+	"github.com/vmware/govmomi/vim25"
+)
+
+// sessionCache keeps a logged in client per ESXi URL, whose session the commands a runner runs share rather
+// than logging in, and leaving a session behind, for each command. A client is safe for concurrent use.
+type sessionCache struct {
+	mutex   sync.Mutex
+	clients map[string]*vim25.Client
+}
+
+func newSessionCache() *sessionCache {
+	return &sessionCache{clients: map[string]*vim25.Client{}}
+}
+
+// attach makes the flag's client join the cached session for its key, logging in first when there is none,
+// and returns the client holding the session. The key includes the credentials, so commands run with other
+// credentials get their own session.
+func (s *sessionCache) attach(clientFlag *flags.ClientFlag, key string) (*vim25.Client, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if cached, found := s.clients[key]; found {
+		// Login is govc's hook for authenticating the client a flag creates
+		clientFlag.Login = func(ctx context.Context, client *vim25.Client) error {
+			client.Jar.SetCookies(client.URL(), cached.Jar.Cookies(cached.URL()))
+			return nil
+		}
+		return cached, nil
+	}
+
+	client, err := clientFlag.Client()
+	if err != nil {
+		return nil, err
+	}
+
+	s.clients[key] = client
+	return client, nil
+}
+
+// evict forgets the client once its session expired, unless another command which saw it expire already
+// replaced it, and reports whether a command run with the client should run again with the cached session
+func (s *sessionCache) evict(key string, client *vim25.Client) bool {
+	if client == nil {
+		return false
+	}
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if s.clients[key] == client {
+		delete(s.clients, key)
+	}
+	return true
+}
+
+// logout ends every cached session, so they do not linger on the host until they expire
+func (s *sessionCache) logout() error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	var errs []error
+	for key, client := range s.clients {
+		err := session.NewManager(client).Logout(context.Background())
+		if err != nil {
+			errs = append(errs, bosherr.WrapErrorf(err, "Logging out of '%s'", client.URL().Host))
+		}
+		delete(s.clients, key)
+	}
+
+	if len(errs) > 0 {
+		return bosherr.NewMultiError(errs...)
+	}
+	return nil
+}
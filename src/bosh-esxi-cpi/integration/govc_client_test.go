@@ -11,6 +11,7 @@ import (
 	"time"
 
 	"bosh-esxi-cpi/govc"
+	"bosh-esxi-cpi/vm"
 	"fmt"
 	"os"
 )
@@ -41,8 +42,7 @@ var _ = Describe("Govc Client", func() {
 			var found bool
 			var err error
 
-			ovfPath := "../test/fixtures/test.ovf"
-			result, err = client.ImportOvf(ovfPath, stemcellId)
+			result, err = client.ImportStemcell(fixtureImagePath, stemcellId)
 			Expect(err).ToNot(HaveOccurred())
 			Expect(result).To(Equal(""))
 
@@ -50,7 +50,7 @@ var _ = Describe("Govc Client", func() {
 			Expect(err).ToNot(HaveOccurred())
 			Expect(found).To(Equal(false))
 
-			result, err = client.CloneVM(stemcellId, vmId)
+			result, err = client.CloneVM(stemcellId, vmId, "")
 			Expect(err).ToNot(HaveOccurred())
 			Expect(result).To(Equal(""))
 
@@ -58,13 +58,10 @@ var _ = Describe("Govc Client", func() {
 			Expect(err).ToNot(HaveOccurred())
 			Expect(found).To(Equal(true))
 
-			err = client.SetVMNetworkAdapter(vmId, esxNetworkName, "00:50:56:3F:00:00")
-			Expect(err).ToNot(HaveOccurred())
-
-			err = client.SetVMResources(vmId, 2, 1024)
-			Expect(err).ToNot(HaveOccurred())
-
-			err = client.CreateEphemeralDisk(vmId, 2048)
+			err = client.ConfigureVM(vmId, govc.VMSpec{
+				Props: vm.VMProps{CPU: 2, RAM: 1024, Disk: 2048, NestedHardwareVirtualization: true, SyncTimeWithHost: true},
+				NICs:  []govc.NIC{{Network: esxNetworkName, MACAddress: "00:50:56:3F:00:00", AdapterType: "vmxnet3"}},
+			})
 			Expect(err).ToNot(HaveOccurred())
 
 			err = client.CreateDisk("disk-1", 3096)
@@ -115,8 +112,7 @@ var _ = Describe("Govc Client", func() {
 			Expect(err).ToNot(HaveOccurred())
 			Expect(result).To(Equal(""))
 
-			ovfPath := "../test/fixtures/test.ovf"
-			result, err = client.ImportOvf(ovfPath, vmId)
+			result, err = client.ImportStemcell(fixtureImagePath, vmId)
 			Expect(err).ToNot(HaveOccurred())
 			Expect(result).To(Equal(""))
 
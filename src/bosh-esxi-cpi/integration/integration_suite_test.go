@@ -33,6 +33,7 @@ func gexecCommandWithStdin(commandBin string, commandArgs ...string) (*gexec.Ses
 }
 
 var extractedStemcellTempDir string
+var fixtureImagePath string
 
 func extractStemcell() string {
 	stemcellFile := "../../../ci/deploy-test/state/stemcell.tgz"
@@ -46,6 +47,18 @@ func extractStemcell() string {
 	return stemcellTempDir
 }
 
+// fixtureImage packs the test OVF the way a stemcell image is packed
+func fixtureImage() string {
+	imageTempDir, err := ioutil.TempDir("", "image-")
+	Expect(err).ToNot(HaveOccurred())
+
+	imagePath := filepath.Join(imageTempDir, "image")
+	err = archiver.TarGz.Make(imagePath, []string{"../test/fixtures/test.ovf", "../test/fixtures/test.vmdk"})
+	Expect(err).ToNot(HaveOccurred())
+
+	return imagePath
+}
+
 var configTemplate, _ = template.New("parse").Parse(`{
 	"cloud": {
 		"plugin": "vsphere",
@@ -111,9 +124,11 @@ func GenerateCPIConfig() string {
 
 var _ = BeforeSuite(func() {
 	extractedStemcellTempDir = extractStemcell()
+	fixtureImagePath = fixtureImage()
 })
 
 var _ = AfterSuite(func() {
 	os.RemoveAll(extractedStemcellTempDir)
+	os.RemoveAll(filepath.Dir(fixtureImagePath))
 	gexec.CleanupBuildArtifacts()
 })
@@ -0,0 +1,150 @@
+package logging
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"runtime/debug"
+	"sync"
+	"time"
+
+	bosherr "github.com/cloudfoundry/bosh-utils/errors"
+	boshlog "github.com/cloudfoundry/bosh-utils/logger"
+)
+
+const (
+	// FormatText writes lines like bosh-utils' logger
+	FormatText = "text"
+
+	// FormatJSON writes one JSON object per line, see jsonLine
+	FormatJSON = "json"
+)
+
+// NewLogger returns a logger writing lines of the format, FormatText when empty
+func NewLogger(level boshlog.LogLevel, format string, out io.Writer) (boshlog.Logger, error) {
+	switch format {
+	case "", FormatText:
+		return boshlog.NewWriterLogger(level, out), nil
+	case FormatJSON:
+		return &jsonLogger{level: level, out: out}, nil
+	}
+
+	return nil, bosherr.Errorf("Unknown log format '%s', expected '%s' or '%s'", format, FormatText, FormatJSON)
+}
+
+// fieldLogger logs lines with the fields of a request, see NewRequestLogger
+type fieldLogger interface {
+	boshlog.Logger
+	logWithFields(level boshlog.LogLevel, fields Fields, tag string, msg string, details interface{})
+}
+
+// jsonLine is a line written by the JSON logger
+type jsonLine struct {
+	Time    string `json:"time"`
+	Level   string `json:"level"`
+	Tag     string `json:"tag"`
+	Message string `json:"message"`
+	Details string `json:"details,omitempty"`
+	Fields
+}
+
+type jsonLogger struct {
+	level       boshlog.LogLevel
+	forcedDebug bool
+
+	mutex sync.Mutex
+	out   io.Writer
+}
+
+var _ fieldLogger = &jsonLogger{}
+
+func (l *jsonLogger) Debug(tag, msg string, args ...interface{}) {
+	l.logWithFields(boshlog.LevelDebug, Fields{}, tag, fmt.Sprintf(msg, args...), nil)
+}
+
+func (l *jsonLogger) DebugWithDetails(tag, msg string, args ...interface{}) {
+	msg, details := splitDetails(msg, args)
+	l.logWithFields(boshlog.LevelDebug, Fields{}, tag, msg, details)
+}
+
+func (l *jsonLogger) Info(tag, msg string, args ...interface{}) {
+	l.logWithFields(boshlog.LevelInfo, Fields{}, tag, fmt.Sprintf(msg, args...), nil)
+}
+
+func (l *jsonLogger) Warn(tag, msg string, args ...interface{}) {
+	l.logWithFields(boshlog.LevelWarn, Fields{}, tag, fmt.Sprintf(msg, args...), nil)
+}
+
+func (l *jsonLogger) Error(tag, msg string, args ...interface{}) {
+	l.logWithFields(boshlog.LevelError, Fields{}, tag, fmt.Sprintf(msg, args...), nil)
+}
+
+func (l *jsonLogger) ErrorWithDetails(tag, msg string, args ...interface{}) {
+	msg, details := splitDetails(msg, args)
+	l.logWithFields(boshlog.LevelError, Fields{}, tag, msg, details)
+}
+
+func (l *jsonLogger) HandlePanic(tag string) {
+	if e := recover(); e != nil {
+		l.logWithFields(boshlog.LevelError, Fields{}, tag, fmt.Sprintf("Panic: %v", e), debug.Stack())
+		os.Exit(2)
+	}
+}
+
+func (l *jsonLogger) ToggleForcedDebug() {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+
+	l.forcedDebug = !l.forcedDebug
+}
+
+func (l *jsonLogger) Flush() error                       { return nil }
+func (l *jsonLogger) FlushTimeout(_ time.Duration) error { return nil }
+
+func (l *jsonLogger) logWithFields(level boshlog.LogLevel, fields Fields, tag string, msg string, details interface{}) {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+
+	if level < l.level && !l.forcedDebug {
+		return
+	}
+
+	line := jsonLine{
+		Time:    time.Now().UTC().Format(time.RFC3339Nano),
+		Level:   levelName(level),
+		Tag:     tag,
+		Message: msg,
+		Fields:  fields,
+	}
+	if details != nil {
+		line.Details = fmt.Sprintf("%s", details)
+	}
+
+	bytes, err := json.Marshal(line)
+	if err != nil {
+		bytes = []byte(fmt.Sprintf(`{"level":"error","message":"Marshalling log line: %s"}`, err))
+	}
+
+	l.out.Write(append(bytes, '\n'))
+}
+
+// splitDetails formats a message logged WithDetails, whose last argument is the details
+func splitDetails(msg string, args []interface{}) (string, interface{}) {
+	if len(args) == 0 {
+		return msg, nil
+	}
+	return fmt.Sprintf(msg, args[:len(args)-1]...), args[len(args)-1]
+}
+
+func levelName(level boshlog.LogLevel) string {
+	switch level {
+	case boshlog.LevelDebug:
+		return "debug"
+	case boshlog.LevelInfo:
+		return "info"
+	case boshlog.LevelWarn:
+		return "warn"
+	}
+	return "error"
+}
@@ -0,0 +1,78 @@
+package logging_test
+
+import (
+	"bytes"
+	"encoding/json"
+	"strings"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	boshlog "github.com/cloudfoundry/bosh-utils/logger"
+
+	"bosh-esxi-cpi/logging"
+)
+
+// jsonLines parses the lines a JSON logger wrote
+func jsonLines(out *bytes.Buffer) []map[string]interface{} {
+	var lines []map[string]interface{}
+	for _, line := range strings.Split(strings.TrimSpace(out.String()), "\n") {
+		if line == "" {
+			continue
+		}
+		var parsed map[string]interface{}
+		Expect(json.Unmarshal([]byte(line), &parsed)).To(Succeed(), line)
+		lines = append(lines, parsed)
+	}
+	return lines
+}
+
+var _ = Describe("Logger", func() {
+	var out *bytes.Buffer
+
+	BeforeEach(func() {
+		out = &bytes.Buffer{}
+	})
+
+	It("writes text lines like bosh-utils", func() {
+		logger, err := logging.NewLogger(boshlog.LevelInfo, "", out)
+		Expect(err).ToNot(HaveOccurred())
+
+		logger.Debug("govc", "hidden")
+		logger.Info("govc", "Cloning '%s'", "cs-xenial")
+		Expect(out.String()).To(MatchRegexp(`^\[govc\] .* INFO - Cloning 'cs-xenial'\n$`))
+	})
+
+	It("writes one JSON object per line", func() {
+		logger, err := logging.NewLogger(boshlog.LevelInfo, logging.FormatJSON, out)
+		Expect(err).ToNot(HaveOccurred())
+
+		logger.Debug("govc", "hidden")
+		logger.Warn("pool", "No VM left in the pool of '%s'", "cs-xenial")
+		logger.ErrorWithDetails("govc", "CloneVM: %s", "failed", "details")
+
+		lines := jsonLines(out)
+		Expect(lines).To(HaveLen(2))
+		Expect(lines[0]).To(HaveKeyWithValue("level", "warn"))
+		Expect(lines[0]).To(HaveKeyWithValue("tag", "pool"))
+		Expect(lines[0]).To(HaveKeyWithValue("message", "No VM left in the pool of 'cs-xenial'"))
+		Expect(lines[0]).To(HaveKey("time"))
+		Expect(lines[0]).ToNot(HaveKey("details"))
+		Expect(lines[1]).To(HaveKeyWithValue("message", "CloneVM: failed"))
+		Expect(lines[1]).To(HaveKeyWithValue("details", "details"))
+	})
+
+	It("logs everything once debug is forced", func() {
+		logger, err := logging.NewLogger(boshlog.LevelError, logging.FormatJSON, out)
+		Expect(err).ToNot(HaveOccurred())
+
+		logger.ToggleForcedDebug()
+		logger.Debug("govc", "shown")
+		Expect(jsonLines(out)).To(HaveLen(1))
+	})
+
+	It("rejects unknown formats", func() {
+		_, err := logging.NewLogger(boshlog.LevelInfo, "xml", out)
+		Expect(err).To(MatchError("Unknown log format 'xml', expected 'text' or 'json'"))
+	})
+})
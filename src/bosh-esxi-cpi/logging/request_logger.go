@@ -0,0 +1,116 @@
+package logging
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	boshlog "github.com/cloudfoundry/bosh-utils/logger"
+)
+
+// Fields correlate the lines logged for a CPI request with the director's log
+type Fields struct {
+	RequestID string `json:"request_id,omitempty"`
+	Method    string `json:"method,omitempty"`
+	VMCID     string `json:"vm_cid,omitempty"`
+	DiskCID   string `json:"disk_cid,omitempty"`
+}
+
+// String formats the fields set as 'key=value' pairs, as text lines carry them
+func (f Fields) String() string {
+	var pairs []string
+	for _, field := range []struct{ key, value string }{
+		{"request_id", f.RequestID},
+		{"method", f.Method},
+		{"vm_cid", f.VMCID},
+		{"disk_cid", f.DiskCID},
+	} {
+		if field.value != "" {
+			pairs = append(pairs, field.key+"="+field.value)
+		}
+	}
+	return strings.Join(pairs, " ")
+}
+
+// requestLogger stamps every line with the fields of a request: as JSON fields when the logger
+// writes JSON, or as a prefix of the message otherwise
+type requestLogger struct {
+	logger boshlog.Logger
+	fields Fields
+	prefix string
+}
+
+// NewRequestLogger returns a logger stamping every line logged through it with the fields
+func NewRequestLogger(logger boshlog.Logger, fields Fields) boshlog.Logger {
+	if fields.String() == "" {
+		return logger
+	}
+	return requestLogger{logger: logger, fields: fields, prefix: "[" + fields.String() + "] "}
+}
+
+func (l requestLogger) Debug(tag, msg string, args ...interface{}) {
+	if json, ok := l.logger.(fieldLogger); ok {
+		json.logWithFields(boshlog.LevelDebug, l.fields, tag, fmt.Sprintf(msg, args...), nil)
+		return
+	}
+	l.logger.Debug(tag, l.prefixed(msg), l.args(args)...)
+}
+
+func (l requestLogger) DebugWithDetails(tag, msg string, args ...interface{}) {
+	if json, ok := l.logger.(fieldLogger); ok {
+		msg, details := splitDetails(msg, args)
+		json.logWithFields(boshlog.LevelDebug, l.fields, tag, msg, details)
+		return
+	}
+	l.logger.DebugWithDetails(tag, l.prefixed(msg), l.args(args)...)
+}
+
+func (l requestLogger) Info(tag, msg string, args ...interface{}) {
+	if json, ok := l.logger.(fieldLogger); ok {
+		json.logWithFields(boshlog.LevelInfo, l.fields, tag, fmt.Sprintf(msg, args...), nil)
+		return
+	}
+	l.logger.Info(tag, l.prefixed(msg), l.args(args)...)
+}
+
+func (l requestLogger) Warn(tag, msg string, args ...interface{}) {
+	if json, ok := l.logger.(fieldLogger); ok {
+		json.logWithFields(boshlog.LevelWarn, l.fields, tag, fmt.Sprintf(msg, args...), nil)
+		return
+	}
+	l.logger.Warn(tag, l.prefixed(msg), l.args(args)...)
+}
+
+func (l requestLogger) Error(tag, msg string, args ...interface{}) {
+	if json, ok := l.logger.(fieldLogger); ok {
+		json.logWithFields(boshlog.LevelError, l.fields, tag, fmt.Sprintf(msg, args...), nil)
+		return
+	}
+	l.logger.Error(tag, l.prefixed(msg), l.args(args)...)
+}
+
+func (l requestLogger) ErrorWithDetails(tag, msg string, args ...interface{}) {
+	if json, ok := l.logger.(fieldLogger); ok {
+		msg, details := splitDetails(msg, args)
+		json.logWithFields(boshlog.LevelError, l.fields, tag, msg, details)
+		return
+	}
+	l.logger.ErrorWithDetails(tag, l.prefixed(msg), l.args(args)...)
+}
+
+// HandlePanic only recovers when deferred itself, defer it on the logger the decorator wraps
+func (l requestLogger) HandlePanic(tag string) { l.logger.HandlePanic(tag) }
+func (l requestLogger) ToggleForcedDebug()     { l.logger.ToggleForcedDebug() }
+func (l requestLogger) Flush() error           { return l.logger.Flush() }
+func (l requestLogger) FlushTimeout(timeout time.Duration) error {
+	return l.logger.FlushTimeout(timeout)
+}
+
+// prefixed passes the prefix as an argument, fields may contain '%'
+func (l requestLogger) prefixed(msg string) string {
+	return "%s" + msg
+}
+
+func (l requestLogger) args(args []interface{}) []interface{} {
+	return append([]interface{}{l.prefix}, args...)
+}
@@ -0,0 +1,75 @@
+package logging_test
+
+import (
+	"bytes"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	boshlog "github.com/cloudfoundry/bosh-utils/logger"
+
+	"bosh-esxi-cpi/logging"
+)
+
+var _ = Describe("RequestLogger", func() {
+	var (
+		out    *bytes.Buffer
+		fields logging.Fields
+	)
+
+	BeforeEach(func() {
+		out = &bytes.Buffer{}
+		fields = logging.Fields{RequestID: "cpi-123", Method: "attach_disk", VMCID: "vm-uuid", DiskCID: "disk-uuid"}
+	})
+
+	It("prefixes text lines with the fields", func() {
+		logger, err := logging.NewLogger(boshlog.LevelDebug, logging.FormatText, out)
+		Expect(err).ToNot(HaveOccurred())
+
+		logging.NewRequestLogger(logger, fields).Info("attach-disk", "Attached at %d%%", 100)
+		Expect(out.String()).To(ContainSubstring("INFO - [request_id=cpi-123 method=attach_disk vm_cid=vm-uuid disk_cid=disk-uuid] Attached at 100%"))
+	})
+
+	It("keeps the details of text lines last", func() {
+		logger, err := logging.NewLogger(boshlog.LevelDebug, logging.FormatText, out)
+		Expect(err).ToNot(HaveOccurred())
+
+		logging.NewRequestLogger(logger, fields).DebugWithDetails("rpc", "Request bytes", "{}")
+		Expect(out.String()).To(ContainSubstring("[request_id=cpi-123 method=attach_disk vm_cid=vm-uuid disk_cid=disk-uuid] Request bytes\n********************\n{}\n"))
+	})
+
+	It("adds the fields to JSON lines", func() {
+		logger, err := logging.NewLogger(boshlog.LevelDebug, logging.FormatJSON, out)
+		Expect(err).ToNot(HaveOccurred())
+
+		logging.NewRequestLogger(logger, fields).ErrorWithDetails("govc", "AttachDisk: %s", "failed", "output")
+
+		lines := jsonLines(out)
+		Expect(lines).To(HaveLen(1))
+		Expect(lines[0]).To(HaveKeyWithValue("request_id", "cpi-123"))
+		Expect(lines[0]).To(HaveKeyWithValue("method", "attach_disk"))
+		Expect(lines[0]).To(HaveKeyWithValue("vm_cid", "vm-uuid"))
+		Expect(lines[0]).To(HaveKeyWithValue("disk_cid", "disk-uuid"))
+		Expect(lines[0]).To(HaveKeyWithValue("message", "AttachDisk: failed"))
+		Expect(lines[0]).To(HaveKeyWithValue("details", "output"))
+	})
+
+	It("leaves out fields which are not set", func() {
+		logger, err := logging.NewLogger(boshlog.LevelDebug, logging.FormatJSON, out)
+		Expect(err).ToNot(HaveOccurred())
+
+		logging.NewRequestLogger(logger, logging.Fields{Method: "info"}).Info("cpi", "info")
+
+		lines := jsonLines(out)
+		Expect(lines[0]).To(HaveKeyWithValue("method", "info"))
+		Expect(lines[0]).ToNot(HaveKey("request_id"))
+		Expect(lines[0]).ToNot(HaveKey("vm_cid"))
+	})
+
+	It("returns the logger itself without fields", func() {
+		logger, err := logging.NewLogger(boshlog.LevelDebug, logging.FormatJSON, out)
+		Expect(err).ToNot(HaveOccurred())
+
+		Expect(logging.NewRequestLogger(logger, logging.Fields{})).To(BeIdenticalTo(logger))
+	})
+})
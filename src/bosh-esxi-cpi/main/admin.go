@@ -0,0 +1,113 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+
+	boshlog "github.com/cloudfoundry/bosh-utils/logger"
+	boshsys "github.com/cloudfoundry/bosh-utils/system"
+	boshuuid "github.com/cloudfoundry/bosh-utils/uuid"
+
+	"bosh-esxi-cpi/admin"
+	"bosh-esxi-cpi/config"
+	"bosh-esxi-cpi/govc"
+	"bosh-esxi-cpi/pool"
+)
+
+const adminUsage = `Usage:
+  esxi-cpi admin orphans -configPath PATH [-cids PATH] [-stemcells] [-apply]
+  esxi-cpi admin pool -configPath PATH`
+
+// runAdmin runs operator commands, which are not part of the CPI protocol
+func runAdmin(args []string) int {
+	if len(args) == 0 {
+		fmt.Fprintln(os.Stderr, adminUsage)
+		return 2
+	}
+
+	switch args[0] {
+	case "orphans":
+		return runOrphans(args[1:])
+	case "pool":
+		return runPool(args[1:])
+	}
+
+	fmt.Fprintln(os.Stderr, adminUsage)
+	return 2
+}
+
+func runOrphans(args []string) int {
+	flags := flag.NewFlagSet("admin orphans", flag.ContinueOnError)
+	configPath := flags.String("configPath", "", "Path to configuration file")
+	cidsPath := flags.String("cids", "", "Path to a file of VM and disk CIDs known to the director, e.g. cut from 'bosh vms' and 'bosh disks'")
+	includeStemcells := flags.Bool("stemcells", false, "Treat stemcells missing from -cids as orphans")
+	apply := flags.Bool("apply", false, "Delete the orphans instead of only reporting them")
+
+	if err := flags.Parse(args); err != nil {
+		return 2
+	}
+
+	logger := boshlog.NewWriterLogger(boshlog.LevelWarn, os.Stderr)
+	fs := boshsys.NewOsFileSystem(logger)
+
+	cpiConfig, govcClient, err := adminDeps(*configPath, fs, logger)
+	if err != nil {
+		logger.Error("admin", "Loading cfg %s", err.Error())
+		return 1
+	}
+
+	vmPool := pool.NewPool(govcClient, cpiConfig.VMPool(), boshuuid.NewGenerator(), logger)
+	command := admin.NewOrphansCommand(admin.NewOrphanSweeper(govcClient, vmPool, logger), fs, os.Stdout)
+	err = command.Run(admin.OrphansOpts{
+		CIDsPath:         *cidsPath,
+		Apply:            *apply,
+		IncludeStemcells: *includeStemcells,
+	})
+	if err != nil {
+		logger.Error("admin", "Finding orphans: %s", err)
+		return 1
+	}
+
+	return 0
+}
+
+// runPool replenishes the warm VM pools, see the pool package
+func runPool(args []string) int {
+	flags := flag.NewFlagSet("admin pool", flag.ContinueOnError)
+	configPath := flags.String("configPath", "", "Path to configuration file")
+
+	if err := flags.Parse(args); err != nil {
+		return 2
+	}
+
+	logger := boshlog.NewWriterLogger(boshlog.LevelWarn, os.Stderr)
+	fs := boshsys.NewOsFileSystem(logger)
+
+	cpiConfig, govcClient, err := adminDeps(*configPath, fs, logger)
+	if err != nil {
+		logger.Error("admin", "Loading cfg %s", err.Error())
+		return 1
+	}
+
+	vmPool := pool.NewPool(govcClient, cpiConfig.VMPool(), boshuuid.NewGenerator(), logger)
+	err = admin.NewPoolCommand(vmPool, os.Stdout).Run()
+	if err != nil {
+		logger.Error("admin", "Replenishing VM pools: %s", err)
+		return 1
+	}
+
+	return 0
+}
+
+func adminDeps(configPath string, fs boshsys.FileSystem, logger boshlog.Logger) (config.Config, govc.GovcClient, error) {
+	cpiConfig, err := config.NewConfigFromPath(configPath, fs)
+	if err != nil {
+		return config.Config{}, nil, err
+	}
+
+	govcRunner := govc.NewRetryingGovcRunner(govc.NewGovcRunner(logger), govc.DefaultRetryPolicy, logger)
+	govcClient := govc.NewClient(govcRunner, govc.NewGovcConfig(cpiConfig), logger)
+
+	return cpiConfig, govcClient, nil
+}
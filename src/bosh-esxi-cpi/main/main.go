@@ -1,12 +1,15 @@
 package main
 
 import (
+	"bytes"
 	"flag"
+	"io/ioutil"
 	"math/rand"
 	"os"
+	"os/signal"
+	"syscall"
 	"time"
 
-	boshcmd "github.com/cloudfoundry/bosh-utils/fileutil"
 	boshlog "github.com/cloudfoundry/bosh-utils/logger"
 	boshsys "github.com/cloudfoundry/bosh-utils/system"
 	boshuuid "github.com/cloudfoundry/bosh-utils/uuid"
@@ -15,37 +18,60 @@ import (
 
 	"bosh-esxi-cpi/action"
 	"bosh-esxi-cpi/config"
+	"bosh-esxi-cpi/daemon"
 	"bosh-esxi-cpi/govc"
-	"bosh-esxi-cpi/stemcell"
-	"bosh-esxi-cpi/vm"
+	"bosh-esxi-cpi/logging"
+	"bosh-esxi-cpi/nsxt"
 )
 
 var (
 	configPathOpt = flag.String("configPath", "", "Path to configuration file")
+	socketPathOpt = flag.String("socket", "", "Path to the socket of a daemon to forward the request to, the request is served in-process when no daemon listens")
+	daemonOpt     = flag.Bool("daemon", false, "Serve requests on -socket until terminated")
 )
 
 func main() {
 	rand.Seed(time.Now().UTC().UnixNano()) // todo MAC generation
 
-	logger, fs, compressor, uuidGen := basicDeps()
+	if len(os.Args) > 1 && os.Args[1] == "admin" {
+		os.Exit(runAdmin(os.Args[2:]))
+	}
+
+	logger, fs, uuidGen := basicDeps()
 
 	defer logger.HandlePanic("Main")
 
 	flag.Parse()
-	cpiConfig, err := config.NewConfigFromPath(*configPathOpt, fs)
+
+	if *daemonOpt {
+		os.Exit(runDaemon(logger, fs, uuidGen))
+	}
+
+	request, err := ioutil.ReadAll(os.Stdin)
 	if err != nil {
-		logger.Error("main", "Loading cfg %s", err.Error())
+		logger.Error("main", "Reading request: %s", err)
 		os.Exit(1)
 	}
 
-	govcRunner := govc.NewGovcRunner(logger)
-	govcClient := govc.NewClient(govcRunner, govc.NewGovcConfig(cpiConfig), logger)
-	stemcellClient := stemcell.NewClient(compressor, fs, logger)
-	agentSettings := vm.NewAgentSettings(fs, logger)
-	agentEnvFactory := apiv1.NewAgentEnvFactory()
-	cpiFactory := action.NewFactory(govcClient, stemcellClient, agentSettings, agentEnvFactory, cpiConfig, fs, uuidGen, logger)
+	if *socketPathOpt != "" {
+		forwarded, err := daemon.Forward(*socketPathOpt, request, os.Stdout)
+		if err != nil {
+			logger.Error("main", "Forwarding to daemon: %s", err)
+			os.Exit(1)
+		}
+		if forwarded {
+			return
+		}
+		logger.Debug("main", "No daemon listening on '%s', serving in-process", *socketPathOpt)
+	}
+
+	cpiFactory, _, logger, err := cpiDeps(logger, fs, uuidGen)
+	if err != nil {
+		logger.Error("main", "Loading cfg %s", err.Error())
+		os.Exit(1)
+	}
 
-	cli := rpc.NewFactory(logger).NewCLI(cpiFactory)
+	cli := rpc.NewCLI(bytes.NewReader(request), os.Stdout, action.NewDispatcher(cpiFactory, logger), logger)
 
 	err = cli.ServeOnce()
 	if err != nil {
@@ -54,12 +80,82 @@ func main() {
 	}
 }
 
-func basicDeps() (boshlog.Logger, boshsys.FileSystem, boshcmd.Compressor, boshuuid.Generator) {
+// runDaemon serves requests on the socket with one set of dependencies, and so one ESXi session,
+// until it receives SIGTERM or SIGINT. Requests in flight are finished before it logs out and exits.
+func runDaemon(logger boshlog.Logger, fs boshsys.FileSystem, uuidGen boshuuid.Generator) int {
+	if *socketPathOpt == "" {
+		logger.Error("main", "-daemon requires -socket")
+		return 2
+	}
+
+	cpiFactory, govcRunner, logger, err := cpiDeps(logger, fs, uuidGen)
+	if err != nil {
+		logger.Error("main", "Loading cfg %s", err.Error())
+		return 1
+	}
+
+	server, err := daemon.NewServer(*socketPathOpt, action.NewDispatcher(cpiFactory, logger), logger)
+	if err != nil {
+		logger.Error("main", "Starting daemon: %s", err)
+		return 1
+	}
+
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGTERM, syscall.SIGINT)
+	closed := make(chan struct{})
+	go func() {
+		sig := <-signals
+		logger.Info("main", "Received %s, finishing requests in flight", sig)
+		server.Close()
+		close(closed)
+	}()
+
+	logger.Info("main", "Serving requests on '%s'", *socketPathOpt)
+
+	err = server.Serve()
+	if err != nil {
+		logger.Error("main", "Serving: %s", err)
+		return 1
+	}
+
+	// Serve returns once the socket is closed, Close once the requests in flight, which share the session, finished
+	<-closed
+	govc.Logout(govcRunner)
+
+	return 0
+}
+
+// cpiDeps loads the config, and returns the factory, the runner it runs govc commands with, and the logger configured in it
+func cpiDeps(logger boshlog.Logger, fs boshsys.FileSystem, uuidGen boshuuid.Generator) (action.Factory, govc.GovcRunner, boshlog.Logger, error) {
+	cpiConfig, err := config.NewConfigFromPath(*configPathOpt, fs)
+	if err != nil {
+		return action.Factory{}, nil, logger, err
+	}
+
+	configuredLogger, err := logging.NewLogger(cpiConfig.LogLevel(), cpiConfig.LogFormat(), os.Stderr)
+	if err != nil {
+		return action.Factory{}, nil, logger, err
+	}
+	logger = configuredLogger
+	fs = boshsys.NewOsFileSystem(logger)
+
+	govcRunner := govc.NewRetryingGovcRunner(govc.NewGovcRunner(logger), govc.DefaultRetryPolicy, logger)
+	var nsxtClient nsxt.NsxtClient
+	if nsxtConfig := cpiConfig.NSXT(); nsxtConfig != nil {
+		nsxtClient, err = nsxt.NewClient(*nsxtConfig, logger)
+		if err != nil {
+			return action.Factory{}, nil, logger, err
+		}
+	}
+	agentEnvFactory := apiv1.NewAgentEnvFactory()
+
+	return action.NewFactory(govcRunner, nsxtClient, agentEnvFactory, cpiConfig, fs, uuidGen, logger), govcRunner, logger, nil
+}
+
+func basicDeps() (boshlog.Logger, boshsys.FileSystem, boshuuid.Generator) {
 	logger := boshlog.NewWriterLogger(boshlog.LevelDebug, os.Stderr)
 	fs := boshsys.NewOsFileSystem(logger)
-	cmdRunner := boshsys.NewExecCmdRunner(logger)
-	compressor := boshcmd.NewTarballCompressor(cmdRunner, fs)
 	uuidGen := boshuuid.NewGenerator()
 
-	return logger, fs, compressor, uuidGen
+	return logger, fs, uuidGen
 }
@@ -0,0 +1,11 @@
+package nsxt
+
+//go:generate counterfeiter -o fakes/fake_nsxt_client.go $GOPATH/src/bosh-esxi-cpi/nsxt/nsxt.go NsxtClient
+type NsxtClient interface {
+	UpdateLogicalPort(string, []Tag) error
+}
+
+type Tag struct {
+	Scope string `json:"scope"`
+	Tag   string `json:"tag"`
+}
@@ -0,0 +1,161 @@
+package nsxt
+
+import (
+	"bytes"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"time"
+
+	bosherr "github.com/cloudfoundry/bosh-utils/errors"
+	boshlog "github.com/cloudfoundry/bosh-utils/logger"
+
+	"bosh-esxi-cpi/config"
+)
+
+type NsxtClientImpl struct {
+	config     config.Nsxt
+	httpClient *http.Client
+	logger     boshlog.Logger
+}
+
+func NewClient(nsxtConfig config.Nsxt, logger boshlog.Logger) (NsxtClient, error) {
+	tlsConfig := &tls.Config{}
+
+	if nsxtConfig.Ca_Cert != "" {
+		certPool := x509.NewCertPool()
+		if !certPool.AppendCertsFromPEM([]byte(nsxtConfig.Ca_Cert)) {
+			return nil, bosherr.Error("Parsing NSX-T ca_cert")
+		}
+		tlsConfig.RootCAs = certPool
+	}
+
+	httpClient := &http.Client{
+		Timeout:   60 * time.Second,
+		Transport: &http.Transport{TLSClientConfig: tlsConfig},
+	}
+
+	return NsxtClientImpl{config: nsxtConfig, httpClient: httpClient, logger: logger}, nil
+}
+
+// UpdateLogicalPort merges tags into the logical port attached to a NIC and
+// applies the configured default_vif_type to its attachment
+func (c NsxtClientImpl) UpdateLogicalPort(attachmentID string, tags []Tag) error {
+	port, err := c.findLogicalPort(attachmentID)
+	if err != nil {
+		return bosherr.WrapErrorf(err, "Finding logical port for attachment '%s'", attachmentID)
+	}
+
+	port["tags"] = mergeTags(port["tags"], tags)
+
+	if c.config.Default_Vif_Type != "" {
+		attachment, ok := port["attachment"].(map[string]interface{})
+		if !ok {
+			return bosherr.Errorf("Logical port '%v' has no attachment", port["id"])
+		}
+
+		attachment["context"] = map[string]interface{}{
+			"resource_type": "VifAttachmentContext",
+			"vif_type":      c.config.Default_Vif_Type,
+		}
+	}
+
+	err = c.request("PUT", fmt.Sprintf("/api/v1/logical-ports/%v", port["id"]), port, nil)
+	if err != nil {
+		return bosherr.WrapErrorf(err, "Updating logical port '%v'", port["id"])
+	}
+
+	return nil
+}
+
+func (c NsxtClientImpl) findLogicalPort(attachmentID string) (map[string]interface{}, error) {
+	var response struct {
+		Results []map[string]interface{} `json:"results"`
+	}
+
+	err := c.request("GET", "/api/v1/logical-ports?attachment_id="+url.QueryEscape(attachmentID), nil, &response)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(response.Results) != 1 {
+		return nil, bosherr.Errorf("Expected 1 logical port, found %d", len(response.Results))
+	}
+
+	return response.Results[0], nil
+}
+
+func (c NsxtClientImpl) request(method string, path string, body interface{}, result interface{}) error {
+	var reqBody bytes.Buffer
+	if body != nil {
+		err := json.NewEncoder(&reqBody).Encode(body)
+		if err != nil {
+			return bosherr.WrapError(err, "Marshalling request")
+		}
+	}
+
+	req, err := http.NewRequest(method, "https://"+c.config.Host+path, &reqBody)
+	if err != nil {
+		return bosherr.WrapError(err, "Building request")
+	}
+
+	req.SetBasicAuth(c.config.Username, c.config.Password)
+	req.Header.Set("Accept", "application/json")
+	req.Header.Set("Content-Type", "application/json")
+
+	c.logger.Debug("nsxt", "%s %s", method, path)
+
+	resp, err := c.httpClient.Do(req)
+	if err != nil {
+		return bosherr.WrapErrorf(err, "%s %s", method, path)
+	}
+	defer resp.Body.Close()
+
+	respBody, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return bosherr.WrapError(err, "Reading response")
+	}
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return bosherr.Errorf("%s %s returned %d: %s", method, path, resp.StatusCode, respBody)
+	}
+
+	if result == nil {
+		return nil
+	}
+
+	err = json.Unmarshal(respBody, result)
+	if err != nil {
+		return bosherr.WrapError(err, "Unmarshalling response")
+	}
+
+	return nil
+}
+
+// mergeTags replaces existing tags of the same scope, keeping all others
+func mergeTags(existing interface{}, tags []Tag) []interface{} {
+	scopes := map[string]bool{}
+	for _, tag := range tags {
+		scopes[tag.Scope] = true
+	}
+
+	merged := []interface{}{}
+	if existingTags, ok := existing.([]interface{}); ok {
+		for _, existingTag := range existingTags {
+			if tag, ok := existingTag.(map[string]interface{}); ok && scopes[fmt.Sprintf("%v", tag["scope"])] {
+				continue
+			}
+			merged = append(merged, existingTag)
+		}
+	}
+
+	for _, tag := range tags {
+		merged = append(merged, tag)
+	}
+
+	return merged
+}
@@ -0,0 +1,121 @@
+package nsxt_test
+
+import (
+	"encoding/json"
+	"encoding/pem"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	fakelogger "github.com/cloudfoundry/bosh-utils/logger/loggerfakes"
+
+	"bosh-esxi-cpi/config"
+	"bosh-esxi-cpi/nsxt"
+)
+
+var _ = Describe("NsxtClient", func() {
+	var (
+		server     *httptest.Server
+		requests   []*http.Request
+		putBody    map[string]interface{}
+		nsxtConfig config.Nsxt
+		ports      string
+	)
+
+	BeforeEach(func() {
+		requests = nil
+		putBody = nil
+		ports = `{"result_count":1,"results":[{
+			"id":"port-1",
+			"_revision":3,
+			"attachment":{"attachment_type":"VIF","id":"attachment-1"},
+			"tags":[{"scope":"bosh/vm_cid","tag":"vm-old"},{"scope":"owner","tag":"ops"}]
+		}]}`
+
+		server = httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			requests = append(requests, r)
+
+			switch r.Method {
+			case "GET":
+				w.Write([]byte(ports))
+			case "PUT":
+				body, _ := ioutil.ReadAll(r.Body)
+				json.Unmarshal(body, &putBody)
+				w.Write(body)
+			}
+		}))
+
+		caCert := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: server.Certificate().Raw})
+		nsxtConfig = config.Nsxt{
+			Host:     strings.TrimPrefix(server.URL, "https://"),
+			Username: "admin",
+			Password: "secret",
+			Ca_Cert:  string(caCert),
+		}
+	})
+
+	AfterEach(func() {
+		server.Close()
+	})
+
+	newClient := func() nsxt.NsxtClient {
+		client, err := nsxt.NewClient(nsxtConfig, &fakelogger.FakeLogger{})
+		Expect(err).ToNot(HaveOccurred())
+		return client
+	}
+
+	It("merges tags into the logical port of the attachment", func() {
+		err := newClient().UpdateLogicalPort("attachment-1", []nsxt.Tag{{Scope: "bosh/vm_cid", Tag: "vm-new"}})
+		Expect(err).ToNot(HaveOccurred())
+
+		Expect(requests).To(HaveLen(2))
+		Expect(requests[0].Method).To(Equal("GET"))
+		Expect(requests[0].URL.Path).To(Equal("/api/v1/logical-ports"))
+		Expect(requests[0].URL.Query().Get("attachment_id")).To(Equal("attachment-1"))
+
+		username, password, ok := requests[0].BasicAuth()
+		Expect(ok).To(BeTrue())
+		Expect(username).To(Equal("admin"))
+		Expect(password).To(Equal("secret"))
+
+		Expect(requests[1].Method).To(Equal("PUT"))
+		Expect(requests[1].URL.Path).To(Equal("/api/v1/logical-ports/port-1"))
+		Expect(putBody["_revision"]).To(BeEquivalentTo(3))
+		Expect(putBody["tags"]).To(ConsistOf(
+			map[string]interface{}{"scope": "owner", "tag": "ops"},
+			map[string]interface{}{"scope": "bosh/vm_cid", "tag": "vm-new"},
+		))
+		Expect(putBody["attachment"]).ToNot(HaveKey("context"))
+	})
+
+	It("applies the default vif type to the attachment", func() {
+		nsxtConfig.Default_Vif_Type = "PARENT"
+
+		err := newClient().UpdateLogicalPort("attachment-1", []nsxt.Tag{{Scope: "bosh/vm_cid", Tag: "vm-new"}})
+		Expect(err).ToNot(HaveOccurred())
+
+		Expect(putBody["attachment"]).To(HaveKeyWithValue("context", map[string]interface{}{
+			"resource_type": "VifAttachmentContext",
+			"vif_type":      "PARENT",
+		}))
+	})
+
+	It("fails when no logical port is attached", func() {
+		ports = `{"result_count":0,"results":[]}`
+
+		err := newClient().UpdateLogicalPort("attachment-1", nil)
+		Expect(err).To(MatchError(ContainSubstring("Expected 1 logical port, found 0")))
+		Expect(requests).To(HaveLen(1))
+	})
+
+	It("rejects an invalid ca_cert", func() {
+		nsxtConfig.Ca_Cert = "not-a-cert"
+
+		_, err := nsxt.NewClient(nsxtConfig, &fakelogger.FakeLogger{})
+		Expect(err).To(MatchError("Parsing NSX-T ca_cert"))
+	})
+})
@@ -0,0 +1,18 @@
+package nsxt_test
+
+import (
+	"testing"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"github.com/onsi/gomega/gexec"
+)
+
+func TestCpi(t *testing.T) {
+	RegisterFailHandler(Fail)
+	RunSpecs(t, "NSX-T Suite")
+}
+
+var _ = AfterSuite(func() {
+	gexec.CleanupBuildArtifacts()
+})
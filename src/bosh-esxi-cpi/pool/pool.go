@@ -0,0 +1,270 @@
+package pool
+
+import (
+	"errors"
+	"sort"
+	"strings"
+
+	bosherr "github.com/cloudfoundry/bosh-utils/errors"
+	boshlog "github.com/cloudfoundry/bosh-utils/logger"
+	boshuuid "github.com/cloudfoundry/bosh-utils/uuid"
+
+	"bosh-esxi-cpi/config"
+	"bosh-esxi-cpi/filelock"
+	"bosh-esxi-cpi/govc"
+)
+
+const (
+	// StemcellNameKey records the name of the stemcell a template was imported from, which pools are sized by
+	StemcellNameKey = "bosh.stemcell_name"
+
+	templatePrefix = "cs-"
+	memberPrefix   = "pool-"
+
+	// memberSeparator ends the template in a member's name, uuids already contain dashes
+	memberSeparator = "."
+)
+
+// ErrReplenishing is returned by Replenish while another process replenishes the pools
+var ErrReplenishing = errors.New("VM pools are already being replenished")
+
+// Status is a template's pool after replenishing it
+type Status struct {
+	Template     string
+	StemcellName string
+	Size         int
+	Members      int
+	Created      int
+	Destroyed    int
+}
+
+// Pool keeps powered off clones of each stemcell template on the default datastore, named
+// 'pool-<template>.<uuid>'. create_vm claims a member by renaming it, rather than copying the
+// template's disks, and Replenish clones new members from cron or monit.
+type Pool struct {
+	govcClient govc.GovcClient
+	config     config.VmPool
+	uuidGen    boshuuid.Generator
+	logger     boshlog.Logger
+}
+
+func NewPool(govcClient govc.GovcClient, config config.VmPool, uuidGen boshuuid.Generator, logger boshlog.Logger) Pool {
+	return Pool{govcClient: govcClient, config: config, uuidGen: uuidGen, logger: logger}
+}
+
+// Claim renames a member of the template's pool to vmName. It returns false when no member is left.
+// Concurrent create_vm calls may try the same member, the loser moves on to the next one.
+func (p Pool) Claim(templateId string, vmName string) (bool, error) {
+	if !p.config.Enabled() {
+		return false, nil
+	}
+
+	vmNames, err := p.govcClient.ListVMs()
+	if err != nil {
+		return false, bosherr.WrapError(err, "Listing VMs")
+	}
+
+	for _, member := range membersOf(vmNames, templateId) {
+		err = p.govcClient.RenameVM(member, vmName)
+		if err == nil {
+			p.logger.Info("pool", "Claimed '%s' as '%s'", member, vmName)
+			return true, nil
+		}
+
+		if govc.IsVMNotFound(err) {
+			continue
+		}
+
+		// the rename may have stopped with the member unregistered, or its folder moved
+		p.destroy(member)
+		p.destroy(vmName)
+
+		return false, bosherr.WrapErrorf(err, "Claiming '%s'", member)
+	}
+
+	p.logger.Info("pool", "No VM left in the pool of '%s'", templateId)
+	return false, nil
+}
+
+// Replenish clones or destroys members until every template's pool has the configured size,
+// and drains the pools of deleted templates. Only one process replenishes at a time.
+func (p Pool) Replenish() ([]Status, error) {
+	lock, err := tryLock(p.govcClient.Host())
+	if err != nil {
+		return nil, err
+	}
+	defer lock.Unlock()
+
+	vmNames, err := p.govcClient.ListVMs()
+	if err != nil {
+		return nil, bosherr.WrapError(err, "Listing VMs")
+	}
+	sort.Strings(vmNames)
+
+	var statuses []Status
+	templates := map[string]bool{}
+
+	for _, vmName := range vmNames {
+		if !strings.HasPrefix(vmName, templatePrefix) {
+			continue
+		}
+
+		extraConfig, err := p.govcClient.GetVMExtraConfig(vmName)
+		if govc.IsVMNotFound(err) {
+			continue
+		}
+		if err != nil {
+			return statuses, bosherr.WrapErrorf(err, "Reading stemcell name of '%s'", vmName)
+		}
+		templates[vmName] = true
+
+		status, err := p.replenish(vmName, extraConfig[StemcellNameKey], membersOf(vmNames, vmName))
+		statuses = append(statuses, status)
+		if err != nil {
+			return statuses, err
+		}
+	}
+
+	for _, templateId := range orphanedTemplates(vmNames, templates) {
+		destroyed, err := p.Drain(templateId)
+		statuses = append(statuses, Status{Template: templateId, Destroyed: destroyed})
+		if err != nil {
+			return statuses, err
+		}
+	}
+
+	return statuses, nil
+}
+
+// Drain destroys every member of the template's pool, including the folders of unregistered ones
+func (p Pool) Drain(templateId string) (int, error) {
+	vmNames, err := p.govcClient.ListVMs()
+	if err != nil {
+		return 0, bosherr.WrapError(err, "Listing VMs")
+	}
+
+	files, err := p.govcClient.ListDatastoreFiles()
+	if err != nil {
+		return 0, bosherr.WrapError(err, "Listing datastore files")
+	}
+
+	for _, file := range files {
+		vmNames = append(vmNames, strings.SplitN(file.Path, "/", 2)[0])
+	}
+
+	var destroyed int
+	found := map[string]bool{}
+	for _, member := range membersOf(vmNames, templateId) {
+		if found[member] {
+			continue
+		}
+		found[member] = true
+
+		_, err = p.govcClient.DestroyVM(member)
+		if err != nil {
+			return destroyed, bosherr.WrapErrorf(err, "Destroying '%s'", member)
+		}
+		destroyed++
+	}
+
+	if destroyed > 0 {
+		p.logger.Info("pool", "Drained %d VMs from the pool of '%s'", destroyed, templateId)
+	}
+
+	return destroyed, nil
+}
+
+func (p Pool) replenish(templateId string, stemcellName string, members []string) (Status, error) {
+	status := Status{
+		Template:     templateId,
+		StemcellName: stemcellName,
+		Size:         p.config.SizeFor(stemcellName),
+		Members:      len(members),
+	}
+
+	for status.Members > status.Size {
+		member := members[status.Members-1]
+
+		_, err := p.govcClient.DestroyVM(member)
+		if err != nil {
+			return status, bosherr.WrapErrorf(err, "Destroying '%s'", member)
+		}
+		status.Members--
+		status.Destroyed++
+	}
+
+	for status.Members < status.Size {
+		memberUuid, err := p.uuidGen.Generate()
+		if err != nil {
+			return status, bosherr.WrapError(err, "Generating member name")
+		}
+		member := memberPrefix + templateId + memberSeparator + memberUuid
+
+		_, err = p.govcClient.CloneVM(templateId, member, "")
+		if err != nil {
+			p.destroy(member)
+			return status, bosherr.WrapErrorf(err, "Cloning '%s' to '%s'", templateId, member)
+		}
+		status.Members++
+		status.Created++
+	}
+
+	return status, nil
+}
+
+// destroy removes what is left of a VM, failures are only logged
+func (p Pool) destroy(vmName string) {
+	_, err := p.govcClient.DestroyVM(vmName)
+	if err != nil {
+		p.logger.Error("pool", "Destroying '%s': %s", vmName, err)
+	}
+}
+
+// membersOf returns the names of the members of the template's pool
+func membersOf(vmNames []string, templateId string) []string {
+	prefix := memberPrefix + templateId + memberSeparator
+
+	var members []string
+	for _, vmName := range vmNames {
+		if strings.HasPrefix(vmName, prefix) {
+			members = append(members, vmName)
+		}
+	}
+	return members
+}
+
+// orphanedTemplates returns the templates named by members which are not among the templates
+func orphanedTemplates(vmNames []string, templates map[string]bool) []string {
+	var orphaned []string
+	found := map[string]bool{}
+
+	for _, vmName := range vmNames {
+		if !strings.HasPrefix(vmName, memberPrefix) {
+			continue
+		}
+
+		i := strings.LastIndex(vmName, memberSeparator)
+		if i < 0 {
+			continue
+		}
+
+		templateId := strings.TrimPrefix(vmName[:i], memberPrefix)
+		if templates[templateId] || found[templateId] {
+			continue
+		}
+
+		found[templateId] = true
+		orphaned = append(orphaned, templateId)
+	}
+
+	return orphaned
+}
+
+// tryLock takes the replenish lock of the host without waiting, a cron job finding it held has nothing to do
+func tryLock(host string) (filelock.Lock, error) {
+	lock, err := filelock.TryAcquire("pool", host)
+	if err == filelock.ErrLocked {
+		return lock, ErrReplenishing
+	}
+	return lock, err
+}
@@ -0,0 +1,204 @@
+package pool_test
+
+import (
+	"errors"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"github.com/vmware/govmomi/vim25/soap"
+	"github.com/vmware/govmomi/vim25/types"
+
+	fakegovc "bosh-esxi-cpi/govc/fakes"
+
+	fakelogger "github.com/cloudfoundry/bosh-utils/logger/loggerfakes"
+	fakeuuid "github.com/cloudfoundry/bosh-utils/uuid/fakes"
+
+	"bosh-esxi-cpi/config"
+	"bosh-esxi-cpi/govc"
+	"bosh-esxi-cpi/pool"
+)
+
+var _ = Describe("Pool", func() {
+	var (
+		govcClient *fakegovc.FakeGovcClient
+		uuidGen    *fakeuuid.FakeGenerator
+		vmPool     config.VmPool
+	)
+
+	BeforeEach(func() {
+		govcClient = &fakegovc.FakeGovcClient{}
+		uuidGen = &fakeuuid.FakeGenerator{}
+		vmPool = config.VmPool{Size: 2, Stemcells: map[string]int{"windows": 0}}
+	})
+
+	newPool := func() pool.Pool {
+		return pool.NewPool(govcClient, vmPool, uuidGen, &fakelogger.FakeLogger{})
+	}
+
+	Describe("Claim", func() {
+		BeforeEach(func() {
+			govcClient.ListVMsReturns([]string{"cs-xenial", "pool-cs-other.1", "pool-cs-xenial.1", "pool-cs-xenial.2", "vm-1"}, nil)
+		})
+
+		It("renames a member of the template's pool", func() {
+			claimed, err := newPool().Claim("cs-xenial", "vm-uuid")
+			Expect(err).ToNot(HaveOccurred())
+			Expect(claimed).To(BeTrue())
+
+			Expect(govcClient.RenameVMCallCount()).To(Equal(1))
+			member, vmName := govcClient.RenameVMArgsForCall(0)
+			Expect(member).To(Equal("pool-cs-xenial.1"))
+			Expect(vmName).To(Equal("vm-uuid"))
+		})
+
+		It("moves on to the next member when another VM claimed it first", func() {
+			govcClient.RenameVMReturnsOnCall(0, errorVMNotFound())
+
+			claimed, err := newPool().Claim("cs-xenial", "vm-uuid")
+			Expect(err).ToNot(HaveOccurred())
+			Expect(claimed).To(BeTrue())
+
+			Expect(govcClient.RenameVMCallCount()).To(Equal(2))
+			member, _ := govcClient.RenameVMArgsForCall(1)
+			Expect(member).To(Equal("pool-cs-xenial.2"))
+		})
+
+		It("claims nothing from an empty pool", func() {
+			govcClient.RenameVMReturns(errorVMNotFound())
+
+			claimed, err := newPool().Claim("cs-xenial", "vm-uuid")
+			Expect(err).ToNot(HaveOccurred())
+			Expect(claimed).To(BeFalse())
+			Expect(govcClient.RenameVMCallCount()).To(Equal(2))
+		})
+
+		It("claims nothing when no pool is configured", func() {
+			vmPool = config.VmPool{}
+
+			claimed, err := newPool().Claim("cs-xenial", "vm-uuid")
+			Expect(err).ToNot(HaveOccurred())
+			Expect(claimed).To(BeFalse())
+			Expect(govcClient.ListVMsCallCount()).To(Equal(0))
+		})
+
+		It("destroys what a failed rename left behind", func() {
+			govcClient.RenameVMReturns(errors.New("move failed"))
+
+			claimed, err := newPool().Claim("cs-xenial", "vm-uuid")
+			Expect(err).To(MatchError(ContainSubstring("move failed")))
+			Expect(claimed).To(BeFalse())
+
+			Expect(govcClient.DestroyVMCallCount()).To(Equal(2))
+			Expect(govcClient.DestroyVMArgsForCall(0)).To(Equal("pool-cs-xenial.1"))
+			Expect(govcClient.DestroyVMArgsForCall(1)).To(Equal("vm-uuid"))
+		})
+	})
+
+	Describe("Replenish", func() {
+		BeforeEach(func() {
+			govcClient.ListVMsReturns([]string{"cs-windows", "cs-xenial", "pool-cs-windows.1", "pool-cs-xenial.1", "vm-1"}, nil)
+			govcClient.GetVMExtraConfigStub = func(vmName string) (map[string]string, error) {
+				switch vmName {
+				case "cs-windows":
+					return map[string]string{pool.StemcellNameKey: "windows"}, nil
+				default:
+					return map[string]string{pool.StemcellNameKey: "xenial"}, nil
+				}
+			}
+		})
+
+		It("sizes the pool of each template by its stemcell name", func() {
+			statuses, err := newPool().Replenish()
+			Expect(err).ToNot(HaveOccurred())
+			Expect(statuses).To(Equal([]pool.Status{
+				{Template: "cs-windows", StemcellName: "windows", Size: 0, Members: 0, Destroyed: 1},
+				{Template: "cs-xenial", StemcellName: "xenial", Size: 2, Members: 2, Created: 1},
+			}))
+
+			Expect(govcClient.DestroyVMCallCount()).To(Equal(1))
+			Expect(govcClient.DestroyVMArgsForCall(0)).To(Equal("pool-cs-windows.1"))
+
+			Expect(govcClient.CloneVMCallCount()).To(Equal(1))
+			template, member, datastore := govcClient.CloneVMArgsForCall(0)
+			Expect(template).To(Equal("cs-xenial"))
+			Expect(member).To(Equal("pool-cs-xenial.fake-uuid-0"))
+			Expect(datastore).To(BeEmpty())
+		})
+
+		It("drains the pools of deleted templates", func() {
+			govcClient.ListVMsReturns([]string{"cs-xenial", "pool-cs-deleted.1", "pool-cs-xenial.1", "pool-cs-xenial.2"}, nil)
+			govcClient.ListDatastoreFilesReturns([]govc.DatastoreFile{
+				{Path: "pool-cs-deleted.1/xenial.vmx"},
+				{Path: "pool-cs-deleted.2/xenial-flat.vmdk"},
+			}, nil)
+
+			statuses, err := newPool().Replenish()
+			Expect(err).ToNot(HaveOccurred())
+			Expect(statuses).To(ContainElement(pool.Status{Template: "cs-deleted", Destroyed: 2}))
+
+			Expect(govcClient.DestroyVMCallCount()).To(Equal(2))
+			Expect(govcClient.DestroyVMArgsForCall(0)).To(Equal("pool-cs-deleted.1"))
+			Expect(govcClient.DestroyVMArgsForCall(1)).To(Equal("pool-cs-deleted.2"))
+		})
+
+		It("destroys a member it failed to clone", func() {
+			govcClient.CloneVMReturns("", errors.New("copy failed"))
+
+			_, err := newPool().Replenish()
+			Expect(err).To(MatchError(ContainSubstring("copy failed")))
+			Expect(govcClient.DestroyVMArgsForCall(1)).To(Equal("pool-cs-xenial.fake-uuid-0"))
+		})
+
+		It("leaves the pools to a replenish already in progress", func() {
+			govcClient.CloneVMStub = func(string, string, string) (string, error) {
+				_, err := newPool().Replenish()
+				Expect(err).To(Equal(pool.ErrReplenishing))
+				return "", nil
+			}
+
+			_, err := newPool().Replenish()
+			Expect(err).ToNot(HaveOccurred())
+			Expect(govcClient.CloneVMCallCount()).To(Equal(1))
+		})
+
+		It("replenishes the pools of another host meanwhile", func() {
+			govcClient.HostReturns("esxi-1")
+			otherClient := &fakegovc.FakeGovcClient{}
+			otherClient.HostReturns("esxi-2")
+
+			govcClient.CloneVMStub = func(string, string, string) (string, error) {
+				_, err := pool.NewPool(otherClient, vmPool, uuidGen, &fakelogger.FakeLogger{}).Replenish()
+				Expect(err).ToNot(HaveOccurred())
+				return "", nil
+			}
+
+			_, err := newPool().Replenish()
+			Expect(err).ToNot(HaveOccurred())
+			Expect(otherClient.ListVMsCallCount()).To(Equal(1))
+		})
+	})
+
+	Describe("Drain", func() {
+		It("destroys registered members and the folders of unregistered ones", func() {
+			govcClient.ListVMsReturns([]string{"cs-xenial", "pool-cs-xenial.1", "pool-cs-other.1"}, nil)
+			govcClient.ListDatastoreFilesReturns([]govc.DatastoreFile{
+				{Path: "pool-cs-xenial.1/xenial.vmx"},
+				{Path: "pool-cs-xenial.2/xenial.vmx"},
+				{Path: "disk-1.vmdk"},
+			}, nil)
+
+			destroyed, err := newPool().Drain("cs-xenial")
+			Expect(err).ToNot(HaveOccurred())
+			Expect(destroyed).To(Equal(2))
+
+			Expect(govcClient.DestroyVMCallCount()).To(Equal(2))
+			Expect(govcClient.DestroyVMArgsForCall(0)).To(Equal("pool-cs-xenial.1"))
+			Expect(govcClient.DestroyVMArgsForCall(1)).To(Equal("pool-cs-xenial.2"))
+		})
+	})
+})
+
+// errorVMNotFound is what RenameVM returns for a member another VM claimed first
+func errorVMNotFound() error {
+	return soap.WrapVimFault(&types.ManagedObjectNotFound{})
+}
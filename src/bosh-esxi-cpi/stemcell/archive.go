@@ -0,0 +1,123 @@
+package stemcell
+
+import (
+	"archive/tar"
+	"bufio"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"os"
+	"path"
+
+	bosherr "github.com/cloudfoundry/bosh-utils/errors"
+)
+
+var gzipMagic = []byte{0x1f, 0x8b}
+
+const ovaExtension = ".ova"
+
+// Archive reads files straight out of a stemcell image tarball, gzipped or not,
+// or out of an OVA inside it, so the image never has to be extracted to disk
+type Archive struct {
+	path string
+}
+
+func NewArchive(path string) Archive {
+	return Archive{path: path}
+}
+
+// Open returns the first file whose base name matches the pattern, see path.Match.
+// Files of an OVA packaged in the image are found too, unless the pattern matches the OVA itself.
+// Every call reads the tarball from the start, so files can be opened in any order.
+func (a Archive) Open(pattern string) (io.ReadCloser, int64, error) {
+	file, err := os.Open(a.path)
+	if err != nil {
+		return nil, 0, bosherr.WrapErrorf(err, "Opening stemcell image '%s'", a.path)
+	}
+
+	reader, err := a.tarReader(file)
+	if err != nil {
+		file.Close()
+		return nil, 0, err
+	}
+
+	entry, size, err := a.find(reader, pattern)
+	if err != nil {
+		file.Close()
+		return nil, 0, err
+	}
+
+	return archiveEntry{Reader: entry, file: file}, size, nil
+}
+
+func (a Archive) find(reader *tar.Reader, pattern string) (io.Reader, int64, error) {
+	for {
+		header, err := reader.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, 0, bosherr.WrapErrorf(err, "Reading stemcell image '%s'", a.path)
+		}
+
+		if header.Typeflag != tar.TypeReg && header.Typeflag != tar.TypeRegA {
+			continue
+		}
+
+		name := path.Base(header.Name)
+		matched, err := path.Match(pattern, name)
+		if err != nil {
+			return nil, 0, err
+		}
+
+		if matched {
+			return reader, header.Size, nil
+		}
+
+		// an OVA is an uncompressed tarball of the OVF and its disks
+		if path.Ext(name) == ovaExtension {
+			entry, size, err := a.find(tar.NewReader(reader), pattern)
+			if _, notFound := err.(FileNotFoundError); !notFound {
+				return entry, size, err
+			}
+		}
+	}
+
+	return nil, 0, FileNotFoundError{Pattern: pattern}
+}
+
+func (a Archive) tarReader(file io.Reader) (*tar.Reader, error) {
+	buffered := bufio.NewReader(file)
+
+	magic, err := buffered.Peek(len(gzipMagic))
+	if err != nil && err != io.EOF {
+		return nil, bosherr.WrapErrorf(err, "Reading stemcell image '%s'", a.path)
+	}
+
+	if len(magic) == len(gzipMagic) && magic[0] == gzipMagic[0] && magic[1] == gzipMagic[1] {
+		gzipReader, err := gzip.NewReader(buffered)
+		if err != nil {
+			return nil, bosherr.WrapErrorf(err, "Decompressing stemcell image '%s'", a.path)
+		}
+		return tar.NewReader(gzipReader), nil
+	}
+
+	return tar.NewReader(buffered), nil
+}
+
+type FileNotFoundError struct {
+	Pattern string
+}
+
+func (e FileNotFoundError) Error() string {
+	return fmt.Sprintf("stemcell does not contain '%s'", e.Pattern)
+}
+
+type archiveEntry struct {
+	io.Reader
+	file io.Closer
+}
+
+func (e archiveEntry) Close() error {
+	return e.file.Close()
+}
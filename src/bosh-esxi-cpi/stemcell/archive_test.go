@@ -0,0 +1,162 @@
+package stemcell_test
+
+import (
+	"archive/tar"
+	"bytes"
+	"crypto/sha1"
+	"crypto/sha256"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	"bosh-esxi-cpi/stemcell"
+)
+
+var _ = Describe("Archive", func() {
+	var tempDir string
+
+	BeforeEach(func() {
+		var err error
+		tempDir, err = ioutil.TempDir("", "stemcell-archive-")
+		Expect(err).ToNot(HaveOccurred())
+	})
+
+	AfterEach(func() {
+		os.RemoveAll(tempDir)
+	})
+
+	read := func(archive stemcell.Archive, pattern string) (string, int64) {
+		reader, size, err := archive.Open(pattern)
+		Expect(err).ToNot(HaveOccurred())
+		defer reader.Close()
+
+		contents, err := ioutil.ReadAll(reader)
+		Expect(err).ToNot(HaveOccurred())
+		return string(contents), size
+	}
+
+	files := map[string]string{"image.ovf": "<Envelope/>", "image-disk1.vmdk": "disk contents"}
+
+	It("reads files from a gzipped image in any order", func() {
+		archive := stemcell.NewArchive(writeImage(tempDir, true, files, "image-disk1.vmdk", "image.ovf"))
+
+		contents, size := read(archive, "*.ovf")
+		Expect(contents).To(Equal("<Envelope/>"))
+		Expect(size).To(Equal(int64(11)))
+
+		contents, size = read(archive, "image-disk1.vmdk")
+		Expect(contents).To(Equal("disk contents"))
+		Expect(size).To(Equal(int64(13)))
+	})
+
+	It("reads files from an uncompressed image", func() {
+		archive := stemcell.NewArchive(writeImage(tempDir, false, files, "image.ovf", "image-disk1.vmdk"))
+
+		contents, _ := read(archive, "image-disk1.vmdk")
+		Expect(contents).To(Equal("disk contents"))
+	})
+
+	Context("when the image packages an OVA", func() {
+		ova := func(order ...string) string {
+			var buffer bytes.Buffer
+			tarWriter := tar.NewWriter(&buffer)
+			for _, name := range order {
+				Expect(tarWriter.WriteHeader(&tar.Header{Name: name, Mode: 0644, Size: int64(len(files[name]))})).To(Succeed())
+				_, err := tarWriter.Write([]byte(files[name]))
+				Expect(err).ToNot(HaveOccurred())
+			}
+			Expect(tarWriter.Close()).To(Succeed())
+			return buffer.String()
+		}
+
+		It("reads files from the OVA", func() {
+			image := map[string]string{"image.ova": ova("image.ovf", "image-disk1.vmdk"), "stemcell.MF": "name: stemcell"}
+			archive := stemcell.NewArchive(writeImage(tempDir, true, image, "stemcell.MF", "image.ova"))
+
+			contents, size := read(archive, "*.ovf")
+			Expect(contents).To(Equal("<Envelope/>"))
+			Expect(size).To(Equal(int64(11)))
+
+			contents, _ = read(archive, "image-disk1.vmdk")
+			Expect(contents).To(Equal("disk contents"))
+		})
+
+		It("reads files next to the OVA", func() {
+			image := map[string]string{"image.ova": ova("image.ovf"), "stemcell.MF": "name: stemcell"}
+			archive := stemcell.NewArchive(writeImage(tempDir, false, image, "image.ova", "stemcell.MF"))
+
+			contents, _ := read(archive, "stemcell.MF")
+			Expect(contents).To(Equal("name: stemcell"))
+
+			_, _, err := archive.Open("*.vmdk")
+			Expect(err).To(MatchError("stemcell does not contain '*.vmdk'"))
+		})
+	})
+
+	It("fails when the image does not contain the file", func() {
+		archive := stemcell.NewArchive(writeImage(tempDir, true, files, "image-disk1.vmdk"))
+
+		_, _, err := archive.Open("*.ovf")
+		Expect(err).To(MatchError("stemcell does not contain '*.ovf'"))
+	})
+
+	It("fails when the image does not exist", func() {
+		archive := stemcell.NewArchive(filepath.Join(tempDir, "missing"))
+
+		_, _, err := archive.Open("*.ovf")
+		Expect(err).To(HaveOccurred())
+	})
+
+	Describe("VerifyManifest", func() {
+		withManifest := func(manifest string) map[string]string {
+			return map[string]string{
+				"image.ovf":        "<Envelope/>",
+				"image-disk1.vmdk": "disk contents",
+				"image.mf":         manifest,
+			}
+		}
+
+		It("accepts files matching their SHA1 and SHA256 digests", func() {
+			manifest := fmt.Sprintf("SHA1(image.ovf)= %x\nSHA256(image-disk1.vmdk)= %x\n",
+				sha1.Sum([]byte("<Envelope/>")), sha256.Sum256([]byte("disk contents")))
+			archive := stemcell.NewArchive(writeImage(tempDir, true, withManifest(manifest), "image.ovf", "image.mf", "image-disk1.vmdk"))
+
+			Expect(archive.VerifyManifest()).To(Succeed())
+		})
+
+		It("accepts images without a manifest", func() {
+			archive := stemcell.NewArchive(writeImage(tempDir, true, files, "image.ovf", "image-disk1.vmdk"))
+
+			Expect(archive.VerifyManifest()).To(Succeed())
+		})
+
+		It("fails when a digest does not match", func() {
+			manifest := fmt.Sprintf("SHA1(image-disk1.vmdk)= %x\n", sha1.Sum([]byte("other contents")))
+			archive := stemcell.NewArchive(writeImage(tempDir, true, withManifest(manifest), "image.ovf", "image.mf", "image-disk1.vmdk"))
+
+			err := archive.VerifyManifest()
+			Expect(err).To(MatchError(fmt.Sprintf(
+				"SHA1 digest of 'image-disk1.vmdk' does not match the OVF manifest: expected %x, got %x",
+				sha1.Sum([]byte("other contents")), sha1.Sum([]byte("disk contents")))))
+		})
+
+		It("fails when a listed file is missing", func() {
+			manifest := fmt.Sprintf("SHA1(image-disk2.vmdk)= %x\n", sha1.Sum([]byte("disk contents")))
+			archive := stemcell.NewArchive(writeImage(tempDir, true, withManifest(manifest), "image.ovf", "image.mf", "image-disk1.vmdk"))
+
+			err := archive.VerifyManifest()
+			Expect(err).To(MatchError("Verifying 'image-disk2.vmdk' listed in the OVF manifest: stemcell does not contain 'image-disk2.vmdk'"))
+		})
+
+		It("fails on unsupported digest algorithms", func() {
+			archive := stemcell.NewArchive(writeImage(tempDir, true, withManifest("MD5(image-disk1.vmdk)= 0123abcd\n"), "image.mf"))
+
+			err := archive.VerifyManifest()
+			Expect(err).To(MatchError("OVF manifest digest algorithm 'MD5' is not supported"))
+		})
+	})
+})
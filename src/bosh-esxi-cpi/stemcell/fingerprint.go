@@ -0,0 +1,35 @@
+package stemcell
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"io"
+)
+
+// Fingerprint identifies the contents of a stemcell image by the digest of its OVF manifest,
+// which lists the digest of every file, or else by the stemcell name and version.
+// It is empty when the image has neither.
+func Fingerprint(archive Archive, props StemcellProps) (string, error) {
+	digest := sha256.New()
+
+	reader, _, err := archive.Open("*.mf")
+	switch err.(type) {
+	case nil:
+		defer reader.Close()
+
+		io.WriteString(digest, "manifest\n")
+		if _, err = io.Copy(digest, reader); err != nil {
+			return "", err
+		}
+	case FileNotFoundError:
+		if props.Name == "" || props.Version == "" {
+			return "", nil
+		}
+
+		io.WriteString(digest, "stemcell\n"+props.Name+"\n"+props.Version)
+	default:
+		return "", err
+	}
+
+	return "sha256:" + hex.EncodeToString(digest.Sum(nil)), nil
+}
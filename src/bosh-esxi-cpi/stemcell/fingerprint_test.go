@@ -0,0 +1,58 @@
+package stemcell_test
+
+import (
+	"io/ioutil"
+	"os"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	"bosh-esxi-cpi/stemcell"
+)
+
+var _ = Describe("Fingerprint", func() {
+	var tempDir string
+
+	props := stemcell.StemcellProps{Name: "bosh-vsphere-esxi-ubuntu-trusty-go_agent", Version: "3541.5"}
+
+	BeforeEach(func() {
+		var err error
+		tempDir, err = ioutil.TempDir("", "stemcell-fingerprint-")
+		Expect(err).ToNot(HaveOccurred())
+	})
+
+	AfterEach(func() {
+		os.RemoveAll(tempDir)
+	})
+
+	fingerprintOf := func(props stemcell.StemcellProps, files map[string]string, order ...string) string {
+		fingerprint, err := stemcell.Fingerprint(stemcell.NewArchive(writeImage(tempDir, true, files, order...)), props)
+		Expect(err).ToNot(HaveOccurred())
+		return fingerprint
+	}
+
+	It("is derived from the OVF manifest, regardless of name and version", func() {
+		files := map[string]string{"image.ovf": "<Envelope/>", "image.mf": "SHA1(image.ovf)= 0123"}
+
+		fingerprint := fingerprintOf(props, files, "image.ovf", "image.mf")
+		Expect(fingerprint).To(HavePrefix("sha256:"))
+		Expect(fingerprintOf(stemcell.StemcellProps{Name: "other", Version: "1"}, files, "image.mf", "image.ovf")).To(Equal(fingerprint))
+
+		files["image.mf"] = "SHA1(image.ovf)= 4567"
+		Expect(fingerprintOf(props, files, "image.ovf", "image.mf")).ToNot(Equal(fingerprint))
+	})
+
+	It("falls back to the name and version without a manifest", func() {
+		files := map[string]string{"image.ovf": "<Envelope/>"}
+
+		fingerprint := fingerprintOf(props, files, "image.ovf")
+		Expect(fingerprint).To(HavePrefix("sha256:"))
+
+		otherVersion := stemcell.StemcellProps{Name: props.Name, Version: "3541.6"}
+		Expect(fingerprintOf(otherVersion, files, "image.ovf")).ToNot(Equal(fingerprint))
+	})
+
+	It("is empty without a manifest, name or version", func() {
+		Expect(fingerprintOf(stemcell.StemcellProps{}, map[string]string{"image.ovf": "<Envelope/>"}, "image.ovf")).To(BeEmpty())
+	})
+})
@@ -0,0 +1,108 @@
+package stemcell
+
+import (
+	"bufio"
+	"crypto/sha1"
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/hex"
+	"hash"
+	"io"
+	"regexp"
+	"strings"
+
+	bosherr "github.com/cloudfoundry/bosh-utils/errors"
+)
+
+// manifestLine matches an OVF manifest entry such as "SHA1(image-disk1.vmdk)= 0123abcd..."
+var manifestLine = regexp.MustCompile(`^(\w+)\((.+)\)\s*=\s*([0-9a-fA-F]+)$`)
+
+var manifestHashes = map[string]func() hash.Hash{
+	"SHA1":   sha1.New,
+	"SHA256": sha256.New,
+	"SHA512": sha512.New,
+}
+
+type manifestEntry struct {
+	algorithm string
+	file      string
+	digest    string
+}
+
+// VerifyManifest checks every file listed in the image's OVF manifest against its digest.
+// Images without a manifest are not verified.
+func (a Archive) VerifyManifest() error {
+	reader, _, err := a.Open("*.mf")
+	if _, ok := err.(FileNotFoundError); ok {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	defer reader.Close()
+
+	entries, err := parseManifest(reader)
+	if err != nil {
+		return err
+	}
+
+	for _, entry := range entries {
+		err = a.verify(entry)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (a Archive) verify(entry manifestEntry) error {
+	reader, _, err := a.Open(entry.file)
+	if err != nil {
+		return bosherr.WrapErrorf(err, "Verifying '%s' listed in the OVF manifest", entry.file)
+	}
+	defer reader.Close()
+
+	digest := manifestHashes[entry.algorithm]()
+	_, err = io.Copy(digest, reader)
+	if err != nil {
+		return bosherr.WrapErrorf(err, "Reading '%s'", entry.file)
+	}
+
+	actual := hex.EncodeToString(digest.Sum(nil))
+	if actual != entry.digest {
+		return bosherr.Errorf("%s digest of '%s' does not match the OVF manifest: expected %s, got %s", entry.algorithm, entry.file, entry.digest, actual)
+	}
+
+	return nil
+}
+
+func parseManifest(reader io.Reader) ([]manifestEntry, error) {
+	var entries []manifestEntry
+
+	scanner := bufio.NewScanner(reader)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" {
+			continue
+		}
+
+		match := manifestLine.FindStringSubmatch(line)
+		if match == nil {
+			return nil, bosherr.Errorf("Parsing OVF manifest line '%s'", line)
+		}
+
+		algorithm := strings.ToUpper(match[1])
+		if _, ok := manifestHashes[algorithm]; !ok {
+			return nil, bosherr.Errorf("OVF manifest digest algorithm '%s' is not supported", match[1])
+		}
+
+		entries = append(entries, manifestEntry{algorithm: algorithm, file: match[2], digest: strings.ToLower(match[3])})
+	}
+
+	if err := scanner.Err(); err != nil {
+		return nil, bosherr.WrapError(err, "Reading OVF manifest")
+	}
+
+	return entries, nil
+}
@@ -0,0 +1,79 @@
+package stemcell
+
+import (
+	"strings"
+
+	bosherr "github.com/cloudfoundry/bosh-utils/errors"
+	"github.com/cppforlife/bosh-cpi-go/apiv1"
+)
+
+const (
+	InfrastructureVSphere = "vsphere"
+	HypervisorESXi        = "esxi"
+	ArchitectureX86_64    = "x86_64"
+)
+
+type StemcellProps struct {
+	Name           string
+	Version        string
+	Infrastructure string
+	Hypervisor     string
+	Architecture   string
+
+	// A light stemcell names the template to clone instead of carrying an image:
+	// either a VM on the host, or the .vmx of a VM on the datastore, relative to its root
+	SourceVM   string `json:"source_vm"`
+	SourcePath string `json:"source_path"`
+}
+
+// NewStemcellProps parses the cloud properties from the stemcell's stemcell.MF
+func NewStemcellProps(cloudProps apiv1.StemcellCloudProps) (StemcellProps, error) {
+	var stemcellProps StemcellProps
+
+	err := cloudProps.As(&stemcellProps)
+	if err != nil {
+		return StemcellProps{}, err
+	}
+
+	err = stemcellProps.Validate()
+	if err != nil {
+		return StemcellProps{}, bosherr.WrapErrorf(err, "Validating stemcell '%s' version '%s'", stemcellProps.Name, stemcellProps.Version)
+	}
+
+	return stemcellProps, nil
+}
+
+// Validate rejects stemcells built for another infrastructure. Stemcells
+// predating the architecture property were only built for x86_64.
+func (p StemcellProps) Validate() error {
+	if p.Infrastructure != InfrastructureVSphere {
+		return bosherr.Errorf("infrastructure must be '%s', got '%s'", InfrastructureVSphere, p.Infrastructure)
+	}
+
+	if p.Hypervisor != HypervisorESXi {
+		return bosherr.Errorf("hypervisor must be '%s', got '%s'", HypervisorESXi, p.Hypervisor)
+	}
+
+	if p.Architecture != "" && p.Architecture != ArchitectureX86_64 {
+		return bosherr.Errorf("architecture must be '%s', got '%s'", ArchitectureX86_64, p.Architecture)
+	}
+
+	if p.SourceVM != "" && p.SourcePath != "" {
+		return bosherr.Error("only one of source_vm and source_path may be set")
+	}
+
+	if p.SourcePath != "" && !strings.HasSuffix(p.SourcePath, ".vmx") {
+		return bosherr.Errorf("source_path must name a .vmx file, got '%s'", p.SourcePath)
+	}
+
+	if strings.HasPrefix(p.SourcePath, "[") {
+		return bosherr.Errorf("source_path must be relative to the datastore root, got '%s'", p.SourcePath)
+	}
+
+	return nil
+}
+
+// IsLight reports whether the stemcell clones an existing template rather than importing its image
+func (p StemcellProps) IsLight() bool {
+	return p.SourceVM != "" || p.SourcePath != ""
+}
@@ -0,0 +1,105 @@
+package stemcell_test
+
+import (
+	"encoding/json"
+
+	"github.com/cppforlife/bosh-cpi-go/apiv1"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	"bosh-esxi-cpi/stemcell"
+)
+
+var _ = Describe("StemcellProps", func() {
+	cloudPropsFrom := func(raw string) apiv1.CloudPropsImpl {
+		var cloudProps apiv1.CloudPropsImpl
+		err := json.Unmarshal([]byte(raw), &cloudProps)
+		Expect(err).ToNot(HaveOccurred())
+		return cloudProps
+	}
+
+	It("parses the properties of a vsphere stemcell", func() {
+		stemcellProps, err := stemcell.NewStemcellProps(cloudPropsFrom(`{
+			"architecture": "x86_64",
+			"container_format": "bare",
+			"disk": 3072,
+			"disk_format": "ovf",
+			"hypervisor": "esxi",
+			"infrastructure": "vsphere",
+			"name": "bosh-vsphere-esxi-ubuntu-trusty-go_agent",
+			"os_distro": "ubuntu",
+			"os_type": "linux",
+			"root_device_name": "/dev/sda1",
+			"version": "3541.5"
+		}`))
+		Expect(err).ToNot(HaveOccurred())
+
+		Expect(stemcellProps).To(Equal(stemcell.StemcellProps{
+			Name:           "bosh-vsphere-esxi-ubuntu-trusty-go_agent",
+			Version:        "3541.5",
+			Infrastructure: "vsphere",
+			Hypervisor:     "esxi",
+			Architecture:   "x86_64",
+		}))
+	})
+
+	It("accepts stemcells predating the architecture property", func() {
+		_, err := stemcell.NewStemcellProps(cloudPropsFrom(`{"infrastructure": "vsphere", "hypervisor": "esxi"}`))
+		Expect(err).ToNot(HaveOccurred())
+	})
+
+	It("rejects stemcells for other infrastructures", func() {
+		_, err := stemcell.NewStemcellProps(cloudPropsFrom(`{
+			"name": "bosh-aws-xen-hvm-ubuntu-trusty-go_agent",
+			"version": "3541.5",
+			"infrastructure": "aws",
+			"hypervisor": "xen",
+			"architecture": "x86_64"
+		}`))
+		Expect(err).To(MatchError("Validating stemcell 'bosh-aws-xen-hvm-ubuntu-trusty-go_agent' version '3541.5': infrastructure must be 'vsphere', got 'aws'"))
+	})
+
+	It("rejects stemcells without an infrastructure", func() {
+		_, err := stemcell.NewStemcellProps(cloudPropsFrom(`{}`))
+		Expect(err).To(MatchError(ContainSubstring("infrastructure must be 'vsphere', got ''")))
+	})
+
+	It("rejects stemcells for other hypervisors", func() {
+		_, err := stemcell.NewStemcellProps(cloudPropsFrom(`{"infrastructure": "vsphere", "hypervisor": "kvm"}`))
+		Expect(err).To(MatchError(ContainSubstring("hypervisor must be 'esxi', got 'kvm'")))
+	})
+
+	It("rejects stemcells for other architectures", func() {
+		_, err := stemcell.NewStemcellProps(cloudPropsFrom(`{"infrastructure": "vsphere", "hypervisor": "esxi", "architecture": "ppc64le"}`))
+		Expect(err).To(MatchError(ContainSubstring("architecture must be 'x86_64', got 'ppc64le'")))
+	})
+
+	Context("for light stemcells", func() {
+		It("parses the template to clone", func() {
+			stemcellProps, err := stemcell.NewStemcellProps(cloudPropsFrom(`{"infrastructure": "vsphere", "hypervisor": "esxi", "source_vm": "ubuntu-xenial"}`))
+			Expect(err).ToNot(HaveOccurred())
+			Expect(stemcellProps.SourceVM).To(Equal("ubuntu-xenial"))
+			Expect(stemcellProps.IsLight()).To(BeTrue())
+
+			stemcellProps, err = stemcell.NewStemcellProps(cloudPropsFrom(`{"infrastructure": "vsphere", "hypervisor": "esxi", "source_path": "templates/xenial.vmx"}`))
+			Expect(err).ToNot(HaveOccurred())
+			Expect(stemcellProps.SourcePath).To(Equal("templates/xenial.vmx"))
+			Expect(stemcellProps.IsLight()).To(BeTrue())
+		})
+
+		It("rejects setting both sources", func() {
+			_, err := stemcell.NewStemcellProps(cloudPropsFrom(`{"infrastructure": "vsphere", "hypervisor": "esxi", "source_vm": "xenial", "source_path": "xenial/xenial.vmx"}`))
+			Expect(err).To(MatchError(ContainSubstring("only one of source_vm and source_path may be set")))
+		})
+
+		It("rejects source paths which are not .vmx files", func() {
+			_, err := stemcell.NewStemcellProps(cloudPropsFrom(`{"infrastructure": "vsphere", "hypervisor": "esxi", "source_path": "xenial/xenial-disk1.vmdk"}`))
+			Expect(err).To(MatchError(ContainSubstring("source_path must name a .vmx file, got 'xenial/xenial-disk1.vmdk'")))
+		})
+
+		It("rejects source paths on another datastore", func() {
+			_, err := stemcell.NewStemcellProps(cloudPropsFrom(`{"infrastructure": "vsphere", "hypervisor": "esxi", "source_path": "[datastore2] xenial/xenial.vmx"}`))
+			Expect(err).To(MatchError(ContainSubstring("source_path must be relative to the datastore root")))
+		})
+	})
+})
@@ -1,6 +1,11 @@
 package stemcell_test
 
 import (
+	"archive/tar"
+	"compress/gzip"
+	"io"
+	"os"
+	"path/filepath"
 	"testing"
 
 	. "github.com/onsi/ginkgo"
@@ -16,3 +21,30 @@ func TestCpi(t *testing.T) {
 var _ = AfterSuite(func() {
 	gexec.CleanupBuildArtifacts()
 })
+
+// writeImage packs the files into a stemcell image tarball in the given order
+func writeImage(dir string, compress bool, files map[string]string, order ...string) string {
+	imagePath := filepath.Join(dir, "image")
+	file, err := os.Create(imagePath)
+	Expect(err).ToNot(HaveOccurred())
+	defer file.Close()
+
+	var writer io.Writer = file
+	if compress {
+		gzipWriter := gzip.NewWriter(file)
+		defer gzipWriter.Close()
+		writer = gzipWriter
+	}
+
+	tarWriter := tar.NewWriter(writer)
+	defer tarWriter.Close()
+
+	Expect(tarWriter.WriteHeader(&tar.Header{Name: "./", Typeflag: tar.TypeDir, Mode: 0755})).To(Succeed())
+	for _, name := range order {
+		Expect(tarWriter.WriteHeader(&tar.Header{Name: "./" + name, Mode: 0644, Size: int64(len(files[name]))})).To(Succeed())
+		_, err = tarWriter.Write([]byte(files[name]))
+		Expect(err).ToNot(HaveOccurred())
+	}
+
+	return imagePath
+}
@@ -3,7 +3,6 @@ package vm
 import (
 	"crypto/rand"
 	"fmt"
-	"io/ioutil"
 	"os"
 	"path/filepath"
 
@@ -14,6 +13,12 @@ import (
 	"github.com/rn/iso9660wrap"
 )
 
+// legacyEnvPath is where the env of the VM created last was written before envs were kept per VM
+const legacyEnvPath = "/tmp/env.json"
+
+// envDir keeps the env of each VM, which attach_disk and detach_disk update in requests of their own
+var envDir = filepath.Join(os.TempDir(), "bosh-esxi-cpi-env")
+
 type AgentSettingsImpl struct {
 	fs            boshsys.FileSystem
 	logger        boshlog.Logger
@@ -21,18 +26,33 @@ type AgentSettingsImpl struct {
 }
 
 func NewAgentSettings(fs boshsys.FileSystem, logger boshlog.Logger) AgentSettings {
-	parentTempDir, _ := fs.TempDir("agent-settings-env-iso-")
-
 	return &AgentSettingsImpl{
-		fs:            fs,
-		logger:        logger,
-		parentTempDir: parentTempDir,
+		fs:     fs,
+		logger: logger,
 	}
 }
 
-func (s AgentSettingsImpl) GenerateAgentEnvIso(agentEnv apiv1.AgentEnv) (string, error) {
-	envBytes, _ := agentEnv.AsBytes()
-	ioutil.WriteFile("/tmp/env.json", envBytes, 0666)
+// GenerateAgentEnvIso writes the ISO to a temp dir created on first use, which Cleanup removes,
+// and keeps the env as the VM's for AgentEnvBytes
+func (s *AgentSettingsImpl) GenerateAgentEnvIso(vmId string, agentEnv apiv1.AgentEnv) (string, error) {
+	envBytes, err := agentEnv.AsBytes()
+	if err != nil {
+		return "", bosherr.WrapError(err, "serializing agent env failed")
+	}
+
+	err = s.writeAgentEnv(vmId, envBytes)
+	if err != nil {
+		return "", err
+	}
+
+	if s.parentTempDir == "" {
+		parentTempDir, err := s.fs.TempDir("agent-settings-env-iso-")
+		if err != nil {
+			return "", bosherr.WrapError(err, "creating tempdir failed")
+		}
+		s.parentTempDir = parentTempDir
+	}
+
 	envIsoPath := filepath.Join(s.parentTempDir, "env.iso")
 
 	isoFile, err := s.fs.OpenFile(envIsoPath, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0666)
@@ -43,19 +63,58 @@ func (s AgentSettingsImpl) GenerateAgentEnvIso(agentEnv apiv1.AgentEnv) (string,
 
 	iso9660wrap.WriteBuffer(isoFile, envBytes, "ENV")
 
+	return envIsoPath, nil
+}
+
+// AgentEnvBytes returns the env last generated for the VM. VMs created before envs were kept per VM
+// only have the env written for the VM created last.
+func (s AgentSettingsImpl) AgentEnvBytes(vmId string) ([]byte, error) {
+	envPath := filepath.Join(envDir, vmId+".json")
+	if !s.fs.FileExists(envPath) {
+		envPath = legacyEnvPath
+	}
+
+	envBytes, err := s.fs.ReadFile(envPath)
 	if err != nil {
-		return "", bosherr.WrapError(err, "creating tempdir failed")
+		return nil, bosherr.WrapErrorf(err, "reading agent env of '%s' failed", vmId)
 	}
+	return envBytes, nil
+}
 
-	return envIsoPath, nil
+// DeleteAgentEnv removes the env kept for the VM
+func (s AgentSettingsImpl) DeleteAgentEnv(vmId string) error {
+	err := s.fs.RemoveAll(filepath.Join(envDir, vmId+".json"))
+	if err != nil {
+		return bosherr.WrapErrorf(err, "removing agent env of '%s' failed", vmId)
+	}
+	return nil
 }
 
-func (s AgentSettingsImpl) AgentEnvBytesFromFile() []byte {
-	bytes, err := ioutil.ReadFile("/tmp/env.json")
+// writeAgentEnv replaces the VM's env by renaming a complete copy over it, so it is never read half written.
+// The env holds the agent's credentials, so only the CPI's user may read it.
+func (s AgentSettingsImpl) writeAgentEnv(vmId string, envBytes []byte) error {
+	err := s.fs.MkdirAll(envDir, 0700)
+	if err != nil {
+		return bosherr.WrapError(err, "creating agent env dir failed")
+	}
+
+	envPath := filepath.Join(envDir, vmId+".json")
+	file, err := s.fs.OpenFile(envPath+".tmp", os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0600)
 	if err != nil {
-		panic("failed to read")
+		return bosherr.WrapErrorf(err, "writing agent env of '%s' failed", vmId)
 	}
-	return bytes
+
+	_, err = file.Write(envBytes)
+	file.Close()
+	if err != nil {
+		return bosherr.WrapErrorf(err, "writing agent env of '%s' failed", vmId)
+	}
+
+	err = s.fs.Rename(envPath+".tmp", envPath)
+	if err != nil {
+		return bosherr.WrapErrorf(err, "writing agent env of '%s' failed", vmId)
+	}
+	return nil
 }
 
 func (s AgentSettingsImpl) GenerateMacAddress() (string, error) {
@@ -70,9 +129,14 @@ func (s AgentSettingsImpl) GenerateMacAddress() (string, error) {
 	return fmt.Sprintf("00:50:56:3f:%02x:%02x", buf[0], buf[1]), nil
 }
 
-func (s AgentSettingsImpl) Cleanup() {
+func (s *AgentSettingsImpl) Cleanup() {
+	if s.parentTempDir == "" {
+		return
+	}
+
 	err := s.fs.RemoveAll(s.parentTempDir)
 	if err != nil {
 		s.logger.Error("stemcell-client", "Cleaning up stemcell temp dir '%s'", s.parentTempDir)
 	}
+	s.parentTempDir = ""
 }
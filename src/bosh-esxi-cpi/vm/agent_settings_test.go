@@ -2,6 +2,7 @@ package vm_test
 
 import (
 	"bytes"
+	"fmt"
 
 	"github.com/cppforlife/bosh-cpi-go/apiv1"
 	. "github.com/onsi/ginkgo"
@@ -21,7 +22,7 @@ var _ = Describe("AgentSettings", func() {
 		agentEnv, err := apiv1.AgentEnvFactory{}.FromBytes([]byte("{}"))
 		agentSettings := vm.NewAgentSettings(fs, logger)
 
-		isoPath, err := agentSettings.GenerateAgentEnvIso(agentEnv)
+		isoPath, err := agentSettings.GenerateAgentEnvIso("vm-uuid", agentEnv)
 		Expect(err).ToNot(HaveOccurred())
 		Expect(isoPath).To(ContainSubstring("/env.iso"))
 
@@ -32,4 +33,61 @@ var _ = Describe("AgentSettings", func() {
 		Expect(bytes.Contains(fileStats.Content, agentEnvBytes)).To(BeTrue())
 		Expect(len(fileStats.Content)).To(Equal(4096))
 	})
+	It("creates a temp dir on first use and removes it on cleanup", func() {
+		fs := fakesys.NewFakeFileSystem()
+		agentSettings := vm.NewAgentSettings(fs, &fakelogger.FakeLogger{})
+
+		// there is nothing to remove yet, the fake panics when asked to remove ""
+		agentSettings.Cleanup()
+
+		agentEnv, err := apiv1.AgentEnvFactory{}.FromBytes([]byte("{}"))
+		Expect(err).ToNot(HaveOccurred())
+
+		isoPath, err := agentSettings.GenerateAgentEnvIso("vm-uuid", agentEnv)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(fs.FileExists(isoPath)).To(BeTrue())
+
+		agentSettings.Cleanup()
+		Expect(fs.FileExists(isoPath)).To(BeFalse())
+	})
+	It("keeps the env of each VM until it is deleted", func() {
+		fs := fakesys.NewFakeFileSystem()
+		agentSettings := vm.NewAgentSettings(fs, &fakelogger.FakeLogger{})
+
+		for _, vmId := range []string{"vm-1", "vm-2"} {
+			agentEnv, err := apiv1.AgentEnvFactory{}.FromBytes([]byte(fmt.Sprintf(`{"agent_id":"%s"}`, vmId)))
+			Expect(err).ToNot(HaveOccurred())
+
+			_, err = agentSettings.GenerateAgentEnvIso(vmId, agentEnv)
+			Expect(err).ToNot(HaveOccurred())
+		}
+
+		envBytes, err := agentSettings.AgentEnvBytes("vm-1")
+		Expect(err).ToNot(HaveOccurred())
+		Expect(string(envBytes)).To(ContainSubstring(`"agent_id":"vm-1"`))
+
+		Expect(agentSettings.DeleteAgentEnv("vm-1")).To(Succeed())
+
+		envBytes, err = agentSettings.AgentEnvBytes("vm-2")
+		Expect(err).ToNot(HaveOccurred())
+		Expect(string(envBytes)).To(ContainSubstring(`"agent_id":"vm-2"`))
+	})
+
+	It("reads the env written last for VMs created before envs were kept per VM", func() {
+		fs := fakesys.NewFakeFileSystem()
+		Expect(fs.WriteFileString("/tmp/env.json", `{"agent_id":"legacy"}`)).To(Succeed())
+		agentSettings := vm.NewAgentSettings(fs, &fakelogger.FakeLogger{})
+
+		envBytes, err := agentSettings.AgentEnvBytes("vm-legacy")
+		Expect(err).ToNot(HaveOccurred())
+		Expect(string(envBytes)).To(Equal(`{"agent_id":"legacy"}`))
+	})
+
+	It("fails when no env was kept for the VM", func() {
+		agentSettings := vm.NewAgentSettings(fakesys.NewFakeFileSystem(), &fakelogger.FakeLogger{})
+
+		_, err := agentSettings.AgentEnvBytes("vm-unknown")
+		Expect(err).To(HaveOccurred())
+		Expect(err.Error()).To(ContainSubstring("reading agent env of 'vm-unknown' failed"))
+	})
 })
@@ -12,10 +12,11 @@ type FakeAgentSettings struct {
 	CleanupStub                    func()
 	cleanupMutex                   sync.RWMutex
 	cleanupArgsForCall             []struct{}
-	GenerateAgentEnvIsoStub        func(apiv1.AgentEnv) (string, error)
+	GenerateAgentEnvIsoStub        func(string, apiv1.AgentEnv) (string, error)
 	generateAgentEnvIsoMutex       sync.RWMutex
 	generateAgentEnvIsoArgsForCall []struct {
-		arg1 apiv1.AgentEnv
+		arg1 string
+		arg2 apiv1.AgentEnv
 	}
 	generateAgentEnvIsoReturns struct {
 		result1 string
@@ -36,14 +37,29 @@ type FakeAgentSettings struct {
 		result1 string
 		result2 error
 	}
-	AgentEnvBytesFromFileStub        func() []byte
-	agentEnvBytesFromFileMutex       sync.RWMutex
-	agentEnvBytesFromFileArgsForCall []struct{}
-	agentEnvBytesFromFileReturns     struct {
+	AgentEnvBytesStub        func(string) ([]byte, error)
+	agentEnvBytesMutex       sync.RWMutex
+	agentEnvBytesArgsForCall []struct {
+		arg1 string
+	}
+	agentEnvBytesReturns struct {
 		result1 []byte
+		result2 error
 	}
-	agentEnvBytesFromFileReturnsOnCall map[int]struct {
+	agentEnvBytesReturnsOnCall map[int]struct {
 		result1 []byte
+		result2 error
+	}
+	DeleteAgentEnvStub        func(string) error
+	deleteAgentEnvMutex       sync.RWMutex
+	deleteAgentEnvArgsForCall []struct {
+		arg1 string
+	}
+	deleteAgentEnvReturns struct {
+		result1 error
+	}
+	deleteAgentEnvReturnsOnCall map[int]struct {
+		result1 error
 	}
 	invocations      map[string][][]interface{}
 	invocationsMutex sync.RWMutex
@@ -65,16 +81,17 @@ func (fake *FakeAgentSettings) CleanupCallCount() int {
 	return len(fake.cleanupArgsForCall)
 }
 
-func (fake *FakeAgentSettings) GenerateAgentEnvIso(arg1 apiv1.AgentEnv) (string, error) {
+func (fake *FakeAgentSettings) GenerateAgentEnvIso(arg1 string, arg2 apiv1.AgentEnv) (string, error) {
 	fake.generateAgentEnvIsoMutex.Lock()
 	ret, specificReturn := fake.generateAgentEnvIsoReturnsOnCall[len(fake.generateAgentEnvIsoArgsForCall)]
 	fake.generateAgentEnvIsoArgsForCall = append(fake.generateAgentEnvIsoArgsForCall, struct {
-		arg1 apiv1.AgentEnv
-	}{arg1})
-	fake.recordInvocation("GenerateAgentEnvIso", []interface{}{arg1})
+		arg1 string
+		arg2 apiv1.AgentEnv
+	}{arg1, arg2})
+	fake.recordInvocation("GenerateAgentEnvIso", []interface{}{arg1, arg2})
 	fake.generateAgentEnvIsoMutex.Unlock()
 	if fake.GenerateAgentEnvIsoStub != nil {
-		return fake.GenerateAgentEnvIsoStub(arg1)
+		return fake.GenerateAgentEnvIsoStub(arg1, arg2)
 	}
 	if specificReturn {
 		return ret.result1, ret.result2
@@ -88,10 +105,10 @@ func (fake *FakeAgentSettings) GenerateAgentEnvIsoCallCount() int {
 	return len(fake.generateAgentEnvIsoArgsForCall)
 }
 
-func (fake *FakeAgentSettings) GenerateAgentEnvIsoArgsForCall(i int) apiv1.AgentEnv {
+func (fake *FakeAgentSettings) GenerateAgentEnvIsoArgsForCall(i int) (string, apiv1.AgentEnv) {
 	fake.generateAgentEnvIsoMutex.RLock()
 	defer fake.generateAgentEnvIsoMutex.RUnlock()
-	return fake.generateAgentEnvIsoArgsForCall[i].arg1
+	return fake.generateAgentEnvIsoArgsForCall[i].arg1, fake.generateAgentEnvIsoArgsForCall[i].arg2
 }
 
 func (fake *FakeAgentSettings) GenerateAgentEnvIsoReturns(result1 string, result2 error) {
@@ -159,43 +176,102 @@ func (fake *FakeAgentSettings) GenerateMacAddressReturnsOnCall(i int, result1 st
 	}{result1, result2}
 }
 
-func (fake *FakeAgentSettings) AgentEnvBytesFromFile() []byte {
-	fake.agentEnvBytesFromFileMutex.Lock()
-	ret, specificReturn := fake.agentEnvBytesFromFileReturnsOnCall[len(fake.agentEnvBytesFromFileArgsForCall)]
-	fake.agentEnvBytesFromFileArgsForCall = append(fake.agentEnvBytesFromFileArgsForCall, struct{}{})
-	fake.recordInvocation("AgentEnvBytesFromFile", []interface{}{})
-	fake.agentEnvBytesFromFileMutex.Unlock()
-	if fake.AgentEnvBytesFromFileStub != nil {
-		return fake.AgentEnvBytesFromFileStub()
+func (fake *FakeAgentSettings) AgentEnvBytes(arg1 string) ([]byte, error) {
+	fake.agentEnvBytesMutex.Lock()
+	ret, specificReturn := fake.agentEnvBytesReturnsOnCall[len(fake.agentEnvBytesArgsForCall)]
+	fake.agentEnvBytesArgsForCall = append(fake.agentEnvBytesArgsForCall, struct {
+		arg1 string
+	}{arg1})
+	fake.recordInvocation("AgentEnvBytes", []interface{}{arg1})
+	fake.agentEnvBytesMutex.Unlock()
+	if fake.AgentEnvBytesStub != nil {
+		return fake.AgentEnvBytesStub(arg1)
 	}
 	if specificReturn {
-		return ret.result1
+		return ret.result1, ret.result2
 	}
-	return fake.agentEnvBytesFromFileReturns.result1
+	return fake.agentEnvBytesReturns.result1, fake.agentEnvBytesReturns.result2
+}
+
+func (fake *FakeAgentSettings) AgentEnvBytesCallCount() int {
+	fake.agentEnvBytesMutex.RLock()
+	defer fake.agentEnvBytesMutex.RUnlock()
+	return len(fake.agentEnvBytesArgsForCall)
 }
 
-func (fake *FakeAgentSettings) AgentEnvBytesFromFileCallCount() int {
-	fake.agentEnvBytesFromFileMutex.RLock()
-	defer fake.agentEnvBytesFromFileMutex.RUnlock()
-	return len(fake.agentEnvBytesFromFileArgsForCall)
+func (fake *FakeAgentSettings) AgentEnvBytesArgsForCall(i int) string {
+	fake.agentEnvBytesMutex.RLock()
+	defer fake.agentEnvBytesMutex.RUnlock()
+	return fake.agentEnvBytesArgsForCall[i].arg1
 }
 
-func (fake *FakeAgentSettings) AgentEnvBytesFromFileReturns(result1 []byte) {
-	fake.AgentEnvBytesFromFileStub = nil
-	fake.agentEnvBytesFromFileReturns = struct {
+func (fake *FakeAgentSettings) AgentEnvBytesReturns(result1 []byte, result2 error) {
+	fake.AgentEnvBytesStub = nil
+	fake.agentEnvBytesReturns = struct {
 		result1 []byte
-	}{result1}
+		result2 error
+	}{result1, result2}
 }
 
-func (fake *FakeAgentSettings) AgentEnvBytesFromFileReturnsOnCall(i int, result1 []byte) {
-	fake.AgentEnvBytesFromFileStub = nil
-	if fake.agentEnvBytesFromFileReturnsOnCall == nil {
-		fake.agentEnvBytesFromFileReturnsOnCall = make(map[int]struct {
+func (fake *FakeAgentSettings) AgentEnvBytesReturnsOnCall(i int, result1 []byte, result2 error) {
+	fake.AgentEnvBytesStub = nil
+	if fake.agentEnvBytesReturnsOnCall == nil {
+		fake.agentEnvBytesReturnsOnCall = make(map[int]struct {
 			result1 []byte
+			result2 error
 		})
 	}
-	fake.agentEnvBytesFromFileReturnsOnCall[i] = struct {
+	fake.agentEnvBytesReturnsOnCall[i] = struct {
 		result1 []byte
+		result2 error
+	}{result1, result2}
+}
+
+func (fake *FakeAgentSettings) DeleteAgentEnv(arg1 string) error {
+	fake.deleteAgentEnvMutex.Lock()
+	ret, specificReturn := fake.deleteAgentEnvReturnsOnCall[len(fake.deleteAgentEnvArgsForCall)]
+	fake.deleteAgentEnvArgsForCall = append(fake.deleteAgentEnvArgsForCall, struct {
+		arg1 string
+	}{arg1})
+	fake.recordInvocation("DeleteAgentEnv", []interface{}{arg1})
+	fake.deleteAgentEnvMutex.Unlock()
+	if fake.DeleteAgentEnvStub != nil {
+		return fake.DeleteAgentEnvStub(arg1)
+	}
+	if specificReturn {
+		return ret.result1
+	}
+	return fake.deleteAgentEnvReturns.result1
+}
+
+func (fake *FakeAgentSettings) DeleteAgentEnvCallCount() int {
+	fake.deleteAgentEnvMutex.RLock()
+	defer fake.deleteAgentEnvMutex.RUnlock()
+	return len(fake.deleteAgentEnvArgsForCall)
+}
+
+func (fake *FakeAgentSettings) DeleteAgentEnvArgsForCall(i int) string {
+	fake.deleteAgentEnvMutex.RLock()
+	defer fake.deleteAgentEnvMutex.RUnlock()
+	return fake.deleteAgentEnvArgsForCall[i].arg1
+}
+
+func (fake *FakeAgentSettings) DeleteAgentEnvReturns(result1 error) {
+	fake.DeleteAgentEnvStub = nil
+	fake.deleteAgentEnvReturns = struct {
+		result1 error
+	}{result1}
+}
+
+func (fake *FakeAgentSettings) DeleteAgentEnvReturnsOnCall(i int, result1 error) {
+	fake.DeleteAgentEnvStub = nil
+	if fake.deleteAgentEnvReturnsOnCall == nil {
+		fake.deleteAgentEnvReturnsOnCall = make(map[int]struct {
+			result1 error
+		})
+	}
+	fake.deleteAgentEnvReturnsOnCall[i] = struct {
+		result1 error
 	}{result1}
 }
 
@@ -208,8 +284,10 @@ func (fake *FakeAgentSettings) Invocations() map[string][][]interface{} {
 	defer fake.generateAgentEnvIsoMutex.RUnlock()
 	fake.generateMacAddressMutex.RLock()
 	defer fake.generateMacAddressMutex.RUnlock()
-	fake.agentEnvBytesFromFileMutex.RLock()
-	defer fake.agentEnvBytesFromFileMutex.RUnlock()
+	fake.agentEnvBytesMutex.RLock()
+	defer fake.agentEnvBytesMutex.RUnlock()
+	fake.deleteAgentEnvMutex.RLock()
+	defer fake.deleteAgentEnvMutex.RUnlock()
 	copiedInvocations := map[string][][]interface{}{}
 	for key, value := range fake.invocations {
 		copiedInvocations[key] = value
@@ -0,0 +1,84 @@
+package vm
+
+import (
+	"strings"
+
+	bosherr "github.com/cloudfoundry/bosh-utils/errors"
+	"github.com/cppforlife/bosh-cpi-go/apiv1"
+)
+
+const (
+	DefaultNetworkAdapterType = "vmxnet3"
+	DefaultVSwitch            = "vSwitch0"
+)
+
+var NetworkAdapterTypes = []string{"e1000", "e1000e", "vmxnet3", "pcnet32"}
+
+type NetworkProps struct {
+	Name        string
+	AdapterType string `json:"adapter_type"`
+	VSwitch     string `json:"vswitch"`
+	VLANID      *int   `json:"vlan_id"`
+}
+
+// NewNetworkProps parses network cloud properties, falling back to the VM's adapter type
+func NewNetworkProps(cloudProps apiv1.NetworkCloudProps, defaultAdapterType string) (NetworkProps, error) {
+	networkProps := NetworkProps{
+		AdapterType: defaultAdapterType,
+	}
+
+	err := cloudProps.As(&networkProps)
+	if err != nil {
+		return NetworkProps{}, err
+	}
+
+	if networkProps.VLANID != nil && networkProps.VSwitch == "" {
+		networkProps.VSwitch = DefaultVSwitch
+	}
+
+	err = networkProps.Validate()
+	if err != nil {
+		return NetworkProps{}, bosherr.WrapErrorf(err, "Validating network '%s'", networkProps.Name)
+	}
+
+	return networkProps, nil
+}
+
+func (p NetworkProps) Validate() error {
+	err := ValidateNetworkAdapterType(p.AdapterType)
+	if err != nil {
+		return err
+	}
+
+	if p.ManagesPortGroup() && p.Name == "" {
+		return bosherr.Error("name is required when vswitch or vlan_id is set")
+	}
+
+	if p.VLANID != nil && (*p.VLANID < 0 || *p.VLANID > 4095) {
+		return bosherr.Errorf("vlan_id must be between 0 and 4095, got %d", *p.VLANID)
+	}
+
+	return nil
+}
+
+// ManagesPortGroup is true when the CPI should ensure the port group exists on the host
+func (p NetworkProps) ManagesPortGroup() bool {
+	return p.VSwitch != "" || p.VLANID != nil
+}
+
+func (p NetworkProps) VLAN() int {
+	if p.VLANID == nil {
+		return 0
+	}
+	return *p.VLANID
+}
+
+func ValidateNetworkAdapterType(adapterType string) error {
+	for _, knownType := range NetworkAdapterTypes {
+		if adapterType == knownType {
+			return nil
+		}
+	}
+
+	return bosherr.Errorf("adapter type must be one of %s, got '%s'", strings.Join(NetworkAdapterTypes, ", "), adapterType)
+}
@@ -0,0 +1,43 @@
+package vm_test
+
+import (
+	"encoding/json"
+
+	"github.com/cppforlife/bosh-cpi-go/apiv1"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	"bosh-esxi-cpi/vm"
+)
+
+var _ = Describe("NetworkProps", func() {
+	networkPropsFrom := func(raw string) (vm.NetworkProps, error) {
+		var cloudProps apiv1.CloudPropsImpl
+		err := json.Unmarshal([]byte(raw), &cloudProps)
+		Expect(err).ToNot(HaveOccurred())
+		return vm.NewNetworkProps(cloudProps, "vmxnet3")
+	}
+
+	It("leaves port groups unmanaged by default", func() {
+		networkProps, err := networkPropsFrom(`{"name": "VM Network"}`)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(networkProps.AdapterType).To(Equal("vmxnet3"))
+		Expect(networkProps.ManagesPortGroup()).To(BeFalse())
+	})
+
+	It("defaults the vswitch when only vlan_id is set", func() {
+		networkProps, err := networkPropsFrom(`{"name": "VLAN 0", "vlan_id": 0}`)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(networkProps.ManagesPortGroup()).To(BeTrue())
+		Expect(networkProps.VSwitch).To(Equal("vSwitch0"))
+		Expect(networkProps.VLAN()).To(Equal(0))
+	})
+
+	It("rejects invalid port group properties", func() {
+		_, err := networkPropsFrom(`{"name": "VLAN", "vlan_id": 4096}`)
+		Expect(err).To(MatchError(ContainSubstring("vlan_id must be between 0 and 4095, got 4096")))
+
+		_, err = networkPropsFrom(`{"vswitch": "vSwitch1"}`)
+		Expect(err).To(MatchError(ContainSubstring("name is required when vswitch or vlan_id is set")))
+	})
+})
@@ -7,7 +7,8 @@ import (
 //go:generate counterfeiter -o fakes/fake_agent_settings.go $GOPATH/src/bosh-esxi-cpi/vm/agent_settings.go AgentSettings
 type AgentSettings interface {
 	Cleanup()
-	GenerateAgentEnvIso(apiv1.AgentEnv) (string, error)
+	GenerateAgentEnvIso(string, apiv1.AgentEnv) (string, error)
 	GenerateMacAddress() (string, error)
-	AgentEnvBytesFromFile() []byte
+	AgentEnvBytes(string) ([]byte, error)
+	DeleteAgentEnv(string) error
 }
@@ -1,13 +1,36 @@
 package vm
 
 import (
+	"fmt"
+
+	bosherr "github.com/cloudfoundry/bosh-utils/errors"
 	"github.com/cppforlife/bosh-cpi-go/apiv1"
 )
 
+const (
+	FirmwareBIOS = "bios"
+	FirmwareEFI  = "efi"
+)
+
 type VMProps struct {
 	CPU  int
 	RAM  int
 	Disk int
+
+	CoresPerSocket               int                    `json:"cores_per_socket"`
+	CPUReservation               int                    `json:"cpu_reservation"`
+	MemoryReservation            int                    `json:"memory_reservation"`
+	CPUHotAdd                    bool                   `json:"cpu_hot_add"`
+	MemoryHotAdd                 bool                   `json:"memory_hot_add"`
+	NestedHardwareVirtualization bool                   `json:"nested_hardware_virtualization"`
+	SyncTimeWithHost             bool                   `json:"sync_time_with_host"`
+	Firmware                     string                 `json:"firmware"`
+	Version                      int                    `json:"version"`
+	VMXOptions                   map[string]interface{} `json:"vmx_options"`
+	NetworkAdapterType           string                 `json:"network_adapter_type"`
+
+	// Datastore places the VM on another datastore than the host's default one, where stemcells are imported
+	Datastore string `json:"datastore"`
 }
 
 func NewVMProps(cloudProps apiv1.VMCloudProps) (VMProps, error) {
@@ -15,6 +38,10 @@ func NewVMProps(cloudProps apiv1.VMCloudProps) (VMProps, error) {
 		CPU:  1,
 		RAM:  512,
 		Disk: 5000,
+
+		NestedHardwareVirtualization: true,
+		SyncTimeWithHost:             true,
+		NetworkAdapterType:           DefaultNetworkAdapterType,
 	}
 
 	err := cloudProps.As(&vmProps)
@@ -22,5 +49,61 @@ func NewVMProps(cloudProps apiv1.VMCloudProps) (VMProps, error) {
 		return VMProps{}, err
 	}
 
+	err = vmProps.Validate()
+	if err != nil {
+		return VMProps{}, err
+	}
+
 	return vmProps, nil
 }
+
+func (p VMProps) Validate() error {
+	if p.CPU < 1 {
+		return bosherr.Errorf("cpu must be at least 1, got %d", p.CPU)
+	}
+
+	if p.RAM < 1 {
+		return bosherr.Errorf("ram must be at least 1, got %d", p.RAM)
+	}
+
+	if p.CoresPerSocket < 0 || (p.CoresPerSocket > 0 && p.CPU%p.CoresPerSocket != 0) {
+		return bosherr.Errorf("cores_per_socket (%d) must evenly divide cpu (%d)", p.CoresPerSocket, p.CPU)
+	}
+
+	if p.CPUReservation < 0 {
+		return bosherr.Errorf("cpu_reservation must not be negative, got %d", p.CPUReservation)
+	}
+
+	if p.MemoryReservation < 0 || p.MemoryReservation > p.RAM {
+		return bosherr.Errorf("memory_reservation (%d) must be between 0 and ram (%d)", p.MemoryReservation, p.RAM)
+	}
+
+	switch p.Firmware {
+	case "", FirmwareBIOS, FirmwareEFI:
+	default:
+		return bosherr.Errorf("firmware must be '%s' or '%s', got '%s'", FirmwareBIOS, FirmwareEFI, p.Firmware)
+	}
+
+	if p.Version < 0 {
+		return bosherr.Errorf("version must not be negative, got %d", p.Version)
+	}
+
+	err := ValidateNetworkAdapterType(p.NetworkAdapterType)
+	if err != nil {
+		return bosherr.WrapError(err, "Validating network_adapter_type")
+	}
+
+	return nil
+}
+
+// ExtraConfig returns the vmx settings which have no dedicated config spec field. Cores per socket,
+// hot-add and firmware have one, which vSphere prefers over the vmx keys.
+func (p VMProps) ExtraConfig() map[string]string {
+	extraConfig := map[string]string{}
+
+	for key, value := range p.VMXOptions {
+		extraConfig[key] = fmt.Sprintf("%v", value)
+	}
+
+	return extraConfig
+}
@@ -0,0 +1,76 @@
+package vm_test
+
+import (
+	"encoding/json"
+
+	"github.com/cppforlife/bosh-cpi-go/apiv1"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	"bosh-esxi-cpi/vm"
+)
+
+var _ = Describe("VMProps", func() {
+	cloudPropsFrom := func(raw string) apiv1.CloudPropsImpl {
+		var cloudProps apiv1.CloudPropsImpl
+		err := json.Unmarshal([]byte(raw), &cloudProps)
+		Expect(err).ToNot(HaveOccurred())
+		return cloudProps
+	}
+
+	It("applies defaults for missing properties", func() {
+		vmProps, err := vm.NewVMProps(cloudPropsFrom(`{}`))
+		Expect(err).ToNot(HaveOccurred())
+
+		Expect(vmProps).To(Equal(vm.VMProps{
+			CPU:                          1,
+			RAM:                          512,
+			Disk:                         5000,
+			NestedHardwareVirtualization: true,
+			SyncTimeWithHost:             true,
+			NetworkAdapterType:           "vmxnet3",
+		}))
+		Expect(vmProps.ExtraConfig()).To(BeEmpty())
+	})
+
+	It("parses hardware properties, leaving those with a config spec field out of extra config", func() {
+		vmProps, err := vm.NewVMProps(cloudPropsFrom(`{
+			"cpu": 4,
+			"ram": 4096,
+			"cores_per_socket": 2,
+			"cpu_hot_add": true,
+			"memory_hot_add": true,
+			"nested_hardware_virtualization": false,
+			"firmware": "efi",
+			"version": 13,
+			"vmx_options": {"disk.enableUUID": "TRUE", "numa.autosize": true}
+		}`))
+		Expect(err).ToNot(HaveOccurred())
+
+		Expect(vmProps.NestedHardwareVirtualization).To(BeFalse())
+		Expect(vmProps.SyncTimeWithHost).To(BeTrue())
+		Expect(vmProps.Version).To(Equal(13))
+		Expect(vmProps.CoresPerSocket).To(Equal(2))
+		Expect(vmProps.CPUHotAdd).To(BeTrue())
+		Expect(vmProps.MemoryHotAdd).To(BeTrue())
+		Expect(vmProps.Firmware).To(Equal("efi"))
+		Expect(vmProps.ExtraConfig()).To(Equal(map[string]string{
+			"disk.enableUUID": "TRUE",
+			"numa.autosize":   "true",
+		}))
+	})
+
+	It("rejects invalid properties", func() {
+		_, err := vm.NewVMProps(cloudPropsFrom(`{"cpu": 3, "cores_per_socket": 2}`))
+		Expect(err).To(MatchError(ContainSubstring("cores_per_socket (2) must evenly divide cpu (3)")))
+
+		_, err = vm.NewVMProps(cloudPropsFrom(`{"ram": 1024, "memory_reservation": 2048}`))
+		Expect(err).To(MatchError(ContainSubstring("memory_reservation (2048) must be between 0 and ram (1024)")))
+
+		_, err = vm.NewVMProps(cloudPropsFrom(`{"firmware": "uefi"}`))
+		Expect(err).To(MatchError(ContainSubstring("firmware must be 'bios' or 'efi', got 'uefi'")))
+
+		_, err = vm.NewVMProps(cloudPropsFrom(`{"network_adapter_type": "vmxnet2"}`))
+		Expect(err).To(MatchError(ContainSubstring("adapter type must be one of e1000, e1000e, vmxnet3, pcnet32, got 'vmxnet2'")))
+	})
+})